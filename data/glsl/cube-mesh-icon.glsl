@@ -1,25 +1,29 @@
 [VERTEX]
 #version 100
 
+const float cUnitScale = 1.0 / 16.0;
+
 uniform mat4 uModelMatrix;
 uniform mat4 uProjectionMatrix; 
 uniform vec3 uPosition;
 uniform vec3 uOrigin;
 
 attribute vec3 aVertexPosition;
-attribute vec2 aAtlasXY;
+attribute vec3 aAtlasXYZ;
 attribute vec2 aVertexUV;
 attribute float aVertexLightLevel;
 
 varying float lightLevel;
 varying vec2 uv;
 varying vec2 atlasXY;
+varying float atlasPage;
 
 void main() {
-    uv = aVertexUV;
-    atlasXY = aAtlasXY;
+    uv = aVertexUV * cUnitScale;
+    atlasXY = aAtlasXYZ.xy;
+    atlasPage = aAtlasXYZ.z;
     lightLevel = aVertexLightLevel;
-    vec3 pos = aVertexPosition - uOrigin;
+    vec3 pos = aVertexPosition * cUnitScale - uOrigin;
     pos = vec3(uModelMatrix * vec4(pos, 1.0));
     pos = pos + uPosition;
 	gl_Position = uProjectionMatrix * vec4(pos, 1.0);
@@ -32,17 +36,35 @@ precision mediump float;
 
 const float cAtlasScale = 1.0 / 128.0;
 
-uniform sampler2D uAtlas;
+uniform sampler2D uAtlas0;
+uniform sampler2D uAtlas1;
+uniform sampler2D uAtlas2;
+uniform sampler2D uAtlas3;
 
 varying float lightLevel;
 varying vec2 uv;
 varying vec2 atlasXY;
+varying float atlasPage;
+
+vec4 atlas(vec2 auv) {
+    if (atlasPage < 0.5) {
+        return texture2D(uAtlas0, auv);
+    } else if (atlasPage < 1.5) {
+        return texture2D(uAtlas1, auv);
+    } else if (atlasPage < 2.5) {
+        return texture2D(uAtlas2, auv);
+    }
+    return texture2D(uAtlas3, auv);
+}
 
 void main() {
     vec2 auv = atlasXY;
     auv.x += fract(uv.x);
     auv.y += fract(uv.y);
     auv *= cAtlasScale;
-    vec3 color = texture2D(uAtlas, auv).rgb;
-    gl_FragColor = vec4(color * lightLevel, 1.0);
+    vec4 color = atlas(auv);
+    if (color.a < 0.5) {
+        discard;
+    }
+    gl_FragColor = vec4(color.rgb * lightLevel, 1.0);
 }
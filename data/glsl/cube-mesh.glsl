@@ -1,24 +1,33 @@
 [VERTEX]
 #version 100
 
+const float cUnitScale = 1.0 / 16.0;
+
 uniform mat4 uModelViewMatrix;
 uniform mat4 uProjectionMatrix;
+uniform float uFogStart;
+uniform float uFogEnd;
 
 attribute vec3 aVertexPosition;
-attribute vec2 aAtlasXY;
+attribute vec3 aAtlasXYZ;
 attribute vec2 aVertexUV;
 attribute float aVertexLightLevel;
 
 varying float lightLevel;
 varying vec2 uv;
 varying vec2 atlasXY;
+varying float atlasPage;
+varying float fog;
 
 void main() {
-    uv = aVertexUV;
-    atlasXY = aAtlasXY;
+    uv = aVertexUV * cUnitScale;
+    atlasXY = aAtlasXYZ.xy;
+    atlasPage = aAtlasXYZ.z;
     lightLevel = aVertexLightLevel;
-	gl_Position = uProjectionMatrix * uModelViewMatrix *
-        vec4(aVertexPosition, 1.0);
+    vec4 ep = uModelViewMatrix * vec4(aVertexPosition * cUnitScale, 1.0);
+    fog = clamp((length(ep.xyz) - uFogStart) / (uFogEnd - uFogStart), 0.0,
+        1.0);
+	gl_Position = uProjectionMatrix * ep;
 }
 
 [FRAGMENT]
@@ -28,17 +37,39 @@ precision mediump float;
 
 const float cAtlasScale = 1.0 / 128.0;
 
-uniform sampler2D uAtlas;
+uniform sampler2D uAtlas0;
+uniform sampler2D uAtlas1;
+uniform sampler2D uAtlas2;
+uniform sampler2D uAtlas3;
+uniform float uSkyLight;
+uniform vec3 uFogColor;
 
 varying float lightLevel;
 varying vec2 uv;
 varying vec2 atlasXY;
+varying float atlasPage;
+varying float fog;
+
+vec4 atlas(vec2 auv) {
+    if (atlasPage < 0.5) {
+        return texture2D(uAtlas0, auv);
+    } else if (atlasPage < 1.5) {
+        return texture2D(uAtlas1, auv);
+    } else if (atlasPage < 2.5) {
+        return texture2D(uAtlas2, auv);
+    }
+    return texture2D(uAtlas3, auv);
+}
 
 void main() {
     vec2 auv = atlasXY;
     auv.x += fract(uv.x);
     auv.y += fract(uv.y);
     auv *= cAtlasScale;
-    vec3 color = texture2D(uAtlas, auv).rgb;
-    gl_FragColor = vec4(color * lightLevel, 1.0);
+    vec4 color = atlas(auv);
+    if (color.a < 0.5) {
+        discard;
+    }
+    gl_FragColor = vec4(mix(color.rgb * lightLevel * uSkyLight, uFogColor,
+        fog), 1.0);
 }
@@ -1,28 +1,28 @@
 [VERTEX]
 #version 100
 
-const float scale = 1.0/16.0;
-
-uniform mat4 uModelMatrix;
 uniform mat4 uViewMatrix;
 uniform mat4 uProjectionMatrix;
-uniform vec3 uRotationPoint;
 uniform float uLightLevels[6];
+uniform float uFogStart;
+uniform float uFogEnd;
 
 attribute vec3 aVertexPosition;
 attribute vec3 aVertexColor;
 attribute float aVertexFacing;
+attribute mat4 aModelMatrix;
 
 varying vec3 color;
 varying float lightLevel;
+varying float fog;
 
 void main() {
 	color = aVertexColor;
 	lightLevel = uLightLevels[int(aVertexFacing)];
-	vec3 vp = aVertexPosition-uRotationPoint;
-	vp = vp * scale;
-	vp = vec3(uModelMatrix * vec4(vp, 1.0));
-	gl_Position = uProjectionMatrix * uViewMatrix * vec4(vp, 1.0);
+	vec4 ep = uViewMatrix * aModelMatrix * vec4(aVertexPosition, 1.0);
+	fog = clamp((length(ep.xyz) - uFogStart) / (uFogEnd - uFogStart), 0.0,
+		1.0);
+	gl_Position = uProjectionMatrix * ep;
 }
 
 [FRAGMENT]
@@ -30,9 +30,14 @@ void main() {
 
 precision mediump float;
 
+uniform float uSkyLight;
+uniform vec3 uFogColor;
+
 varying vec3 color;
 varying float lightLevel;
+varying float fog;
 
 void main() {
-	gl_FragColor = vec4(color * lightLevel, 1.0);
+	gl_FragColor = vec4(mix(color * lightLevel * uSkyLight, uFogColor, fog),
+		1.0);
 }
@@ -0,0 +1,73 @@
+[VERTEX]
+#version 100
+
+uniform mat4 uViewMatrix;
+uniform mat4 uProjectionMatrix;
+uniform float uFogStart;
+uniform float uFogEnd;
+
+attribute vec3 aVertexPosition;
+attribute vec2 aVertexUV;
+attribute vec4 aVertexColor;
+attribute float aAtlasPage;
+attribute float aVertexLightLevel;
+
+varying vec2 uv;
+varying vec4 color;
+varying float atlasPage;
+varying float lightLevel;
+varying float fog;
+
+void main() {
+	uv = aVertexUV;
+	color = aVertexColor;
+	atlasPage = aAtlasPage;
+	lightLevel = aVertexLightLevel;
+	vec4 ep = uViewMatrix * vec4(aVertexPosition, 1.0);
+	fog = clamp((length(ep.xyz) - uFogStart) / (uFogEnd - uFogStart), 0.0,
+		1.0);
+	gl_Position = uProjectionMatrix * ep;
+}
+
+[FRAGMENT]
+#version 100
+
+precision mediump float;
+
+uniform sampler2D uAtlas0;
+uniform sampler2D uAtlas1;
+uniform sampler2D uAtlas2;
+uniform sampler2D uAtlas3;
+uniform float uSkyLight;
+uniform vec3 uFogColor;
+
+varying vec2 uv;
+varying vec4 color;
+varying float atlasPage;
+varying float lightLevel;
+varying float fog;
+
+vec4 atlas(vec2 auv) {
+	if (atlasPage < 0.5) {
+		return texture2D(uAtlas0, auv);
+	} else if (atlasPage < 1.5) {
+		return texture2D(uAtlas1, auv);
+	} else if (atlasPage < 2.5) {
+		return texture2D(uAtlas2, auv);
+	}
+	return texture2D(uAtlas3, auv);
+}
+
+void main() {
+	// Untextured particles use an atlas page past the last
+	vec4 c = color;
+	if (atlasPage < 3.5) {
+		vec4 t = atlas(uv);
+		if (t.a < 0.5) {
+			discard;
+		}
+		c.rgb *= t.rgb;
+	}
+	gl_FragColor = vec4(mix(c.rgb * lightLevel * uSkyLight, uFogColor, fog),
+		c.a);
+}
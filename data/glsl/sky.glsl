@@ -0,0 +1,51 @@
+[VERTEX]
+#version 100
+
+uniform mat4 uInverseMatrix;
+
+attribute vec2 aVertexPosition;
+
+varying vec3 direction;
+
+void main() {
+	vec4 d = uInverseMatrix * vec4(aVertexPosition, 1.0, 1.0);
+	direction = d.xyz / d.w;
+	gl_Position = vec4(aVertexPosition, 1.0, 1.0);
+}
+
+[FRAGMENT]
+#version 100
+
+precision mediump float;
+
+const float cSunSize = 0.9990;
+const float cMoonSize = 0.9995;
+
+uniform vec3 uZenithColor;
+uniform vec3 uHorizonColor;
+uniform vec3 uSunDirection;
+uniform vec3 uSunColor;
+uniform vec3 uMoonColor;
+
+varying vec3 direction;
+
+void main() {
+	vec3 d = normalize(direction);
+	float h = clamp(d.y, 0.0, 1.0);
+	vec3 color = mix(uHorizonColor, uZenithColor, sqrt(h));
+	// Sun glow and disk
+	float sun = dot(d, uSunDirection);
+	color += uSunColor * pow(max(sun, 0.0), 64.0) * 0.35;
+	if (sun > cSunSize) {
+		color = uSunColor;
+	}
+	// Moon disk
+	if (dot(d, -uSunDirection) > cMoonSize) {
+		color = uMoonColor;
+	}
+	// Below the horizon fades into the fog
+	if (d.y < 0.0) {
+		color = uHorizonColor;
+	}
+	gl_FragColor = vec4(color, 1.0);
+}
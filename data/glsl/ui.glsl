@@ -6,11 +6,14 @@ uniform vec3 uPosition;
 
 attribute vec2 aVertexPosition;
 attribute vec2 aVertexUV;
+attribute float aAtlasPage;
 
 varying vec2 uv;
+varying float atlasPage;
 
 void main() {
 	uv = aVertexUV / 128.0;
+    atlasPage = aAtlasPage;
     vec3 pos = vec3(aVertexPosition, 0.0)+uPosition;
 	gl_Position = uProjectionMatrix * vec4(pos, 1.0);
 }
@@ -20,11 +23,26 @@ void main() {
 
 precision mediump float;
 
-uniform sampler2D uAtlas;
+uniform sampler2D uAtlas0;
+uniform sampler2D uAtlas1;
+uniform sampler2D uAtlas2;
+uniform sampler2D uAtlas3;
 
 varying vec2 uv;
+varying float atlasPage;
+
+vec4 atlas(vec2 auv) {
+    if (atlasPage < 0.5) {
+        return texture2D(uAtlas0, auv);
+    } else if (atlasPage < 1.5) {
+        return texture2D(uAtlas1, auv);
+    } else if (atlasPage < 2.5) {
+        return texture2D(uAtlas2, auv);
+    }
+    return texture2D(uAtlas3, auv);
+}
 
 void main() {
-    vec4 color = texture2D(uAtlas, uv);
+    vec4 color = atlas(uv);
     gl_FragColor = color;
 }
@@ -9,6 +9,8 @@ uniform mat4 uViewMatrix;
 uniform mat4 uProjectionMatrix;
 uniform float uLightLevels[6*6];
 uniform int uFacing;
+uniform float uFogStart;
+uniform float uFogEnd;
 
 attribute vec3 aVertexPosition;
 attribute vec3 aVertexColor;
@@ -16,13 +18,16 @@ attribute float aVertexFacing;
 
 varying vec3 color;
 varying float lightLevel;
+varying float fog;
 
 void main() {
 	color = aVertexColor;
 	lightLevel = uLightLevels[uFacing*6+int(aVertexFacing)];
 	vec3 vp = aVertexPosition-cRotationPoint;
-	gl_Position = uProjectionMatrix * uViewMatrix * uModelMatrix *
-		vec4(vp*cScale, 1.0);
+	vec4 ep = uViewMatrix * uModelMatrix * vec4(vp*cScale, 1.0);
+	fog = clamp((length(ep.xyz) - uFogStart) / (uFogEnd - uFogStart), 0.0,
+		1.0);
+	gl_Position = uProjectionMatrix * ep;
 }
 
 [FRAGMENT]
@@ -30,9 +35,14 @@ void main() {
 
 precision mediump float;
 
+uniform float uSkyLight;
+uniform vec3 uFogColor;
+
 varying vec3 color;
 varying float lightLevel;
+varying float fog;
 
 void main() {
-	gl_FragColor = vec4(color * lightLevel, 1.0);
+	gl_FragColor = vec4(mix(color * lightLevel * uSkyLight, uFogColor, fog),
+		1.0);
 }
@@ -1,84 +1,297 @@
 package c3d
 
 import (
+	"math"
+	"sort"
+
 	"github.com/go-gl/mathgl/mgl32"
 )
 
-// AnimationFrame describes a single frame of the animation.
-type AnimationFrame struct {
-	Time    float32                // Time T for interpolation to Q
-	TTL     float32                // Time left to move
-	Joints  map[string]*mgl32.Quat // Map of part ID's to quaternions that describe that part's final rotation
-	Targets map[string]mgl32.Quat  // Target rotations for each joint
-	Starts  map[string]mgl32.Quat  // Staring rotation of the part in the frame
+// Easing selects the function used to interpolate into a keyframe from the
+// keyframe before it.
+type Easing uint8
+
+const (
+	EaseLinear Easing = iota // Constant rate of change
+	EaseIn                   // Starts slow and speeds up
+	EaseOut                  // Starts fast and slows down
+	EaseInOut                // Starts and ends slow
+	EaseStep                 // Holds the previous value until the keyframe
+	EaseCubic                // Smooth Catmull-Rom curve through the neighboring keyframes
+)
+
+// easingNames maps easing names as they appear in data files to values.
+var easingNames = map[string]Easing{
+	"linear":    EaseLinear,
+	"easeIn":    EaseIn,
+	"easeOut":   EaseOut,
+	"easeInOut": EaseInOut,
+	"step":      EaseStep,
+	"cubic":     EaseCubic,
 }
 
-// setRotations sets the joint rotations for the given time t, where t is in the
-// open range (0.0-1.0).
-func (f *AnimationFrame) setRotations(t float32) {
-	for k := range f.Targets {
-		q := mgl32.QuatNlerp(
-			f.Starts[k],
-			f.Targets[k],
-			t,
-		)
-		q = q.Normalize()
-		*f.Joints[k] = q
+// EasingByName returns the easing function with the given name. False is
+// returned if the name is unknown. The empty string names EaseLinear.
+func EasingByName(n string) (Easing, bool) {
+	if n == "" {
+		return EaseLinear, true
 	}
+	e, found := easingNames[n]
+	return e, found
 }
 
-// Animation contains the information and functionality to play an animation
-// loop on a model. Animations consist of rotations to joints only.
-type Animation struct {
-	Frames []*AnimationFrame // List of all frames
-	i      int               // Current frame index
+// String returns the name of the easing function as it appears in data
+// files.
+func (e Easing) String() string {
+	for k, v := range easingNames {
+		if v == e {
+			return k
+		}
+	}
+	return "linear"
 }
 
-// Play starts the animation playing.
-func (a *Animation) Play() {
-	a.i = 0
-	if len(a.Frames) < 1 {
-		return
+// apply maps the linear interpolation factor s to the eased factor. EaseCubic
+// is linear here as it is handled by the curve itself.
+func (e Easing) apply(s float32) float32 {
+	switch e {
+	case EaseIn:
+		return s * s
+	case EaseOut:
+		return s * (2 - s)
+	case EaseInOut:
+		return s * s * (3 - 2*s)
+	case EaseStep:
+		return 0
+	}
+	return s
+}
+
+// ChannelType identifies the property of a joint a channel animates.
+type ChannelType uint8
+
+const (
+	ChannelRotation ChannelType = iota // Rotation of the joint
+	ChannelPosition                    // Translation of the joint from its bind pose in voxels
+	ChannelScale                       // Scale of the joint's mesh
+)
+
+// Keyframe is a single key of an animation channel.
+type Keyframe struct {
+	Time   float32    // Time of the key from the start of the clip in seconds
+	Value  mgl32.Vec3 // Euler X, Y, Z angles in radians for rotations, otherwise the vector value
+	Easing Easing     // Easing used to interpolate into this key from the previous one
+	q      mgl32.Quat // Rotation quaternion for rotation keys
+}
+
+// Channel is an independent timeline of keyframes for one property of one
+// joint.
+type Channel struct {
+	Joint string      // ID of the part animated
+	Type  ChannelType // Property of the part animated
+	Keys  []Keyframe  // Keyframes sorted by time
+}
+
+// eulerToQuat converts X, Y, Z Euler angles in radians to a quaternion using
+// the same convention as model and animation files.
+func eulerToQuat(v mgl32.Vec3) mgl32.Quat {
+	return mgl32.AnglesToQuat(v[2], v[1], v[0], mgl32.ZYX)
+}
+
+// prepare sorts the keys of the channel and computes derived values.
+func (c *Channel) prepare() {
+	sort.SliceStable(c.Keys, func(i, j int) bool {
+		return c.Keys[i].Time < c.Keys[j].Time
+	})
+	for i := range c.Keys {
+		c.Keys[i].q = eulerToQuat(c.Keys[i].Value)
 	}
-	a.startFrame()
 }
 
-// startFrame starts the current frame.
-func (a *Animation) startFrame() {
-	f := a.Frames[a.i]
-	for k := range f.Joints {
-		f.Starts[k] = *f.Joints[k]
+// key returns the key at index i, wrapping around the clip when looping or
+// clamping to the first and last keys otherwise. The returned time is
+// adjusted by the length of the clip for wrapped keys.
+func (c *Channel) key(i int, length float32, loop bool) (Keyframe, float32) {
+	n := len(c.Keys)
+	if !loop || length <= 0 {
+		k := c.Keys[min(max(i, 0), n-1)]
+		return k, k.Time
 	}
-	f.TTL = f.Time
+	wraps := int(math.Floor(float64(i) / float64(n)))
+	k := c.Keys[i-wraps*n]
+	return k, k.Time + float32(wraps)*length
 }
 
-// Update advances toward the next frame, ensuring that when a frame
-// transition occurs the final position is reached by the target joint. The t
-// argument is the number of seconds elapsed since the last call to this
-// function. Frames are transitioned as appropriate.
-func (a *Animation) Update(dt float32) {
-	if len(a.Frames) < 1 {
-		return
+// sample returns the value of the channel at time s into the clip. Rotation
+// channels return the quaternion, other channels return the vector.
+func (c *Channel) sample(s, length float32, loop bool) (mgl32.Vec3,
+	mgl32.Quat) {
+	if len(c.Keys) == 1 {
+		return c.Keys[0].Value, c.Keys[0].q
+	}
+	// Index of the first key after time s
+	i1 := sort.Search(len(c.Keys), func(i int) bool {
+		return c.Keys[i].Time > s
+	})
+	if !loop && (i1 == 0 || i1 == len(c.Keys)) {
+		k, _ := c.key(i1-min(i1, 1), length, loop)
+		return k.Value, k.q
+	}
+	k0, t0 := c.key(i1-1, length, loop)
+	k1, t1 := c.key(i1, length, loop)
+	f := float32(1)
+	if t1 > t0 {
+		f = (s - t0) / (t1 - t0)
+	}
+	f = k1.Easing.apply(f)
+	if k1.Easing == EaseCubic {
+		p0, _ := c.key(i1-2, length, loop)
+		p3, _ := c.key(i1+1, length, loop)
+		v := catmullRom(p0.Value, k0.Value, k1.Value, p3.Value, f)
+		return v, eulerToQuat(v)
+	}
+	v := k0.Value.Add(k1.Value.Sub(k0.Value).Mul(f))
+	if c.Type != ChannelRotation {
+		return v, mgl32.QuatIdent()
+	}
+	return v, nlerp(k0.q, k1.q, f)
+}
+
+// catmullRom returns the point at s along the Catmull-Rom spline segment
+// between p1 and p2.
+func catmullRom(p0, p1, p2, p3 mgl32.Vec3, s float32) mgl32.Vec3 {
+	s2 := s * s
+	s3 := s2 * s
+	return p1.Mul(2).
+		Add(p2.Sub(p0).Mul(s)).
+		Add(p0.Mul(2).Sub(p1.Mul(5)).Add(p2.Mul(4)).Sub(p3).Mul(s2)).
+		Add(p1.Mul(3).Sub(p0).Sub(p2.Mul(3)).Add(p3).Mul(s3)).
+		Mul(0.5)
+}
+
+// AnimationEvent is a named event that fires when playback of an animation
+// reaches the event's time.
+type AnimationEvent struct {
+	Time float32 // Time of the event from the start of the clip in seconds
+	Name string  // Name of the event
+}
+
+// AnimationClip is the shared, immutable description of an animation.
+type AnimationClip struct {
+	Length   float32          // Length of the clip in seconds
+	Channels []*Channel       // All channels of the clip
+	Events   []AnimationEvent // All events of the clip sorted by time
+}
+
+// NewAnimationClip returns a new animation clip for the given channels and
+// events. If length is not greater than zero the time of the last key or event
+// is used.
+func NewAnimationClip(length float32, channels []*Channel,
+	events []AnimationEvent) *AnimationClip {
+	ret := &AnimationClip{
+		Length: length,
+		Events: append([]AnimationEvent(nil), events...),
+	}
+	sort.SliceStable(ret.Events, func(i, j int) bool {
+		return ret.Events[i].Time < ret.Events[j].Time
+	})
+	if length <= 0 && len(ret.Events) > 0 {
+		ret.Length = ret.Events[len(ret.Events)-1].Time
 	}
-	for {
-		f := a.Frames[a.i]
-		ft := dt
-		if ft > f.TTL {
-			ft = f.TTL
+	for _, c := range channels {
+		if len(c.Keys) < 1 {
+			continue
 		}
-		dt -= ft
-		f.TTL -= ft
-		qt := 1.0 - (f.TTL / f.Time)
-		f.setRotations(qt)
-		if f.TTL <= 0 {
-			a.i++
-			if a.i >= len(a.Frames) {
-				a.i = 0
-			}
-			a.startFrame()
+		c.prepare()
+		ret.Channels = append(ret.Channels, c)
+		if length <= 0 {
+			ret.Length = max(ret.Length, c.Keys[len(c.Keys)-1].Time)
 		}
-		if dt <= 0 {
+	}
+	return ret
+}
+
+// Animation is the playback state of an animation clip. Many animations may
+// share the same clip.
+type Animation struct {
+	Clip    *AnimationClip // Clip being played
+	Time    float32        // Current time into the clip in seconds
+	Loop    bool           // If true the animation loops at the end of the clip
+	Done    bool           // True once a non-looping animation reaches the end
+	started bool           // If true the animation has been updated since Play
+}
+
+// NewAnimation returns a new animation that plays the clip.
+func NewAnimation(c *AnimationClip, loop bool) *Animation {
+	return &Animation{
+		Clip: c,
+		Loop: loop,
+	}
+}
+
+// Play starts the animation playing from the beginning.
+func (a *Animation) Play() {
+	a.Time = 0
+	a.Done = false
+	a.started = false
+}
+
+// Update advances the animation by dt seconds, looping at the end of the clip
+// if Loop is true. The function fn, if not nil, is called for every event
+// passed during the update in order. Events at the very start of the clip fire
+// on the first update after Play and on every loop. True is returned if a
+// non-looping animation reached the end of the clip during this update.
+func (a *Animation) Update(dt float32, fn func(e AnimationEvent)) bool {
+	if a.Done {
+		return false
+	}
+	from := a.Time
+	inclusive := !a.started
+	a.started = true
+	a.Time += dt
+	if a.Time < a.Clip.Length {
+		a.fire(from, a.Time, inclusive, fn)
+		return false
+	}
+	if !a.Loop || a.Clip.Length <= 0 {
+		a.Time = a.Clip.Length
+		a.Done = true
+		a.fire(from, a.Time, inclusive, fn)
+		return true
+	}
+	loops := int(a.Time / a.Clip.Length)
+	a.fire(from, a.Clip.Length, inclusive, fn)
+	for i := 1; i < loops; i++ {
+		a.fire(0, a.Clip.Length, true, fn)
+	}
+	a.Time = float32(math.Mod(float64(a.Time), float64(a.Clip.Length)))
+	a.fire(0, a.Time, true, fn)
+	return false
+}
+
+// fire calls fn for every event after time from, or at from if inclusive is
+// true, up to and including time to.
+func (a *Animation) fire(from, to float32, inclusive bool,
+	fn func(e AnimationEvent)) {
+	if fn == nil {
+		return
+	}
+	for _, e := range a.Clip.Events {
+		if e.Time > to {
 			break
 		}
+		if e.Time > from || (inclusive && e.Time == from) {
+			fn(e)
+		}
+	}
+}
+
+// Sample calls fn for every channel of the clip with the value of the channel
+// at the current time. Rotation channels provide the quaternion in q, other
+// channels provide the vector value in v.
+func (a *Animation) Sample(fn func(c *Channel, v mgl32.Vec3, q mgl32.Quat)) {
+	for _, c := range a.Clip.Channels {
+		v, q := c.sample(a.Time, a.Clip.Length, a.Loop)
+		fn(c, v, q)
 	}
 }
@@ -0,0 +1,363 @@
+package c3d
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// nlerp returns the normalized linear interpolation between a and b along the
+// shortest path.
+func nlerp(a, b mgl32.Quat, f float32) mgl32.Quat {
+	if a.Dot(b) < 0 {
+		b = b.Scale(-1)
+	}
+	return mgl32.QuatNlerp(a, b, f).Normalize()
+}
+
+// lerp returns the linear interpolation between a and b.
+func lerp(a, b mgl32.Vec3, f float32) mgl32.Vec3 {
+	return a.Add(b.Sub(a).Mul(f))
+}
+
+// jointPose is the blended state of a single joint.
+type jointPose struct {
+	q mgl32.Quat // Rotation
+	p mgl32.Vec3 // Translation from the bind pose in voxels
+	s mgl32.Vec3 // Mesh scale
+}
+
+// layerSample accumulates the weighted samples of all animations of a layer
+// for a single joint.
+type layerSample struct {
+	jointPose
+	w [3]float32 // Total weight accumulated by channel type
+}
+
+// animationTrack is one animation playing on a layer.
+type animationTrack struct {
+	a        *Animation // Playback state
+	weight   float32    // Current weight in the range 0-1
+	fade     float32    // Fade time in seconds
+	fadeRate float32    // Change in weight per second
+	done     func()     // Completion callback, if any
+}
+
+// AnimationLayer is one layer of animations blended by an Animator. The
+// animations playing on a layer crossfade between each other.
+type AnimationLayer struct {
+	Name     string                  // Name of the layer
+	Weight   float32                 // Weight of the layer in the range 0-1
+	Additive bool                    // If true the layer adds to the layers below rather than replacing them
+	Mask     map[string]float32      // Per-joint weights in the range 0-1, or nil for all joints at full weight
+	tracks   []*animationTrack       // Animations playing on the layer, the last is current
+	samples  map[string]*layerSample // Blended samples of the layer's animations by part ID
+}
+
+// jointWeight returns the weight of the layer for the joint.
+func (l *AnimationLayer) jointWeight(j string) float32 {
+	if l.Mask == nil {
+		return l.Weight
+	}
+	return l.Weight * l.Mask[j]
+}
+
+// Current returns the animation most recently started on the layer, or nil if
+// the layer is not playing anything.
+func (l *AnimationLayer) Current() *Animation {
+	if len(l.tracks) < 1 {
+		return nil
+	}
+	tr := l.tracks[len(l.tracks)-1]
+	if tr.fadeRate < 0 {
+		return nil
+	}
+	return tr.a
+}
+
+// Animator blends animations playing on any number of layers and applies the
+// resulting pose to a hierarchy of parts. Layers are blended in the order they
+// were created, each replacing or adding to the pose of the layers before it.
+type Animator struct {
+	// OnEvent, if not nil, is called for every event passed by animations
+	// during Update that are not fading out, after the pose is applied.
+	OnEvent func(l *AnimationLayer, e AnimationEvent)
+	layers  []*AnimationLayer     // Layers in blend order
+	joints  map[string]*Part      // Parts by ID
+	pose    map[string]*jointPose // Blended pose by part ID
+}
+
+// NewAnimator returns a new Animator that animates the given parts by ID.
+func NewAnimator(joints map[string]*Part) *Animator {
+	ret := &Animator{
+		joints: joints,
+		pose:   map[string]*jointPose{},
+	}
+	for k := range joints {
+		ret.pose[k] = &jointPose{}
+	}
+	return ret
+}
+
+// Layer returns the named layer, creating it with full weight on top of all
+// other layers if needed.
+func (a *Animator) Layer(name string) *AnimationLayer {
+	for _, l := range a.layers {
+		if l.Name == name {
+			return l
+		}
+	}
+	l := &AnimationLayer{
+		Name:    name,
+		Weight:  1,
+		samples: map[string]*layerSample{},
+	}
+	a.layers = append(a.layers, l)
+	return l
+}
+
+// Play starts the clip on the named layer, crossfading from any animations
+// already playing on the layer over fade seconds. If the clip is already the
+// current animation of the layer it is left playing. Non-looping animations
+// fade out over the final fade seconds of the clip and are then removed. The
+// done function, if not nil, is called when the animation is removed from the
+// layer, either because it completed or because it was stopped or replaced.
+func (a *Animator) Play(layer string, c *AnimationClip, fade float32,
+	loop bool, done func()) *Animation {
+	l := a.Layer(layer)
+	if cur := l.Current(); cur != nil && cur.Clip == c && !cur.Done {
+		return cur
+	}
+	a.fadeOut(l, fade)
+	tr := &animationTrack{
+		a:      NewAnimation(c, loop),
+		weight: 1,
+		fade:   fade,
+		done:   done,
+	}
+	if fade > 0 && len(l.tracks) > 0 {
+		tr.weight = 0
+		tr.fadeRate = 1 / fade
+	}
+	tr.a.Play()
+	l.tracks = append(l.tracks, tr)
+	return tr.a
+}
+
+// Stop fades out all animations on the named layer over fade seconds.
+func (a *Animator) Stop(layer string, fade float32) {
+	for _, l := range a.layers {
+		if l.Name == layer {
+			a.fadeOut(l, fade)
+		}
+	}
+}
+
+// fadeOut starts all animations of the layer fading out over fade seconds.
+// Animations are removed immediately if fade is not greater than zero.
+func (a *Animator) fadeOut(l *AnimationLayer, fade float32) {
+	for _, tr := range l.tracks {
+		if fade <= 0 {
+			tr.weight = 0
+			tr.fadeRate = -1
+			continue
+		}
+		if tr.fadeRate >= 0 {
+			tr.fadeRate = -1 / fade
+		}
+	}
+	if fade <= 0 {
+		for _, d := range l.prune() {
+			d()
+		}
+	}
+}
+
+// prune removes all tracks that have faded out or completed and returns their
+// completion callbacks.
+func (l *AnimationLayer) prune() []func() {
+	var ret []func()
+	tracks := l.tracks[:0]
+	for _, tr := range l.tracks {
+		if (tr.fadeRate < 0 && tr.weight <= 0) || (tr.a.Done && tr.fade <= 0) {
+			if tr.done != nil {
+				ret = append(ret, tr.done)
+			}
+			continue
+		}
+		tracks = append(tracks, tr)
+	}
+	for i := len(tracks); i < len(l.tracks); i++ {
+		l.tracks[i] = nil
+	}
+	l.tracks = tracks
+	return ret
+}
+
+// Update advances all animations by dt seconds, blends all layers and applies
+// the resulting pose to the parts.
+func (a *Animator) Update(dt float32) {
+	type layerEvent struct {
+		l *AnimationLayer
+		e AnimationEvent
+	}
+	var done []func()
+	var events []layerEvent
+	for _, l := range a.layers {
+		for _, tr := range l.tracks {
+			var fn func(e AnimationEvent)
+			if a.OnEvent != nil && tr.fadeRate >= 0 {
+				fn = func(e AnimationEvent) {
+					events = append(events, layerEvent{l: l, e: e})
+				}
+			}
+			tr.a.Update(dt, fn)
+			if !tr.a.Loop && tr.fade > 0 && tr.fadeRate >= 0 &&
+				tr.a.Clip.Length-tr.a.Time <= tr.fade {
+				tr.fadeRate = -1 / tr.fade
+			}
+			tr.weight += tr.fadeRate * dt
+			if tr.weight >= 1 {
+				tr.weight = 1
+				if tr.fadeRate > 0 {
+					tr.fadeRate = 0
+				}
+			}
+			if tr.weight < 0 {
+				tr.weight = 0
+			}
+		}
+		done = append(done, l.prune()...)
+	}
+	a.apply()
+	for _, e := range events {
+		a.OnEvent(e.l, e.e)
+	}
+	for _, d := range done {
+		d()
+	}
+}
+
+// apply blends all layers over the bind pose and applies the result to the
+// parts.
+func (a *Animator) apply() {
+	for _, jp := range a.pose {
+		*jp = jointPose{
+			q: mgl32.QuatIdent(),
+			s: mgl32.Vec3{1, 1, 1},
+		}
+	}
+	for k, p := range a.joints {
+		a.pose[k].q = p.Pose.Q
+	}
+	for _, l := range a.layers {
+		if l.Additive {
+			a.applyAdditive(l)
+		} else {
+			a.applyOverride(l)
+		}
+	}
+	for k, p := range a.joints {
+		jp := a.pose[k]
+		p.Orientation.Q = jp.q
+		p.Orientation.P = p.Pose.P.Add(jp.p.Mul(t.VoxelScale))
+		p.Scale = jp.s
+	}
+}
+
+// applyOverride blends the animations of the layer and then blends the result
+// over the pose by the weight of the layer.
+func (a *Animator) applyOverride(l *AnimationLayer) {
+	for _, s := range l.samples {
+		s.w = [3]float32{}
+	}
+	for _, tr := range l.tracks {
+		if tr.weight <= 0 {
+			continue
+		}
+		tr.a.Sample(func(c *Channel, v mgl32.Vec3, q mgl32.Quat) {
+			s := l.samples[c.Joint]
+			if s == nil {
+				s = &layerSample{}
+				l.samples[c.Joint] = s
+			}
+			first := s.w[c.Type] == 0
+			s.w[c.Type] += tr.weight
+			f := tr.weight / s.w[c.Type]
+			switch c.Type {
+			case ChannelRotation:
+				if first {
+					s.q = q
+				} else {
+					s.q = nlerp(s.q, q, f)
+				}
+			case ChannelPosition:
+				if first {
+					s.p = v
+				} else {
+					s.p = lerp(s.p, v, f)
+				}
+			case ChannelScale:
+				if first {
+					s.s = v
+				} else {
+					s.s = lerp(s.s, v, f)
+				}
+			}
+		})
+	}
+	for k, s := range l.samples {
+		jp := a.pose[k]
+		if jp == nil {
+			continue
+		}
+		w := l.jointWeight(k)
+		if w <= 0 {
+			continue
+		}
+		if s.w[ChannelRotation] > 0 {
+			jp.q = nlerp(jp.q, s.q, w*min(s.w[ChannelRotation], 1))
+		}
+		if s.w[ChannelPosition] > 0 {
+			jp.p = lerp(jp.p, s.p, w*min(s.w[ChannelPosition], 1))
+		}
+		if s.w[ChannelScale] > 0 {
+			jp.s = lerp(jp.s, s.s, w*min(s.w[ChannelScale], 1))
+		}
+	}
+}
+
+// applyAdditive adds the difference between the current value of each
+// channel of the layer's animations and the channel's first key to the pose,
+// scaled by the weights of the animation and layer.
+func (a *Animator) applyAdditive(l *AnimationLayer) {
+	for _, tr := range l.tracks {
+		if tr.weight <= 0 {
+			continue
+		}
+		tr.a.Sample(func(c *Channel, v mgl32.Vec3, q mgl32.Quat) {
+			jp := a.pose[c.Joint]
+			if jp == nil {
+				return
+			}
+			w := tr.weight * l.jointWeight(c.Joint)
+			if w <= 0 {
+				return
+			}
+			ref := c.Keys[0]
+			switch c.Type {
+			case ChannelRotation:
+				d := ref.q.Inverse().Mul(q)
+				jp.q = jp.q.Mul(nlerp(mgl32.QuatIdent(), d, w)).Normalize()
+			case ChannelPosition:
+				jp.p = jp.p.Add(v.Sub(ref.Value).Mul(w))
+			case ChannelScale:
+				for i := 0; i < 3; i++ {
+					if ref.Value[i] == 0 {
+						continue
+					}
+					jp.s[i] *= 1 + (v[i]/ref.Value[i]-1)*w
+				}
+			}
+		})
+	}
+}
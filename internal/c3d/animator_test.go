@@ -0,0 +1,205 @@
+package c3d
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// positionChannel returns a position channel of the joint moving along the X
+// axis through the given time and X value pairs.
+func positionChannel(joint string, e Easing, keys ...float32) *Channel {
+	ret := &Channel{
+		Joint: joint,
+		Type:  ChannelPosition,
+	}
+	for i := 0; i+1 < len(keys); i += 2 {
+		ret.Keys = append(ret.Keys, Keyframe{
+			Time:   keys[i],
+			Value:  mgl32.Vec3{keys[i+1], 0, 0},
+			Easing: e,
+		})
+	}
+	return ret
+}
+
+// holdClip returns a clip one second long holding each joint at the given X
+// position.
+func holdClip(x float32, joints ...string) *AnimationClip {
+	var channels []*Channel
+	for _, j := range joints {
+		channels = append(channels, positionChannel(j, EaseLinear, 0, x))
+	}
+	return NewAnimationClip(1, channels, nil)
+}
+
+// newTestAnimator returns an animator of parts with the given IDs in their
+// bind poses.
+func newTestAnimator(ids ...string) *Animator {
+	joints := map[string]*Part{}
+	for _, id := range ids {
+		joints[id] = &Part{
+			ID:    id,
+			Pose:  t.O(),
+			Scale: mgl32.Vec3{1, 1, 1},
+		}
+	}
+	return NewAnimator(joints)
+}
+
+// checkX checks the blended X position of each joint of the animator.
+func checkX(tt *testing.T, step string, a *Animator, want map[string]float32) {
+	tt.Helper()
+	for j, x := range want {
+		if got := a.pose[j].p[0]; mgl32.Abs(got-x) > 1e-4 {
+			tt.Errorf("%s: joint %s at %v, want %v", step, j, got, x)
+		}
+	}
+}
+
+func TestChannelSample(t *testing.T) {
+	ch := func(e Easing, keys ...float32) *Channel {
+		return positionChannel("j", e, keys...)
+	}
+	tests := []struct {
+		name string
+		c    *Channel
+		s    float32
+		loop bool
+		want float32
+	}{
+		{"linear", ch(EaseLinear, 0, 0, 1, 10), 0.5, false, 5},
+		{"ease in", ch(EaseIn, 0, 0, 1, 10), 0.5, false, 2.5},
+		{"ease out", ch(EaseOut, 0, 0, 1, 10), 0.5, false, 7.5},
+		{"ease in out", ch(EaseInOut, 0, 0, 1, 10), 0.25, false, 1.5625},
+		{"step", ch(EaseStep, 0, 0, 1, 10), 0.9, false, 0},
+		{"cubic at key", ch(EaseCubic, 0, 0, 0.5, 4, 1, 10), 0.5, false, 4},
+		{"before first key", ch(EaseLinear, 0.25, 0, 0.75, 10), 0, false, 0},
+		{"after last key", ch(EaseLinear, 0, 0, 0.5, 10), 0.75, false, 10},
+		{"loop after last key", ch(EaseLinear, 0, 0, 0.5, 10), 0.75, true, 5},
+		{"loop before first key", ch(EaseLinear, 0.25, 0, 0.75, 10), 0, true,
+			5},
+	}
+	for _, tt := range tests {
+		tt.c.prepare()
+		v, _ := tt.c.sample(tt.s, 1, tt.loop)
+		if mgl32.Abs(v[0]-tt.want) > 1e-4 {
+			t.Errorf("%s: sampled %v, want %v", tt.name, v[0], tt.want)
+		}
+	}
+}
+
+func TestChannelSampleRotation(t *testing.T) {
+	c := &Channel{
+		Joint: "j",
+		Type:  ChannelRotation,
+		Keys: []Keyframe{
+			{Time: 0},
+			{Time: 1, Value: mgl32.Vec3{0, mgl32.DegToRad(90), 0}},
+		},
+	}
+	c.prepare()
+	_, q := c.sample(0.5, 1, false)
+	want := mgl32.QuatRotate(mgl32.DegToRad(45), mgl32.Vec3{0, 1, 0})
+	if !q.ApproxEqualThreshold(want, 1e-4) {
+		t.Errorf("sampled %v, want %v", q, want)
+	}
+}
+
+func TestAnimatorCrossfade(t *testing.T) {
+	a := newTestAnimator("j")
+	done := 0
+	a.Play("base", holdClip(10, "j"), 0, true, func() { done++ })
+	a.Update(0)
+	checkX(t, "first", a, map[string]float32{"j": 10})
+	second := holdClip(20, "j")
+	a.Play("base", second, 1, true, nil)
+	a.Update(0.5)
+	checkX(t, "halfway", a, map[string]float32{"j": 15})
+	if done != 0 {
+		t.Errorf("fading animation removed early")
+	}
+	a.Update(0.5)
+	checkX(t, "faded", a, map[string]float32{"j": 20})
+	if done != 1 || len(a.Layer("base").tracks) != 1 {
+		t.Errorf("faded animation not removed")
+	}
+	if cur := a.Layer("base").Current(); cur == nil || cur.Clip != second {
+		t.Errorf("current animation is not the second clip")
+	}
+}
+
+func TestAnimatorLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		weight   float32
+		mask     map[string]float32
+		additive bool
+		want     map[string]float32
+	}{
+		{"override", 1, nil, false, map[string]float32{"a": 20, "b": 20}},
+		{"half weight", 0.5, nil, false, map[string]float32{"a": 15, "b": 15}},
+		{"masked", 1, map[string]float32{"a": 1, "b": 0.25}, false,
+			map[string]float32{"a": 20, "b": 12.5}},
+		{"additive", 1, nil, true, map[string]float32{"a": 15, "b": 15}},
+		{"additive masked", 0.5, map[string]float32{"a": 1}, true,
+			map[string]float32{"a": 12.5, "b": 10}},
+	}
+	for _, tt := range tests {
+		a := newTestAnimator("a", "b")
+		a.Play("base", holdClip(10, "a", "b"), 0, true, nil)
+		l := a.Layer("upper")
+		l.Weight = tt.weight
+		l.Mask = tt.mask
+		l.Additive = tt.additive
+		if tt.additive {
+			// Adds the change from the first key, 5 voxels at the midpoint
+			a.Play("upper", NewAnimationClip(1, []*Channel{
+				positionChannel("a", EaseLinear, 0, 100, 1, 110),
+				positionChannel("b", EaseLinear, 0, 100, 1, 110),
+			}, nil), 0, true, nil)
+			a.Update(0.5)
+		} else {
+			a.Play("upper", holdClip(20, "a", "b"), 0, true, nil)
+			a.Update(0)
+		}
+		checkX(t, tt.name, a, tt.want)
+	}
+}
+
+func TestAnimatorOneShot(t *testing.T) {
+	a := newTestAnimator("j")
+	done := 0
+	a.Play("action", holdClip(10, "j"), 0.25, false, func() { done++ })
+	a.Update(0.7)
+	checkX(t, "playing", a, map[string]float32{"j": 10})
+	// The final 0.25 seconds of the clip fade out
+	a.Update(0.1)
+	checkX(t, "fading", a, map[string]float32{"j": 6})
+	if cur := a.Layer("action").Current(); cur != nil {
+		t.Errorf("fading one-shot is still current")
+	}
+	a.Update(0.2)
+	checkX(t, "done", a, map[string]float32{"j": 0})
+	if done != 1 || len(a.Layer("action").tracks) != 0 {
+		t.Errorf("completed one-shot not removed")
+	}
+	a.Update(0.1)
+	if done != 1 {
+		t.Errorf("completion callback called %d times", done)
+	}
+}
+
+func TestAnimatorStop(t *testing.T) {
+	a := newTestAnimator("j")
+	done := 0
+	a.Play("base", holdClip(10, "j"), 0, true, func() { done++ })
+	a.Update(0)
+	a.Stop("base", 0)
+	if done != 1 {
+		t.Fatalf("stopped animation not removed")
+	}
+	a.Update(0)
+	checkX(t, "stopped", a, map[string]float32{"j": 0})
+}
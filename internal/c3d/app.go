@@ -1,10 +1,12 @@
 package c3d
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	"io/fs"
 	"sort"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
@@ -12,79 +14,123 @@ import (
 // App manages a set of drawable objects and the shader programs used to draw
 // them.
 type App struct {
-	CursorVisible     bool                      // If true, draw the cursor
-	CrosshairVisible  bool                      // If true, draw the crosshair
-	DebugTextVisible  bool                      // If true, draw the debug text
-	WireFramesVisible bool                      // If true, draws wire frames
-	chunkDDs          []*ChunkDrawDescriptor    // List of chunks to draw
-	modelDDs          []*ModelDrawDescriptor    // List of models to draw
-	lineDDs           []*LineMeshDrawDescriptor // List of line meshes to draw
-	uiMeshes          []*UIMesh                 // List of UI meshes to draw
-	cursor            *UIMesh                   // Cursor mesh
-	crosshair         *UIMesh                   // Crosshair mesh
-	axis              *LineMesh                 // Debug axis indicator
-	chunkBounds       AABB                      // Cached chunk bounds wire frame
-	pWireFrame        *program                  // RGB with no lighting
-	pVoxelMesh        *program                  // RGB voxel meshes
-	pModelMesh        *program                  // RGB voxel meshes rigged for animation
-	pCubeMesh         *program                  // Face atlas texturing
-	pText             *program                  // Text rendering
-	pUI               *program                  // UI tile rendering
-	pCubeMeshIcon     *program                  // Cube mesh icon rendering
-	faces             *FaceAtlas                // Face atlas to use for cube mesh rendering
-	tiles             *FaceAtlas                // Face atlas to use for ui tile rendering
-	fm                *fontManager              // Font manager for the application
-	debugLines        []ColoredString           // Lines for the debug messages
-	debugText         *TextMesh                 // Debug text
+	CursorVisible     bool                             // If true, draw the cursor
+	CrosshairVisible  bool                             // If true, draw the crosshair
+	DebugTextVisible  bool                             // If true, draw the debug text
+	WireFramesVisible bool                             // If true, draws wire frames
+	OcclusionCulling  bool                             // If true, chunks that can not be seen from the camera are not drawn
+	Sky               *Sky                             // Sky, sky light and fog
+	Particles         *ParticleSystem                  // Particle effects
+	ShaderSources     []fs.FS                          // File systems searched for shader programs by ReloadShaders in order
+	LODDistances      [LODLevels - 1]float32           // Distance in cubes beyond which chunks are drawn at each reduced level of detail, zero disables the level
+	b                 Backend                          // Graphics backend
+	chunkDDs          []*ChunkDrawDescriptor           // List of chunks to draw
+	chunkGrid         map[t.IVec3]*ChunkDrawDescriptor // Chunks by chunk coordinates
+	chunkVisited      map[t.IVec3]bool                 // Chunks reached by the visibility search
+	chunkQueue        []visibleChunk                   // Visibility search queue
+	visibleChunks     []*ChunkDrawDescriptor           // Chunks to draw this frame
+	modelDDs          []*ModelDrawDescriptor           // List of models to draw
+	lineDDs           []*LineMeshDrawDescriptor        // List of line meshes to draw
+	billboardDDs      []*BillboardDrawDescriptor       // List of billboards to draw
+	uiMeshes          []*UIMesh                        // List of UI meshes to draw
+	cursor            *UIMesh                          // Cursor mesh
+	crosshair         *UIMesh                          // Crosshair mesh
+	axis              *LineMesh                        // Debug axis indicator
+	chunkBounds       AABB                             // Cached chunk bounds wire frame
+	pWireFrame        *program                         // RGB with no lighting
+	pSky              *program                         // Sky gradient, sun and moon
+	pVoxelMesh        *program                         // RGB voxel meshes
+	pModelMesh        *program                         // RGB voxel meshes rigged for animation
+	pCubeMesh         *program                         // Face atlas texturing
+	pText             *program                         // Text rendering
+	pUI               *program                         // UI tile rendering
+	pCubeMeshIcon     *program                         // Cube mesh icon rendering
+	pParticle         *program                         // Particle rendering
+	faces             *FaceAtlas                       // Face atlas to use for cube mesh rendering
+	tiles             *FaceAtlas                       // Face atlas to use for ui tile rendering
+	fm                *fontManager                     // Font manager for the application
+	debugLines        []ColoredString                  // Lines for the debug messages
+	debugText         *TextMesh                        // Debug text
+	instanceVBO       uint32                           // Per-instance model matrix buffer
+	instanceMeshes    []*VoxelMesh                     // Part meshes to draw this frame in order
+	instances         map[*VoxelMesh][]float32         // Model matrixes of part mesh instances
+	modelCubes        []modelCube                      // Cube meshes attached to models to draw this frame
 }
 
-// NewApp constructs a new App object with the given resources ready to draw.
-func NewApp(faces *FaceAtlas, tiles *FaceAtlas) (*App, error) {
+// modelCube is a cube mesh attached to a model part.
+type modelCube struct {
+	m  *CubeMesh  // Cube mesh
+	mm mgl32.Mat4 // Model matrix of the part
+}
+
+// NewApp constructs a new App object with the given resources ready to draw
+// with the backend.
+func NewApp(b Backend, faces *FaceAtlas, tiles *FaceAtlas) (*App, error) {
 	var err error
 	ret := &App{
-		faces: faces,
-		tiles: tiles,
+		b:                b,
+		faces:            faces,
+		tiles:            tiles,
+		instances:        map[*VoxelMesh][]float32{},
+		Sky:              NewSky(),
+		Particles:        newParticleSystem(),
+		chunkGrid:        map[t.IVec3]*ChunkDrawDescriptor{},
+		chunkVisited:     map[t.IVec3]bool{},
+		OcclusionCulling: true,
+		LODDistances: [LODLevels - 1]float32{
+			64,
+			128,
+		},
 	}
 	// wireframe.glsl
-	ret.pWireFrame, err = loadProgram("wireframe")
+	ret.pWireFrame, err = loadProgram(b, "wireframe")
+	if err != nil {
+		return nil, err
+	}
+	// sky.glsl
+	ret.pSky, err = loadProgram(b, "sky")
 	if err != nil {
 		return nil, err
 	}
 	// voxel-mesh.glsl
-	ret.pVoxelMesh, err = loadProgram("voxel-mesh")
+	ret.pVoxelMesh, err = loadProgram(b, "voxel-mesh")
 	if err != nil {
 		return nil, err
 	}
-	gl.Uniform1fv(ret.pVoxelMesh.uni("uLightLevels"),
-		int32(len(voxelLightLevels)), &voxelLightLevels[0])
 	// model-mesh.glsl
-	ret.pModelMesh, err = loadProgram("model-mesh")
+	ret.pModelMesh, err = loadProgram(b, "model-mesh")
 	if err != nil {
 		return nil, err
 	}
+	ret.instanceVBO = b.NewBuffer()
 	// cube-mesh.glsl
-	ret.pCubeMesh, err = loadProgram("cube-mesh")
+	ret.pCubeMesh, err = loadProgram(b, "cube-mesh")
 	if err != nil {
 		return nil, err
 	}
 	ret.faces.upload(ret.pCubeMesh)
 	ret.faces.freeMemory()
 	// text.glsl
-	ret.pText, err = loadProgram("text")
+	ret.pText, err = loadProgram(b, "text")
 	if err != nil {
 		return nil, err
 	}
 	ret.fm = newFontManager(ret.pText)
 	ret.debugText = ret.NewTextMesh()
 	// ui.glsl
-	ret.pUI, err = loadProgram("ui")
+	ret.pUI, err = loadProgram(b, "ui")
 	if err != nil {
 		return nil, err
 	}
 	ret.tiles.upload(ret.pUI)
 	ret.tiles.freeMemory()
 	// cube-mesh-icon.glsl
-	ret.pCubeMeshIcon, err = loadProgram("cube-mesh-icon")
+	ret.pCubeMeshIcon, err = loadProgram(b, "cube-mesh-icon")
+	if err != nil {
+		return nil, err
+	}
+	// particle.glsl
+	ret.pParticle, err = loadProgram(b, "particle")
 	if err != nil {
 		return nil, err
 	}
@@ -124,10 +170,40 @@ func (a *App) NewUIMesh() *UIMesh {
 
 // Delete removes all memory and GPU resources managed by the app.
 func (a *App) Delete() {
-	a.pVoxelMesh.delete()
-	a.pWireFrame.delete()
-	a.pCubeMesh.delete()
-	a.pText.delete()
+	for _, p := range a.programs() {
+		p.delete()
+	}
+	a.Sky.delete()
+	a.Particles.delete(a.b)
+	a.b.DeleteBuffer(a.instanceVBO)
+}
+
+// programs returns all shader programs of the app.
+func (a *App) programs() []*program {
+	return []*program{
+		a.pWireFrame,
+		a.pSky,
+		a.pVoxelMesh,
+		a.pModelMesh,
+		a.pCubeMesh,
+		a.pText,
+		a.pUI,
+		a.pCubeMeshIcon,
+		a.pParticle,
+	}
+}
+
+// ReloadShaders rebuilds all shader programs from the GLSL sources found in
+// ShaderSources, falling back to the embedded sources. Programs that fail to
+// build keep their previous build and their errors are returned together.
+func (a *App) ReloadShaders() error {
+	var errs []error
+	for _, p := range a.programs() {
+		if err := p.reload(a.ShaderSources); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // AddDebugLine sets the debug text drawn in the bottom-left.
@@ -193,14 +269,27 @@ func (a *App) RemoveUIMesh(m *UIMesh) {
 // AddChunkDD adds the chunk draw descriptor to the list to draw.
 func (a *App) AddChunkDD(d *ChunkDrawDescriptor) {
 	a.chunkDDs = append(a.chunkDDs, d)
+	a.chunkGrid[chunkKey(d.CubeDD.Position)] = d
+}
+
+// Chunks returns the number of chunks added to the app.
+func (a *App) Chunks() int {
+	return len(a.chunkDDs)
+}
+
+// VisibleChunks returns the number of chunks drawn in the last frame.
+func (a *App) VisibleChunks() int {
+	return len(a.visibleChunks)
 }
 
 // RemoveChunkDD removes the chunk draw descriptor by ID.
 func (a *App) RemoveChunkDD(id uint32) {
 	for i := 0; i < len(a.chunkDDs); i++ {
 		if a.chunkDDs[i].ID == id {
+			delete(a.chunkGrid, chunkKey(a.chunkDDs[i].CubeDD.Position))
 			a.chunkDDs[i] = a.chunkDDs[len(a.chunkDDs)-1]
-			a.chunkDDs = a.chunkDDs[:len(a.chunkDDs)]
+			a.chunkDDs[len(a.chunkDDs)-1] = nil
+			a.chunkDDs = a.chunkDDs[:len(a.chunkDDs)-1]
 			return
 		}
 	}
@@ -216,7 +305,8 @@ func (a *App) RemoveModelDD(id uint32) {
 	for i := 0; i < len(a.modelDDs); i++ {
 		if a.modelDDs[i].ID == id {
 			a.modelDDs[i] = a.modelDDs[len(a.modelDDs)-1]
-			a.modelDDs = a.modelDDs[:len(a.modelDDs)]
+			a.modelDDs[len(a.modelDDs)-1] = nil
+			a.modelDDs = a.modelDDs[:len(a.modelDDs)-1]
 			return
 		}
 	}
@@ -238,6 +328,24 @@ func (a *App) RemoveLineDD(id uint32) {
 	}
 }
 
+// Update advances time-based rendering state, such as face animations, by dt
+// seconds.
+func (a *App) Update(dt float32) {
+	a.faces.update(dt)
+	a.tiles.update(dt)
+	a.Particles.update(dt)
+}
+
+// drawVoxelMesh draws the voxel mesh at position p with facing f. The voxel
+// mesh program must be in use.
+func (a *App) drawVoxelMesh(m *VoxelMesh, p mgl32.Vec3, f t.Facing) {
+	o := t.FacingToOrientation[f]
+	o.P = p.Add(mgl32.Vec3{8, 8, 8}.Mul(t.VoxelScale))
+	a.pVoxelMesh.setMat4("uModelMatrix", o.TransformMatrix())
+	a.pVoxelMesh.setInt("uFacing", int32(f))
+	m.draw(a.pVoxelMesh)
+}
+
 // Draw draws everything with the given camera for 3D space..
 func (a *App) Draw(c *Camera) {
 	// Variable setup
@@ -246,49 +354,53 @@ func (a *App) Draw(c *Camera) {
 			float32(t.VirtualScreenHeight),
 		0.1, 1000.0)
 	vMat := c.TransformMatrix()
+	a.cullChunks(c.Position, pMat.Mul4(vMat))
 	// Frame setup
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	fc := a.Sky.FogColor()
+	a.b.Clear(fc)
+	a.Sky.draw(a.pSky, pMat, vMat)
 	// Draw wire frames
 	if a.WireFramesVisible {
 		// Debug axis indicator
 		a.pWireFrame.use()
-		gl.UniformMatrix4fv(int32(a.pWireFrame.uni("uProjectionMatrix")), 1, false,
-			&pMat[0])
+		a.pWireFrame.setMat4("uProjectionMatrix", pMat)
 		mt := c.TransformMatrix().Mul4(a.axis.Orientation.TransformMatrix())
-		gl.UniformMatrix4fv(a.pWireFrame.uni("uModelViewMatrix"), 1, false, &mt[0])
+		a.pWireFrame.setMat4("uModelViewMatrix", mt)
 		a.axis.draw(a.pWireFrame)
 		// Debug line meshes
 		for _, d := range a.lineDDs {
-			if d.Mesh == nil {
+			if d.Mesh == nil || d.Overlay {
 				continue
 			}
 			mvm := mt.Mul4(d.Orientation.TransformMatrix())
-			gl.UniformMatrix4fv(a.pCubeMesh.uni("uModelViewMatrix"), 1, false,
-				&mvm[0])
+			a.pWireFrame.setMat4("uModelViewMatrix", mvm)
 			d.Mesh.draw(a.pWireFrame)
 		}
 		// Chunk bounds
 		for _, d := range a.chunkDDs {
 			mvm := mt.Mul4(t.O().Translate(d.CubeDD.Position).TransformMatrix())
-			gl.UniformMatrix4fv(a.pCubeMesh.uni("uModelViewMatrix"), 1, false,
-				&mvm[0])
+			a.pWireFrame.setMat4("uModelViewMatrix", mvm)
 			a.chunkBounds.draw(a.pWireFrame)
 		}
 		// Model bounds
 		for _, m := range a.modelDDs {
 			mvm := mt.Mul4(t.O().Translate(m.Orientation.P).TransformMatrix())
-			gl.UniformMatrix4fv(a.pCubeMesh.uni("uModelViewMatrix"), 1, false,
-				&mvm[0])
+			a.pWireFrame.setMat4("uModelViewMatrix", mvm)
 			m.Bounds.draw(a.pWireFrame)
 		}
 	}
 	// Draw chunks
 	a.pCubeMesh.use()
-	gl.UniformMatrix4fv(int32(a.pCubeMesh.uni("uProjectionMatrix")), 1, false,
-		&pMat[0])
+	a.pCubeMesh.setMat4("uProjectionMatrix", pMat)
 	a.faces.bind(a.pCubeMesh)
-	for _, d := range a.chunkDDs {
-		if d.CubeDD.Mesh == nil {
+	a.Sky.bind(a.pCubeMesh)
+	for _, d := range a.visibleChunks {
+		d.lod = a.chunkLOD(d, c.Position)
+		m := d.CubeDD.Mesh
+		if d.lod > 0 && d.LODCubeMeshes[d.lod-1] != nil {
+			m = d.LODCubeMeshes[d.lod-1]
+		}
+		if m == nil {
 			continue
 		}
 		mt := vMat.Mul4(mgl32.Translate3D(
@@ -296,54 +408,79 @@ func (a *App) Draw(c *Camera) {
 			d.CubeDD.Position[1],
 			d.CubeDD.Position[2],
 		))
-		gl.UniformMatrix4fv(a.pCubeMesh.uni("uModelViewMatrix"), 1, false,
-			&mt[0])
-		d.CubeDD.Mesh.draw(a.pCubeMesh)
+		a.pCubeMesh.setMat4("uModelViewMatrix", mt)
+		m.draw(a.pCubeMesh)
 	}
 	// Draw voxel cells
 	a.pVoxelMesh.use()
-	gl.Uniform1fv(a.pVoxelMesh.uni("uLightLevels"),
-		int32(len(voxelLightLevels)), &voxelLightLevels[0])
-	gl.UniformMatrix4fv(int32(a.pVoxelMesh.uni("uProjectionMatrix")), 1, false,
-		&pMat[0])
-	gl.UniformMatrix4fv(a.pVoxelMesh.uni("uViewMatrix"), 1, false,
-		&vMat[0])
-	for _, d := range a.chunkDDs {
+	a.pVoxelMesh.setFloats("uLightLevels", voxelLightLevels)
+	a.pVoxelMesh.setMat4("uProjectionMatrix", pMat)
+	a.pVoxelMesh.setMat4("uViewMatrix", vMat)
+	a.Sky.bind(a.pVoxelMesh)
+	for _, d := range a.visibleChunks {
+		if d.lod > 0 && d.LODVoxelMesh != nil {
+			// Reduced meshes are baked like the vox cell mesh of the chunk
+			a.drawVoxelMesh(d.LODVoxelMesh, d.CubeDD.Position.Sub(mgl32.Vec3{
+				VoxCellMeshMargin,
+				VoxCellMeshMargin,
+				VoxCellMeshMargin,
+			}), t.North)
+			continue
+		}
 		for _, v := range d.VoxelDDs {
 			if v.Mesh == nil {
 				continue
 			}
-			o := t.FacingToOrientation[v.Facing]
-			o.P = v.Position.Add(mgl32.Vec3{8, 8, 8}.Mul(t.VoxelScale))
-			mm := o.TransformMatrix()
-			gl.UniformMatrix4fv(a.pVoxelMesh.uni("uModelMatrix"), 1, false,
-				&mm[0])
-			gl.Uniform1i(a.pVoxelMesh.uni("uFacing"), int32(v.Facing))
-			v.Mesh.draw(a.pVoxelMesh)
+			a.drawVoxelMesh(v.Mesh, v.Position, v.Facing)
 		}
 	}
 	// Draw voxel models
 	a.pModelMesh.use()
-	gl.Uniform1fv(a.pModelMesh.uni("uLightLevels"), 6, &voxelLightLevels[0])
-	gl.UniformMatrix4fv(int32(a.pModelMesh.uni("uProjectionMatrix")), 1, false,
-		&pMat[0])
-	gl.UniformMatrix4fv(a.pModelMesh.uni("uViewMatrix"), 1, false,
-		&vMat[0])
-	for _, d := range a.modelDDs {
-		if d.Root != nil {
-			d.Root.draw(a.pModelMesh, d.Orientation)
+	a.pModelMesh.setFloats("uLightLevels", voxelLightLevels[:6])
+	a.pModelMesh.setMat4("uProjectionMatrix", pMat)
+	a.pModelMesh.setMat4("uViewMatrix", vMat)
+	a.Sky.bind(a.pModelMesh)
+	a.drawModels(pMat, vMat)
+	// Draw particles, which are sorted back to front and do not write depth
+	// so translucent particles blend over each other
+	if a.Particles.Count() > 0 {
+		a.pParticle.use()
+		a.pParticle.setMat4("uProjectionMatrix", pMat)
+		a.pParticle.setMat4("uViewMatrix", vMat)
+		a.faces.bind(a.pParticle)
+		a.Sky.bind(a.pParticle)
+		a.b.SetDepthMask(false)
+		a.Particles.draw(a.pParticle, vMat, c.Position)
+		a.b.SetDepthMask(true)
+	}
+	// Draw billboards, which like particles do not write depth
+	if a.fm.imgDirty {
+		a.fm.updateAtlasTexture()
+	}
+	a.sortBillboards(c.Position)
+	a.b.SetDepthMask(false)
+	a.drawBillboards(pMat, vMat, false)
+	a.b.SetDepthMask(true)
+	// Draw overlay line meshes and billboards
+	a.b.SetDepthTest(false)
+	a.pWireFrame.use()
+	a.pWireFrame.setMat4("uProjectionMatrix", pMat)
+	for _, d := range a.lineDDs {
+		if d.Mesh == nil || !d.Overlay {
+			continue
 		}
+		mvm := vMat.Mul4(d.Orientation.TransformMatrix())
+		a.pWireFrame.setMat4("uModelViewMatrix", mvm)
+		d.Mesh.draw(a.pWireFrame)
 	}
+	a.drawBillboards(pMat, vMat, true)
 	// Draw UI elements
 	sort.Slice(a.uiMeshes, func(i, j int) bool {
 		return a.uiMeshes[i].Layer < a.uiMeshes[j].Layer
 	})
-	gl.Disable(gl.DEPTH_TEST)
+	a.b.SetDepthTest(false)
 	pMat = mgl32.Ortho(0, float32(t.VirtualScreenWidth), 0,
 		float32(t.VirtualScreenHeight), -1000, 1000)
-	if a.fm.imgDirty {
-		a.fm.updateAtlasTexture()
-	}
 	for _, m := range a.uiMeshes {
 		if m.Hidden {
 			continue
@@ -351,74 +488,125 @@ func (a *App) Draw(c *Camera) {
 		// UI tiles
 		if m.count > 0 {
 			a.pUI.use()
-			gl.UniformMatrix4fv(a.pUI.uni("uProjectionMatrix"), 1, false, &pMat[0])
+			a.pUI.setMat4("uProjectionMatrix", pMat)
 			a.tiles.bind(a.pUI)
-			gl.Uniform3f(a.pUI.uni("uPosition"), m.Position[0], -m.Position[1],
+			a.pUI.setVec3("uPosition", m.Position[0], -m.Position[1],
 				float32(m.Layer)/0x7FF)
 			m.draw(a.pUI)
 		}
 		// Text layer
 		if m.text != nil {
 			a.pText.use()
-			gl.UniformMatrix4fv(a.pText.uni("uProjectionMatrix"), 1, false,
-				&pMat[0])
+			a.pText.setMat4("uProjectionMatrix", pMat)
 			a.fm.bind(a.pText)
-			gl.Uniform3f(a.pText.uni("uPosition"), m.Position[0],
+			a.pText.setVec3("uPosition", m.Position[0],
 				-m.Position[1], float32(m.Layer)/0x7FF)
 			m.text.draw(a.pText)
 		}
 		// Cubes layer
 		if len(m.Cubes) > 0 {
 			a.pCubeMeshIcon.use()
-			gl.UniformMatrix4fv(a.pCubeMeshIcon.uni("uProjectionMatrix"), 1,
-				false, &pMat[0])
+			a.pCubeMeshIcon.setMat4("uProjectionMatrix", pMat)
 			a.faces.bind(a.pCubeMeshIcon)
 		}
 		for _, c := range m.Cubes {
 			if c.Mesh == nil {
 				continue
 			}
-			gl.Uniform3f(a.pCubeMeshIcon.uni("uPosition"),
-				c.Position[0],
+			a.pCubeMeshIcon.setVec3("uPosition", c.Position[0],
 				float32(t.VirtualScreenHeight)-c.Position[1],
 				c.Position[2],
 			)
-			gl.Uniform3f(a.pCubeMeshIcon.uni("uOrigin"),
-				c.Orientation.P[0],
+			a.pCubeMeshIcon.setVec3("uOrigin", c.Orientation.P[0],
 				c.Orientation.P[1],
 				c.Orientation.P[2],
 			)
 			mm := c.Orientation.RotationMatrix()
-			gl.UniformMatrix4fv(a.pCubeMeshIcon.uni("uModelMatrix"), 1, false,
-				&mm[0])
+			a.pCubeMeshIcon.setMat4("uModelMatrix", mm)
 			c.Mesh.draw(a.pCubeMeshIcon)
 		}
 	}
 	// Draw common screen components
 	a.pUI.use()
-	gl.UniformMatrix4fv(a.pUI.uni("uProjectionMatrix"), 1, false, &pMat[0])
+	a.pUI.setMat4("uProjectionMatrix", pMat)
 	a.tiles.bind(a.pUI)
 	if a.CrosshairVisible && a.crosshair != nil {
 		m := a.crosshair
-		gl.Uniform3f(a.pUI.uni("uPosition"), m.Position[0], -m.Position[1],
+		a.pUI.setVec3("uPosition", m.Position[0], -m.Position[1],
 			float32(m.Layer)/0xFFFF)
 		m.draw(a.pUI)
 	}
 	if a.CursorVisible && a.cursor != nil {
 		m := a.cursor
-		gl.Uniform3f(a.pUI.uni("uPosition"), m.Position[0], -m.Position[1],
+		a.pUI.setVec3("uPosition", m.Position[0], -m.Position[1],
 			float32(m.Layer)/0xFFFF)
 		m.draw(a.pUI)
 	}
 	if a.DebugTextVisible && a.debugText != nil {
 		a.updateDebugText()
 		a.pText.use()
-		gl.UniformMatrix4fv(a.pText.uni("uProjectionMatrix"), 1, false,
-			&pMat[0])
+		a.pText.setMat4("uProjectionMatrix", pMat)
 		a.fm.bind(a.pText)
-		gl.Uniform3f(a.pText.uni("uPosition"), 0, 0, 0)
+		a.pText.setVec3("uPosition", 0, 0, 0)
 		a.debugText.draw(a.pText)
 	}
 	a.debugLines = a.debugLines[:0]
-	gl.Enable(gl.DEPTH_TEST)
+	a.b.SetDepthTest(true)
+}
+
+// Screenshot returns a copy of the frame drawn by the last call to Draw, or
+// nil if the backend can not read back the color buffer. This must be called
+// before the frame is presented. Backends that can not read back frames may
+// be wrapped in a MirrorBackend to read them back from a software renderer.
+func (a *App) Screenshot() *image.RGBA {
+	return a.b.ReadPixels()
+}
+
+// drawModels draws all model draw descriptors. All instances of each part
+// mesh are drawn with a single instanced draw call where supported. Cube
+// meshes attached to parts are drawn afterward.
+func (a *App) drawModels(pMat, vMat mgl32.Mat4) {
+	for _, m := range a.instanceMeshes {
+		a.instances[m] = a.instances[m][:0]
+	}
+	a.instanceMeshes = a.instanceMeshes[:0]
+	a.modelCubes = a.modelCubes[:0]
+	for _, d := range a.modelDDs {
+		if d.Root == nil {
+			continue
+		}
+		d.Root.collect(d.Orientation, func(p *Part, mm mgl32.Mat4) {
+			if p.Cube != nil {
+				a.modelCubes = append(a.modelCubes, modelCube{
+					m:  p.Cube,
+					mm: mm,
+				})
+			}
+			m := p.Mesh
+			if m == nil || m.count < 1 {
+				return
+			}
+			mms := a.instances[m]
+			if len(mms) == 0 {
+				a.instanceMeshes = append(a.instanceMeshes, m)
+			}
+			a.instances[m] = append(mms, mm[:]...)
+		})
+	}
+	for _, m := range a.instanceMeshes {
+		m.drawInstanced(a.pModelMesh, a.instanceVBO, a.instances[m])
+	}
+	if len(a.modelCubes) == 0 {
+		return
+	}
+	a.pCubeMesh.use()
+	a.pCubeMesh.setMat4("uProjectionMatrix", pMat)
+	a.faces.bind(a.pCubeMesh)
+	for _, c := range a.modelCubes {
+		// Cube mesh vertexes are scaled down to cube units by the shader
+		mvm := vMat.Mul4(c.mm).Mul4(mgl32.Scale3D(
+			cubeMeshUnits, cubeMeshUnits, cubeMeshUnits))
+		a.pCubeMesh.setMat4("uModelViewMatrix", mvm)
+		c.m.draw(a.pCubeMesh)
+	}
 }
@@ -0,0 +1,421 @@
+package c3d
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// testCells is a box of cells implementing VoxelSource. Cells outside the box
+// are invalid.
+type testCells struct {
+	w, h, d int      // Dimensions
+	cells   []t.Cell // Cells in X, then Z, then Y order
+}
+
+// newTestCells returns a new box of invalid cells.
+func newTestCells(w, h, d int) *testCells {
+	ret := &testCells{
+		w:     w,
+		h:     h,
+		d:     d,
+		cells: make([]t.Cell, w*h*d),
+	}
+	for i := range ret.cells {
+		ret.cells[i] = t.CellInvalid
+	}
+	return ret
+}
+
+// set sets the cell at x, y, z.
+func (c *testCells) set(x, y, z int, v t.Cell) {
+	c.cells[(y*c.d+z)*c.w+x] = v
+}
+
+// Get implements the VoxelSource interface.
+func (c *testCells) Get(x, y, z int) t.Cell {
+	if x < 0 || y < 0 || z < 0 || x >= c.w || y >= c.h || z >= c.d {
+		return t.CellInvalid
+	}
+	return c.cells[(y*c.d+z)*c.w+x]
+}
+
+// Dimensions implements the VoxelSource interface.
+func (c *testCells) Dimensions() (w, h, d int) {
+	return c.w, c.h, c.d
+}
+
+// IsEmpty implements the VoxelSource interface.
+func (c *testCells) IsEmpty(v t.Cell) bool {
+	return !v.IsCube()
+}
+
+// testVoxels is a box of colored voxels implementing VoxelSource.
+type testVoxels struct {
+	w, h, d int      // Dimensions
+	c       [4]uint8 // Color of all voxels within the box
+	hole    [3]int   // Position of a single empty voxel
+}
+
+// Get implements the VoxelSource interface.
+func (v *testVoxels) Get(x, y, z int) [4]uint8 {
+	if x < 0 || y < 0 || z < 0 || x >= v.w || y >= v.h || z >= v.d ||
+		[3]int{x, y, z} == v.hole {
+		return [4]uint8{}
+	}
+	return v.c
+}
+
+// Dimensions implements the VoxelSource interface.
+func (v *testVoxels) Dimensions() (w, h, d int) {
+	return v.w, v.h, v.d
+}
+
+// IsEmpty implements the VoxelSource interface.
+func (v *testVoxels) IsEmpty(c [4]uint8) bool {
+	return c[3] == 0
+}
+
+// testFace returns a solid face image of color c.
+func testFace(c color.RGBA) *image.RGBA {
+	ret := image.NewRGBA(image.Rect(0, 0, t.FaceDims, t.FaceDims))
+	draw.Draw(ret, ret.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	return ret
+}
+
+// testScene holds an app and the resources it was built with.
+type testScene struct {
+	tt    *testing.T  // Test the scene belongs to
+	app   *App        // App under test
+	cubes []*t.Cube   // Cube definitions
+	tile  t.FaceIndex // White UI tile
+}
+
+// newTestScene returns a new app drawing with backend b. The face atlas holds
+// a red, green and blue face used by the single cube definition, and the
+// tile atlas holds a single white tile.
+func newTestScene(tt *testing.T, b Backend) *testScene {
+	faces := NewFaceAtlas()
+	red := faces.AddFace(testFace(color.RGBA{255, 0, 0, 255}))
+	green := faces.AddFace(testFace(color.RGBA{0, 255, 0, 255}))
+	blue := faces.AddFace(testFace(color.RGBA{0, 0, 255, 255}))
+	tiles := NewFaceAtlas()
+	tile := tiles.AddFace(testFace(color.RGBA{255, 255, 255, 255}))
+	app, err := NewApp(b, faces, tiles)
+	if err != nil {
+		tt.Fatal(err)
+	}
+	app.Sky.Time = 0.5
+	return &testScene{
+		tt:  tt,
+		app: app,
+		cubes: []*t.Cube{{
+			Ref:   0,
+			Name:  "test",
+			Faces: [6]t.FaceIndex{red, red, blue, blue, green, green},
+		}},
+		tile: tile,
+	}
+}
+
+// addCube adds a chunk at p holding a single cube at its origin and returns
+// its draw descriptor.
+func (s *testScene) addCube(id uint32, p mgl32.Vec3) *ChunkDrawDescriptor {
+	cells := newTestCells(16, 16, 16)
+	cells.set(0, 0, 0, t.CellForCube(0, t.North))
+	m := NewCubeMesh(s.cubes)
+	if err := BuildCubeMesh(cells, m); err != nil {
+		s.tt.Fatal(err)
+	}
+	d := &ChunkDrawDescriptor{
+		ID: id,
+		CubeDD: CubeMeshDrawDescriptor{
+			ID:          id,
+			Mesh:        m,
+			Position:    p,
+			Orientation: t.O(),
+		},
+		Visibility: ChunkVisibilityAll,
+	}
+	s.app.AddChunkDD(d)
+	return d
+}
+
+// addModel adds a model at p with a single part using the voxel mesh m and
+// returns its draw descriptor.
+func (s *testScene) addModel(id uint32, p mgl32.Vec3,
+	m *VoxelMesh) *ModelDrawDescriptor {
+	d := &ModelDrawDescriptor{
+		ID:          id,
+		Orientation: t.O().Translate(p),
+		Root: &Part{
+			Mesh:        m,
+			Orientation: t.O(),
+			Pose:        t.O(),
+			Scale:       mgl32.Vec3{1, 1, 1},
+		},
+	}
+	s.app.AddModelDD(d)
+	return d
+}
+
+// testVoxelMesh returns the mesh of an 8x8x8 box of gray voxels.
+func testVoxelMesh() *VoxelMesh {
+	m := NewVoxelMesh()
+	BuildVoxelMesh[[4]uint8](&testVoxels{
+		w:    8,
+		h:    8,
+		d:    8,
+		c:    [4]uint8{160, 160, 160, 255},
+		hole: [3]int{-1, -1, -1},
+	}, m)
+	return m
+}
+
+func TestDrawChunk(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	s.addCube(1, mgl32.Vec3{16, 0, 0})
+	c := NewCamera(mgl32.Vec3{8, 8, 8})
+	s.app.Draw(c)
+	if b.Clears != 1 {
+		t.Errorf("cleared %d times, want 1", b.Clears)
+	}
+	draws := b.DrawsOf("cube-mesh")
+	if len(draws) != 1 {
+		t.Fatalf("drew %d cube meshes, want 1", len(draws))
+	}
+	d := draws[0]
+	if d.Mode != PrimitiveTriangles || d.Count != 6*6 || d.Instances != 1 {
+		t.Errorf("drew %d vertexes of mode %d in %d instances, want 36 "+
+			"triangle vertexes", d.Count, d.Mode, d.Instances)
+	}
+	if !d.DepthTest || !d.DepthMask {
+		t.Error("chunk drawn without depth testing and writes")
+	}
+	want := c.TransformMatrix().Mul4(mgl32.Translate3D(16, 0, 0))
+	mv, _ := d.Uniforms["uModelViewMatrix"].(mgl32.Mat4)
+	if !mv.ApproxEqual(want) {
+		t.Errorf("model view matrix\n%v\nwant\n%v", mv, want)
+	}
+	var pos RecordedAttrib
+	for _, a := range b.VertexArrays[d.VertexArray] {
+		if a.Name == "aVertexPosition" {
+			pos = a
+		}
+	}
+	if pos.Type != AttribUShort || pos.Size != 3 ||
+		pos.Stride != cubeMeshStride {
+		t.Errorf("vertex positions sourced as %+v", pos)
+	}
+	// All vertexes of the cube lie on its faces
+	for i := 0; i < int(d.Count); i++ {
+		v, ok := b.Attrib(d.VertexArray, "aVertexPosition", i, 0)
+		if !ok {
+			t.Fatalf("vertex %d has no position", i)
+		}
+		for j := range v {
+			if v[j] != 0 && v[j] != cubeMeshUnits {
+				t.Fatalf("vertex %d at %v is not on the cube", i, v)
+			}
+		}
+	}
+}
+
+func TestDrawChunkOutsideFrustum(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	s.addCube(1, mgl32.Vec3{0, 0, 0})
+	s.addCube(2, mgl32.Vec3{0, 0, 16})
+	// The default camera looks toward negative Z
+	s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+	if n := len(b.DrawsOf("cube-mesh")); n != 1 {
+		t.Errorf("drew %d cube meshes, want 1", n)
+	}
+	if n := s.app.VisibleChunks(); n != 1 {
+		t.Errorf("%d visible chunks, want 1", n)
+	}
+}
+
+func TestDrawChunkVoxOverhang(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	// The chunk lies behind the camera but its vox mesh reaches in front
+	d := s.addCube(1, mgl32.Vec3{0, 0, 16})
+	d.VoxelDDs = append(d.VoxelDDs, &VoxelMeshDrawDescriptor{
+		ID:       1,
+		Mesh:     testVoxBox(16, 16, 320),
+		Position: mgl32.Vec3{7.5, 7.5, 4},
+	})
+	s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+	if n := len(b.DrawsOf("voxel-mesh")); n != 1 {
+		t.Errorf("drew %d voxel meshes, want 1", n)
+	}
+	if n := s.app.VisibleChunks(); n != 1 {
+		t.Errorf("%d visible chunks, want 1", n)
+	}
+	// Moving the mesh within the chunk hides it again
+	d.VoxelDDs[0].Position = mgl32.Vec3{7.5, 7.5, 20}
+	d.VoxelDDs[0].Mesh = testVoxBox(16, 16, 16)
+	b.Reset()
+	s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+	if n := len(b.DrawsOf("voxel-mesh")); n != 0 {
+		t.Errorf("drew %d voxel meshes, want none", n)
+	}
+}
+
+func TestRemoveChunkDD(t *testing.T) {
+	for _, culling := range []bool{true, false} {
+		b := NewRecordingBackend()
+		s := newTestScene(t, b)
+		s.app.OcclusionCulling = culling
+		s.addCube(1, mgl32.Vec3{0, 0, 0})
+		s.addCube(2, mgl32.Vec3{0, 0, -16})
+		s.app.RemoveChunkDD(1)
+		if n := s.app.Chunks(); n != 1 {
+			t.Fatalf("%d chunks after removal, want 1", n)
+		}
+		s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+		if n := len(b.DrawsOf("cube-mesh")); n != 1 {
+			t.Errorf("culling %v: drew %d cube meshes, want 1", culling, n)
+		}
+		s.app.RemoveChunkDD(2)
+		b.Reset()
+		s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+		if n := len(b.DrawsOf("cube-mesh")); n != 0 || s.app.Chunks() != 0 {
+			t.Errorf("culling %v: drew %d cube meshes of %d chunks, want "+
+				"none", culling, n, s.app.Chunks())
+		}
+	}
+}
+
+func TestDrawModelsInstanced(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	m := testVoxelMesh()
+	s.addModel(1, mgl32.Vec3{0, 0, -4}, m)
+	s.addModel(2, mgl32.Vec3{2, 0, -4}, m)
+	s.app.Draw(NewCamera(mgl32.Vec3{0, 0, 0}))
+	draws := b.DrawsOf("model-mesh")
+	if len(draws) != 1 {
+		t.Fatalf("drew %d model meshes, want one instanced draw",
+			len(draws))
+	}
+	d := draws[0]
+	if d.Instances != 2 || d.Count != 6*6 {
+		t.Fatalf("drew %d instances of %d vertexes, want 2 of 36",
+			d.Instances, d.Count)
+	}
+	for i, x := range []float32{0, 2} {
+		mm, ok := b.Attrib(d.VertexArray, "aModelMatrix", 0, i)
+		if !ok || len(mm) != 16 {
+			t.Fatalf("instance %d has no model matrix", i)
+		}
+		if mm[12] != x || mm[14] != -4 {
+			t.Errorf("instance %d translated to %v, want %v", i,
+				mm[12:15], []float32{x, 0, -4})
+		}
+	}
+}
+
+func TestDrawModelsWithoutInstancing(t *testing.T) {
+	b := NewRecordingBackend()
+	b.NoInstancing = true
+	s := newTestScene(t, b)
+	m := testVoxelMesh()
+	s.addModel(1, mgl32.Vec3{0, 0, -4}, m)
+	s.addModel(2, mgl32.Vec3{2, 0, -4}, m)
+	s.app.Draw(NewCamera(mgl32.Vec3{0, 0, 0}))
+	draws := b.DrawsOf("model-mesh")
+	if len(draws) != 2 {
+		t.Fatalf("drew %d model meshes, want one per instance", len(draws))
+	}
+	for _, d := range draws {
+		if d.Instances != 1 {
+			t.Errorf("drew %d instances without instancing", d.Instances)
+		}
+	}
+	for _, a := range b.VertexArrays[draws[0].VertexArray] {
+		if a.Divisor != 0 {
+			t.Errorf("attribute %s has divisor %d", a.Name, a.Divisor)
+		}
+	}
+	// The model matrix of the last instance remains set
+	mm, ok := b.Attrib(draws[1].VertexArray, "aModelMatrix", 0, 0)
+	if !ok || len(mm) != 16 || mm[12] != 2 || mm[14] != -4 {
+		t.Errorf("last model matrix is %v", mm)
+	}
+}
+
+func TestDrawUIMesh(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	m := s.app.NewUIMesh()
+	m.Tile(0, 0, s.tile)
+	m.Position = mgl32.Vec2{10, 20}
+	m.Layer = 3
+	s.app.AddUIMesh(m)
+	hidden := s.app.NewUIMesh()
+	hidden.Tile(0, 0, s.tile)
+	hidden.Hidden = true
+	s.app.AddUIMesh(hidden)
+	s.app.Draw(NewCamera(mgl32.Vec3{0, 0, 0}))
+	draws := b.DrawsOf("ui")
+	if len(draws) != 1 {
+		t.Fatalf("drew %d ui meshes, want 1", len(draws))
+	}
+	d := draws[0]
+	if d.DepthTest {
+		t.Error("ui mesh drawn with depth testing")
+	}
+	if d.Count != 6 {
+		t.Errorf("drew %d vertexes, want one quad", d.Count)
+	}
+	want := mgl32.Vec3{10, -20, float32(3) / 0x7FF}
+	if p, _ := d.Uniforms["uPosition"].(mgl32.Vec3); p != want {
+		t.Errorf("position uniform %v, want %v", p, want)
+	}
+	// Depth testing is restored for the next frame
+	if !b.DepthTest {
+		t.Error("depth testing left disabled")
+	}
+}
+
+func TestDrawBillboards(t *testing.T) {
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	near := s.app.NewUIMesh()
+	near.Tile(0, 0, s.tile)
+	far := s.app.NewUIMesh()
+	far.Tile(0, 0, s.tile)
+	far.Tile(16, 0, s.tile)
+	s.app.AddBillboardDD(&BillboardDrawDescriptor{
+		ID:       1,
+		Mesh:     near,
+		Position: mgl32.Vec3{0, 0, -2},
+	})
+	s.app.AddBillboardDD(&BillboardDrawDescriptor{
+		ID:       2,
+		Mesh:     far,
+		Position: mgl32.Vec3{0, 0, -8},
+	})
+	s.app.Draw(NewCamera(mgl32.Vec3{0, 0, 0}))
+	draws := b.DrawsOf("ui")
+	if len(draws) != 2 {
+		t.Fatalf("drew %d billboards, want 2", len(draws))
+	}
+	// Billboards are drawn back to front without writing depth
+	if draws[0].Count != 12 || draws[1].Count != 6 {
+		t.Errorf("drew billboards of %d and %d vertexes, want far first",
+			draws[0].Count, draws[1].Count)
+	}
+	for _, d := range draws {
+		if !d.DepthTest || d.DepthMask {
+			t.Error("billboard drawn with depth writes or without testing")
+		}
+	}
+}
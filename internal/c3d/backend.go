@@ -0,0 +1,163 @@
+package c3d
+
+import (
+	"image"
+	"strings"
+	"unsafe"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// AttribType is the data type of the components of a vertex attribute.
+type AttribType int
+
+const (
+	AttribUByte  AttribType = 0 // Unsigned 8-bit integer
+	AttribShort  AttribType = 1 // Signed 16-bit integer
+	AttribUShort AttribType = 2 // Unsigned 16-bit integer
+	AttribFloat  AttribType = 3 // 32-bit float
+)
+
+// Size returns the size of one component of the type in bytes.
+func (t AttribType) Size() int {
+	switch t {
+	case AttribShort, AttribUShort:
+		return 2
+	case AttribFloat:
+		return 4
+	}
+	return 1
+}
+
+// Primitive is the type of primitive a draw call assembles vertexes into.
+type Primitive int
+
+const (
+	PrimitiveTriangles Primitive = 0 // Every three vertexes form a triangle
+	PrimitiveLines     Primitive = 1 // Every two vertexes form a line
+	PrimitivePoints    Primitive = 2 // Every vertex is a point
+)
+
+// Backend is implemented by the graphics APIs the App renders with. Objects
+// are referred to by handles allocated by the backend. Uniforms and vertex
+// attributes are referred to by the locations returned for their names, which
+// are -1 for names the program does not use. All calls are made from the
+// thread the App is used on.
+type Backend interface {
+	// NewProgram compiles and links a program from the GLSL version 1.00
+	// vertex and fragment shader sources and returns its handle.
+	NewProgram(name, vSrc, fSrc string) (uint32, error)
+	// DeleteProgram deletes the program.
+	DeleteProgram(p uint32)
+	// UseProgram makes the program the target of uniform updates and draws.
+	UseProgram(p uint32)
+	// AttribLocation returns the location of the named vertex attribute of
+	// the program.
+	AttribLocation(p uint32, name string) int32
+	// UniformLocation returns the location of the named uniform of the
+	// program.
+	UniformLocation(p uint32, name string) int32
+	// Uniform1i sets an integer or sampler uniform of the current program.
+	Uniform1i(l int32, v int32)
+	// Uniform1f sets a float uniform of the current program.
+	Uniform1f(l int32, v float32)
+	// Uniform1fv sets a float array uniform of the current program.
+	Uniform1fv(l int32, v []float32)
+	// Uniform3f sets a vec3 uniform of the current program.
+	Uniform3f(l int32, x, y, z float32)
+	// UniformMatrix4fv sets a mat4 uniform of the current program.
+	UniformMatrix4fv(l int32, m mgl32.Mat4)
+	// NewVertexArray returns the handle of a new vertex array.
+	NewVertexArray() uint32
+	// DeleteVertexArray deletes the vertex array.
+	DeleteVertexArray(id uint32)
+	// BindVertexArray makes the vertex array the target of attribute setup
+	// and the source of vertexes for draws.
+	BindVertexArray(id uint32)
+	// NewBuffer returns the handle of a new vertex buffer.
+	NewBuffer() uint32
+	// DeleteBuffer deletes the vertex buffer.
+	DeleteBuffer(id uint32)
+	// BindBuffer makes the vertex buffer the target of BufferData and the
+	// source of attributes set up with VertexAttrib.
+	BindBuffer(id uint32)
+	// BufferData replaces the contents of the bound buffer. Stream should be
+	// true for data replaced every frame.
+	BufferData(d []byte, stream bool)
+	// VertexAttrib sources the attribute at location l of the bound vertex
+	// array from the bound buffer. Attributes with a divisor greater than
+	// zero advance once per divisor instances rather than once per vertex.
+	VertexAttrib(l int32, size int, typ AttribType, normalized bool,
+		stride, offset int, divisor uint32)
+	// VertexAttribConst stops sourcing the attribute at location l of the
+	// bound vertex array from a buffer and uses the constant value v for all
+	// vertexes instead.
+	VertexAttribConst(l int32, v mgl32.Vec4)
+	// NewTexture returns the handle of a new texture with the contents of
+	// img. Textures are sampled with linear filtering if linear is true or
+	// nearest filtering otherwise, and clamp to the edge.
+	NewTexture(img *image.RGBA, linear bool) uint32
+	// UpdateTexture replaces the portion of the texture at x, y with img.
+	UpdateTexture(id uint32, x, y int, img *image.RGBA)
+	// DeleteTexture deletes the texture.
+	DeleteTexture(id uint32)
+	// BindTexture binds the texture to the texture unit.
+	BindTexture(unit int, id uint32)
+	// Clear clears the color buffer to c and the depth buffer.
+	Clear(c mgl32.Vec3)
+	// SetDepthTest enables or disables depth testing.
+	SetDepthTest(enabled bool)
+	// SetDepthMask enables or disables writes to the depth buffer.
+	SetDepthMask(enabled bool)
+	// DrawArrays draws count vertexes of the bound vertex array starting at
+	// first with the current program.
+	DrawArrays(mode Primitive, first, count int32)
+	// DrawArraysInstanced draws n instances of count vertexes of the bound
+	// vertex array starting at first with the current program.
+	DrawArraysInstanced(mode Primitive, first, count, n int32)
+	// Instancing returns true if DrawArraysInstanced and attribute divisors
+	// are supported.
+	Instancing() bool
+	// ReadPixels returns a copy of the color buffer with the origin at the
+	// top left, or nil if the backend has no color buffer.
+	ReadPixels() *image.RGBA
+}
+
+// declaration is a variable declaration of a GLSL shader.
+type declaration struct {
+	name      string // Name of the variable
+	locations int    // Number of attribute locations used
+}
+
+// declarations returns the declarations of the given qualifier, such as
+// attribute or uniform, in the GLSL source in order.
+func declarations(src, qualifier string) []declaration {
+	var ret []declaration
+	for _, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(strings.TrimSuffix(
+			strings.TrimSpace(line), ";"))
+		if len(fields) < 3 || fields[0] != qualifier {
+			continue
+		}
+		name, _, _ := strings.Cut(fields[len(fields)-1], "[")
+		n := 1
+		if qualifier == "attribute" && fields[len(fields)-2] == "mat4" {
+			n = 4
+		}
+		ret = append(ret, declaration{
+			name:      name,
+			locations: n,
+		})
+	}
+	return ret
+}
+
+// float32Bytes returns the memory of the slice of floats as a slice of bytes
+// without copying.
+func float32Bytes(v []float32) []byte {
+	if len(v) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&v[0])), len(v)*4)
+}
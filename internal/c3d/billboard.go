@@ -0,0 +1,82 @@
+package c3d
+
+import (
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// DefaultBillboardScale is the size of one virtual screen unit in cubes of
+// billboards that do not set a scale, making a glyph a quarter cube wide.
+const DefaultBillboardScale float32 = 1.0 / 16.0
+
+// AddBillboardDD adds the billboard draw descriptor to the list to draw.
+func (a *App) AddBillboardDD(d *BillboardDrawDescriptor) {
+	a.billboardDDs = append(a.billboardDDs, d)
+}
+
+// RemoveBillboardDD removes the billboard draw descriptor by ID.
+func (a *App) RemoveBillboardDD(id uint32) {
+	for i := 0; i < len(a.billboardDDs); i++ {
+		if a.billboardDDs[i].ID == id {
+			a.billboardDDs[i] = a.billboardDDs[len(a.billboardDDs)-1]
+			a.billboardDDs[len(a.billboardDDs)-1] = nil
+			a.billboardDDs = a.billboardDDs[:len(a.billboardDDs)-1]
+			return
+		}
+	}
+}
+
+// sortBillboards sorts the billboards back to front from the camera position
+// p so translucent texels blend over the billboards behind them.
+func (a *App) sortBillboards(p mgl32.Vec3) {
+	sort.Slice(a.billboardDDs, func(i, j int) bool {
+		di := a.billboardDDs[i].Position.Sub(p).LenSqr()
+		dj := a.billboardDDs[j].Position.Sub(p).LenSqr()
+		return di > dj
+	})
+}
+
+// drawBillboards draws all billboards whose Overlay flag matches overlay with
+// the projection matrix pMat and view matrix vMat. Billboards are drawn with
+// the UI and text programs, rotated by the inverse of the view rotation so the
+// virtual screen plane of the mesh faces the camera.
+func (a *App) drawBillboards(pMat, vMat mgl32.Mat4, overlay bool) {
+	pvMat := pMat.Mul4(vMat)
+	r := vMat.Mat3().Transpose().Mat4()
+	for _, d := range a.billboardDDs {
+		m := d.Mesh
+		if d.Overlay != overlay || m == nil || m.Hidden {
+			continue
+		}
+		s := d.Scale
+		if s == 0 {
+			s = DefaultBillboardScale
+		}
+		mvp := pvMat.Mul4(mgl32.Translate3D(
+			d.Position[0],
+			d.Position[1],
+			d.Position[2],
+		)).Mul4(r).Mul4(mgl32.Scale3D(s, s, s))
+		// Mesh vertexes have Y inverted within the virtual screen
+		x := m.Position[0] - d.Anchor[0]
+		y := d.Anchor[1] - m.Position[1] - float32(t.VirtualScreenHeight)
+		// UI tiles
+		if m.count > 0 {
+			a.pUI.use()
+			a.pUI.setMat4("uProjectionMatrix", mvp)
+			a.tiles.bind(a.pUI)
+			a.pUI.setVec3("uPosition", x, y, 0)
+			m.draw(a.pUI)
+		}
+		// Text layer
+		if m.text != nil {
+			a.pText.use()
+			a.pText.setMat4("uProjectionMatrix", mvp)
+			a.fm.bind(a.pText)
+			a.pText.setVec3("uPosition", x, y, 0)
+			m.text.draw(a.pText)
+		}
+	}
+}
@@ -1,10 +1,6 @@
 package c3d
 
 import (
-	"fmt"
-	"strings"
-
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
@@ -38,9 +34,13 @@ type VoxelMeshDrawDescriptor struct {
 // ChunkDrawDescriptor describes how and where to render the static portions of
 // a scene.
 type ChunkDrawDescriptor struct {
-	ID       uint32                     // ID
-	CubeDD   CubeMeshDrawDescriptor     // The draw descriptor for the cube mesh
-	VoxelDDs []*VoxelMeshDrawDescriptor // Draw descriptors for all voxel meshes contained within the chunk
+	ID            uint32                     // ID
+	CubeDD        CubeMeshDrawDescriptor     // The draw descriptor for the cube mesh
+	VoxelDDs      []*VoxelMeshDrawDescriptor // Draw descriptors for all voxel meshes contained within the chunk
+	LODCubeMeshes [LODLevels - 1]*CubeMesh   // Cube meshes for levels of detail 1 and up, see BuildCubeMeshLOD
+	LODVoxelMesh  *VoxelMesh                 // Reduced detail vox cell mesh, see BuildVoxCellMeshLOD
+	Visibility    ChunkVisibility            // Sides of the chunk connected by open cells, see BuildChunkVisibility
+	lod           int                        // Level of detail the chunk was last drawn at
 }
 
 // ModelDrawDescriptor describes how and where to render a dynamic model.
@@ -57,27 +57,19 @@ type LineMeshDrawDescriptor struct {
 	ID          uint32        // ID
 	Orientation t.Orientation // Origin of the model
 	Mesh        *LineMesh     // Mesh
+	Overlay     bool          // If true the mesh is always drawn, over everything else
 }
 
-type getObjIv func(uint32, uint32, *int32)
-type getObjInfoLog func(uint32, int32, *int32, *uint8)
-
-func getGlError(glHandle uint32, checkTrueParam uint32, getObjIvFn getObjIv,
-	getObjInfoLogFn getObjInfoLog, failMsg string) error {
-	var success int32
-	getObjIvFn(glHandle, checkTrueParam, &success)
-	if success == gl.FALSE {
-		var logLength int32
-		getObjIvFn(glHandle, gl.INFO_LOG_LENGTH, &logLength)
-		log := "NO LOG"
-		if logLength > 0 {
-			gls := gl.Str(strings.Repeat("\x00", int(logLength)))
-			getObjInfoLogFn(glHandle, logLength, nil, gls)
-			log = gl.GoStr(gls)
-		}
-		return fmt.Errorf("%s: %s", failMsg, log)
-	}
-	return nil
+// BillboardDrawDescriptor describes how and where to draw a UI mesh in world
+// space facing the camera, such as a name plate or an icon over an entity.
+// Cube icons of the mesh are not drawn.
+type BillboardDrawDescriptor struct {
+	ID       uint32     // ID
+	Mesh     *UIMesh    // Tiles and text to draw
+	Position mgl32.Vec3 // Position in world space the anchor point is drawn at
+	Anchor   mgl32.Vec2 // Point of the mesh in virtual screen units drawn at Position
+	Scale    float32    // Size of one virtual screen unit in cubes, DefaultBillboardScale if zero
+	Overlay  bool       // If true the billboard is always drawn, over everything else
 }
 
 // AABB represents an axis-aligned bounding box with an optional bounds mesh.
@@ -1,7 +1,10 @@
 package c3d
 
 import (
-	gl "github.com/go-gl/gl/v3.1/gles2"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
 
@@ -10,14 +13,37 @@ const invalidVBO = 0xFFFFFFFF
 
 var facingLightLevels = [6]byte{223, 223, 191, 191, 255, 127}
 
+// cubeMeshUnits is the number of vertex position units per cube.
+const cubeMeshUnits = 16
+
+// cubeMeshStride is the size of one cube mesh vertex in bytes.
+const cubeMeshStride = 3*2 + 2*2 + 3*1 + 1*1
+
+// MaxMeshDims is the largest dimension of a cube or voxel mesh in cubes.
+// Vertex positions of both are stored as 16-bit unsigned integers in units of
+// 1/16th of a cube, the size of one voxel.
+const MaxMeshDims = 0xFFFF / cubeMeshUnits
+
+// checkMeshDims returns an error if a volume of the given dimensions in cubes
+// exceeds MaxMeshDims along any axis.
+func checkMeshDims(w, h, d int) error {
+	if w > MaxMeshDims || h > MaxMeshDims || d > MaxMeshDims {
+		return fmt.Errorf(
+			"mesh volume of %dx%dx%d cubes exceeds the limit of %d cubes",
+			w, h, d, MaxMeshDims)
+	}
+	return nil
+}
+
 // CubeMesh is a utility struct that builds cube-based meshes.
 type CubeMesh struct {
-	vao        uint32    // Vertex Array Object ID
-	vbo        uint32    // Vertex Buffer Object ID
-	count      int32     // Vertex count
-	vboCurrent bool      // If false, the VBO needs to be reuploaded.
-	d          []byte    // Raw mesh data
-	defs       []*t.Cube // List of cube definitions
+	vao        uint32               // Vertex Array Object ID
+	vbo        uint32               // Vertex Buffer Object ID
+	count      int32                // Vertex count
+	vboCurrent bool                 // If false, the VBO needs to be reuploaded.
+	d          []byte               // Raw mesh data
+	defs       []*t.Cube            // List of cube definitions
+	vbuf       [cubeMeshStride]byte // Vertex buffer
 }
 
 // NewCubeMesh constructs a new CubeMesh object ready for use.
@@ -30,20 +56,147 @@ func NewCubeMesh(defs []*t.Cube) *CubeMesh {
 	return ret
 }
 
-// vert adds a single vertex to the data buffer.
-func (m *CubeMesh) vert(x, y, z, u, v uint8, i int, c t.Cell, f t.Facing) {
+// vert adds a single vertex to the data buffer. Positions and texture
+// coordinates are in cubes and must not exceed MaxMeshDims.
+func (m *CubeMesh) vert(x, y, z, u, v uint16, i int, c t.Cell, f t.Facing) {
+	m.fineVert(
+		x*cubeMeshUnits,
+		y*cubeMeshUnits,
+		z*cubeMeshUnits,
+		u*cubeMeshUnits,
+		v*cubeMeshUnits,
+		c, f,
+	)
+}
+
+// fineVert adds a single vertex to the data buffer. Positions and texture
+// coordinates are in units of 1/16th of a cube.
+func (m *CubeMesh) fineVert(x, y, z, u, v uint16, c t.Cell, f t.Facing) {
 	cube, _, _ := c.Decompose()
 	if int(cube) >= len(m.defs) {
 		return
 	}
 	cd := m.defs[cube]
 	face := cd.Faces[f]
-	fx, fy := face.ToAtlasXY()
-	m.d = append(m.d, x, y, z, uint8(fx), uint8(fy), u, v, facingLightLevels[f])
+	fx, fy, fz := face.ToAtlasXYZ()
+	d := m.vbuf[:]
+	binary.LittleEndian.PutUint16(d[0:2], x)
+	binary.LittleEndian.PutUint16(d[2:4], y)
+	binary.LittleEndian.PutUint16(d[4:6], z)
+	binary.LittleEndian.PutUint16(d[6:8], u)
+	binary.LittleEndian.PutUint16(d[8:10], v)
+	d[10] = uint8(fx)
+	d[11] = uint8(fy)
+	d[12] = uint8(fz)
+	d[13] = facingLightLevels[f]
+	m.d = append(m.d, d...)
 	m.count++
 	m.vboCurrent = false
 }
 
+// quad adds a single quad to the mesh. Corners are given as top-left,
+// top-right, bottom-left, and bottom-right relative to the front of the quad
+// in cube units, along with matching texture coordinates. The position p is
+// added to all corners.
+func (m *CubeMesh) quad(p mgl32.Vec3, q [4]mgl32.Vec3, uv [4]mgl32.Vec2,
+	c t.Cell, f t.Facing) {
+	fine := func(v float32) uint16 {
+		return uint16(v*cubeMeshUnits + 0.5)
+	}
+	vert := func(i int) {
+		v := q[i].Add(p)
+		m.fineVert(fine(v[0]), fine(v[1]), fine(v[2]),
+			fine(uv[i][0]), fine(uv[i][1]), c, f)
+	}
+	vert(0) // TL
+	vert(1) // TR
+	vert(2) // BL
+	vert(2) // BL
+	vert(1) // TR
+	vert(3) // BR
+}
+
+// boxFace returns the corners of the side f of the box b as top-left,
+// top-right, bottom-left, and bottom-right relative to the front of the side,
+// and true if the side lies on the boundary of the unit cell.
+func boxFace(b t.AABB, f t.Facing) ([4]mgl32.Vec3, bool) {
+	x0, y0, z0 := b[0][0], b[0][1], b[0][2]
+	x1, y1, z1 := b[1][0], b[1][1], b[1][2]
+	switch f {
+	case t.North:
+		return [4]mgl32.Vec3{{x1, y1, z0}, {x0, y1, z0}, {x1, y0, z0},
+			{x0, y0, z0}}, z0 == 0
+	case t.South:
+		return [4]mgl32.Vec3{{x0, y1, z1}, {x1, y1, z1}, {x0, y0, z1},
+			{x1, y0, z1}}, z1 == 1
+	case t.East:
+		return [4]mgl32.Vec3{{x1, y1, z1}, {x1, y1, z0}, {x1, y0, z1},
+			{x1, y0, z0}}, x1 == 1
+	case t.West:
+		return [4]mgl32.Vec3{{x0, y1, z0}, {x0, y1, z1}, {x0, y0, z0},
+			{x0, y0, z1}}, x0 == 0
+	case t.Top:
+		return [4]mgl32.Vec3{{x0, y1, z0}, {x1, y1, z0}, {x0, y1, z1},
+			{x1, y1, z1}}, y1 == 1
+	}
+	return [4]mgl32.Vec3{{x1, y0, z0}, {x0, y0, z0}, {x1, y0, z1},
+		{x0, y0, z1}}, y0 == 0
+}
+
+// addBox adds the faces of a box to the mesh. The position of the cell is
+// given as p and the box b is relative to the cell in cube units. Faces on the
+// boundary of the cell for which cull returns true are skipped.
+func (m *CubeMesh) addBox(p mgl32.Vec3, b t.AABB, c t.Cell,
+	cull func(t.Facing) bool) {
+	for f := t.North; f <= t.Bottom; f++ {
+		q, onEdge := boxFace(b, f)
+		if onEdge && cull(f) {
+			continue
+		}
+		// Texture coordinates are taken from the position of the corner
+		// within the cell so partial faces show the matching portion of the
+		// face graphic.
+		var uv [4]mgl32.Vec2
+		for i, v := range q {
+			switch f {
+			case t.North:
+				uv[i] = mgl32.Vec2{1 - v[0], 1 - v[1]}
+			case t.South:
+				uv[i] = mgl32.Vec2{v[0], 1 - v[1]}
+			case t.East:
+				uv[i] = mgl32.Vec2{1 - v[2], 1 - v[1]}
+			case t.West:
+				uv[i] = mgl32.Vec2{v[2], 1 - v[1]}
+			case t.Top:
+				uv[i] = mgl32.Vec2{v[0], v[2]}
+			case t.Bottom:
+				uv[i] = mgl32.Vec2{1 - v[0], v[2]}
+			}
+		}
+		m.quad(p, q, uv, c, f)
+	}
+}
+
+// addCross adds two crossed, double-sided planes spanning the diagonals of the
+// box b to the mesh. The position of the cell is given as p and the box is
+// relative to the cell in cube units. All planes use the north face graphic.
+func (m *CubeMesh) addCross(p mgl32.Vec3, b t.AABB, c t.Cell) {
+	x0, y0, z0 := b[0][0], b[0][1], b[0][2]
+	x1, y1, z1 := b[1][0], b[1][1], b[1][2]
+	uv := [4]mgl32.Vec2{{0, 0}, {1, 0}, {0, 1}, {1, 1}}
+	planes := [2][4]mgl32.Vec3{
+		{{x0, y1, z0}, {x1, y1, z1}, {x0, y0, z0}, {x1, y0, z1}},
+		{{x0, y1, z1}, {x1, y1, z0}, {x0, y0, z1}, {x1, y0, z0}},
+	}
+	for _, q := range planes {
+		m.quad(p, q, uv, c, t.North)
+		m.quad(p,
+			[4]mgl32.Vec3{q[1], q[0], q[3], q[2]},
+			[4]mgl32.Vec2{uv[1], uv[0], uv[3], uv[2]},
+			c, t.North)
+	}
+}
+
 // Reset rests the mesh builder state.
 func (m *CubeMesh) Reset() {
 	m.d = m.d[:0]
@@ -53,40 +206,130 @@ func (m *CubeMesh) Reset() {
 
 // draw draws the cube mesh.
 func (m *CubeMesh) draw(p *program) {
+	b := p.b
 	if m.vao == invalidVAO {
-		gl.GenVertexArrays(1, &m.vao)
+		m.vao = b.NewVertexArray()
 	}
 	if m.vbo == invalidVBO {
-		var stride int32 = 3*1 + 2*1 + 2*1 + 1*1
+		var stride int = cubeMeshStride
 		var offset int = 0
-		gl.GenBuffers(1, &m.vbo)
-		gl.BindVertexArray(m.vao)
-		gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexPosition")),
-			3, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexPosition")))
+		m.vbo = b.NewBuffer()
+		b.BindVertexArray(m.vao)
+		b.BindBuffer(m.vbo)
+		p.attrib("aVertexPosition", 3, AttribUShort, false, stride, offset)
+		offset += 3 * 2
+		p.attrib("aVertexUV", 2, AttribUShort, false, stride, offset)
+		offset += 2 * 2
+		p.attrib("aAtlasXYZ", 3, AttribUByte, false, stride, offset)
 		offset += 3 * 1
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aAtlasXY")),
-			2, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aAtlasXY")))
-		offset += 2 * 1
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexUV")),
-			2, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexUV")))
-		offset += 2 * 1
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexLightLevel")),
-			1, gl.UNSIGNED_BYTE, true, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexLightLevel")))
+		p.attrib("aVertexLightLevel", 1, AttribUByte, true, stride, offset)
 		offset += 1 * 1
 	}
 	if !m.vboCurrent {
 		if len(m.d) > 0 {
-			gl.BindVertexArray(m.vao)
-			gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-			gl.BufferData(gl.ARRAY_BUFFER, len(m.d), gl.Ptr(m.d), gl.STATIC_DRAW)
+			b.BindVertexArray(m.vao)
+			b.BindBuffer(m.vbo)
+			b.BufferData(m.d, false)
 		}
 		m.vboCurrent = true
 	}
-	gl.BindVertexArray(m.vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, m.count)
+	b.BindVertexArray(m.vao)
+	b.DrawArrays(PrimitiveTriangles, 0, m.count)
+}
+
+// cubeSource wraps a volume of cells to mesh only full cubes with face
+// culling that respects cube shapes and transparency.
+type cubeSource struct {
+	v    VoxelSource[t.Cell] // Source cells
+	defs []*t.Cube           // List of cube definitions
+}
+
+// cube returns the cube definition for the cell, or nil if it is not a cube.
+func (s *cubeSource) cube(c t.Cell) *t.Cube {
+	if !c.IsCube() {
+		return nil
+	}
+	r, _, _ := c.Decompose()
+	if int(r) >= len(s.defs) {
+		return nil
+	}
+	return s.defs[r]
+}
+
+// Get implements the VoxelSource interface.
+func (s *cubeSource) Get(x, y, z int) t.Cell {
+	return s.v.Get(x, y, z)
+}
+
+// Dimensions implements the VoxelSource interface.
+func (s *cubeSource) Dimensions() (w, h, d int) {
+	return s.v.Dimensions()
+}
+
+// IsEmpty implements the VoxelSource interface. Cells containing anything
+// other than a full cube are considered empty.
+func (s *cubeSource) IsEmpty(c t.Cell) bool {
+	cd := s.cube(c)
+	return cd == nil || cd.Shape != t.ShapeFull
+}
+
+// Occludes implements the VoxelOccluder interface.
+func (s *cubeSource) Occludes(c t.Cell, side t.Facing, n t.Cell) bool {
+	cd := s.cube(c)
+	if cd == nil {
+		return false
+	}
+	_, _, f := c.Decompose()
+	return cd.Occludes(f, side, s.cube(n))
+}
+
+// BuildCubeMesh builds the cube mesh for a volume of cells, such as a chunk.
+// Full cubes are greedy meshed and all other shapes are added cell by cell.
+// Note that the destination mesh is not reset before faces are added. An error
+// is returned if the volume exceeds MaxMeshDims.
+func BuildCubeMesh(v VoxelSource[t.Cell], m *CubeMesh) error {
+	width, height, depth := v.Dimensions()
+	if err := checkMeshDims(width, height, depth); err != nil {
+		return err
+	}
+	src := &cubeSource{
+		v:    v,
+		defs: m.defs,
+	}
+	BuildVoxelMesh[t.Cell](src, m)
+	for iz := 0; iz < depth; iz++ {
+		for iy := 0; iy < height; iy++ {
+			for ix := 0; ix < width; ix++ {
+				c := v.Get(ix, iy, iz)
+				cd := src.cube(c)
+				if cd == nil || cd.Shape == t.ShapeFull {
+					continue
+				}
+				_, _, f := c.Decompose()
+				p := mgl32.Vec3{float32(ix), float32(iy), float32(iz)}
+				if cd.Shape == t.ShapeCross {
+					for _, b := range cd.Shape.Boxes(f) {
+						m.addCross(p, b, c)
+					}
+					continue
+				}
+				neighbor := func(s t.Facing) t.Cell {
+					o := t.FacingOffsets[s]
+					return v.Get(ix+o[0], iy+o[1], iz+o[2])
+				}
+				connects := func(s t.Facing) bool {
+					return cd.ConnectsTo(src.cube(neighbor(s)))
+				}
+				// Fence arms meet the arms of the neighbor at the cell edge
+				cull := func(s t.Facing) bool {
+					return src.Occludes(neighbor(s), s.Opposite(), c) ||
+						connects(s)
+				}
+				for _, b := range cd.Shape.ConnectedBoxes(f, connects) {
+					m.addBox(p, b, c, cull)
+				}
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,77 @@
+package c3d
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// testCubeDefs returns a single full cube definition.
+func testCubeDefs() []*t.Cube {
+	return []*t.Cube{{Ref: 0, Name: "test"}}
+}
+
+// testCubeRow returns a row of w cells along the X axis with a cube in the
+// last cell.
+func testCubeRow(w int) *testCells {
+	ret := newTestCells(w, 1, 1)
+	ret.set(w-1, 0, 0, t.CellForCube(0, t.North))
+	return ret
+}
+
+// noVoxMeshes is a vox mesh lookup function without any meshes.
+func noVoxMeshes(t.VoxRef) *VoxelMesh {
+	return nil
+}
+
+func TestMeshDimsLimit(t *testing.T) {
+	defs := testCubeDefs()
+	cube := func(v *testCells) error {
+		return BuildCubeMesh(v, NewCubeMesh(defs))
+	}
+	lod := func(v *testCells) error {
+		return BuildCubeMeshLOD(v, 1, NewCubeMesh(defs))
+	}
+	vox := func(v *testCells) error {
+		return BuildVoxCellMesh(v, defs, noVoxMeshes, NewVoxelMesh())
+	}
+	tests := []struct {
+		name  string
+		w     int
+		build func(v *testCells) error
+		ok    bool
+	}{
+		{"cube", MaxMeshDims, cube, true},
+		{"cube past limit", MaxMeshDims + 1, cube, false},
+		{"lod", MaxMeshDims - 1, lod, true},
+		{"lod rounded past limit", MaxMeshDims, lod, false},
+		{"vox cells", MaxMeshDims - VoxCellMeshMargin*2, vox, true},
+		{"vox cells past limit", MaxMeshDims - VoxCellMeshMargin*2 + 1, vox,
+			false},
+	}
+	for _, tt := range tests {
+		err := tt.build(testCubeRow(tt.w))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
+
+func TestCubeMeshFarEdge(t *testing.T) {
+	m := NewCubeMesh(testCubeDefs())
+	if err := BuildCubeMesh(testCubeRow(MaxMeshDims), m); err != nil {
+		t.Fatal(err)
+	}
+	lo, hi := 0xFFFF, 0
+	for i := 0; i < len(m.d); i += cubeMeshStride {
+		x := int(binary.LittleEndian.Uint16(m.d[i:]))
+		lo = min(lo, x)
+		hi = max(hi, x)
+	}
+	want := MaxMeshDims * cubeMeshUnits
+	if lo != want-cubeMeshUnits || hi != want {
+		t.Errorf("vertexes span %d-%d, want %d-%d", lo, hi,
+			want-cubeMeshUnits, want)
+	}
+}
@@ -5,45 +5,71 @@ import (
 	"image"
 	"image/draw"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/qbradq/cubit/internal/t"
 )
 
-// FaceAtlas manages
+// faceAnimation manages one atlas slot that cycles through a series of face
+// graphics.
+type faceAnimation struct {
+	index     t.FaceIndex   // Atlas slot the frames are displayed in
+	frames    []*image.RGBA // Frame graphics
+	frameTime float32       // Time each frame is displayed in seconds
+	elapsed   float32       // Time elapsed in the current frame
+	frame     int           // Index of the current frame
+}
+
+// FaceAtlas manages a set of atlas texture pages that face graphics are packed
+// into. New pages are added as the existing pages fill up, up to t.AtlasPages.
 type FaceAtlas struct {
-	textureID uint32      // Texture ID in OpenGL for the atlas
-	nextIndex t.FaceIndex // The next FaceIndex value to be assigned
-	img       *image.RGBA // The atlas texture image while building
+	b          Backend                   // Backend the pages were uploaded to
+	textureIDs []uint32                  // Texture handles for each atlas page
+	nextIndex  t.FaceIndex               // The next FaceIndex value to be assigned
+	pages      []*image.RGBA             // The atlas texture page images while building
+	animations []*faceAnimation          // All animated atlas slots
+	animIndex  map[string]*faceAnimation // Animated atlas slots by frame set
 }
 
 // NewFaceAtlas creates a new FaceAtlas object ready for use.
 func NewFaceAtlas() *FaceAtlas {
 	ret := &FaceAtlas{
-		img: image.NewRGBA(image.Rect(0, 0, t.AtlasTextureDims,
-			t.AtlasTextureDims)),
+		animIndex: map[string]*faceAnimation{},
 	}
+	ret.addPage()
 	return ret
 }
 
+// addPage adds a new blank page to the atlas.
+func (a *FaceAtlas) addPage() {
+	a.pages = append(a.pages, image.NewRGBA(image.Rect(0, 0,
+		t.AtlasTextureDims, t.AtlasTextureDims)))
+}
+
 // freeMemory frees internal memory pages used during image rendering. This is
 // not needed after a call to upload().
 func (a *FaceAtlas) freeMemory() {
-	a.img = nil
+	a.pages = nil
 }
 
 // AddFace adds a single face graphic to the atlas and returns the FaceIndex.
+// If all atlas pages are full t.FaceIndexInvalid is returned.
 func (a *FaceAtlas) AddFace(img *image.RGBA) t.FaceIndex {
-	if img.Bounds().Max.X != t.FaceDims || img.Bounds().Max.Y != t.FaceDims {
+	if img.Bounds().Dx() != t.FaceDims || img.Bounds().Dy() != t.FaceDims {
 		panic(fmt.Errorf("all face images must be %dx%d pixels", t.FaceDims,
 			t.FaceDims))
 	}
-	x, y := a.nextIndex.ToAtlasXY()
+	if a.nextIndex == t.FaceIndexInvalid {
+		return t.FaceIndexInvalid
+	}
+	x, y, z := a.nextIndex.ToAtlasXYZ()
+	for z >= len(a.pages) {
+		a.addPage()
+	}
 	draw.Draw(
-		a.img,
+		a.pages[z],
 		image.Rect(x*t.FaceDims, y*t.FaceDims, (x+1)*t.FaceDims,
 			(y+1)*t.FaceDims),
 		img,
-		image.Pt(0, 0),
+		img.Bounds().Min,
 		draw.Src,
 	)
 	ret := a.nextIndex
@@ -51,24 +77,91 @@ func (a *FaceAtlas) AddFace(img *image.RGBA) t.FaceIndex {
 	return ret
 }
 
-// upload uploads the entire face atlas to the GPU as a 2D texture array.
+// getFace returns a copy of the face graphic at the given index. This is only
+// valid before a call to freeMemory().
+func (a *FaceAtlas) getFace(i t.FaceIndex) *image.RGBA {
+	x, y, z := i.ToAtlasXYZ()
+	ret := image.NewRGBA(image.Rect(0, 0, t.FaceDims, t.FaceDims))
+	draw.Draw(ret, ret.Bounds(), a.pages[z],
+		image.Pt(x*t.FaceDims, y*t.FaceDims), draw.Src)
+	return ret
+}
+
+// AddAnimation adds an atlas slot that cycles through the given faces, which
+// must have already been added to the atlas, showing each for frameTime
+// seconds. The returned index may be used like any other face index. Identical
+// animations share the same atlas slot. If no valid frames are given or the
+// atlas is full t.FaceIndexInvalid is returned.
+func (a *FaceAtlas) AddAnimation(frames []t.FaceIndex,
+	frameTime float32) t.FaceIndex {
+	key := fmt.Sprintf("%v:%f", frames, frameTime)
+	if fa, found := a.animIndex[key]; found {
+		return fa.index
+	}
+	fa := &faceAnimation{
+		frameTime: frameTime,
+	}
+	for _, f := range frames {
+		if f == t.FaceIndexInvalid || f >= a.nextIndex {
+			continue
+		}
+		fa.frames = append(fa.frames, a.getFace(f))
+	}
+	if len(fa.frames) < 1 {
+		return t.FaceIndexInvalid
+	}
+	fa.index = a.AddFace(fa.frames[0])
+	if fa.index == t.FaceIndexInvalid {
+		return t.FaceIndexInvalid
+	}
+	a.animations = append(a.animations, fa)
+	a.animIndex[key] = fa
+	return fa.index
+}
+
+// update advances all face animations by dt seconds, updating the atlas slots
+// of any animations that changed frames.
+func (a *FaceAtlas) update(dt float32) {
+	for _, fa := range a.animations {
+		if len(fa.frames) < 2 {
+			continue
+		}
+		fa.elapsed += dt
+		if fa.elapsed < fa.frameTime {
+			continue
+		}
+		for fa.elapsed >= fa.frameTime {
+			fa.elapsed -= fa.frameTime
+			fa.frame = (fa.frame + 1) % len(fa.frames)
+		}
+		x, y, z := fa.index.ToAtlasXYZ()
+		if z >= len(a.textureIDs) {
+			continue
+		}
+		a.b.UpdateTexture(a.textureIDs[z], x*t.FaceDims, y*t.FaceDims,
+			fa.frames[fa.frame])
+	}
+}
+
+// upload uploads each page of the face atlas to the GPU as a 2D texture.
 func (a *FaceAtlas) upload(prg *program) {
 	prg.use()
-	gl.GenTextures(1, &a.textureID)
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, a.textureID)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA,
-		t.AtlasTextureDims, t.AtlasTextureDims, 0,
-		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(a.img.Pix))
+	a.b = prg.b
+	a.textureIDs = make([]uint32, len(a.pages))
+	for i, page := range a.pages {
+		a.textureIDs[i] = a.b.NewTexture(page, false)
+	}
 }
 
-// bind binds the texture to the 3D texture unit.
+// bind binds each atlas page to its own texture unit. Pages that have not been
+// allocated are bound to the first page's texture so all samplers are valid.
 func (a *FaceAtlas) bind(prg *program) {
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, a.textureID)
-	gl.Uniform1i(prg.uni("uAtlas"), 0)
+	for i := 0; i < t.AtlasPages; i++ {
+		id := a.textureIDs[0]
+		if i < len(a.textureIDs) {
+			id = a.textureIDs[i]
+		}
+		prg.b.BindTexture(i, id)
+		prg.setInt(fmt.Sprintf("uAtlas%d", i), int32(i))
+	}
 }
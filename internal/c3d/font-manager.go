@@ -5,7 +5,6 @@ import (
 	"image/draw"
 	"log"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/golang/freetype/truetype"
 	"github.com/qbradq/cubit/data"
 	"github.com/qbradq/cubit/internal/t"
@@ -20,7 +19,8 @@ type glyph struct {
 
 // fontManager manages font rendering for an app.
 type fontManager struct {
-	t        uint32          // Texture ID in OpenGL
+	b        Backend         // Backend the texture was created with
+	t        uint32          // Texture handle
 	img      *image.RGBA     // Image atlas backing the texture
 	imgDirty bool            // If true, img has been updated since the last draw call
 	f        *truetype.Font  // Font used for text rendering
@@ -64,29 +64,15 @@ func newFontManager(prg *program) *fontManager {
 	}
 	// GL setup
 	prg.use()
-	gl.GenTextures(1, &ret.t)
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, ret.t)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA,
-		int32(t.FADims), int32(t.FADims), 0,
-		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(ret.img.Pix),
-	)
+	ret.b = prg.b
+	ret.t = ret.b.NewTexture(ret.img, true)
 	return ret
 }
 
 // updateAtlasTexture uploads the backing image to the GPU to replace the
 // current glyph atlas.
 func (m *fontManager) updateAtlasTexture() {
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, m.t)
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA,
-		int32(t.FADims), int32(t.FADims), 0,
-		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(m.img.Pix),
-	)
+	m.b.UpdateTexture(m.t, 0, 0, m.img)
 	m.imgDirty = false
 }
 
@@ -151,7 +137,6 @@ func (f *fontManager) getGlyph(r rune) glyph {
 
 // bind binds the texture to the 3D texture unit.
 func (f *fontManager) bind(prg *program) {
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, f.t)
-	gl.Uniform1i(prg.uni("uFont"), 0)
+	prg.b.BindTexture(0, f.t)
+	prg.setInt("uFont", 0)
 }
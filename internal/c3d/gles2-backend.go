@@ -0,0 +1,383 @@
+package c3d
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	gl "github.com/go-gl/gl/v3.1/gles2"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// gles2AttribTypes maps attribute types to their OpenGL enumerations.
+var gles2AttribTypes = map[AttribType]uint32{
+	AttribUByte:  gl.UNSIGNED_BYTE,
+	AttribShort:  gl.SHORT,
+	AttribUShort: gl.UNSIGNED_SHORT,
+	AttribFloat:  gl.FLOAT,
+}
+
+// gles2Primitives maps primitives to their OpenGL enumerations.
+var gles2Primitives = map[Primitive]uint32{
+	PrimitiveTriangles: gl.TRIANGLES,
+	PrimitiveLines:     gl.LINES,
+	PrimitivePoints:    gl.POINTS,
+}
+
+// gles2Program tracks the shaders of a program.
+type gles2Program struct {
+	vs uint32 // Vertex shader ID
+	fs uint32 // Fragment shader ID
+}
+
+// GLES2Backend implements the Backend interface with OpenGL ES 2.0.
+type GLES2Backend struct {
+	programs   map[uint32]gles2Program // Shaders by program ID
+	instancing bool                    // If true instanced draws are supported
+}
+
+// NewGLES2Backend initializes OpenGL for the current context and returns a
+// new backend using it.
+func NewGLES2Backend() (*GLES2Backend, error) {
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.BlendEquation(gl.FUNC_ADD)
+	gl.ClearDepthf(1)
+	gl.DepthFunc(gl.LEQUAL)
+	gl.Enable(gl.CULL_FACE)
+	gl.CullFace(gl.BACK)
+	gl.FrontFace(gl.CW)
+	return &GLES2Backend{
+		programs:   map[uint32]gles2Program{},
+		instancing: glInstancing(gl.GoStr(gl.GetString(gl.VERSION))),
+	}, nil
+}
+
+// glInstancing returns true if the OpenGL or OpenGL ES version string s is
+// for a version with instanced draws and attribute divisors, which are OpenGL
+// ES 3.0 and OpenGL 3.3.
+func glInstancing(s string) bool {
+	var major, minor int
+	es := strings.HasPrefix(s, "OpenGL ES ")
+	s = strings.TrimPrefix(s, "OpenGL ES ")
+	if _, err := fmt.Sscanf(s, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	if es {
+		return major >= 3
+	}
+	return major > 3 || (major == 3 && minor >= 3)
+}
+
+type getObjIv func(uint32, uint32, *int32)
+type getObjInfoLog func(uint32, int32, *int32, *uint8)
+
+func getGlError(glHandle uint32, checkTrueParam uint32, getObjIvFn getObjIv,
+	getObjInfoLogFn getObjInfoLog, failMsg string) error {
+	var success int32
+	getObjIvFn(glHandle, checkTrueParam, &success)
+	if success == gl.FALSE {
+		var logLength int32
+		getObjIvFn(glHandle, gl.INFO_LOG_LENGTH, &logLength)
+		log := "NO LOG"
+		if logLength > 0 {
+			gls := gl.Str(strings.Repeat("\x00", int(logLength)))
+			getObjInfoLogFn(glHandle, logLength, nil, gls)
+			log = gl.GoStr(gls)
+		}
+		return fmt.Errorf("%s: %s", failMsg, log)
+	}
+	return nil
+}
+
+// compileShader compiles a shader of the given OpenGL type.
+func (b *GLES2Backend) compileShader(src string, st uint32) (uint32,
+	error) {
+	ts := "vertex"
+	if st == gl.FRAGMENT_SHADER {
+		ts = "fragment"
+	}
+	id := gl.CreateShader(st)
+	glStrs, freeFunc := gl.Strs(src + "\x00")
+	defer freeFunc()
+	gl.ShaderSource(id, 1, glStrs, nil)
+	gl.CompileShader(id)
+	err := getGlError(id, gl.COMPILE_STATUS, gl.GetShaderiv,
+		gl.GetShaderInfoLog, "shader:compile:"+ts)
+	if err != nil {
+		gl.DeleteShader(id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// NewProgram implements the Backend interface.
+func (b *GLES2Backend) NewProgram(name, vSrc, fSrc string) (uint32, error) {
+	vs, err := b.compileShader(vSrc, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, fmt.Errorf("in glsl program %s, vertex shader: %s", name,
+			err)
+	}
+	fs, err := b.compileShader(fSrc, gl.FRAGMENT_SHADER)
+	if err != nil {
+		gl.DeleteShader(vs)
+		return 0, fmt.Errorf("in glsl program %s, fragment shader: %s", name,
+			err)
+	}
+	id := gl.CreateProgram()
+	gl.AttachShader(id, vs)
+	gl.AttachShader(id, fs)
+	// Attribute locations are assigned in order of declaration so a program
+	// rebuilt from modified sources keeps the vertex layouts of existing
+	// vertex arrays as long as the attribute declarations are unchanged.
+	var l uint32
+	for _, d := range declarations(vSrc, "attribute") {
+		gl.BindAttribLocation(id, l, gl.Str(d.name+"\x00"))
+		l += uint32(d.locations)
+	}
+	gl.LinkProgram(id)
+	if err := getGlError(id, gl.LINK_STATUS, gl.GetProgramiv,
+		gl.GetProgramInfoLog, "program:link"); err != nil {
+		gl.DeleteProgram(id)
+		gl.DeleteShader(vs)
+		gl.DeleteShader(fs)
+		return 0, fmt.Errorf("in glsl program %s: %s", name, err)
+	}
+	b.programs[id] = gles2Program{
+		vs: vs,
+		fs: fs,
+	}
+	return id, nil
+}
+
+// DeleteProgram implements the Backend interface.
+func (b *GLES2Backend) DeleteProgram(p uint32) {
+	if s, found := b.programs[p]; found {
+		gl.DeleteShader(s.vs)
+		gl.DeleteShader(s.fs)
+		delete(b.programs, p)
+	}
+	gl.DeleteProgram(p)
+}
+
+// UseProgram implements the Backend interface.
+func (b *GLES2Backend) UseProgram(p uint32) {
+	gl.UseProgram(p)
+}
+
+// AttribLocation implements the Backend interface.
+func (b *GLES2Backend) AttribLocation(p uint32, name string) int32 {
+	return gl.GetAttribLocation(p, gl.Str(name+"\x00"))
+}
+
+// UniformLocation implements the Backend interface.
+func (b *GLES2Backend) UniformLocation(p uint32, name string) int32 {
+	return gl.GetUniformLocation(p, gl.Str(name+"\x00"))
+}
+
+// Uniform1i implements the Backend interface.
+func (b *GLES2Backend) Uniform1i(l int32, v int32) {
+	gl.Uniform1i(l, v)
+}
+
+// Uniform1f implements the Backend interface.
+func (b *GLES2Backend) Uniform1f(l int32, v float32) {
+	gl.Uniform1f(l, v)
+}
+
+// Uniform1fv implements the Backend interface.
+func (b *GLES2Backend) Uniform1fv(l int32, v []float32) {
+	if len(v) == 0 {
+		return
+	}
+	gl.Uniform1fv(l, int32(len(v)), &v[0])
+}
+
+// Uniform3f implements the Backend interface.
+func (b *GLES2Backend) Uniform3f(l int32, x, y, z float32) {
+	gl.Uniform3f(l, x, y, z)
+}
+
+// UniformMatrix4fv implements the Backend interface.
+func (b *GLES2Backend) UniformMatrix4fv(l int32, m mgl32.Mat4) {
+	gl.UniformMatrix4fv(l, 1, false, &m[0])
+}
+
+// NewVertexArray implements the Backend interface.
+func (b *GLES2Backend) NewVertexArray() uint32 {
+	var id uint32
+	gl.GenVertexArrays(1, &id)
+	return id
+}
+
+// DeleteVertexArray implements the Backend interface.
+func (b *GLES2Backend) DeleteVertexArray(id uint32) {
+	gl.DeleteVertexArrays(1, &id)
+}
+
+// BindVertexArray implements the Backend interface.
+func (b *GLES2Backend) BindVertexArray(id uint32) {
+	gl.BindVertexArray(id)
+}
+
+// NewBuffer implements the Backend interface.
+func (b *GLES2Backend) NewBuffer() uint32 {
+	var id uint32
+	gl.GenBuffers(1, &id)
+	return id
+}
+
+// DeleteBuffer implements the Backend interface.
+func (b *GLES2Backend) DeleteBuffer(id uint32) {
+	gl.DeleteBuffers(1, &id)
+}
+
+// BindBuffer implements the Backend interface.
+func (b *GLES2Backend) BindBuffer(id uint32) {
+	gl.BindBuffer(gl.ARRAY_BUFFER, id)
+}
+
+// BufferData implements the Backend interface.
+func (b *GLES2Backend) BufferData(d []byte, stream bool) {
+	if len(d) == 0 {
+		return
+	}
+	usage := uint32(gl.STATIC_DRAW)
+	if stream {
+		usage = gl.STREAM_DRAW
+	}
+	gl.BufferData(gl.ARRAY_BUFFER, len(d), gl.Ptr(d), usage)
+}
+
+// VertexAttrib implements the Backend interface.
+func (b *GLES2Backend) VertexAttrib(l int32, size int, typ AttribType,
+	normalized bool, stride, offset int, divisor uint32) {
+	if l < 0 {
+		return
+	}
+	gl.VertexAttribPointerWithOffset(uint32(l), int32(size),
+		gles2AttribTypes[typ], normalized, int32(stride), uintptr(offset))
+	gl.EnableVertexAttribArray(uint32(l))
+	if divisor > 0 {
+		gl.VertexAttribDivisor(uint32(l), divisor)
+	}
+}
+
+// VertexAttribConst implements the Backend interface.
+func (b *GLES2Backend) VertexAttribConst(l int32, v mgl32.Vec4) {
+	if l < 0 {
+		return
+	}
+	gl.DisableVertexAttribArray(uint32(l))
+	gl.VertexAttrib4f(uint32(l), v[0], v[1], v[2], v[3])
+}
+
+// NewTexture implements the Backend interface.
+func (b *GLES2Backend) NewTexture(img *image.RGBA, linear bool) uint32 {
+	var id uint32
+	filter := int32(gl.NEAREST)
+	if linear {
+		filter = gl.LINEAR
+	}
+	gl.GenTextures(1, &id)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA,
+		int32(img.Rect.Dx()), int32(img.Rect.Dy()), 0,
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	return id
+}
+
+// UpdateTexture implements the Backend interface.
+func (b *GLES2Backend) UpdateTexture(id uint32, x, y int, img *image.RGBA) {
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, int32(x), int32(y),
+		int32(img.Rect.Dx()), int32(img.Rect.Dy()),
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+}
+
+// DeleteTexture implements the Backend interface.
+func (b *GLES2Backend) DeleteTexture(id uint32) {
+	gl.DeleteTextures(1, &id)
+}
+
+// BindTexture implements the Backend interface.
+func (b *GLES2Backend) BindTexture(unit int, id uint32) {
+	gl.ActiveTexture(gl.TEXTURE0 + uint32(unit))
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// Clear implements the Backend interface.
+func (b *GLES2Backend) Clear(c mgl32.Vec3) {
+	gl.ClearColor(c[0], c[1], c[2], 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+}
+
+// SetDepthTest implements the Backend interface.
+func (b *GLES2Backend) SetDepthTest(enabled bool) {
+	if enabled {
+		gl.Enable(gl.DEPTH_TEST)
+	} else {
+		gl.Disable(gl.DEPTH_TEST)
+	}
+}
+
+// SetDepthMask implements the Backend interface.
+func (b *GLES2Backend) SetDepthMask(enabled bool) {
+	gl.DepthMask(enabled)
+}
+
+// DrawArrays implements the Backend interface.
+func (b *GLES2Backend) DrawArrays(mode Primitive, first, count int32) {
+	gl.DrawArrays(gles2Primitives[mode], first, count)
+}
+
+// DrawArraysInstanced implements the Backend interface.
+func (b *GLES2Backend) DrawArraysInstanced(mode Primitive, first, count,
+	n int32) {
+	gl.DrawArraysInstanced(gles2Primitives[mode], first, count, n)
+}
+
+// Instancing implements the Backend interface.
+func (b *GLES2Backend) Instancing() bool {
+	return b.instancing
+}
+
+// ReadPixels implements the Backend interface.
+func (b *GLES2Backend) ReadPixels() *image.RGBA {
+	var vp [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &vp[0])
+	w := int(vp[2])
+	h := int(vp[3])
+	if w < 1 || h < 1 {
+		return nil
+	}
+	ret := image.NewRGBA(image.Rect(0, 0, w, h))
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(vp[0], vp[1], vp[2], vp[3], gl.RGBA, gl.UNSIGNED_BYTE,
+		gl.Ptr(ret.Pix))
+	// OpenGL rows start at the bottom and the default framebuffer may have
+	// no alpha channel
+	row := make([]byte, ret.Stride)
+	for y := 0; y < h/2; y++ {
+		a := ret.Pix[y*ret.Stride : (y+1)*ret.Stride]
+		b := ret.Pix[(h-1-y)*ret.Stride : (h-y)*ret.Stride]
+		copy(row, a)
+		copy(a, b)
+		copy(b, row)
+	}
+	for i := 3; i < len(ret.Pix); i += 4 {
+		ret.Pix[i] = 255
+	}
+	return ret
+}
@@ -0,0 +1,145 @@
+package c3d
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// update rewrites the golden images with the images rendered.
+var update = flag.Bool("update", false, "rewrite golden images")
+
+// goldenSize is the size of golden images, the virtual screen.
+var goldenSize = image.Pt(t.VirtualScreenWidth, t.VirtualScreenHeight)
+
+// compareGolden compares img with the golden image testdata/<name>.png. Small
+// differences of rounding are tolerated.
+func compareGolden(tt *testing.T, name string, img *image.RGBA) {
+	fp := filepath.Join("testdata", name+".png")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			tt.Fatal(err)
+		}
+		f, err := os.Create(fp)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, img); err != nil {
+			tt.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		tt.Fatalf("%s, run go test with -update to create it", err)
+	}
+	defer f.Close()
+	gi, err := png.Decode(f)
+	if err != nil {
+		tt.Fatal(err)
+	}
+	if gi.Bounds() != img.Bounds() {
+		tt.Fatalf("image is %v, golden image is %v", img.Bounds(),
+			gi.Bounds())
+	}
+	bad := 0
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			r, g, b, _ := gi.At(x, y).RGBA()
+			want := [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+			o := img.PixOffset(x, y)
+			for i := range want {
+				if d := int(img.Pix[o+i]) - want[i]; d < -2 || d > 2 {
+					bad++
+					break
+				}
+			}
+		}
+	}
+	// Allow for edge pixels rasterised differently on other platforms
+	if limit := len(img.Pix) / 4 / 200; bad > limit {
+		tt.Errorf("%d pixels differ from %s, at most %d may", bad, fp,
+			limit)
+	}
+}
+
+func TestGoldenCubeMesh(t *testing.T) {
+	b := NewSoftwareBackend(goldenSize.X, goldenSize.Y)
+	s := newTestScene(t, b)
+	s.addCube(1, mgl32.Vec3{0, 0, 0})
+	c := NewCamera(mgl32.Vec3{-1.5, 2, 3})
+	c.Yaw = -55
+	c.Pitch = -25
+	s.app.Draw(c)
+	compareGolden(t, "cube-mesh", b.Image)
+}
+
+func TestGoldenVoxelMesh(t *testing.T) {
+	b := NewSoftwareBackend(goldenSize.X, goldenSize.Y)
+	s := newTestScene(t, b)
+	m := NewVoxelMesh()
+	BuildVoxelMesh[[4]uint8](&testVoxels{
+		w:    8,
+		h:    8,
+		d:    8,
+		c:    [4]uint8{200, 120, 40, 255},
+		hole: [3]int{7, 7, 7},
+	}, m)
+	s.addModel(1, mgl32.Vec3{0, 0, -2}, m)
+	c := NewCamera(mgl32.Vec3{0.9, 0.9, -0.8})
+	c.Yaw = -124
+	c.Pitch = -30
+	s.app.Draw(c)
+	compareGolden(t, "voxel-mesh", b.Image)
+}
+
+func TestGoldenUIMesh(t *testing.T) {
+	b := NewSoftwareBackend(goldenSize.X, goldenSize.Y)
+	s := newTestScene(t, b)
+	m := s.app.NewUIMesh()
+	m.Scaled(0, 0, 64, 32, s.tile)
+	m.Print(4, 40, [3]uint8{255, 255, 0}, "Cubit")
+	m.Position = mgl32.Vec2{16, 16}
+	s.app.AddUIMesh(m)
+	s.app.Draw(NewCamera(mgl32.Vec3{0, 0, 0}))
+	compareGolden(t, "ui-mesh", b.Image)
+}
+
+func TestMirrorBackend(t *testing.T) {
+	soft := NewSoftwareBackend(goldenSize.X, goldenSize.Y)
+	mirror := NewMirrorBackend(NewRecordingBackend(), goldenSize.X,
+		goldenSize.Y)
+	c := NewCamera(mgl32.Vec3{-1.5, 2, 3})
+	c.Yaw = -55
+	c.Pitch = -25
+	var apps []*App
+	for _, b := range []Backend{soft, mirror} {
+		s := newTestScene(t, b)
+		s.addCube(1, mgl32.Vec3{0, 0, 0})
+		s.addModel(2, mgl32.Vec3{0.5, 1.5, 0.5}, testVoxelMesh())
+		s.app.Draw(c)
+		apps = append(apps, s.app)
+	}
+	if img := apps[1].Screenshot(); img != nil {
+		t.Fatal("mirror read back a frame it did not rasterise")
+	}
+	mirror.Rasterize = true
+	apps[1].Draw(c)
+	img := apps[1].Screenshot()
+	if img == nil {
+		t.Fatal("mirror did not read back the rasterised frame")
+	}
+	want := apps[0].Screenshot()
+	for i := range want.Pix {
+		if img.Pix[i] != want.Pix[i] {
+			t.Fatal("mirrored frame differs from the software frame")
+		}
+	}
+}
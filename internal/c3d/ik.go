@@ -0,0 +1,199 @@
+package c3d
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// ikEpsilon is the smallest length considered by the IK solvers.
+const ikEpsilon = 0.0001
+
+// partPath returns the parts from root to p inclusive, or nil if p is not
+// part of the hierarchy of root.
+func partPath(root, p *Part) []*Part {
+	if root == p {
+		return []*Part{root}
+	}
+	for _, c := range root.Children {
+		if path := partPath(c, p); path != nil {
+			return append([]*Part{root}, path...)
+		}
+	}
+	return nil
+}
+
+// PartWorld returns the orientation of the part p in world space, along with
+// the orientation of its parent, given the orientation o of the root part's
+// parent. False is returned if p is not part of the hierarchy of root.
+func PartWorld(root, p *Part, o t.Orientation) (parent, self t.Orientation,
+	ok bool) {
+	path := partPath(root, p)
+	if path == nil {
+		return o, o, false
+	}
+	parent = o
+	for _, pp := range path[:len(path)-1] {
+		parent = pp.Orientation.Accumulate(parent)
+	}
+	return parent, p.Orientation.Accumulate(parent), true
+}
+
+// rotateWorld rotates the part by the world space rotation r, blended by
+// weight, given the world space rotation of the part's parent pq.
+func rotateWorld(p *Part, pq, r mgl32.Quat, weight float32) {
+	q := pq.Inverse().Mul(r).Mul(pq).Mul(p.Orientation.Q).Normalize()
+	p.Orientation.Q = nlerp(p.Orientation.Q, q, min(max(weight, 0), 1))
+}
+
+// IKChain is a limb of one or two parts solved with inverse kinematics.
+type IKChain struct {
+	Upper *Part      // Upper joint of the limb
+	Lower *Part      // Lower joint of the limb, or nil for limbs of a single part
+	End   mgl32.Vec3 // End effector relative to the joint of the last part in world units
+	Pole  mgl32.Vec3 // Direction in model space the limb bends toward
+}
+
+// last returns the last part of the chain.
+func (c *IKChain) last() *Part {
+	if c.Lower != nil {
+		return c.Lower
+	}
+	return c.Upper
+}
+
+// EndPosition returns the position of the end effector in world space, given
+// the root part of the model and the orientation of the model o.
+func (c *IKChain) EndPosition(root *Part, o t.Orientation) (mgl32.Vec3,
+	bool) {
+	_, w, ok := PartWorld(root, c.last(), o)
+	if !ok {
+		return mgl32.Vec3{}, false
+	}
+	return w.Q.Rotate(c.End).Add(w.P), true
+}
+
+// Solve rotates the joints of the chain so the end effector reaches for the
+// target in world space, given the root part of the model and the
+// orientation of the model o. Two-part chains bend the lower joint toward the
+// pole. Single-part chains that can not reach the target swing toward the
+// pole to reach the point above or below the target instead. The weight in
+// the range 0-1 blends between the current pose and the solution. True is
+// returned if the target was reached.
+func (c *IKChain) Solve(root *Part, o t.Orientation, target mgl32.Vec3,
+	weight float32) bool {
+	if c.Lower == nil {
+		return c.solveOne(root, o, target, weight)
+	}
+	pp1, w1, ok := PartWorld(root, c.Upper, o)
+	if !ok {
+		return false
+	}
+	_, w2, ok := PartWorld(root, c.Lower, o)
+	if !ok {
+		return false
+	}
+	p1 := w1.P
+	p2 := w2.P
+	e := w2.Q.Rotate(c.End).Add(p2)
+	a := p2.Sub(p1).Len()
+	b := e.Sub(p2).Len()
+	v := target.Sub(p1)
+	l := v.Len()
+	if a < ikEpsilon || b < ikEpsilon || l < ikEpsilon {
+		return false
+	}
+	cl := min(max(l, float32(math.Abs(float64(a-b)))+ikEpsilon),
+		a+b-ikEpsilon)
+	d := v.Mul(1 / l)
+	// Plane of the bend
+	pole := o.Q.Rotate(c.Pole)
+	n := d.Cross(pole)
+	if n.Len() < ikEpsilon {
+		n = p2.Sub(p1).Cross(e.Sub(p2))
+	}
+	if n.Len() < ikEpsilon {
+		n = d.Cross(t.XAxis)
+		if n.Len() < ikEpsilon {
+			n = d.Cross(t.ZAxis)
+		}
+	}
+	n = n.Normalize()
+	// Angle at the upper joint from the law of cosines
+	ca := (a*a + cl*cl - b*b) / (2 * a * cl)
+	alpha := float32(math.Acos(float64(min(max(ca, -1), 1))))
+	m := mgl32.QuatRotate(alpha, n).Rotate(d).Mul(a).Add(p1)
+	et := d.Mul(cl).Add(p1)
+	// Upper joint
+	r1 := mgl32.QuatBetweenVectors(p2.Sub(p1), m.Sub(p1))
+	rotateWorld(c.Upper, pp1.Q, r1, weight)
+	// Lower joint
+	pp2, w2, _ := PartWorld(root, c.Lower, o)
+	e = w2.Q.Rotate(c.End).Add(w2.P)
+	r2 := mgl32.QuatBetweenVectors(e.Sub(w2.P), et.Sub(w2.P))
+	rotateWorld(c.Lower, pp2.Q, r2, weight)
+	return l <= a+b
+}
+
+// solveOne solves a chain of a single part.
+func (c *IKChain) solveOne(root *Part, o t.Orientation, target mgl32.Vec3,
+	weight float32) bool {
+	pp, w, ok := PartWorld(root, c.Upper, o)
+	if !ok {
+		return false
+	}
+	e := w.Q.Rotate(c.End)
+	l := e.Len()
+	v := target.Sub(w.P)
+	if l < ikEpsilon || v.Len() < ikEpsilon {
+		return false
+	}
+	// Move the target along the pole to a point the limb can reach
+	reached := true
+	pole := o.Q.Rotate(c.Pole)
+	if pole.Len() > ikEpsilon {
+		pole = pole.Normalize()
+		pv := pole.Dot(v)
+		disc := pv*pv - v.Dot(v) + l*l
+		if disc >= 0 {
+			s := -pv + float32(math.Sqrt(float64(disc)))
+			v = v.Add(pole.Mul(s))
+		} else {
+			reached = false
+		}
+	}
+	if v.Len() < ikEpsilon {
+		return false
+	}
+	rotateWorld(c.Upper, pp.Q, mgl32.QuatBetweenVectors(e, v), weight)
+	return reached
+}
+
+// LookAt rotates the part so that the direction forward, relative to the
+// part, points toward the target in world space, given the root part of the
+// model and the orientation of the model o. The rotation is limited to
+// maxAngle radians from the current pose if maxAngle is greater than zero.
+// The weight in the range 0-1 blends between the current pose and the
+// solution.
+func LookAt(root, p *Part, o t.Orientation, forward, target mgl32.Vec3,
+	maxAngle, weight float32) {
+	pp, w, ok := PartWorld(root, p, o)
+	if !ok || forward.Len() < ikEpsilon {
+		return
+	}
+	f := w.Q.Rotate(forward).Normalize()
+	d := target.Sub(w.P)
+	if d.Len() < ikEpsilon {
+		return
+	}
+	d = d.Normalize()
+	r := mgl32.QuatBetweenVectors(f, d)
+	if maxAngle > 0 {
+		angle := float32(math.Acos(float64(min(max(f.Dot(d), -1), 1))))
+		if angle > maxAngle {
+			r = mgl32.QuatSlerp(mgl32.QuatIdent(), r, maxAngle/angle)
+		}
+	}
+	rotateWorld(p, pp.Q, r, weight)
+}
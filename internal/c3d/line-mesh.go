@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"math"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
@@ -87,35 +86,31 @@ func (m *LineMesh) draw(prg *program) {
 	if m.Hidden {
 		return
 	}
+	b := prg.b
 	if m.vao == invalidVAO {
-		gl.GenVertexArrays(1, &m.vao)
+		m.vao = b.NewVertexArray()
 	}
 	if m.vbo == invalidVBO {
-		var stride int32 = 3*4 + 3*1
+		var stride int = 3*4 + 3*1
 		var offset int = 0
-		gl.GenBuffers(1, &m.vbo)
-		gl.BindVertexArray(m.vao)
-		gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexPosition")),
-			3, gl.FLOAT, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexPosition")))
+		m.vbo = b.NewBuffer()
+		b.BindVertexArray(m.vao)
+		b.BindBuffer(m.vbo)
+		prg.attrib("aVertexPosition", 3, AttribFloat, false, stride, offset)
 		offset += 3 * 4
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexColor")),
-			3, gl.UNSIGNED_BYTE, true, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexColor")))
+		prg.attrib("aVertexColor", 3, AttribUByte, true, stride, offset)
 		offset += 3 * 1
 	}
 	if m.vboDirty {
 		if len(m.d) > 0 {
-			gl.BindVertexArray(m.vao)
-			gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
+			b.BindVertexArray(m.vao)
+			b.BindBuffer(m.vbo)
 			db := append(m.d, m.pd...)
-			gl.BufferData(gl.ARRAY_BUFFER, len(db), gl.Ptr(db),
-				gl.STATIC_DRAW)
+			b.BufferData(db, false)
 		}
 		m.vboDirty = false
 	}
-	gl.BindVertexArray(m.vao)
-	gl.DrawArrays(gl.LINES, 0, m.count)
-	gl.DrawArrays(gl.POINTS, 0, m.count+m.pCount)
+	b.BindVertexArray(m.vao)
+	b.DrawArrays(PrimitiveLines, 0, m.count)
+	b.DrawArrays(PrimitivePoints, 0, m.count+m.pCount)
 }
@@ -0,0 +1,239 @@
+package c3d
+
+import (
+	"encoding/binary"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// LODLevels is the number of levels of detail chunks are drawn at. Level 0 is
+// full detail and each following level merges twice as many cells along each
+// axis into one, so level 1 merges 2x2x2 cells and level 2 merges 4x4x4.
+const LODLevels = 3
+
+// lodHysteresis is the distance in cubes the camera must move past an LOD
+// distance before a chunk switches level, so chunks near a threshold do not
+// flicker between levels.
+const lodHysteresis = 4
+
+// lodBlockCells is the number of cells in the largest block merged by any
+// level of detail.
+const lodBlockCells = 1 << (3 * (LODLevels - 1))
+
+// LODScale returns the number of cells merged along each axis at the level of
+// detail l.
+func LODScale(l int) int {
+	return 1 << l
+}
+
+// lodSource reduces a volume of cells by merging blocks of s x s x s cells
+// into the full cube whose faces dominate the block.
+type lodSource struct {
+	*cubeSource     // Full detail cells
+	s           int // Cells per block along each axis
+}
+
+// Dimensions implements the VoxelSource interface.
+func (s *lodSource) Dimensions() (w, h, d int) {
+	w, h, d = s.cubeSource.Dimensions()
+	return (w + s.s - 1) / s.s, (h + s.s - 1) / s.s, (d + s.s - 1) / s.s
+}
+
+// Get implements the VoxelSource interface. If at least half of the cells of
+// the block are full cubes the cube with the most exposed faces within the
+// block is returned, so the surface of the block keeps its look, otherwise the
+// block is empty.
+func (s *lodSource) Get(x, y, z int) t.Cell {
+	w, h, d := s.Dimensions()
+	if x < 0 || y < 0 || z < 0 || x >= w || y >= h || z >= d {
+		return t.CellInvalid
+	}
+	// Blocks hold few distinct cubes, so a linear search beats a map
+	var cells []t.Cell
+	var weights []int
+	solid := 0
+	best := t.CellInvalid
+	bestWeight := 0
+	for iz := z * s.s; iz < (z+1)*s.s; iz++ {
+		for iy := y * s.s; iy < (y+1)*s.s; iy++ {
+			for ix := x * s.s; ix < (x+1)*s.s; ix++ {
+				c := s.cubeSource.Get(ix, iy, iz)
+				if s.cubeSource.IsEmpty(c) {
+					continue
+				}
+				solid++
+				// Exposed faces outweigh any number of buried cubes
+				weight := 1
+				for f := t.North; f <= t.Bottom; f++ {
+					o := t.FacingOffsets[f]
+					n := s.cubeSource.Get(ix+o[0], iy+o[1], iz+o[2])
+					if !s.Occludes(n, f.Opposite(), c) {
+						weight += lodBlockCells
+					}
+				}
+				r, _, _ := c.Decompose()
+				i := 0
+				for ; i < len(cells); i++ {
+					if cr, _, _ := cells[i].Decompose(); cr == r {
+						break
+					}
+				}
+				if i == len(cells) {
+					cells = append(cells, c)
+					weights = append(weights, 0)
+				}
+				weights[i] += weight
+				if weights[i] > bestWeight {
+					best = cells[i]
+					bestWeight = weights[i]
+				}
+			}
+		}
+	}
+	if solid*2 < s.s*s.s*s.s {
+		return t.CellInvalid
+	}
+	return best
+}
+
+// lodCubeMesh scales the vertexes of a reduced volume back up to cubes.
+type lodCubeMesh struct {
+	*CubeMesh        // Destination mesh
+	s         uint16 // Cubes per mesh unit
+}
+
+// vert implements the Mesh interface.
+func (m *lodCubeMesh) vert(x, y, z, u, v uint16, i int, c t.Cell,
+	f t.Facing) {
+	m.CubeMesh.vert(x*m.s, y*m.s, z*m.s, u*m.s, v*m.s, i, c, f)
+}
+
+// BuildCubeMeshLOD builds a reduced cube mesh for a volume of cells, such as a
+// chunk, at the level of detail l. Each block of cells is replaced by the full
+// cube that dominates it and all other shapes are dropped. Faces on the
+// boundary of the volume are never culled, so the steps between neighboring
+// volumes drawn at different levels of detail are closed rather than leaving
+// gaps. Note that the destination mesh is not reset before faces are added.
+// An error is returned if the volume rounded up to whole blocks exceeds
+// MaxMeshDims.
+func BuildCubeMeshLOD(v VoxelSource[t.Cell], l int, m *CubeMesh) error {
+	if l <= 0 {
+		return BuildCubeMesh(v, m)
+	}
+	src := &lodSource{
+		cubeSource: &cubeSource{
+			v:    v,
+			defs: m.defs,
+		},
+		s: LODScale(l),
+	}
+	w, h, d := src.Dimensions()
+	if err := checkMeshDims(w*src.s, h*src.s, d*src.s); err != nil {
+		return err
+	}
+	BuildVoxelMesh[t.Cell](src, &lodCubeMesh{
+		CubeMesh: m,
+		s:        uint16(src.s),
+	})
+	return nil
+}
+
+// lodBox returns a mesh of the bounding box of the mesh filled with the
+// average color of its faces. The result is cached until the mesh is reset.
+func (m *VoxelMesh) lodBox() *VoxelMesh {
+	if m.lod != nil {
+		return m.lod
+	}
+	m.lod = NewVoxelMesh()
+	if m.count < 3 {
+		return m.lod
+	}
+	// Average color weighted by triangle area
+	var sum mgl32.Vec3
+	var total float32
+	for i := 0; i+voxelMeshStride*3 <= len(m.d); i += voxelMeshStride * 3 {
+		var ps [3]mgl32.Vec3
+		for j := range ps {
+			d := m.d[i+j*voxelMeshStride:]
+			ps[j] = mgl32.Vec3{
+				float32(binary.LittleEndian.Uint16(d[0:2])),
+				float32(binary.LittleEndian.Uint16(d[2:4])),
+				float32(binary.LittleEndian.Uint16(d[4:6])),
+			}
+		}
+		a := ps[1].Sub(ps[0]).Cross(ps[2].Sub(ps[0])).Len()
+		d := m.d[i:]
+		sum = sum.Add(mgl32.Vec3{
+			float32(d[6]),
+			float32(d[7]),
+			float32(d[8]),
+		}.Mul(a))
+		total += a
+	}
+	if total > 0 {
+		sum = sum.Mul(1 / total)
+	}
+	c := [4]uint8{uint8(sum[0]), uint8(sum[1]), uint8(sum[2]), 255}
+	p := [3]uint16{
+		uint16(m.bounds[0][0]),
+		uint16(m.bounds[0][1]),
+		uint16(m.bounds[0][2]),
+	}
+	d := [3]uint16{
+		uint16(m.bounds[1][0]) - p[0],
+		uint16(m.bounds[1][1]) - p[1],
+		uint16(m.bounds[1][2]) - p[2],
+	}
+	if d[0] == 0 || d[1] == 0 || d[2] == 0 {
+		return m.lod
+	}
+	for f := t.North; f <= t.Bottom; f++ {
+		AddFace[[4]uint8](p, d, 1, f, c, m.lod)
+	}
+	return m.lod
+}
+
+// BuildVoxCellMeshLOD builds a reduced version of the mesh built by
+// BuildVoxCellMesh in which every vox model is collapsed to its bounding box
+// filled with the average color of the model. The mesh is drawn the same way
+// and used for all reduced levels of detail. Note that the destination mesh is
+// not reset before faces are added. An error is returned under the same
+// conditions as BuildVoxCellMesh.
+func BuildVoxCellMeshLOD(v VoxelSource[t.Cell], defs []*t.Cube,
+	meshes func(t.VoxRef) *VoxelMesh, m *VoxelMesh) error {
+	return BuildVoxCellMesh(v, defs, func(r t.VoxRef) *VoxelMesh {
+		vm := meshes(r)
+		if vm == nil {
+			return nil
+		}
+		return vm.lodBox()
+	}, m)
+}
+
+// chunkLOD returns the level of detail to draw the chunk at for a camera at
+// position p, based on the distance from the camera to the nearest point of
+// the chunk.
+func (a *App) chunkLOD(d *ChunkDrawDescriptor, p mgl32.Vec3) int {
+	lo := d.CubeDD.Position
+	var n mgl32.Vec3
+	for i := range n {
+		n[i] = mgl32.Clamp(p[i], lo[i], lo[i]+16)
+	}
+	r := n.Sub(p).Len()
+	ret := 0
+	for i, dist := range a.LODDistances {
+		if dist <= 0 {
+			break
+		}
+		if d.lod > i {
+			dist -= lodHysteresis
+		} else {
+			dist += lodHysteresis
+		}
+		if r > dist {
+			ret = i + 1
+		}
+	}
+	return ret
+}
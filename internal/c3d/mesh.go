@@ -6,15 +6,18 @@ import "github.com/qbradq/cubit/internal/t"
 type Mesh[T any] interface {
 	// draw asks the mesh to draw itself.
 	draw(p *program)
-	// vert adds a vertex to the mesh.
-	vert(x, y, z, u, v uint8, i int, c T, f t.Facing)
+	// vert adds a vertex to the mesh. Positions and texture coordinates are
+	// in mesh units, which are cubes for cube meshes and voxels for voxel
+	// meshes.
+	vert(x, y, z, u, v uint16, i int, c T, f t.Facing)
 	// Reset resets the vertex data of the mesh.
 	Reset()
 }
 
 // AddFace adds the given face with the given position and dimensions to the
 // mesh.
-func AddFace[T any](p, d [3]uint8, uvd uint8, f t.Facing, c T, m Mesh[T]) {
+func AddFace[T any](p, d [3]uint16, uvd uint16, f t.Facing, c T,
+	m Mesh[T]) {
 	d[0] -= 1
 	d[1] -= 1
 	d[2] -= 1
@@ -89,15 +92,16 @@ func AddFace[T any](p, d [3]uint8, uvd uint8, f t.Facing, c T, m Mesh[T]) {
 }
 
 // AddCube adds all of the faces of the given cube definition.
-func AddCube(p, d [3]uint8, uvd uint8, f t.Facing, c *t.Cube, m *CubeMesh) {
+func AddCube(p, d [3]uint16, uvd uint16, f t.Facing, c *t.Cube,
+	m *CubeMesh) {
 	fn := func(face t.Facing) t.Facing {
 		return t.FacingMap[f][face]
 	}
-	n := [3]uint8{p[0], p[1], p[2]}
-	s := [3]uint8{p[0], p[1], p[2] + d[2] - 1}
-	e := [3]uint8{p[0] + d[0] - 1, p[1], p[2]}
-	w := [3]uint8{p[0], p[1], p[2]}
-	top := [3]uint8{p[0], p[1] + d[1] - 1, p[2]}
+	n := [3]uint16{p[0], p[1], p[2]}
+	s := [3]uint16{p[0], p[1], p[2] + d[2] - 1}
+	e := [3]uint16{p[0] + d[0] - 1, p[1], p[2]}
+	w := [3]uint16{p[0], p[1], p[2]}
+	top := [3]uint16{p[0], p[1] + d[1] - 1, p[2]}
 	b := p
 	AddFace(n, d, uvd, fn(t.North), t.CellForCube(c.Ref, f), m)
 	AddFace(s, d, uvd, fn(t.South), t.CellForCube(c.Ref, f), m)
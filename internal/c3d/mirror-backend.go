@@ -0,0 +1,290 @@
+package c3d
+
+import (
+	"image"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// MirrorBackend implements the Backend interface by forwarding every call to
+// a primary backend and mirroring it to a software backend. The software
+// backend only rasterises frames while Rasterize is set, and those frames are
+// returned by ReadPixels in place of the primary's. This is used to capture
+// frames the primary can not read back, such as those of a minimized window.
+// The software backend keeps a copy of every buffer and texture created, so
+// mirroring should only be enabled when such captures are needed.
+//
+// Attribute and uniform locations are resolved against the program in use,
+// so vertex arrays must be set up while the program they are drawn with is in
+// use, as the App does.
+type MirrorBackend struct {
+	Primary    Backend                    // Backend forwarded to
+	Software   *SoftwareBackend           // Backend mirrored to
+	Rasterize  bool                       // If true frames are rasterised by the software backend
+	rasterized bool                       // If true the current frame was rasterised by the software backend
+	program    uint32                     // Current primary program
+	programs   map[uint32]uint32          // Software programs by primary handle
+	vaos       map[uint32]uint32          // Software vertex arrays by primary handle
+	buffers    map[uint32]uint32          // Software buffers by primary handle
+	textures   map[uint32]uint32          // Software textures by primary handle
+	attribs    map[uint32]map[int32]int32 // Software attribute locations by primary program and location
+	uniforms   map[uint32]map[int32]int32 // Software uniform locations by primary program and location
+}
+
+// NewMirrorBackend returns a new backend forwarding to the primary backend
+// and mirroring to a new software backend of the given dimensions.
+func NewMirrorBackend(primary Backend, w, h int) *MirrorBackend {
+	return &MirrorBackend{
+		Primary:  primary,
+		Software: NewSoftwareBackend(w, h),
+		programs: map[uint32]uint32{},
+		vaos:     map[uint32]uint32{},
+		buffers:  map[uint32]uint32{},
+		textures: map[uint32]uint32{},
+		attribs:  map[uint32]map[int32]int32{},
+		uniforms: map[uint32]map[int32]int32{},
+	}
+}
+
+// attrib returns the software location of the primary attribute location l
+// of the current program. Locations following that of a matrix attribute
+// map to the following software locations.
+func (m *MirrorBackend) attrib(l int32) int32 {
+	locs := m.attribs[m.program]
+	for i := int32(0); i < 4; i++ {
+		if ret, found := locs[l-i]; found {
+			return ret + i
+		}
+	}
+	return -1
+}
+
+// uniform returns the software location of the primary uniform location l of
+// the current program.
+func (m *MirrorBackend) uniform(l int32) int32 {
+	if ret, found := m.uniforms[m.program][l]; found {
+		return ret
+	}
+	return -1
+}
+
+// NewProgram implements the Backend interface.
+func (m *MirrorBackend) NewProgram(name, vSrc, fSrc string) (uint32, error) {
+	sp, err := m.Software.NewProgram(name, vSrc, fSrc)
+	if err != nil {
+		return 0, err
+	}
+	p, err := m.Primary.NewProgram(name, vSrc, fSrc)
+	if err != nil {
+		m.Software.DeleteProgram(sp)
+		return 0, err
+	}
+	m.programs[p] = sp
+	m.attribs[p] = map[int32]int32{}
+	m.uniforms[p] = map[int32]int32{}
+	return p, nil
+}
+
+// DeleteProgram implements the Backend interface.
+func (m *MirrorBackend) DeleteProgram(p uint32) {
+	m.Primary.DeleteProgram(p)
+	m.Software.DeleteProgram(m.programs[p])
+	delete(m.programs, p)
+	delete(m.attribs, p)
+	delete(m.uniforms, p)
+}
+
+// UseProgram implements the Backend interface.
+func (m *MirrorBackend) UseProgram(p uint32) {
+	m.Primary.UseProgram(p)
+	m.Software.UseProgram(m.programs[p])
+	m.program = p
+}
+
+// AttribLocation implements the Backend interface.
+func (m *MirrorBackend) AttribLocation(p uint32, name string) int32 {
+	l := m.Primary.AttribLocation(p, name)
+	if l >= 0 && m.attribs[p] != nil {
+		m.attribs[p][l] = m.Software.AttribLocation(m.programs[p], name)
+	}
+	return l
+}
+
+// UniformLocation implements the Backend interface.
+func (m *MirrorBackend) UniformLocation(p uint32, name string) int32 {
+	l := m.Primary.UniformLocation(p, name)
+	if l >= 0 && m.uniforms[p] != nil {
+		m.uniforms[p][l] = m.Software.UniformLocation(m.programs[p], name)
+	}
+	return l
+}
+
+// Uniform1i implements the Backend interface.
+func (m *MirrorBackend) Uniform1i(l int32, v int32) {
+	m.Primary.Uniform1i(l, v)
+	m.Software.Uniform1i(m.uniform(l), v)
+}
+
+// Uniform1f implements the Backend interface.
+func (m *MirrorBackend) Uniform1f(l int32, v float32) {
+	m.Primary.Uniform1f(l, v)
+	m.Software.Uniform1f(m.uniform(l), v)
+}
+
+// Uniform1fv implements the Backend interface.
+func (m *MirrorBackend) Uniform1fv(l int32, v []float32) {
+	m.Primary.Uniform1fv(l, v)
+	m.Software.Uniform1fv(m.uniform(l), v)
+}
+
+// Uniform3f implements the Backend interface.
+func (m *MirrorBackend) Uniform3f(l int32, x, y, z float32) {
+	m.Primary.Uniform3f(l, x, y, z)
+	m.Software.Uniform3f(m.uniform(l), x, y, z)
+}
+
+// UniformMatrix4fv implements the Backend interface.
+func (m *MirrorBackend) UniformMatrix4fv(l int32, v mgl32.Mat4) {
+	m.Primary.UniformMatrix4fv(l, v)
+	m.Software.UniformMatrix4fv(m.uniform(l), v)
+}
+
+// NewVertexArray implements the Backend interface.
+func (m *MirrorBackend) NewVertexArray() uint32 {
+	id := m.Primary.NewVertexArray()
+	m.vaos[id] = m.Software.NewVertexArray()
+	return id
+}
+
+// DeleteVertexArray implements the Backend interface.
+func (m *MirrorBackend) DeleteVertexArray(id uint32) {
+	m.Primary.DeleteVertexArray(id)
+	m.Software.DeleteVertexArray(m.vaos[id])
+	delete(m.vaos, id)
+}
+
+// BindVertexArray implements the Backend interface.
+func (m *MirrorBackend) BindVertexArray(id uint32) {
+	m.Primary.BindVertexArray(id)
+	m.Software.BindVertexArray(m.vaos[id])
+}
+
+// NewBuffer implements the Backend interface.
+func (m *MirrorBackend) NewBuffer() uint32 {
+	id := m.Primary.NewBuffer()
+	m.buffers[id] = m.Software.NewBuffer()
+	return id
+}
+
+// DeleteBuffer implements the Backend interface.
+func (m *MirrorBackend) DeleteBuffer(id uint32) {
+	m.Primary.DeleteBuffer(id)
+	m.Software.DeleteBuffer(m.buffers[id])
+	delete(m.buffers, id)
+}
+
+// BindBuffer implements the Backend interface.
+func (m *MirrorBackend) BindBuffer(id uint32) {
+	m.Primary.BindBuffer(id)
+	m.Software.BindBuffer(m.buffers[id])
+}
+
+// BufferData implements the Backend interface.
+func (m *MirrorBackend) BufferData(d []byte, stream bool) {
+	m.Primary.BufferData(d, stream)
+	m.Software.BufferData(d, stream)
+}
+
+// VertexAttrib implements the Backend interface.
+func (m *MirrorBackend) VertexAttrib(l int32, size int, typ AttribType,
+	normalized bool, stride, offset int, divisor uint32) {
+	m.Primary.VertexAttrib(l, size, typ, normalized, stride, offset, divisor)
+	m.Software.VertexAttrib(m.attrib(l), size, typ, normalized, stride,
+		offset, divisor)
+}
+
+// VertexAttribConst implements the Backend interface.
+func (m *MirrorBackend) VertexAttribConst(l int32, v mgl32.Vec4) {
+	m.Primary.VertexAttribConst(l, v)
+	m.Software.VertexAttribConst(m.attrib(l), v)
+}
+
+// NewTexture implements the Backend interface.
+func (m *MirrorBackend) NewTexture(img *image.RGBA, linear bool) uint32 {
+	id := m.Primary.NewTexture(img, linear)
+	m.textures[id] = m.Software.NewTexture(img, linear)
+	return id
+}
+
+// UpdateTexture implements the Backend interface.
+func (m *MirrorBackend) UpdateTexture(id uint32, x, y int, img *image.RGBA) {
+	m.Primary.UpdateTexture(id, x, y, img)
+	m.Software.UpdateTexture(m.textures[id], x, y, img)
+}
+
+// DeleteTexture implements the Backend interface.
+func (m *MirrorBackend) DeleteTexture(id uint32) {
+	m.Primary.DeleteTexture(id)
+	m.Software.DeleteTexture(m.textures[id])
+	delete(m.textures, id)
+}
+
+// BindTexture implements the Backend interface.
+func (m *MirrorBackend) BindTexture(unit int, id uint32) {
+	m.Primary.BindTexture(unit, id)
+	m.Software.BindTexture(unit, m.textures[id])
+}
+
+// Clear implements the Backend interface. This starts a new frame, which is
+// rasterised by the software backend if Rasterize is set.
+func (m *MirrorBackend) Clear(c mgl32.Vec3) {
+	m.Primary.Clear(c)
+	m.rasterized = m.Rasterize
+	if m.rasterized {
+		m.Software.Clear(c)
+	}
+}
+
+// SetDepthTest implements the Backend interface.
+func (m *MirrorBackend) SetDepthTest(enabled bool) {
+	m.Primary.SetDepthTest(enabled)
+	m.Software.SetDepthTest(enabled)
+}
+
+// SetDepthMask implements the Backend interface.
+func (m *MirrorBackend) SetDepthMask(enabled bool) {
+	m.Primary.SetDepthMask(enabled)
+	m.Software.SetDepthMask(enabled)
+}
+
+// DrawArrays implements the Backend interface.
+func (m *MirrorBackend) DrawArrays(mode Primitive, first, count int32) {
+	m.Primary.DrawArrays(mode, first, count)
+	if m.rasterized {
+		m.Software.DrawArrays(mode, first, count)
+	}
+}
+
+// DrawArraysInstanced implements the Backend interface.
+func (m *MirrorBackend) DrawArraysInstanced(mode Primitive, first, count,
+	n int32) {
+	m.Primary.DrawArraysInstanced(mode, first, count, n)
+	if m.rasterized {
+		m.Software.DrawArraysInstanced(mode, first, count, n)
+	}
+}
+
+// Instancing implements the Backend interface. Instancing is supported if the
+// primary backend supports it.
+func (m *MirrorBackend) Instancing() bool {
+	return m.Primary.Instancing()
+}
+
+// ReadPixels implements the Backend interface. Frames rasterised by the
+// software backend are returned from it, all others from the primary.
+func (m *MirrorBackend) ReadPixels() *image.RGBA {
+	if m.rasterized {
+		return m.Software.ReadPixels()
+	}
+	return m.Primary.ReadPixels()
+}
@@ -1,7 +1,6 @@
 package c3d
 
 import (
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
@@ -10,22 +9,30 @@ import (
 type Part struct {
 	ID          string        // Unique ID of the part for animation references
 	Mesh        *VoxelMesh    // Voxel mesh for the part
+	Cube        *CubeMesh     // Cube mesh for the part, drawn in the same space as Mesh
 	Origin      mgl32.Vec3    // Center point of the part
 	Orientation t.Orientation // Current part orientation relative to the parent
+	Pose        t.Orientation // Bind pose orientation relative to the parent
+	Scale       mgl32.Vec3    // Scale of the part's mesh about the origin
 	Children    []*Part       // Child parts, if any
 }
 
-// draw draws the part relative to the given orientation.
-func (p *Part) draw(prg *program, o t.Orientation) {
+// collect calls fn for the part and all of its children that have a mesh with
+// the model matrix of the part relative to the given orientation. The matrix
+// transforms mesh vertexes in voxel units to world space.
+func (p *Part) collect(o t.Orientation, fn func(p *Part, mm mgl32.Mat4)) {
 	po := p.Orientation.Accumulate(o)
-	if p.Mesh != nil {
-		mm := po.TransformMatrix()
-		gl.UniformMatrix4fv(prg.uni("uModelMatrix"), 1, false, &mm[0])
-		gl.Uniform3f(prg.uni("uRotationPoint"),
-			p.Origin[0], p.Origin[1], p.Origin[2])
-		p.Mesh.draw(prg)
+	if p.Mesh != nil || p.Cube != nil {
+		mm := po.TransformMatrix().
+			Mul4(mgl32.Scale3D(
+				p.Scale[0]*t.VoxelScale,
+				p.Scale[1]*t.VoxelScale,
+				p.Scale[2]*t.VoxelScale,
+			)).
+			Mul4(mgl32.Translate3D(-p.Origin[0], -p.Origin[1], -p.Origin[2]))
+		fn(p, mm)
 	}
 	for _, c := range p.Children {
-		c.draw(prg, po)
+		c.collect(po, fn)
 	}
 }
@@ -0,0 +1,373 @@
+package c3d
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// MaxParticles is the maximum number of live particles. Particles created
+// past this limit are dropped.
+const MaxParticles = 4096
+
+// particleStride is the size of one particle vertex in bytes.
+const particleStride = 3*4 + 2*4 + 4*1 + 1*1 + 1*1 + 2
+
+// particleUntextured is the atlas page of untextured particle vertexes.
+const particleUntextured = 255
+
+// ParticleKind selects how the particles of an effect are drawn.
+type ParticleKind uint8
+
+const (
+	ParticleBillboard ParticleKind = iota // Quads facing the camera textured with a face or filled with a color
+	ParticleVoxel                         // Cubes filled with a color
+)
+
+// ParticleEffect describes the particles an emitter creates. Ranges are given
+// as minimum and maximum values from which each particle picks at random.
+type ParticleEffect struct {
+	Kind         ParticleKind  // How particles are drawn
+	Faces        []t.FaceIndex // Faces billboards are textured with, chosen at random
+	Colors       [][3]uint8    // Colors of particles, chosen at random, white if empty
+	FaceFraction float32       // Fraction of the width and height of the face shown by each billboard, zero shows the whole face
+	Burst        int           // Number of particles created at once by Emit
+	Rate         float32       // Particles created per second by emitters
+	Life         [2]float32    // Range of life times in seconds
+	Size         [2]float32    // Size in cubes at the start and end of life
+	Speed        [2]float32    // Range of initial speeds in cubes per second
+	Direction    mgl32.Vec3    // Initial direction of travel, up if zero
+	Spread       float32       // Angle in radians initial directions deviate from Direction by at most
+	Extents      mgl32.Vec3    // Half size of the box around the emitter particles are created in
+	Gravity      float32       // Downward acceleration in cubes per second squared, negative values rise
+	Drag         float32       // Fraction of velocity lost per second
+	Fade         bool          // If true particles fade out over their life
+	Collide      bool          // If true particles come to rest on solid cells
+}
+
+// ParticleEmitter creates the particles of an effect continuously at a
+// position in the world.
+type ParticleEmitter struct {
+	Effect   *ParticleEffect // Effect to create particles of
+	Position mgl32.Vec3      // Center of the emitter in world space
+	Faces    []t.FaceIndex   // Faces used in place of those of the effect, if any
+	Paused   bool            // If true no particles are created
+	acc      float32         // Particles owed since the last update
+}
+
+// particle is a single live particle.
+type particle struct {
+	e     *ParticleEffect // Effect that created the particle
+	p     mgl32.Vec3      // Position of the center in world space
+	v     mgl32.Vec3      // Velocity in cubes per second
+	age   float32         // Age in seconds
+	life  float32         // Life time in seconds
+	face  t.FaceIndex     // Face the particle is textured with
+	uv    mgl32.Vec2      // Top-left of the portion of the face shown in face units
+	color [3]uint8        // Color
+	d     float32         // Squared distance to the camera while sorting
+}
+
+// ParticleSystem simulates and draws particles created by emitters and
+// bursts.
+type ParticleSystem struct {
+	Solid     func(p mgl32.Vec3) bool // Returns true if p is within a solid cell, particles do not collide if nil
+	particles []particle              // Live particles
+	emitters  []*ParticleEmitter      // Continuous emitters
+	vao       uint32                  // Vertex Array Object ID
+	vbo       uint32                  // Vertex Buffer Object ID
+	count     int32                   // Vertex count
+	d         []byte                  // Vertex data
+	vbuf      [particleStride]byte    // Vertex buffer
+}
+
+// newParticleSystem returns a new particle system ready for use.
+func newParticleSystem() *ParticleSystem {
+	return &ParticleSystem{
+		vao: invalidVAO,
+		vbo: invalidVBO,
+	}
+}
+
+// Count returns the number of live particles.
+func (s *ParticleSystem) Count() int {
+	return len(s.particles)
+}
+
+// AddEmitter adds the emitter to the system.
+func (s *ParticleSystem) AddEmitter(e *ParticleEmitter) {
+	s.emitters = append(s.emitters, e)
+}
+
+// RemoveEmitter removes the emitter from the system. Particles already created
+// live out their lives.
+func (s *ParticleSystem) RemoveEmitter(e *ParticleEmitter) {
+	for i := 0; i < len(s.emitters); i++ {
+		if s.emitters[i] == e {
+			s.emitters[i] = s.emitters[len(s.emitters)-1]
+			s.emitters[len(s.emitters)-1] = nil
+			s.emitters = s.emitters[:len(s.emitters)-1]
+			return
+		}
+	}
+}
+
+// Emit creates a burst of particles of the effect around position p. If faces
+// is not empty billboards are textured with those faces in place of the
+// effect's, such as the faces of a broken cube.
+func (s *ParticleSystem) Emit(e *ParticleEffect, p mgl32.Vec3,
+	faces []t.FaceIndex) {
+	if e == nil {
+		return
+	}
+	for i := 0; i < e.Burst; i++ {
+		s.spawn(e, p, faces)
+	}
+}
+
+// Clear removes all particles and emitters.
+func (s *ParticleSystem) Clear() {
+	s.particles = s.particles[:0]
+	s.emitters = nil
+}
+
+// randRange returns a random value in the range r.
+func randRange(r [2]float32) float32 {
+	return r[0] + rand.Float32()*(r[1]-r[0])
+}
+
+// randDirection returns a random unit vector within angle spread of d.
+func randDirection(d mgl32.Vec3, spread float32) mgl32.Vec3 {
+	if d.Len() == 0 {
+		d = mgl32.Vec3{0, 1, 0}
+	}
+	d = d.Normalize()
+	// Uniform over the spherical cap around d
+	cosMax := float32(math.Cos(float64(min(spread, math.Pi))))
+	ct := 1 - rand.Float32()*(1-cosMax)
+	st := float32(math.Sqrt(float64(max(0, 1-ct*ct))))
+	phi := rand.Float64() * 2 * math.Pi
+	// Orthonormal basis around d
+	a := mgl32.Vec3{1, 0, 0}
+	if abs(d[0]) > 0.9 {
+		a = mgl32.Vec3{0, 1, 0}
+	}
+	u := d.Cross(a).Normalize()
+	w := d.Cross(u)
+	return d.Mul(ct).
+		Add(u.Mul(st * float32(math.Cos(phi)))).
+		Add(w.Mul(st * float32(math.Sin(phi))))
+}
+
+// spawn creates one particle of the effect around position p.
+func (s *ParticleSystem) spawn(e *ParticleEffect, p mgl32.Vec3,
+	faces []t.FaceIndex) {
+	if len(s.particles) >= MaxParticles {
+		return
+	}
+	ret := particle{
+		e:     e,
+		life:  randRange(e.Life),
+		face:  t.FaceIndexInvalid,
+		color: [3]uint8{255, 255, 255},
+	}
+	for i := range ret.p {
+		ret.p[i] = p[i] + (rand.Float32()*2-1)*e.Extents[i]
+	}
+	ret.v = randDirection(e.Direction, e.Spread).Mul(randRange(e.Speed))
+	if len(faces) == 0 {
+		faces = e.Faces
+	}
+	if e.Kind == ParticleBillboard && len(faces) > 0 {
+		ret.face = faces[rand.Intn(len(faces))]
+		if f := e.FaceFraction; f > 0 && f < 1 {
+			ret.uv = mgl32.Vec2{rand.Float32() * (1 - f),
+				rand.Float32() * (1 - f)}
+		}
+	}
+	if len(e.Colors) > 0 {
+		ret.color = e.Colors[rand.Intn(len(e.Colors))]
+	}
+	s.particles = append(s.particles, ret)
+}
+
+// update creates particles for all emitters and advances all particles by dt
+// seconds.
+func (s *ParticleSystem) update(dt float32) {
+	for _, e := range s.emitters {
+		if e.Paused || e.Effect == nil {
+			continue
+		}
+		e.acc += e.Effect.Rate * dt
+		for ; e.acc >= 1; e.acc-- {
+			s.spawn(e.Effect, e.Position, e.Faces)
+		}
+	}
+	for i := 0; i < len(s.particles); i++ {
+		p := &s.particles[i]
+		p.age += dt
+		if p.age >= p.life {
+			s.particles[i] = s.particles[len(s.particles)-1]
+			s.particles = s.particles[:len(s.particles)-1]
+			i--
+			continue
+		}
+		p.v[1] -= p.e.Gravity * dt
+		p.v = p.v.Mul(max(0, 1-p.e.Drag*dt))
+		if !p.e.Collide || s.Solid == nil {
+			p.p = p.p.Add(p.v.Mul(dt))
+			continue
+		}
+		// Move one axis at a time so particles slide along and come to rest
+		// on solid cells
+		for j := range p.p {
+			np := p.p
+			np[j] += p.v[j] * dt
+			if s.Solid(np) {
+				p.v[j] = 0
+				continue
+			}
+			p.p = np
+		}
+		if p.v[1] == 0 {
+			// Ground friction
+			p.v = p.v.Mul(max(0, 1-8*dt))
+		}
+	}
+}
+
+// vert adds a single vertex to the data buffer.
+func (s *ParticleSystem) vert(p mgl32.Vec3, uv mgl32.Vec2, c [4]uint8,
+	page uint8, light uint8) {
+	d := s.vbuf[:]
+	binary.LittleEndian.PutUint32(d[0:4], math.Float32bits(p[0]))
+	binary.LittleEndian.PutUint32(d[4:8], math.Float32bits(p[1]))
+	binary.LittleEndian.PutUint32(d[8:12], math.Float32bits(p[2]))
+	binary.LittleEndian.PutUint32(d[12:16], math.Float32bits(uv[0]))
+	binary.LittleEndian.PutUint32(d[16:20], math.Float32bits(uv[1]))
+	copy(d[20:24], c[:])
+	d[24] = page
+	d[25] = light
+	s.d = append(s.d, d...)
+	s.count++
+}
+
+// quad adds a single quad with corners given as top-left, top-right,
+// bottom-left, and bottom-right relative to the front of the quad.
+func (s *ParticleSystem) quad(q [4]mgl32.Vec3, uv [4]mgl32.Vec2, c [4]uint8,
+	page uint8, light uint8) {
+	for _, i := range []int{0, 1, 2, 2, 1, 3} {
+		s.vert(q[i], uv[i], c, page, light)
+	}
+}
+
+// build rebuilds the vertex data of all particles for a camera with view
+// matrix v at position cp, sorted back to front.
+func (s *ParticleSystem) build(v mgl32.Mat4, cp mgl32.Vec3) {
+	s.d = s.d[:0]
+	s.count = 0
+	for i := range s.particles {
+		s.particles[i].d = s.particles[i].p.Sub(cp).LenSqr()
+	}
+	sort.Slice(s.particles, func(i, j int) bool {
+		return s.particles[i].d > s.particles[j].d
+	})
+	// Camera right and up vectors in world space
+	right := mgl32.Vec3{v[0], v[4], v[8]}
+	up := mgl32.Vec3{v[1], v[5], v[9]}
+	for i := range s.particles {
+		p := &s.particles[i]
+		f := p.age / p.life
+		size := p.e.Size[0] + (p.e.Size[1]-p.e.Size[0])*f
+		c := [4]uint8{p.color[0], p.color[1], p.color[2], 255}
+		if p.e.Fade {
+			c[3] = toByte(1 - f)
+		}
+		if p.e.Kind == ParticleVoxel {
+			h := size / 2
+			b := t.AABB{
+				p.p.Sub(mgl32.Vec3{h, h, h}),
+				p.p.Add(mgl32.Vec3{h, h, h}),
+			}
+			for side := t.North; side <= t.Bottom; side++ {
+				q, _ := boxFace(b, side)
+				s.quad(q, [4]mgl32.Vec2{}, c, particleUntextured,
+					facingLightLevels[side])
+			}
+			continue
+		}
+		r := right.Mul(size / 2)
+		u := up.Mul(size / 2)
+		q := [4]mgl32.Vec3{
+			p.p.Sub(r).Add(u),
+			p.p.Add(r).Add(u),
+			p.p.Sub(r).Sub(u),
+			p.p.Add(r).Sub(u),
+		}
+		var uv [4]mgl32.Vec2
+		page := uint8(particleUntextured)
+		if p.face != t.FaceIndexInvalid {
+			x, y, z := p.face.ToAtlasXYZ()
+			page = uint8(z)
+			ff := p.e.FaceFraction
+			if ff <= 0 || ff > 1 {
+				ff = 1
+			}
+			u0 := (float32(x) + p.uv[0]) * t.PageStep
+			v0 := (float32(y) + p.uv[1]) * t.PageStep
+			u1 := u0 + ff*t.PageStep
+			v1 := v0 + ff*t.PageStep
+			uv = [4]mgl32.Vec2{{u0, v0}, {u1, v0}, {u0, v1}, {u1, v1}}
+		}
+		s.quad(q, uv, c, page, 255)
+	}
+}
+
+// draw draws all particles with the particle program, which must be in use.
+func (s *ParticleSystem) draw(prg *program, v mgl32.Mat4, cp mgl32.Vec3) {
+	if len(s.particles) == 0 {
+		return
+	}
+	s.build(v, cp)
+	b := prg.b
+	if s.vao == invalidVAO {
+		s.vao = b.NewVertexArray()
+	}
+	if s.vbo == invalidVBO {
+		var stride int = particleStride
+		var offset int = 0
+		s.vbo = b.NewBuffer()
+		b.BindVertexArray(s.vao)
+		b.BindBuffer(s.vbo)
+		prg.attrib("aVertexPosition", 3, AttribFloat, false, stride, offset)
+		offset += 3 * 4
+		prg.attrib("aVertexUV", 2, AttribFloat, false, stride, offset)
+		offset += 2 * 4
+		prg.attrib("aVertexColor", 4, AttribUByte, true, stride, offset)
+		offset += 4 * 1
+		prg.attrib("aAtlasPage", 1, AttribUByte, false, stride, offset)
+		offset += 1 * 1
+		prg.attrib("aVertexLightLevel", 1, AttribUByte, true, stride, offset)
+		offset += 1 * 1
+	}
+	b.BindVertexArray(s.vao)
+	b.BindBuffer(s.vbo)
+	b.BufferData(s.d, true)
+	b.DrawArrays(PrimitiveTriangles, 0, s.count)
+}
+
+// delete releases the GPU resources of the particle system.
+func (s *ParticleSystem) delete(b Backend) {
+	if s.vao != invalidVAO {
+		b.DeleteVertexArray(s.vao)
+		s.vao = invalidVAO
+	}
+	if s.vbo != invalidVBO {
+		b.DeleteBuffer(s.vbo)
+		s.vbo = invalidVBO
+	}
+}
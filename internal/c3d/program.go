@@ -1,27 +1,46 @@
 package c3d
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"path/filepath"
+	"path"
 	"strings"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/data"
 )
 
 // program manages a single GPU program.
 type program struct {
-	id      uint32  // ID of the program
-	vShader *shader // Vertex shader
-	fShader *shader // Fragment shader
+	b       Backend          // Backend the program was compiled with
+	id      uint32           // Backend handle of the program
+	name    string           // Name of the program
+	attribs map[string]int32 // Cache of attribute locations by name
+	unis    map[string]int32 // Cache of uniform locations by name
 }
 
-// loadProgram loads the named GLSL version 1.00 vertex+fragment shader program.
-func loadProgram(name string) (*program, error) {
-	d, err := data.FS.ReadFile(filepath.Join("glsl", name+".glsl"))
-	if err != nil {
-		return nil, err
+// readProgram reads the vertex and fragment shader sources of the named GLSL
+// version 1.00 program. The file glsl/<name>.glsl is read from the first of
+// the file systems that contains it, falling back to the embedded data.
+func readProgram(name string, srcs []fs.FS) (string, string, error) {
+	fp := path.Join("glsl", name+".glsl")
+	var d []byte
+	var err error
+	for _, src := range srcs {
+		if d, err = fs.ReadFile(src, fp); err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", fmt.Errorf("in glsl program %s: %s", name, err)
+		}
+		d = nil
+	}
+	if d == nil {
+		if d, err = data.FS.ReadFile(fp); err != nil {
+			return "", "", err
+		}
 	}
 	lines := strings.Split(string(d), "\n")
 	which := 0
@@ -40,59 +59,118 @@ func loadProgram(name string) (*program, error) {
 			case 2:
 				fSrc += line + "\n"
 			default:
-				return nil, fmt.Errorf(
+				return "", "", fmt.Errorf(
 					"in glsl program %s, no shader target given", name)
 			}
 		}
 	}
-	ret := &program{}
-	ret.vShader, err = newShader(vSrc, shaderTypeVertex)
+	return vSrc, fSrc, nil
+}
+
+// loadProgram loads the named GLSL version 1.00 vertex+fragment shader program
+// from the embedded data.
+func loadProgram(b Backend, name string) (*program, error) {
+	ret := &program{
+		b:    b,
+		name: name,
+	}
+	if err := ret.reload(nil); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// reload rebuilds the program from the sources found in srcs as described by
+// readProgram. If the sources fail to compile the error is returned and the
+// program is left unchanged.
+func (p *program) reload(srcs []fs.FS) error {
+	vSrc, fSrc, err := readProgram(p.name, srcs)
 	if err != nil {
-		return nil, fmt.Errorf("in glsl program %s, vertex shader: %s", name,
-			err)
+		return err
 	}
-	ret.fShader, err = newShader(fSrc, shaderTypeFragment)
+	id, err := p.b.NewProgram(p.name, vSrc, fSrc)
 	if err != nil {
-		return nil, fmt.Errorf("in glsl program %s, fragment shader: %s", name,
-			err)
+		return err
 	}
-	ret.id = gl.CreateProgram()
-	gl.AttachShader(ret.id, ret.vShader.id)
-	gl.AttachShader(ret.id, ret.fShader.id)
-	gl.LinkProgram(ret.id)
-	if err := getGlError(ret.id, gl.LINK_STATUS, gl.GetProgramiv,
-		gl.GetProgramInfoLog, "program:link"); err != nil {
-		return nil, err
+	if p.attribs != nil {
+		p.b.DeleteProgram(p.id)
 	}
-	return ret, nil
+	p.id = id
+	p.attribs = map[string]int32{}
+	p.unis = map[string]int32{}
+	return nil
 }
 
 // delete deletes the program.
 func (p *program) delete() {
-	p.vShader.delete()
-	p.fShader.delete()
-	gl.DeleteProgram(p.id)
+	p.b.DeleteProgram(p.id)
 }
 
 // use makes this program the active one.
 func (p *program) use() {
-	gl.UseProgram(p.id)
+	p.b.UseProgram(p.id)
 }
 
-// attr returns the id of a named shader attribute.
+// attr returns the id of a named shader attribute. Locations are cached so
+// the warning for a missing attribute is only logged once.
 func (p *program) attr(name string) int32 {
-	id := gl.GetAttribLocation(p.id, gl.Str(name+"\x00"))
+	if id, found := p.attribs[name]; found {
+		return id
+	}
+	id := p.b.AttribLocation(p.id, name)
 	if id < 0 {
-		log.Println("warning: unable to locate shader attribute \"" + name + "\"")
+		log.Printf("warning: unable to locate shader attribute \"%s\" in "+
+			"program %s", name, p.name)
 	}
+	p.attribs[name] = id
 	return id
 }
 
-// uni returns the id of a named shader uniform.
+// uni returns the id of a named shader uniform. Locations are cached so the
+// warning for a missing uniform is only logged once.
 func (p *program) uni(name string) int32 {
-	id := gl.GetUniformLocation(p.id, gl.Str(name+"\x00"))
+	if id, found := p.unis[name]; found {
+		return id
+	}
+	id := p.b.UniformLocation(p.id, name)
 	if id < 0 {
-		log.Println("warning: unable to locate shader uniform \"" + name + "\"")
+		log.Printf("warning: unable to locate shader uniform \"%s\" in "+
+			"program %s", name, p.name)
 	}
+	p.unis[name] = id
 	return id
 }
+
+// setInt sets the named integer or sampler uniform of the program, which
+// must be in use.
+func (p *program) setInt(name string, v int32) {
+	p.b.Uniform1i(p.uni(name), v)
+}
+
+// setFloat sets the named float uniform of the program, which must be in use.
+func (p *program) setFloat(name string, v float32) {
+	p.b.Uniform1f(p.uni(name), v)
+}
+
+// setFloats sets the named float array uniform of the program, which must be
+// in use.
+func (p *program) setFloats(name string, v []float32) {
+	p.b.Uniform1fv(p.uni(name), v)
+}
+
+// setVec3 sets the named vec3 uniform of the program, which must be in use.
+func (p *program) setVec3(name string, x, y, z float32) {
+	p.b.Uniform3f(p.uni(name), x, y, z)
+}
+
+// setMat4 sets the named mat4 uniform of the program, which must be in use.
+func (p *program) setMat4(name string, m mgl32.Mat4) {
+	p.b.UniformMatrix4fv(p.uni(name), m)
+}
+
+// attrib sources the named vertex attribute of the bound vertex array from
+// the bound buffer.
+func (p *program) attrib(name string, size int, typ AttribType,
+	normalized bool, stride, offset int) {
+	p.b.VertexAttrib(p.attr(name), size, typ, normalized, stride, offset, 0)
+}
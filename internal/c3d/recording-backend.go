@@ -0,0 +1,509 @@
+package c3d
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"maps"
+	"math"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// RecordedProgram describes a program compiled by a recording backend.
+type RecordedProgram struct {
+	Name           string         // Name of the program
+	VertexSource   string         // Vertex shader source
+	FragmentSource string         // Fragment shader source
+	Attribs        map[string]int // Number of locations used by each attribute by name
+	Uniforms       map[string]any // Current value of each uniform by name, nil if never set
+}
+
+// RecordedAttrib describes one vertex attribute location of a vertex array.
+type RecordedAttrib struct {
+	Name       string     // Name of the attribute
+	Column     int        // Column of matrix attributes sourced by this location
+	Buffer     uint32     // Buffer the attribute is sourced from
+	Size       int        // Number of components
+	Type       AttribType // Type of the components
+	Normalized bool       // If true integer components are normalized to 0-1 or -1-1
+	Stride     int        // Distance between vertexes in bytes
+	Offset     int        // Offset of the first vertex in bytes
+	Divisor    uint32     // Instances per step, zero to step once per vertex
+}
+
+// RecordedDraw describes a single draw call made to a recording backend.
+type RecordedDraw struct {
+	Program     string         // Name of the program used
+	Mode        Primitive      // Primitive drawn
+	First       int32          // First vertex drawn
+	Count       int32          // Number of vertexes drawn
+	Instances   int32          // Number of instances drawn, 1 for non-instanced draws
+	VertexArray uint32         // Vertex array drawn
+	Uniforms    map[string]any // Uniform values of the program at the time of the draw
+	Textures    map[int]uint32 // Textures bound by unit at the time of the draw
+	DepthTest   bool           // If true depth testing was enabled
+	DepthMask   bool           // If true depth writes were enabled
+}
+
+// recordedLocation is the target of a location handed out by a recording
+// backend.
+type recordedLocation struct {
+	program uint32 // Program handle
+	name    string // Attribute or uniform name
+	column  int    // Column of matrix attributes
+}
+
+// RecordingBackend implements the Backend interface without a GPU. It tracks
+// the contents of all objects created through it and records every draw call
+// for assertions in tests. Locations are unique across all programs.
+type RecordingBackend struct {
+	Programs     map[uint32]*RecordedProgram         // Programs by handle
+	Buffers      map[uint32][]byte                   // Buffer contents by handle
+	VertexArrays map[uint32]map[int32]RecordedAttrib // Attributes of vertex arrays by location
+	Textures     map[uint32]*image.RGBA              // Texture contents by handle
+	Draws        []RecordedDraw                      // Draw calls made since the last Reset
+	Clears       int                                 // Number of calls to Clear
+	ClearColor   mgl32.Vec3                          // Color of the last clear
+	DepthTest    bool                                // If true depth testing is enabled
+	DepthMask    bool                                // If true depth writes are enabled
+	NoInstancing bool                                // If true Instancing returns false
+	nextHandle   uint32                              // Next object handle
+	attribs      map[int32]recordedLocation          // Attribute location targets
+	uniforms     map[int32]recordedLocation          // Uniform location targets
+	constants    map[int32]mgl32.Vec4                // Constant attribute values by location
+	attribLocs   map[uint32]map[string]int32         // Attribute locations by program and name
+	uniformLocs  map[uint32]map[string]int32         // Uniform locations by program and name
+	program      uint32                              // Current program
+	vao          uint32                              // Bound vertex array
+	vbo          uint32                              // Bound buffer
+	units        map[int]uint32                      // Bound textures by unit
+}
+
+// NewRecordingBackend returns a new recording backend ready for use.
+func NewRecordingBackend() *RecordingBackend {
+	return &RecordingBackend{
+		Programs:     map[uint32]*RecordedProgram{},
+		Buffers:      map[uint32][]byte{},
+		VertexArrays: map[uint32]map[int32]RecordedAttrib{},
+		Textures:     map[uint32]*image.RGBA{},
+		DepthTest:    true,
+		DepthMask:    true,
+		nextHandle:   1,
+		attribs:      map[int32]recordedLocation{},
+		uniforms:     map[int32]recordedLocation{},
+		constants:    map[int32]mgl32.Vec4{},
+		attribLocs:   map[uint32]map[string]int32{},
+		uniformLocs:  map[uint32]map[string]int32{},
+		units:        map[int]uint32{},
+	}
+}
+
+// Reset forgets all recorded draw calls and clears.
+func (r *RecordingBackend) Reset() {
+	r.Draws = r.Draws[:0]
+	r.Clears = 0
+}
+
+// DrawsOf returns all recorded draw calls made with the named program.
+func (r *RecordingBackend) DrawsOf(program string) []RecordedDraw {
+	var ret []RecordedDraw
+	for _, d := range r.Draws {
+		if d.Program == program {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// Attrib returns the components of the named attribute of the vertex array
+// for the given vertex and instance, converted to floats. Matrix attributes
+// return all columns in order. Attributes not sourced by the vertex array
+// return the constant values set with VertexAttribConst. False is returned if
+// the attribute has no value or the data is out of range.
+func (r *RecordingBackend) Attrib(vao uint32, name string, vertex,
+	instance int) ([]float32, bool) {
+	var cols []RecordedAttrib
+	for _, a := range r.VertexArrays[vao] {
+		if a.Name == name {
+			cols = append(cols, a)
+		}
+	}
+	if len(cols) == 0 {
+		return r.constAttrib(name)
+	}
+	var ret []float32
+	for c := 0; c < len(cols); c++ {
+		var a *RecordedAttrib
+		for i := range cols {
+			if cols[i].Column == c {
+				a = &cols[i]
+			}
+		}
+		if a == nil {
+			return nil, false
+		}
+		i := vertex
+		if a.Divisor > 0 {
+			i = instance / int(a.Divisor)
+		}
+		d := r.Buffers[a.Buffer]
+		o := a.Offset + i*a.Stride
+		if o < 0 || o+a.Size*a.Type.Size() > len(d) {
+			return nil, false
+		}
+		for j := 0; j < a.Size; j++ {
+			ret = append(ret, decodeComponent(d[o:], a.Type, a.Normalized))
+			o += a.Type.Size()
+		}
+	}
+	return ret, true
+}
+
+// constAttrib returns the constant value of the named attribute with all
+// columns of matrix attributes in order.
+func (r *RecordingBackend) constAttrib(name string) ([]float32, bool) {
+	var cols []mgl32.Vec4
+	for l, v := range r.constants {
+		loc := r.attribs[l]
+		if loc.name != name {
+			continue
+		}
+		for len(cols) <= loc.column {
+			cols = append(cols, mgl32.Vec4{})
+		}
+		cols[loc.column] = v
+	}
+	if len(cols) == 0 {
+		return nil, false
+	}
+	var ret []float32
+	for _, c := range cols {
+		ret = append(ret, c[:]...)
+	}
+	return ret, true
+}
+
+// decodeComponent decodes a single attribute component at the start of d.
+func decodeComponent(d []byte, typ AttribType, normalized bool) float32 {
+	switch typ {
+	case AttribShort:
+		v := float32(int16(binary.LittleEndian.Uint16(d)))
+		if normalized {
+			return max(v/math.MaxInt16, -1)
+		}
+		return v
+	case AttribUShort:
+		v := float32(binary.LittleEndian.Uint16(d))
+		if normalized {
+			return v / math.MaxUint16
+		}
+		return v
+	case AttribFloat:
+		return math.Float32frombits(binary.LittleEndian.Uint32(d))
+	}
+	v := float32(d[0])
+	if normalized {
+		return v / math.MaxUint8
+	}
+	return v
+}
+
+// handle returns a new object handle.
+func (r *RecordingBackend) handle() uint32 {
+	ret := r.nextHandle
+	r.nextHandle++
+	return ret
+}
+
+// NewProgram implements the Backend interface.
+func (r *RecordingBackend) NewProgram(name, vSrc, fSrc string) (uint32,
+	error) {
+	if strings.TrimSpace(vSrc) == "" || strings.TrimSpace(fSrc) == "" {
+		return 0, fmt.Errorf("in glsl program %s, missing shader source",
+			name)
+	}
+	id := r.handle()
+	p := &RecordedProgram{
+		Name:           name,
+		VertexSource:   vSrc,
+		FragmentSource: fSrc,
+		Attribs:        map[string]int{},
+		Uniforms:       map[string]any{},
+	}
+	for _, d := range declarations(vSrc, "attribute") {
+		p.Attribs[d.name] = d.locations
+	}
+	for _, src := range []string{vSrc, fSrc} {
+		for _, d := range declarations(src, "uniform") {
+			p.Uniforms[d.name] = nil
+		}
+	}
+	r.Programs[id] = p
+	r.attribLocs[id] = map[string]int32{}
+	r.uniformLocs[id] = map[string]int32{}
+	return id, nil
+}
+
+// DeleteProgram implements the Backend interface.
+func (r *RecordingBackend) DeleteProgram(p uint32) {
+	delete(r.Programs, p)
+	delete(r.attribLocs, p)
+	delete(r.uniformLocs, p)
+}
+
+// UseProgram implements the Backend interface.
+func (r *RecordingBackend) UseProgram(p uint32) {
+	r.program = p
+}
+
+// location returns the location of the named attribute or uniform of the
+// program from locs, allocating n consecutive locations in targets on first
+// use.
+func (r *RecordingBackend) location(p uint32, name string, n int,
+	locs map[uint32]map[string]int32,
+	targets map[int32]recordedLocation) int32 {
+	if l, found := locs[p][name]; found {
+		return l
+	}
+	l := int32(r.handle())
+	for i := 0; i < n; i++ {
+		targets[l+int32(i)] = recordedLocation{
+			program: p,
+			name:    name,
+			column:  i,
+		}
+	}
+	// Reserve the remaining locations of matrix attributes
+	r.nextHandle += uint32(n - 1)
+	locs[p][name] = l
+	return l
+}
+
+// AttribLocation implements the Backend interface.
+func (r *RecordingBackend) AttribLocation(p uint32, name string) int32 {
+	prg := r.Programs[p]
+	if prg == nil {
+		return -1
+	}
+	n, found := prg.Attribs[name]
+	if !found {
+		return -1
+	}
+	return r.location(p, name, n, r.attribLocs, r.attribs)
+}
+
+// UniformLocation implements the Backend interface.
+func (r *RecordingBackend) UniformLocation(p uint32, name string) int32 {
+	prg := r.Programs[p]
+	if prg == nil {
+		return -1
+	}
+	if _, found := prg.Uniforms[name]; !found {
+		return -1
+	}
+	return r.location(p, name, 1, r.uniformLocs, r.uniforms)
+}
+
+// setUniform records the value of the uniform at location l for the current
+// program.
+func (r *RecordingBackend) setUniform(l int32, v any) {
+	loc, found := r.uniforms[l]
+	if !found {
+		return
+	}
+	if p := r.Programs[r.program]; p != nil {
+		p.Uniforms[loc.name] = v
+	}
+}
+
+// Uniform1i implements the Backend interface.
+func (r *RecordingBackend) Uniform1i(l int32, v int32) {
+	r.setUniform(l, v)
+}
+
+// Uniform1f implements the Backend interface.
+func (r *RecordingBackend) Uniform1f(l int32, v float32) {
+	r.setUniform(l, v)
+}
+
+// Uniform1fv implements the Backend interface.
+func (r *RecordingBackend) Uniform1fv(l int32, v []float32) {
+	r.setUniform(l, append([]float32(nil), v...))
+}
+
+// Uniform3f implements the Backend interface.
+func (r *RecordingBackend) Uniform3f(l int32, x, y, z float32) {
+	r.setUniform(l, mgl32.Vec3{x, y, z})
+}
+
+// UniformMatrix4fv implements the Backend interface.
+func (r *RecordingBackend) UniformMatrix4fv(l int32, m mgl32.Mat4) {
+	r.setUniform(l, m)
+}
+
+// NewVertexArray implements the Backend interface.
+func (r *RecordingBackend) NewVertexArray() uint32 {
+	id := r.handle()
+	r.VertexArrays[id] = map[int32]RecordedAttrib{}
+	return id
+}
+
+// DeleteVertexArray implements the Backend interface.
+func (r *RecordingBackend) DeleteVertexArray(id uint32) {
+	delete(r.VertexArrays, id)
+}
+
+// BindVertexArray implements the Backend interface.
+func (r *RecordingBackend) BindVertexArray(id uint32) {
+	r.vao = id
+}
+
+// NewBuffer implements the Backend interface.
+func (r *RecordingBackend) NewBuffer() uint32 {
+	id := r.handle()
+	r.Buffers[id] = nil
+	return id
+}
+
+// DeleteBuffer implements the Backend interface.
+func (r *RecordingBackend) DeleteBuffer(id uint32) {
+	delete(r.Buffers, id)
+}
+
+// BindBuffer implements the Backend interface.
+func (r *RecordingBackend) BindBuffer(id uint32) {
+	r.vbo = id
+}
+
+// BufferData implements the Backend interface.
+func (r *RecordingBackend) BufferData(d []byte, stream bool) {
+	if _, found := r.Buffers[r.vbo]; !found {
+		return
+	}
+	r.Buffers[r.vbo] = append(r.Buffers[r.vbo][:0], d...)
+}
+
+// VertexAttrib implements the Backend interface.
+func (r *RecordingBackend) VertexAttrib(l int32, size int, typ AttribType,
+	normalized bool, stride, offset int, divisor uint32) {
+	loc, found := r.attribs[l]
+	attribs := r.VertexArrays[r.vao]
+	if !found || attribs == nil {
+		return
+	}
+	if stride == 0 {
+		stride = size * typ.Size()
+	}
+	attribs[l] = RecordedAttrib{
+		Name:       loc.name,
+		Column:     loc.column,
+		Buffer:     r.vbo,
+		Size:       size,
+		Type:       typ,
+		Normalized: normalized,
+		Stride:     stride,
+		Offset:     offset,
+		Divisor:    divisor,
+	}
+}
+
+// VertexAttribConst implements the Backend interface.
+func (r *RecordingBackend) VertexAttribConst(l int32, v mgl32.Vec4) {
+	if _, found := r.attribs[l]; !found {
+		return
+	}
+	if attribs := r.VertexArrays[r.vao]; attribs != nil {
+		delete(attribs, l)
+	}
+	r.constants[l] = v
+}
+
+// NewTexture implements the Backend interface.
+func (r *RecordingBackend) NewTexture(img *image.RGBA, linear bool) uint32 {
+	id := r.handle()
+	t := image.NewRGBA(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()))
+	draw.Draw(t, t.Rect, img, img.Rect.Min, draw.Src)
+	r.Textures[id] = t
+	return id
+}
+
+// UpdateTexture implements the Backend interface.
+func (r *RecordingBackend) UpdateTexture(id uint32, x, y int,
+	img *image.RGBA) {
+	t := r.Textures[id]
+	if t == nil {
+		return
+	}
+	draw.Draw(t, image.Rect(x, y, x+img.Rect.Dx(), y+img.Rect.Dy()), img,
+		img.Rect.Min, draw.Src)
+}
+
+// DeleteTexture implements the Backend interface.
+func (r *RecordingBackend) DeleteTexture(id uint32) {
+	delete(r.Textures, id)
+}
+
+// BindTexture implements the Backend interface.
+func (r *RecordingBackend) BindTexture(unit int, id uint32) {
+	r.units[unit] = id
+}
+
+// Clear implements the Backend interface.
+func (r *RecordingBackend) Clear(c mgl32.Vec3) {
+	r.Clears++
+	r.ClearColor = c
+}
+
+// SetDepthTest implements the Backend interface.
+func (r *RecordingBackend) SetDepthTest(enabled bool) {
+	r.DepthTest = enabled
+}
+
+// SetDepthMask implements the Backend interface.
+func (r *RecordingBackend) SetDepthMask(enabled bool) {
+	r.DepthMask = enabled
+}
+
+// DrawArrays implements the Backend interface.
+func (r *RecordingBackend) DrawArrays(mode Primitive, first, count int32) {
+	r.DrawArraysInstanced(mode, first, count, 1)
+}
+
+// DrawArraysInstanced implements the Backend interface.
+func (r *RecordingBackend) DrawArraysInstanced(mode Primitive, first, count,
+	n int32) {
+	if count < 1 || n < 1 {
+		return
+	}
+	d := RecordedDraw{
+		Mode:        mode,
+		First:       first,
+		Count:       count,
+		Instances:   n,
+		VertexArray: r.vao,
+		Uniforms:    map[string]any{},
+		Textures:    maps.Clone(r.units),
+		DepthTest:   r.DepthTest,
+		DepthMask:   r.DepthMask,
+	}
+	if p := r.Programs[r.program]; p != nil {
+		d.Program = p.Name
+		d.Uniforms = maps.Clone(p.Uniforms)
+	}
+	r.Draws = append(r.Draws, d)
+}
+
+// Instancing implements the Backend interface. Instancing is supported unless
+// NoInstancing is set.
+func (r *RecordingBackend) Instancing() bool {
+	return !r.NoInstancing
+}
+
+// ReadPixels implements the Backend interface. The recording backend has no
+// color buffer so nil is always returned.
+func (r *RecordingBackend) ReadPixels() *image.RGBA {
+	return nil
+}
@@ -0,0 +1,158 @@
+package c3d
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// skyKey describes the colors and light of the sky at one time of day.
+type skyKey struct {
+	time    float32    // Time of day of the key
+	zenith  mgl32.Vec3 // Color of the sky straight up
+	horizon mgl32.Vec3 // Color of the sky at the horizon, also used for fog
+	light   float32    // Sky light intensity in the range 0-1
+}
+
+// skyKeys are the sky keys for a full day in order of time. The first and
+// last keys must match so the cycle wraps smoothly.
+var skyKeys = []skyKey{
+	{0.00, mgl32.Vec3{0.01, 0.01, 0.05}, mgl32.Vec3{0.03, 0.04, 0.10}, 0.20},
+	{0.20, mgl32.Vec3{0.01, 0.01, 0.05}, mgl32.Vec3{0.03, 0.04, 0.10}, 0.20},
+	{0.25, mgl32.Vec3{0.15, 0.20, 0.45}, mgl32.Vec3{0.95, 0.55, 0.30}, 0.55},
+	{0.32, mgl32.Vec3{0.20, 0.45, 0.90}, mgl32.Vec3{0.60, 0.80, 1.00}, 1.00},
+	{0.68, mgl32.Vec3{0.20, 0.45, 0.90}, mgl32.Vec3{0.60, 0.80, 1.00}, 1.00},
+	{0.75, mgl32.Vec3{0.15, 0.20, 0.45}, mgl32.Vec3{0.95, 0.45, 0.25}, 0.55},
+	{0.80, mgl32.Vec3{0.01, 0.01, 0.05}, mgl32.Vec3{0.03, 0.04, 0.10}, 0.20},
+	{1.00, mgl32.Vec3{0.01, 0.01, 0.05}, mgl32.Vec3{0.03, 0.04, 0.10}, 0.20},
+}
+
+// skyVerts are the vertexes of the full-screen quad the sky is drawn on in
+// clip space.
+var skyVerts = []float32{
+	-1, -1, -1, 1, 1, -1,
+	-1, 1, 1, 1, 1, -1,
+}
+
+// Sky renders the sky gradient, sun and moon for a time of day and provides
+// the sky light and fog parameters used by the 3D shaders.
+type Sky struct {
+	Time      float32    // Time of day in the range 0-1, 0 being midnight and 0.5 noon
+	FogStart  float32    // Distance from the camera fog begins in world units
+	FogEnd    float32    // Distance from the camera fog is opaque in world units
+	SunColor  mgl32.Vec3 // Color of the sun disk
+	MoonColor mgl32.Vec3 // Color of the moon disk
+	b         Backend    // Backend the buffers were created with
+	vao       uint32     // Vertex Array Object ID
+	vbo       uint32     // Vertex Buffer Object ID
+}
+
+// NewSky returns a new sky at dawn ready for use.
+func NewSky() *Sky {
+	return &Sky{
+		Time:      t.DawnTime,
+		FogStart:  48,
+		FogEnd:    96,
+		SunColor:  mgl32.Vec3{1.0, 0.95, 0.8},
+		MoonColor: mgl32.Vec3{0.8, 0.85, 0.9},
+		vao:       invalidVAO,
+		vbo:       invalidVBO,
+	}
+}
+
+// key returns the sky key for the current time of day interpolated between
+// the surrounding keys.
+func (s *Sky) key() skyKey {
+	tod := s.Time - float32(math.Floor(float64(s.Time)))
+	for i := 1; i < len(skyKeys); i++ {
+		b := skyKeys[i]
+		if tod > b.time {
+			continue
+		}
+		a := skyKeys[i-1]
+		f := (tod - a.time) / (b.time - a.time)
+		return skyKey{
+			time:    tod,
+			zenith:  lerp(a.zenith, b.zenith, f),
+			horizon: lerp(a.horizon, b.horizon, f),
+			light:   a.light + (b.light-a.light)*f,
+		}
+	}
+	return skyKeys[len(skyKeys)-1]
+}
+
+// SunDirection returns the unit vector pointing toward the sun in world
+// space. The sun rises in the east at dawn and sets in the west at dusk. The
+// moon is always opposite the sun.
+func (s *Sky) SunDirection() mgl32.Vec3 {
+	a := float64((s.Time - t.DawnTime) / (t.DuskTime - t.DawnTime) * math.Pi)
+	return mgl32.Vec3{
+		float32(math.Cos(a)),
+		float32(math.Sin(a)),
+		0.25,
+	}.Normalize()
+}
+
+// Light returns the intensity of the sky light in the range 0-1.
+func (s *Sky) Light() float32 {
+	return s.key().light
+}
+
+// FogColor returns the color of the fog, which matches the horizon.
+func (s *Sky) FogColor() mgl32.Vec3 {
+	return s.key().horizon
+}
+
+// bind sets the sky light and fog uniforms of the program, which must be in
+// use.
+func (s *Sky) bind(p *program) {
+	k := s.key()
+	p.setFloat("uSkyLight", k.light)
+	p.setVec3("uFogColor", k.horizon[0], k.horizon[1], k.horizon[2])
+	p.setFloat("uFogStart", s.FogStart)
+	p.setFloat("uFogEnd", max(s.FogEnd, s.FogStart+1))
+}
+
+// draw draws the sky behind everything else with the given projection and
+// view matrixes.
+func (s *Sky) draw(p *program, pMat, vMat mgl32.Mat4) {
+	b := p.b
+	p.use()
+	if s.vao == invalidVAO {
+		s.b = b
+		s.vao = b.NewVertexArray()
+		s.vbo = b.NewBuffer()
+		b.BindVertexArray(s.vao)
+		b.BindBuffer(s.vbo)
+		b.BufferData(float32Bytes(skyVerts), false)
+		p.attrib("aVertexPosition", 2, AttribFloat, false, 2*4, 0)
+	}
+	k := s.key()
+	// Only the rotation of the view matters to the sky
+	vMat.SetCol(3, mgl32.Vec4{0, 0, 0, 1})
+	im := pMat.Mul4(vMat).Inv()
+	sun := s.SunDirection()
+	p.setMat4("uInverseMatrix", im)
+	p.setVec3("uZenithColor", k.zenith[0], k.zenith[1], k.zenith[2])
+	p.setVec3("uHorizonColor", k.horizon[0], k.horizon[1], k.horizon[2])
+	p.setVec3("uSunDirection", sun[0], sun[1], sun[2])
+	p.setVec3("uSunColor", s.SunColor[0], s.SunColor[1], s.SunColor[2])
+	p.setVec3("uMoonColor", s.MoonColor[0], s.MoonColor[1], s.MoonColor[2])
+	b.SetDepthTest(false)
+	b.SetDepthMask(false)
+	b.BindVertexArray(s.vao)
+	b.DrawArrays(PrimitiveTriangles, 0, int32(len(skyVerts)/2))
+	b.SetDepthMask(true)
+	b.SetDepthTest(true)
+}
+
+// delete releases the GPU resources of the sky.
+func (s *Sky) delete() {
+	if s.vao != invalidVAO {
+		s.b.DeleteVertexArray(s.vao)
+		s.b.DeleteBuffer(s.vbo)
+		s.vao = invalidVAO
+		s.vbo = invalidVBO
+	}
+}
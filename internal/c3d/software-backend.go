@@ -0,0 +1,337 @@
+package c3d
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// softwareVertex is a vertex of a primitive after the vertex stage.
+type softwareVertex struct {
+	p mgl32.Vec4 // Clip space position
+	v []float32  // Varyings
+}
+
+// SoftwareBackend implements the Backend interface with a CPU rasteriser that
+// renders into an image. Only the programs with a Go port in
+// softwareShaders are rasterised, draws with other programs are recorded but
+// not drawn. The image has no alpha channel, all pixels are opaque. The
+// draws recorded are those since the last call to Clear.
+type SoftwareBackend struct {
+	*RecordingBackend
+	Image  *image.RGBA      // Color buffer
+	depth  []float32        // Depth buffer
+	linear map[uint32]bool  // Textures sampled with linear filtering
+	vs     []softwareVertex // Vertexes of the current instance
+}
+
+// NewSoftwareBackend returns a new software backend that renders into an image
+// of the given dimensions.
+func NewSoftwareBackend(w, h int) *SoftwareBackend {
+	ret := &SoftwareBackend{
+		RecordingBackend: NewRecordingBackend(),
+		Image:            image.NewRGBA(image.Rect(0, 0, w, h)),
+		depth:            make([]float32, w*h),
+		linear:           map[uint32]bool{},
+	}
+	ret.Clear(mgl32.Vec3{})
+	return ret
+}
+
+// NewTexture implements the Backend interface.
+func (s *SoftwareBackend) NewTexture(img *image.RGBA, linear bool) uint32 {
+	id := s.RecordingBackend.NewTexture(img, linear)
+	s.linear[id] = linear
+	return id
+}
+
+// DeleteTexture implements the Backend interface.
+func (s *SoftwareBackend) DeleteTexture(id uint32) {
+	s.RecordingBackend.DeleteTexture(id)
+	delete(s.linear, id)
+}
+
+// Clear implements the Backend interface.
+func (s *SoftwareBackend) Clear(c mgl32.Vec3) {
+	s.Reset()
+	s.RecordingBackend.Clear(c)
+	p := color.RGBA{
+		R: toByte(c[0]),
+		G: toByte(c[1]),
+		B: toByte(c[2]),
+		A: 255,
+	}
+	for i := 0; i < len(s.Image.Pix); i += 4 {
+		s.Image.Pix[i+0] = p.R
+		s.Image.Pix[i+1] = p.G
+		s.Image.Pix[i+2] = p.B
+		s.Image.Pix[i+3] = p.A
+	}
+	for i := range s.depth {
+		s.depth[i] = 1
+	}
+}
+
+// DrawArrays implements the Backend interface.
+func (s *SoftwareBackend) DrawArrays(mode Primitive, first, count int32) {
+	s.DrawArraysInstanced(mode, first, count, 1)
+}
+
+// DrawArraysInstanced implements the Backend interface.
+func (s *SoftwareBackend) DrawArraysInstanced(mode Primitive, first, count,
+	n int32) {
+	nDraws := len(s.Draws)
+	s.RecordingBackend.DrawArraysInstanced(mode, first, count, n)
+	if len(s.Draws) == nDraws {
+		return
+	}
+	d := &s.Draws[len(s.Draws)-1]
+	sh, found := softwareShaders[d.Program]
+	if !found {
+		return
+	}
+	c := &shaderContext{
+		s: s,
+		d: d,
+	}
+	for c.instance = 0; c.instance < int(n); c.instance++ {
+		// Vertex stage
+		s.vs = s.vs[:0]
+		for i := first; i < first+count; i++ {
+			c.vertex = int(i)
+			v := softwareVertex{
+				v: make([]float32, sh.varyings),
+			}
+			v.p = sh.vertex(c, v.v)
+			s.vs = append(s.vs, v)
+		}
+		// Primitive assembly and rasterisation
+		switch mode {
+		case PrimitiveTriangles:
+			for i := 0; i+2 < len(s.vs); i += 3 {
+				s.triangle(c, sh, s.vs[i], s.vs[i+1], s.vs[i+2])
+			}
+		case PrimitiveLines:
+			for i := 0; i+1 < len(s.vs); i += 2 {
+				s.line(c, sh, s.vs[i], s.vs[i+1])
+			}
+		case PrimitivePoints:
+			for _, v := range s.vs {
+				s.point(c, sh, v)
+			}
+		}
+	}
+}
+
+// ReadPixels implements the Backend interface.
+func (s *SoftwareBackend) ReadPixels() *image.RGBA {
+	ret := image.NewRGBA(image.Rect(0, 0, s.Image.Rect.Dx(),
+		s.Image.Rect.Dy()))
+	draw.Draw(ret, ret.Rect, s.Image, s.Image.Rect.Min, draw.Src)
+	return ret
+}
+
+// clipNear clips the polygon against the near plane of clip space.
+func clipNear(vs []softwareVertex) []softwareVertex {
+	var ret []softwareVertex
+	for i := range vs {
+		a := vs[i]
+		b := vs[(i+1)%len(vs)]
+		da := a.p[2] + a.p[3]
+		db := b.p[2] + b.p[3]
+		if da >= 0 {
+			ret = append(ret, a)
+		}
+		if (da >= 0) != (db >= 0) {
+			ret = append(ret, lerpVertex(a, b, da/(da-db)))
+		}
+	}
+	return ret
+}
+
+// lerpVertex returns the vertex f of the way from a to b.
+func lerpVertex(a, b softwareVertex, f float32) softwareVertex {
+	ret := softwareVertex{
+		p: a.p.Add(b.p.Sub(a.p).Mul(f)),
+		v: make([]float32, len(a.v)),
+	}
+	for i := range a.v {
+		ret.v[i] = a.v[i] + (b.v[i]-a.v[i])*f
+	}
+	return ret
+}
+
+// window returns the window coordinates of the clip space position with the
+// origin at the top left of the image, the depth in the range 0-1 and one
+// over w.
+func (s *SoftwareBackend) window(p mgl32.Vec4) (x, y, z, iw float32) {
+	iw = 1 / p[3]
+	w := float32(s.Image.Rect.Dx())
+	h := float32(s.Image.Rect.Dy())
+	x = (p[0]*iw + 1) / 2 * w
+	y = (1 - p[1]*iw) / 2 * h
+	z = (p[2]*iw + 1) / 2
+	return x, y, z, iw
+}
+
+// triangle clips and rasterises one triangle.
+func (s *SoftwareBackend) triangle(c *shaderContext, sh *softwareShader, a,
+	b, d softwareVertex) {
+	poly := clipNear([]softwareVertex{a, b, d})
+	for i := 1; i+1 < len(poly); i++ {
+		s.rasterTriangle(c, sh, [3]softwareVertex{
+			poly[0], poly[i], poly[i+1],
+		})
+	}
+}
+
+// isTopLeft returns true if the edge from a to b is a top or left edge of a
+// triangle with a positive area in image coordinates. Pixels exactly on
+// these edges belong to the triangle so pixels on shared edges are drawn
+// once.
+func isTopLeft(ax, ay, bx, by float32) bool {
+	return (ay == by && bx > ax) || by < ay
+}
+
+// rasterTriangle rasterises a triangle in front of the near plane.
+func (s *SoftwareBackend) rasterTriangle(c *shaderContext,
+	sh *softwareShader, vs [3]softwareVertex) {
+	var x, y, z, iw [3]float32
+	for i, v := range vs {
+		x[i], y[i], z[i], iw[i] = s.window(v.p)
+	}
+	// The front face is clockwise with the Y axis up, which gives a positive
+	// area with the Y axis down, and back faces are culled.
+	area := (x[1]-x[0])*(y[2]-y[0]) - (x[2]-x[0])*(y[1]-y[0])
+	if area <= 0 {
+		return
+	}
+	r := s.Image.Rect
+	minX := max(int(math.Floor(float64(min(x[0], x[1], x[2])))), r.Min.X)
+	maxX := min(int(math.Ceil(float64(max(x[0], x[1], x[2])))), r.Max.X-1)
+	minY := max(int(math.Floor(float64(min(y[0], y[1], y[2])))), r.Min.Y)
+	maxY := min(int(math.Ceil(float64(max(y[0], y[1], y[2])))), r.Max.Y-1)
+	var tl [3]bool
+	for i := 0; i < 3; i++ {
+		j := (i + 1) % 3
+		tl[i] = isTopLeft(x[i], y[i], x[j], y[j])
+	}
+	v := make([]float32, sh.varyings)
+	for py := minY; py <= maxY; py++ {
+		for px := minX; px <= maxX; px++ {
+			cx := float32(px) + 0.5
+			cy := float32(py) + 0.5
+			var e [3]float32
+			inside := true
+			for i := 0; i < 3 && inside; i++ {
+				j := (i + 1) % 3
+				e[i] = (x[j]-x[i])*(cy-y[i]) - (y[j]-y[i])*(cx-x[i])
+				inside = e[i] > 0 || (e[i] == 0 && tl[i])
+			}
+			if !inside {
+				continue
+			}
+			// Barycentric weight of each vertex is the edge opposite it
+			b0 := e[1] / area
+			b1 := e[2] / area
+			b2 := e[0] / area
+			pz := b0*z[0] + b1*z[1] + b2*z[2]
+			piw := b0*iw[0] + b1*iw[1] + b2*iw[2]
+			for i := range v {
+				v[i] = (b0*vs[0].v[i]*iw[0] + b1*vs[1].v[i]*iw[1] +
+					b2*vs[2].v[i]*iw[2]) / piw
+			}
+			s.fragment(c, sh, px, py, pz, v)
+		}
+	}
+}
+
+// line clips and rasterises a one pixel wide line.
+func (s *SoftwareBackend) line(c *shaderContext, sh *softwareShader, a,
+	b softwareVertex) {
+	da := a.p[2] + a.p[3]
+	db := b.p[2] + b.p[3]
+	if da < 0 && db < 0 {
+		return
+	}
+	if da < 0 {
+		a = lerpVertex(a, b, da/(da-db))
+	} else if db < 0 {
+		b = lerpVertex(a, b, da/(da-db))
+	}
+	ax, ay, az, aiw := s.window(a.p)
+	bx, by, bz, biw := s.window(b.p)
+	n := int(math.Ceil(float64(max(abs(bx-ax), abs(by-ay)))))
+	n = max(n, 1)
+	v := make([]float32, sh.varyings)
+	for i := 0; i <= n; i++ {
+		f := float32(i) / float32(n)
+		piw := aiw + (biw-aiw)*f
+		for j := range v {
+			v[j] = (a.v[j]*aiw*(1-f) + b.v[j]*biw*f) / piw
+		}
+		px := int(math.Floor(float64(ax + (bx-ax)*f)))
+		py := int(math.Floor(float64(ay + (by-ay)*f)))
+		s.fragment(c, sh, px, py, az+(bz-az)*f, v)
+	}
+}
+
+// point rasterises a square point of the shader's point size.
+func (s *SoftwareBackend) point(c *shaderContext, sh *softwareShader,
+	v softwareVertex) {
+	if v.p[2]+v.p[3] < 0 {
+		return
+	}
+	x, y, z, _ := s.window(v.p)
+	h := sh.pointSize / 2
+	for py := int(math.Floor(float64(y - h))); float32(py) < y+h; py++ {
+		for px := int(math.Floor(float64(x - h))); float32(px) < x+h; px++ {
+			s.fragment(c, sh, px, py, z, v.v)
+		}
+	}
+}
+
+// fragment runs the fragment stage for one pixel and applies the depth test,
+// depth write and blending.
+func (s *SoftwareBackend) fragment(c *shaderContext, sh *softwareShader, x,
+	y int, z float32, v []float32) {
+	if !image.Pt(x, y).In(s.Image.Rect) {
+		return
+	}
+	// Depth is clamped rather than clipped against the far plane so geometry
+	// on the far plane, like the sky, is not lost to rounding.
+	z = mgl32.Clamp(z, 0, 1)
+	di := (y-s.Image.Rect.Min.Y)*s.Image.Rect.Dx() + x - s.Image.Rect.Min.X
+	if c.d.DepthTest && z > s.depth[di] {
+		return
+	}
+	fc, discard := sh.fragment(c, v)
+	if discard {
+		return
+	}
+	if c.d.DepthTest && c.d.DepthMask {
+		s.depth[di] = z
+	}
+	i := s.Image.PixOffset(x, y)
+	a := mgl32.Clamp(fc[3], 0, 1)
+	for j := 0; j < 3; j++ {
+		dst := float32(s.Image.Pix[i+j]) / 255
+		s.Image.Pix[i+j] = toByte(fc[j]*a + dst*(1-a))
+	}
+}
+
+// toByte converts a color component in the range 0-1 to a byte.
+func toByte(v float32) uint8 {
+	return uint8(mgl32.Clamp(v, 0, 1)*255 + 0.5)
+}
+
+// abs returns the absolute value of v.
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,378 @@
+package c3d
+
+import (
+	"image"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// softwareShader is a Go port of one of the GLSL programs for the software
+// backend.
+type softwareShader struct {
+	varyings  int     // Number of varying floats
+	pointSize float32 // Size of points in pixels
+	// vertex returns the clip space position of the current vertex and
+	// writes the varyings to v.
+	vertex func(c *shaderContext, v []float32) mgl32.Vec4
+	// fragment returns the color of a fragment with the interpolated
+	// varyings v, or true if the fragment is discarded.
+	fragment func(c *shaderContext, v []float32) (mgl32.Vec4, bool)
+}
+
+// shaderContext provides the inputs of a shader stage.
+type shaderContext struct {
+	s        *SoftwareBackend // Backend
+	d        *RecordedDraw    // Draw call being rasterised
+	vertex   int              // Index of the current vertex
+	instance int              // Index of the current instance
+}
+
+// attrib returns the components of the named attribute for the current
+// vertex, or zeros if the attribute is not sourced.
+func (c *shaderContext) attrib(name string) []float32 {
+	v, ok := c.s.Attrib(c.d.VertexArray, name, c.vertex, c.instance)
+	if !ok {
+		return make([]float32, 16)
+	}
+	return v
+}
+
+// attrib3 returns the named vec3 attribute for the current vertex.
+func (c *shaderContext) attrib3(name string) mgl32.Vec3 {
+	v := c.attrib(name)
+	return mgl32.Vec3{v[0], v[1], v[2]}
+}
+
+// float returns the named float uniform.
+func (c *shaderContext) float(name string) float32 {
+	v, _ := c.d.Uniforms[name].(float32)
+	return v
+}
+
+// floats returns the element i of the named float array uniform.
+func (c *shaderContext) floats(name string, i int) float32 {
+	v, _ := c.d.Uniforms[name].([]float32)
+	if i < 0 || i >= len(v) {
+		return 0
+	}
+	return v[i]
+}
+
+// int returns the named integer or sampler uniform.
+func (c *shaderContext) int(name string) int {
+	v, _ := c.d.Uniforms[name].(int32)
+	return int(v)
+}
+
+// vec3 returns the named vec3 uniform.
+func (c *shaderContext) vec3(name string) mgl32.Vec3 {
+	v, _ := c.d.Uniforms[name].(mgl32.Vec3)
+	return v
+}
+
+// mat4 returns the named mat4 uniform.
+func (c *shaderContext) mat4(name string) mgl32.Mat4 {
+	v, _ := c.d.Uniforms[name].(mgl32.Mat4)
+	return v
+}
+
+// texture samples the texture bound to the unit of the named sampler uniform
+// at the texture coordinates u, v, clamping to the edge.
+func (c *shaderContext) texture(sampler string, u, v float32) mgl32.Vec4 {
+	id := c.d.Textures[c.int(sampler)]
+	img := c.s.Textures[id]
+	if img == nil {
+		return mgl32.Vec4{0, 0, 0, 1}
+	}
+	w := float32(img.Rect.Dx())
+	h := float32(img.Rect.Dy())
+	if !c.s.linear[id] {
+		return texel(img, int(floor(u*w)), int(floor(v*h)))
+	}
+	x := u*w - 0.5
+	y := v*h - 0.5
+	x0 := floor(x)
+	y0 := floor(y)
+	fx := x - x0
+	fy := y - y0
+	ix := int(x0)
+	iy := int(y0)
+	t := texel(img, ix, iy).Mul((1 - fx) * (1 - fy))
+	t = t.Add(texel(img, ix+1, iy).Mul(fx * (1 - fy)))
+	t = t.Add(texel(img, ix, iy+1).Mul((1 - fx) * fy))
+	return t.Add(texel(img, ix+1, iy+1).Mul(fx * fy))
+}
+
+// atlas samples the face atlas page bound to the uAtlas sampler uniforms.
+func (c *shaderContext) atlas(page, u, v float32) mgl32.Vec4 {
+	switch {
+	case page < 0.5:
+		return c.texture("uAtlas0", u, v)
+	case page < 1.5:
+		return c.texture("uAtlas1", u, v)
+	case page < 2.5:
+		return c.texture("uAtlas2", u, v)
+	}
+	return c.texture("uAtlas3", u, v)
+}
+
+// fog returns the fog factor for the eye space position.
+func (c *shaderContext) fog(ep mgl32.Vec4) float32 {
+	s := c.float("uFogStart")
+	e := c.float("uFogEnd")
+	return mgl32.Clamp((ep.Vec3().Len()-s)/(e-s), 0, 1)
+}
+
+// fogged returns the lit color mixed with the fog color.
+func (c *shaderContext) fogged(color mgl32.Vec3, light,
+	fog float32) mgl32.Vec4 {
+	color = lerp(color.Mul(light*c.float("uSkyLight")), c.vec3("uFogColor"),
+		fog)
+	return color.Vec4(1)
+}
+
+// texel returns the texel at x, y clamped to the edge of the image in the
+// range 0-1.
+func texel(img *image.RGBA, x, y int) mgl32.Vec4 {
+	x = min(max(x, 0), img.Rect.Dx()-1) + img.Rect.Min.X
+	y = min(max(y, 0), img.Rect.Dy()-1) + img.Rect.Min.Y
+	i := img.PixOffset(x, y)
+	return mgl32.Vec4{
+		float32(img.Pix[i+0]) / 255,
+		float32(img.Pix[i+1]) / 255,
+		float32(img.Pix[i+2]) / 255,
+		float32(img.Pix[i+3]) / 255,
+	}
+}
+
+// floor returns the greatest integer value less than or equal to v.
+func floor(v float32) float32 {
+	return float32(math.Floor(float64(v)))
+}
+
+// fract returns the fractional part of v.
+func fract(v float32) float32 {
+	return v - floor(v)
+}
+
+// cubeMeshFragment is the fragment stage shared by the cube mesh programs.
+// The varyings are the light level, uv, atlas xy, atlas page and fog.
+func cubeMeshFragment(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+	const atlasScale = 1.0 / 128.0
+	u := (v[3] + fract(v[1])) * atlasScale
+	w := (v[4] + fract(v[2])) * atlasScale
+	color := c.atlas(v[5], u, w)
+	if color[3] < 0.5 {
+		return color, true
+	}
+	return c.fogged(color.Vec3(), v[0], v[6]), false
+}
+
+// cubeMeshVaryings writes the varyings shared by the cube mesh programs.
+func cubeMeshVaryings(c *shaderContext, v []float32) {
+	const unitScale = 1.0 / 16.0
+	uv := c.attrib("aVertexUV")
+	a := c.attrib("aAtlasXYZ")
+	v[0] = c.attrib("aVertexLightLevel")[0]
+	v[1] = uv[0] * unitScale
+	v[2] = uv[1] * unitScale
+	v[3] = a[0]
+	v[4] = a[1]
+	v[5] = a[2]
+}
+
+// voxelFragment is the fragment stage shared by the voxel mesh programs. The
+// varyings are the color, light level and fog.
+func voxelFragment(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+	return c.fogged(mgl32.Vec3{v[0], v[1], v[2]}, v[3], v[4]), false
+}
+
+// softwareShaders are the software shaders by program name.
+var softwareShaders = map[string]*softwareShader{
+	// cube-mesh.glsl
+	"cube-mesh": {
+		varyings: 7,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			cubeMeshVaryings(c, v)
+			p := c.attrib3("aVertexPosition").Mul(1.0 / 16.0)
+			ep := c.mat4("uModelViewMatrix").Mul4x1(p.Vec4(1))
+			v[6] = c.fog(ep)
+			return c.mat4("uProjectionMatrix").Mul4x1(ep)
+		},
+		fragment: cubeMeshFragment,
+	},
+	// cube-mesh-icon.glsl
+	"cube-mesh-icon": {
+		varyings: 7,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			cubeMeshVaryings(c, v)
+			p := c.attrib3("aVertexPosition").Mul(1.0 / 16.0).
+				Sub(c.vec3("uOrigin"))
+			p = c.mat4("uModelMatrix").Mul4x1(p.Vec4(1)).Vec3()
+			p = p.Add(c.vec3("uPosition"))
+			return c.mat4("uProjectionMatrix").Mul4x1(p.Vec4(1))
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			const atlasScale = 1.0 / 128.0
+			u := (v[3] + fract(v[1])) * atlasScale
+			w := (v[4] + fract(v[2])) * atlasScale
+			color := c.atlas(v[5], u, w)
+			if color[3] < 0.5 {
+				return color, true
+			}
+			return color.Vec3().Mul(v[0]).Vec4(1), false
+		},
+	},
+	// voxel-mesh.glsl
+	"voxel-mesh": {
+		varyings: 5,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			color := c.attrib3("aVertexColor")
+			copy(v, color[:])
+			f := int(c.attrib("aVertexFacing")[0])
+			v[3] = c.floats("uLightLevels", c.int("uFacing")*6+f)
+			p := c.attrib3("aVertexPosition").Sub(mgl32.Vec3{8, 8, 8}).
+				Mul(1.0 / 16.0)
+			ep := c.mat4("uViewMatrix").Mul4(c.mat4("uModelMatrix")).
+				Mul4x1(p.Vec4(1))
+			v[4] = c.fog(ep)
+			return c.mat4("uProjectionMatrix").Mul4x1(ep)
+		},
+		fragment: voxelFragment,
+	},
+	// model-mesh.glsl
+	"model-mesh": {
+		varyings: 5,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			color := c.attrib3("aVertexColor")
+			copy(v, color[:])
+			v[3] = c.floats("uLightLevels", int(c.attrib("aVertexFacing")[0]))
+			var mm mgl32.Mat4
+			copy(mm[:], c.attrib("aModelMatrix"))
+			p := c.attrib3("aVertexPosition")
+			ep := c.mat4("uViewMatrix").Mul4(mm).Mul4x1(p.Vec4(1))
+			v[4] = c.fog(ep)
+			return c.mat4("uProjectionMatrix").Mul4x1(ep)
+		},
+		fragment: voxelFragment,
+	},
+	// wireframe.glsl
+	"wireframe": {
+		varyings:  3,
+		pointSize: 32,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			color := c.attrib3("aVertexColor")
+			copy(v, color[:])
+			p := c.attrib3("aVertexPosition")
+			return c.mat4("uProjectionMatrix").
+				Mul4(c.mat4("uModelViewMatrix")).Mul4x1(p.Vec4(1))
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			return mgl32.Vec4{v[0], v[1], v[2], 1}, false
+		},
+	},
+	// sky.glsl
+	"sky": {
+		varyings: 3,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			p := c.attrib("aVertexPosition")
+			d := c.mat4("uInverseMatrix").Mul4x1(mgl32.Vec4{p[0], p[1], 1, 1})
+			dir := d.Vec3().Mul(1 / d[3])
+			copy(v, dir[:])
+			return mgl32.Vec4{p[0], p[1], 1, 1}
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			const sunSize = 0.9990
+			const moonSize = 0.9995
+			d := mgl32.Vec3{v[0], v[1], v[2]}.Normalize()
+			zenith := c.vec3("uZenithColor")
+			horizon := c.vec3("uHorizonColor")
+			sunDir := c.vec3("uSunDirection")
+			sunColor := c.vec3("uSunColor")
+			h := mgl32.Clamp(d[1], 0, 1)
+			color := lerp(horizon, zenith,
+				float32(math.Sqrt(float64(h))))
+			sun := d.Dot(sunDir)
+			color = color.Add(sunColor.Mul(float32(
+				math.Pow(float64(max(sun, 0)), 64)) * 0.35))
+			if sun > sunSize {
+				color = sunColor
+			}
+			if d.Dot(sunDir.Mul(-1)) > moonSize {
+				color = c.vec3("uMoonColor")
+			}
+			if d[1] < 0 {
+				color = horizon
+			}
+			return color.Vec4(1), false
+		},
+	},
+	// text.glsl
+	"text": {
+		varyings: 5,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			uv := c.attrib("aVertexUV")
+			color := c.attrib3("aVertexColor")
+			v[0] = uv[0] / 64
+			v[1] = uv[1] / 32
+			copy(v[2:], color[:])
+			p := c.attrib("aVertexPosition")
+			pos := mgl32.Vec3{p[0], p[1], 0}.Add(c.vec3("uPosition"))
+			return c.mat4("uProjectionMatrix").Mul4x1(pos.Vec4(1))
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			f := c.texture("uFont", v[0], v[1])
+			return mgl32.Vec4{f[0] * v[2], f[1] * v[3], f[2] * v[4], f[3]},
+				false
+		},
+	},
+	// particle.glsl
+	"particle": {
+		varyings: 9,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			uv := c.attrib("aVertexUV")
+			color := c.attrib("aVertexColor")
+			v[0] = uv[0]
+			v[1] = uv[1]
+			copy(v[2:6], color[:4])
+			v[6] = c.attrib("aAtlasPage")[0]
+			v[7] = c.attrib("aVertexLightLevel")[0]
+			p := c.attrib3("aVertexPosition")
+			ep := c.mat4("uViewMatrix").Mul4x1(p.Vec4(1))
+			v[8] = c.fog(ep)
+			return c.mat4("uProjectionMatrix").Mul4x1(ep)
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			color := mgl32.Vec4{v[2], v[3], v[4], v[5]}
+			// Untextured particles use an atlas page past the last
+			if v[6] < 3.5 {
+				t := c.atlas(v[6], v[0], v[1])
+				if t[3] < 0.5 {
+					return color, true
+				}
+				color = mgl32.Vec4{color[0] * t[0], color[1] * t[1],
+					color[2] * t[2], color[3]}
+			}
+			ret := c.fogged(color.Vec3(), v[7], v[8])
+			ret[3] = color[3]
+			return ret, false
+		},
+	},
+	// ui.glsl
+	"ui": {
+		varyings: 3,
+		vertex: func(c *shaderContext, v []float32) mgl32.Vec4 {
+			uv := c.attrib("aVertexUV")
+			v[0] = uv[0] / 128
+			v[1] = uv[1] / 128
+			v[2] = c.attrib("aAtlasPage")[0]
+			p := c.attrib("aVertexPosition")
+			pos := mgl32.Vec3{p[0], p[1], 0}.Add(c.vec3("uPosition"))
+			return c.mat4("uProjectionMatrix").Mul4x1(pos.Vec4(1))
+		},
+		fragment: func(c *shaderContext, v []float32) (mgl32.Vec4, bool) {
+			return c.atlas(v[2], v[0], v[1]), false
+		},
+	},
+}
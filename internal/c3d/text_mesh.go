@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/qbradq/cubit/internal/t"
 )
 
@@ -88,40 +87,35 @@ func (text *TextMesh) Print(x, y int, c [3]uint8, s string, args ...any) {
 }
 
 func (t *TextMesh) draw(prg *program) {
+	b := prg.b
 	if t.vao == invalidVAO {
-		gl.GenVertexArrays(1, &t.vao)
+		t.vao = b.NewVertexArray()
 	}
+	// Configure buffer attributes
+	var stride int = 2*2 + 2*1 + 3*1
 	if t.vbo == invalidVBO {
-		gl.GenBuffers(1, &t.vbo)
-		// Configure buffer attributes
-		var stride int32 = 2*2 + 2*1 + 3*1
 		var offset int = 0
-		gl.BindVertexArray(t.vao)
-		gl.BindBuffer(gl.ARRAY_BUFFER, t.vbo)
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexPosition")),
-			2, gl.SHORT, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexPosition")))
+		t.vbo = b.NewBuffer()
+		b.BindVertexArray(t.vao)
+		b.BindBuffer(t.vbo)
+		prg.attrib("aVertexPosition", 2, AttribShort, false, stride, offset)
 		offset += 2 * 2
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexUV")),
-			2, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexUV")))
+		prg.attrib("aVertexUV", 2, AttribUByte, false, stride, offset)
 		offset += 2 * 1
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexColor")),
-			3, gl.UNSIGNED_BYTE, true, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexColor")))
+		prg.attrib("aVertexColor", 3, AttribUByte, true, stride, offset)
 		offset += 3 * 1
 	}
 	if t.vboDirty {
 		if len(t.d) > 0 {
-			gl.BindVertexArray(t.vao)
-			gl.BindBuffer(gl.ARRAY_BUFFER, t.vbo)
-			gl.BufferData(gl.ARRAY_BUFFER, len(t.d), gl.Ptr(t.d), gl.STATIC_DRAW)
+			b.BindVertexArray(t.vao)
+			b.BindBuffer(t.vbo)
+			b.BufferData(t.d, false)
 		}
 		t.vboDirty = false
 	}
 	if len(t.d) <= 0 {
 		return
 	}
-	gl.BindVertexArray(t.vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(t.d)))
+	b.BindVertexArray(t.vao)
+	b.DrawArrays(PrimitiveTriangles, 0, int32(len(t.d)/stride))
 }
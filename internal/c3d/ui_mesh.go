@@ -3,7 +3,6 @@ package c3d
 import (
 	"encoding/binary"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
@@ -25,7 +24,7 @@ type UIMesh struct {
 	vao      uint32                    // Vertex buffer array ID
 	vbo      uint32                    // Vertex buffer object ID
 	vboDirty bool                      // If true, the VBO needs to be updated
-	vbuf     [6]byte                   // Vertex buffer
+	vbuf     [7]byte                   // Vertex buffer
 }
 
 // NewUIMesh creates a new UIMesh ready for use.
@@ -66,12 +65,13 @@ func (e *UIMesh) Reset(ui, text bool) {
 }
 
 // vert packs one vertex into the mesh.
-func (e *UIMesh) vert(x, y, u, v int) {
+func (e *UIMesh) vert(x, y, u, v, p int) {
 	d := e.vbuf[:]
 	binary.LittleEndian.PutUint16(d[0:2], uint16(int16(x)))
 	binary.LittleEndian.PutUint16(d[2:4], uint16(int16(y)))
 	d[4] = byte(u)
 	d[5] = byte(v)
+	d[6] = byte(p)
 	e.d = append(e.d, d...)
 	e.count++
 }
@@ -82,14 +82,14 @@ func (e *UIMesh) Tile(x, y int, i t.FaceIndex) {
 	b := t - vsTileDims
 	l := x
 	r := l + vsTileDims
-	u, v := i.ToAtlasXY()
-	//XY  U   V
-	e.vert(l, t, u, v)     // TL
-	e.vert(r, t, u+1, v)   // TR
-	e.vert(l, b, u, v+1)   // BL
-	e.vert(l, b, u, v+1)   // BL
-	e.vert(r, t, u+1, v)   // TR
-	e.vert(r, b, u+1, v+1) // BR
+	u, v, p := i.ToAtlasXYZ()
+	//XY  U   V    P
+	e.vert(l, t, u, v, p)     // TL
+	e.vert(r, t, u+1, v, p)   // TR
+	e.vert(l, b, u, v+1, p)   // BL
+	e.vert(l, b, u, v+1, p)   // BL
+	e.vert(r, t, u+1, v, p)   // TR
+	e.vert(r, b, u+1, v+1, p) // BR
 	e.vboDirty = true
 }
 
@@ -100,14 +100,14 @@ func (e *UIMesh) Scaled(x, y, w, h int, i t.FaceIndex) {
 	b := t - h
 	l := x
 	r := l + w
-	u, v := i.ToAtlasXY()
-	//XY  U   V
-	e.vert(l, t, u, v)     // TL
-	e.vert(r, t, u+1, v)   // TR
-	e.vert(l, b, u, v+1)   // BL
-	e.vert(l, b, u, v+1)   // BL
-	e.vert(r, t, u+1, v)   // TR
-	e.vert(r, b, u+1, v+1) // BR
+	u, v, p := i.ToAtlasXYZ()
+	//XY  U   V    P
+	e.vert(l, t, u, v, p)     // TL
+	e.vert(r, t, u+1, v, p)   // TR
+	e.vert(l, b, u, v+1, p)   // BL
+	e.vert(l, b, u, v+1, p)   // BL
+	e.vert(r, t, u+1, v, p)   // TR
+	e.vert(r, b, u+1, v+1, p) // BR
 	e.vboDirty = true
 }
 
@@ -163,13 +163,13 @@ func (e *UIMesh) CubeMeshIcon(x, y, w, h, d int, c *t.Cube, f t.Facing,
 		return ret
 	}
 	AddCube(
-		[3]uint8{0, 0, 0},
-		[3]uint8{
-			uint8(w),
-			uint8(h),
-			uint8(d),
+		[3]uint16{0, 0, 0},
+		[3]uint16{
+			uint16(w),
+			uint16(h),
+			uint16(d),
 		},
-		uint8(hd), f,
+		uint16(hd), f,
 		c,
 		ret.Mesh,
 	)
@@ -183,31 +183,30 @@ func (e *UIMesh) draw(prg *program) {
 	if e.Hidden {
 		return
 	}
+	b := prg.b
 	if e.vao == invalidVAO || e.vbo == invalidVBO {
-		gl.GenVertexArrays(1, &e.vao)
-		gl.GenBuffers(1, &e.vbo)
+		e.vao = b.NewVertexArray()
+		e.vbo = b.NewBuffer()
 		// Configure buffer attributes
-		var stride int32 = 2*2 + 2*1
+		var stride int = 2*2 + 2*1 + 1*1
 		var offset int = 0
-		gl.BindVertexArray(e.vao)
-		gl.BindBuffer(gl.ARRAY_BUFFER, e.vbo)
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexPosition")),
-			2, gl.SHORT, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexPosition")))
+		b.BindVertexArray(e.vao)
+		b.BindBuffer(e.vbo)
+		prg.attrib("aVertexPosition", 2, AttribShort, false, stride, offset)
 		offset += 2 * 2
-		gl.VertexAttribPointerWithOffset(uint32(prg.attr("aVertexUV")),
-			2, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(prg.attr("aVertexUV")))
+		prg.attrib("aVertexUV", 2, AttribUByte, false, stride, offset)
 		offset += 2 * 1
+		prg.attrib("aAtlasPage", 1, AttribUByte, false, stride, offset)
+		offset += 1 * 1
 	}
 	if e.vboDirty {
 		if len(e.d) > 0 {
-			gl.BindVertexArray(e.vao)
-			gl.BindBuffer(gl.ARRAY_BUFFER, e.vbo)
-			gl.BufferData(gl.ARRAY_BUFFER, len(e.d), gl.Ptr(e.d), gl.STATIC_DRAW)
+			b.BindVertexArray(e.vao)
+			b.BindBuffer(e.vbo)
+			b.BufferData(e.d, false)
 		}
 		e.vboDirty = false
 	}
-	gl.BindVertexArray(e.vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, e.count)
+	b.BindVertexArray(e.vao)
+	b.DrawArrays(PrimitiveTriangles, 0, e.count)
 }
@@ -0,0 +1,258 @@
+package c3d
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// ChunkVisibility records which pairs of sides of a chunk are connected by
+// open cells, meaning something seen through one side of the chunk might be
+// seen through the other. Each of the 15 pairs of sides is one bit.
+type ChunkVisibility uint16
+
+// ChunkVisibilityAll is the visibility of a chunk in which all sides connect,
+// such as an empty chunk.
+const ChunkVisibilityAll ChunkVisibility = 0x7FFF
+
+// visibilityBit returns the bit of the pair of sides a and b.
+func visibilityBit(a, b t.Facing) ChunkVisibility {
+	if a > b {
+		a, b = b, a
+	}
+	// Pairs are numbered row by row in the upper triangle of a 6x6 matrix
+	i := int(a)*5 - int(a)*(int(a)-1)/2 + int(b) - int(a) - 1
+	return 1 << i
+}
+
+// Connected returns true if the sides a and b of the chunk are connected.
+func (v ChunkVisibility) Connected(a, b t.Facing) bool {
+	if a == b {
+		return true
+	}
+	return v&visibilityBit(a, b) != 0
+}
+
+// BuildChunkVisibility computes the visibility of a chunk by flood filling all
+// cells that are not opaque full cubes and connecting every pair of sides of
+// the chunk that each filled region touches.
+func BuildChunkVisibility(v VoxelSource[t.Cell],
+	defs []*t.Cube) ChunkVisibility {
+	src := &cubeSource{
+		v:    v,
+		defs: defs,
+	}
+	w, h, d := v.Dimensions()
+	open := func(x, y, z int) bool {
+		cd := src.cube(v.Get(x, y, z))
+		return cd == nil || cd.Shape != t.ShapeFull || cd.Transparent
+	}
+	var ret ChunkVisibility
+	visited := make([]bool, w*h*d)
+	var queue []t.IVec3
+	for iz := 0; iz < d; iz++ {
+		for iy := 0; iy < h; iy++ {
+			for ix := 0; ix < w; ix++ {
+				i := (iz*h+iy)*w + ix
+				if visited[i] || !open(ix, iy, iz) {
+					continue
+				}
+				// Flood fill the region collecting the sides it touches
+				var sides [6]bool
+				visited[i] = true
+				queue = append(queue[:0], t.IVec3{ix, iy, iz})
+				for len(queue) > 0 {
+					p := queue[len(queue)-1]
+					queue = queue[:len(queue)-1]
+					for f := t.North; f <= t.Bottom; f++ {
+						n := p.Add(t.FacingOffsets[f])
+						if n[0] < 0 || n[1] < 0 || n[2] < 0 ||
+							n[0] >= w || n[1] >= h || n[2] >= d {
+							sides[f] = true
+							continue
+						}
+						ni := (n[2]*h+n[1])*w + n[0]
+						if visited[ni] || !open(n[0], n[1], n[2]) {
+							continue
+						}
+						visited[ni] = true
+						queue = append(queue, n)
+					}
+				}
+				for a := t.North; a <= t.Bottom; a++ {
+					for b := a + 1; b <= t.Bottom; b++ {
+						if sides[a] && sides[b] {
+							ret |= visibilityBit(a, b)
+						}
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// frustum holds the six planes of a view frustum with normals facing in.
+type frustum [6]mgl32.Vec4
+
+// newFrustum returns the frustum of the projection view matrix m.
+func newFrustum(m mgl32.Mat4) frustum {
+	var ret frustum
+	for i := 0; i < 3; i++ {
+		ret[i*2] = m.Row(3).Add(m.Row(i))
+		ret[i*2+1] = m.Row(3).Sub(m.Row(i))
+	}
+	return ret
+}
+
+// containsBox returns true if any part of the box b may be within the frustum.
+func (f *frustum) containsBox(b t.AABB) bool {
+	for _, p := range f {
+		// Test the corner furthest along the plane normal
+		var c mgl32.Vec3
+		for i := range c {
+			if p[i] >= 0 {
+				c[i] = b[1][i]
+			} else {
+				c[i] = b[0][i]
+			}
+		}
+		if p.Vec3().Dot(c)+p[3] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkKey returns the chunk coordinates of the chunk at position p in cubes.
+func chunkKey(p mgl32.Vec3) t.IVec3 {
+	return t.IVec3{
+		int(math.Floor(float64(p[0]) / 16)),
+		int(math.Floor(float64(p[1]) / 16)),
+		int(math.Floor(float64(p[2]) / 16)),
+	}
+}
+
+// visibleChunk is a chunk reached by the visibility search.
+type visibleChunk struct {
+	p    t.IVec3  // Chunk coordinates
+	from t.Facing // Side the chunk was entered through
+	dirs uint8    // Bit set of all facings travelled to reach the chunk
+}
+
+// cullChunks fills a.visibleChunks with the chunks that may be seen from the
+// camera at position p with the projection view matrix m. A breadth-first
+// search from the camera's chunk steps through the sides of chunks that are
+// within the frustum, never back towards the camera, and only between sides
+// the chunk visibility connects. Chunks without a draw descriptor are
+// considered empty within the bounds of all chunks. Chunks that were not
+// reached are still drawn if their vox cell meshes are within the frustum and
+// extend into a chunk that was reached, or beyond the bounds of all chunks.
+func (a *App) cullChunks(p mgl32.Vec3, m mgl32.Mat4) {
+	a.visibleChunks = a.visibleChunks[:0]
+	if !a.OcclusionCulling {
+		a.visibleChunks = append(a.visibleChunks, a.chunkDDs...)
+		return
+	}
+	start := chunkKey(p)
+	lo, hi := start, start
+	for k := range a.chunkGrid {
+		for i := range k {
+			lo[i] = min(lo[i], k[i])
+			hi[i] = max(hi[i], k[i])
+		}
+	}
+	fr := newFrustum(m)
+	clear(a.chunkVisited)
+	a.chunkVisited[start] = true
+	queue := append(a.chunkQueue[:0], visibleChunk{
+		p:    start,
+		from: 0xFF,
+	})
+	for i := 0; i < len(queue); i++ {
+		c := queue[i]
+		vis := ChunkVisibilityAll
+		if d := a.chunkGrid[c.p]; d != nil {
+			a.visibleChunks = append(a.visibleChunks, d)
+			vis = d.Visibility
+		}
+		for f := t.North; f <= t.Bottom; f++ {
+			if c.dirs&(1<<f.Opposite()) != 0 {
+				continue
+			}
+			if c.from <= t.Bottom && !vis.Connected(c.from, f) {
+				continue
+			}
+			n := c.p.Add(t.FacingOffsets[f])
+			if n[0] < lo[0] || n[1] < lo[1] || n[2] < lo[2] ||
+				n[0] > hi[0] || n[1] > hi[1] || n[2] > hi[2] ||
+				a.chunkVisited[n] {
+				continue
+			}
+			b := t.AABB{
+				mgl32.Vec3{float32(n[0]), float32(n[1]), float32(n[2])}.Mul(16),
+			}
+			b[1] = b[0].Add(mgl32.Vec3{16, 16, 16})
+			if !fr.containsBox(b) {
+				continue
+			}
+			a.chunkVisited[n] = true
+			queue = append(queue, visibleChunk{
+				p:    n,
+				from: f.Opposite(),
+				dirs: c.dirs | 1<<f,
+			})
+		}
+	}
+	a.chunkQueue = queue[:0]
+	// Vox models may extend up to t.VoxMaxCells cells beyond their chunk
+	for _, d := range a.chunkDDs {
+		if a.chunkVisited[chunkKey(d.CubeDD.Position)] {
+			continue
+		}
+		b, ok := d.voxBounds()
+		if !ok || !fr.containsBox(b) {
+			continue
+		}
+		k0, k1 := chunkKey(b[0]), chunkKey(b[1])
+	search:
+		for z := k0[2]; z <= k1[2]; z++ {
+			for y := k0[1]; y <= k1[1]; y++ {
+				for x := k0[0]; x <= k1[0]; x++ {
+					if x < lo[0] || y < lo[1] || z < lo[2] ||
+						x > hi[0] || y > hi[1] || z > hi[2] ||
+						a.chunkVisited[t.IVec3{x, y, z}] {
+						a.visibleChunks = append(a.visibleChunks, d)
+						break search
+					}
+				}
+			}
+		}
+	}
+}
+
+// voxBounds returns the bounds in world space of the vox cell meshes of the
+// chunk, or false if it has none. The reduced detail mesh shares the bounds.
+func (d *ChunkDrawDescriptor) voxBounds() (t.AABB, bool) {
+	var ret t.AABB
+	found := false
+	for _, v := range d.VoxelDDs {
+		if v.Mesh == nil || v.Mesh.count == 0 {
+			continue
+		}
+		b := v.Mesh.bounds
+		b = t.AABB{b[0].Mul(t.VoxelScale), b[1].Mul(t.VoxelScale)}.
+			Rotate(v.Facing).Translate(v.Position)
+		if !found {
+			ret = b
+			found = true
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			ret[0][i] = min(ret[0][i], b[0][i])
+			ret[1][i] = max(ret[1][i], b[1][i])
+		}
+	}
+	return ret, found
+}
@@ -0,0 +1,126 @@
+package c3d
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// testGlassCell is a transparent full cube, see testVisibilityDefs.
+var testGlassCell = t.CellForCube(2, t.North)
+
+// pairBit returns the visibility bit of the pair of sides with the facing
+// values a and b.
+func pairBit(a, b int) ChunkVisibility {
+	return visibilityBit(t.Facing(a), t.Facing(b))
+}
+
+// testSolidCells returns a chunk of cells filled with full cubes.
+func testSolidCells() *testCells {
+	ret := newTestCells(16, 16, 16)
+	for i := range ret.cells {
+		ret.cells[i] = testFullCell
+	}
+	return ret
+}
+
+// testWallCells returns a chunk of cells with a wall of the cell c across
+// the chunk at Z 8, separating the north and south sides.
+func testWallCells(c t.Cell) *testCells {
+	ret := newTestCells(16, 16, 16)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			ret.set(x, y, 8, c)
+		}
+	}
+	return ret
+}
+
+// testVisibilityDefs returns a full cube definition with reference 0, a slab
+// definition with reference 1 and a transparent full cube definition with
+// reference 2.
+func testVisibilityDefs() []*t.Cube {
+	return append(testShapeDefs(), &t.Cube{
+		Ref:         2,
+		Name:        "glass",
+		Transparent: true,
+	})
+}
+
+// wallVisibility returns the visibility of a chunk separated by a wall, in
+// which all pairs of sides except north and south are connected.
+func wallVisibility() ChunkVisibility {
+	return ChunkVisibilityAll &^ pairBit(int(t.North), int(t.South))
+}
+
+func TestVisibilityBit(t *testing.T) {
+	var all ChunkVisibility
+	for a := 0; a < 6; a++ {
+		for b := a + 1; b < 6; b++ {
+			bit := pairBit(a, b)
+			if bit != pairBit(b, a) {
+				t.Errorf("pair %d, %d is not symmetric", a, b)
+			}
+			if all&bit != 0 {
+				t.Errorf("pair %d, %d shares bit %04X", a, b, bit)
+			}
+			all |= bit
+		}
+	}
+	if all != ChunkVisibilityAll {
+		t.Errorf("all pairs set %04X, want %04X", all, ChunkVisibilityAll)
+	}
+}
+
+func TestBuildChunkVisibility(t *testing.T) {
+	tests := []struct {
+		name  string
+		cells *testCells
+		want  ChunkVisibility
+	}{
+		{"empty", newTestCells(16, 16, 16), ChunkVisibilityAll},
+		{"solid", testSolidCells(), 0},
+		{"wall", testWallCells(testFullCell), wallVisibility()},
+		{"slab wall", testWallCells(testSlabCell), ChunkVisibilityAll},
+		{"glass wall", testWallCells(testGlassCell), ChunkVisibilityAll},
+	}
+	for _, tt := range tests {
+		got := BuildChunkVisibility(tt.cells, testVisibilityDefs())
+		if got != tt.want {
+			t.Errorf("%s: visibility %015b, want %015b", tt.name, got,
+				tt.want)
+		}
+	}
+}
+
+func TestDrawChunkOccluded(t *testing.T) {
+	for _, culling := range []bool{true, false} {
+		b := NewRecordingBackend()
+		s := newTestScene(t, b)
+		s.app.OcclusionCulling = culling
+		// The wall chunk between the camera and the far chunk is sealed
+		wall := s.addCube(1, mgl32.Vec3{0, 0, -16})
+		wall.Visibility = BuildChunkVisibility(testWallCells(testFullCell),
+			s.cubes)
+		s.addCube(2, mgl32.Vec3{0, 0, -32})
+		s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+		want := 1
+		if !culling {
+			want = 2
+		}
+		if n := len(b.DrawsOf("cube-mesh")); n != want {
+			t.Errorf("culling %v: drew %d cube meshes, want %d", culling, n,
+				want)
+		}
+	}
+	// The far chunk is seen through an open chunk
+	b := NewRecordingBackend()
+	s := newTestScene(t, b)
+	s.addCube(1, mgl32.Vec3{0, 0, -16})
+	s.addCube(2, mgl32.Vec3{0, 0, -32})
+	s.app.Draw(NewCamera(mgl32.Vec3{8, 8, 8}))
+	if n := len(b.DrawsOf("cube-mesh")); n != 2 {
+		t.Errorf("drew %d cube meshes through an open chunk, want 2", n)
+	}
+}
@@ -13,6 +13,15 @@ type VoxelSource[T any] interface {
 	IsEmpty(v T) bool
 }
 
+// VoxelOccluder may optionally be implemented by VoxelSource implementors to
+// control face culling between neighboring voxels. If not implemented a face
+// is culled whenever the neighboring voxel is not empty.
+type VoxelOccluder[T any] interface {
+	// Occludes returns true if the value v completely covers side s of its
+	// voxel, hiding the face of the neighboring value n that touches it.
+	Occludes(v T, s t.Facing, n T) bool
+}
+
 // voxFace represents one rectangular face representing one or more voxels.
 type voxFace[T any] struct {
 	x, y, z int // Location of the lower-left corner of the face, in voxel units
@@ -168,14 +177,14 @@ func (s *voxFaceSlice[T]) mesh(d Mesh[T]) {
 		if f == nil {
 			continue
 		}
-		AddFace([3]uint8{
-			uint8(f.x),
-			uint8(f.y),
-			uint8(f.z),
-		}, [3]uint8{
-			uint8(f.w),
-			uint8(f.h),
-			uint8(f.d),
+		AddFace([3]uint16{
+			uint16(f.x),
+			uint16(f.y),
+			uint16(f.z),
+		}, [3]uint16{
+			uint16(f.w),
+			uint16(f.h),
+			uint16(f.d),
 		}, 1, s.f, f.v, d)
 	}
 }
@@ -185,6 +194,7 @@ func (s *voxFaceSlice[T]) mesh(d Mesh[T]) {
 // not reset before faces are added.
 func BuildVoxelMesh[T comparable](v VoxelSource[T], d Mesh[T]) {
 	width, height, depth := v.Dimensions()
+	occluder, hasOccluder := v.(VoxelOccluder[T])
 	// Determine if a face is required
 	face := func(pos [3]int, f t.Facing) bool {
 		np := [3]int{}
@@ -192,6 +202,10 @@ func BuildVoxelMesh[T comparable](v VoxelSource[T], d Mesh[T]) {
 		np[1] = pos[1] + t.FacingOffsets[f][1]
 		np[2] = pos[2] + t.FacingOffsets[f][2]
 		vv := v.Get(np[0], np[1], np[2])
+		if hasOccluder {
+			return !occluder.Occludes(vv, f.Opposite(),
+				v.Get(pos[0], pos[1], pos[2]))
+		}
 		return v.IsEmpty(vv)
 	}
 	slices := []*voxFaceSlice[T]{}
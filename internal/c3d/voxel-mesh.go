@@ -1,7 +1,10 @@
 package c3d
 
 import (
-	gl "github.com/go-gl/gl/v3.1/gles2"
+	"encoding/binary"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/t"
 )
 
@@ -24,13 +27,19 @@ func init() {
 	}
 }
 
+// voxelMeshStride is the size of one voxel mesh vertex in bytes.
+const voxelMeshStride = 3*2 + 3*1 + 1*1
+
 // VoxelMesh is a utility struct that builds voxel-based meshes.
 type VoxelMesh struct {
-	vao        uint32 // Vertex Array Object ID
-	vbo        uint32 // Vertex Buffer Object ID
-	count      int32  // Vertex count
-	vboCurrent bool   // If false, the VBO needs to be reuploaded.
-	d          []byte // Raw mesh data
+	vao        uint32                // Vertex Array Object ID
+	vbo        uint32                // Vertex Buffer Object ID
+	count      int32                 // Vertex count
+	vboCurrent bool                  // If false, the VBO needs to be reuploaded.
+	d          []byte                // Raw mesh data
+	bounds     t.AABB                // Bounds of all vertexes in voxel units
+	vbuf       [voxelMeshStride]byte // Vertex buffer
+	lod        *VoxelMesh            // Cached reduced detail mesh, see lodBox
 }
 
 // NewVoxelMesh constructs a new CubeMesh object ready for use.
@@ -42,58 +51,275 @@ func NewVoxelMesh() *VoxelMesh {
 	return ret
 }
 
-// vert adds a vertex with the given attributes.
-func (m *VoxelMesh) vert(x, y, z, u, v uint8, i int, c [4]uint8, f t.Facing) {
-	m.d = append(m.d,
-		x, y, z,
-		c[0], c[1], c[2],
-		byte(f),
-	)
+// vert adds a vertex with the given attributes. Positions are in voxels and
+// must not exceed MaxMeshDims cubes.
+func (m *VoxelMesh) vert(x, y, z, u, v uint16, i int, c [4]uint8,
+	f t.Facing) {
+	m.addVert(x, y, z, c[0], c[1], c[2], f)
+}
+
+// addVert adds a vertex to the data buffer, updating the bounds.
+func (m *VoxelMesh) addVert(x, y, z uint16, r, g, b uint8, f t.Facing) {
+	p := mgl32.Vec3{float32(x), float32(y), float32(z)}
+	for j := 0; j < 3; j++ {
+		if m.count == 0 || p[j] < m.bounds[0][j] {
+			m.bounds[0][j] = p[j]
+		}
+		if m.count == 0 || p[j] > m.bounds[1][j] {
+			m.bounds[1][j] = p[j]
+		}
+	}
+	d := m.vbuf[:]
+	binary.LittleEndian.PutUint16(d[0:2], x)
+	binary.LittleEndian.PutUint16(d[2:4], y)
+	binary.LittleEndian.PutUint16(d[4:6], z)
+	d[6] = r
+	d[7] = g
+	d[8] = b
+	d[9] = byte(f)
+	m.d = append(m.d, d...)
 	m.count++
 	m.vboCurrent = false
 }
 
+// AppendRotated adds all vertexes of the vox model mesh o to the mesh, rotated
+// to facing f about the center of the model's origin cell of 16x16x16 voxels
+// and offset by x, y, z voxels. Faces that lie on the side of a cell are
+// skipped if cull is not nil and returns true for the offset in cells of that
+// cell from the origin cell and the facing of the face after rotation.
+// Triangles with vertexes that would end up outside the range of mesh
+// positions are skipped.
+func (m *VoxelMesh) AppendRotated(o *VoxelMesh, x, y, z int, f t.Facing,
+	cull func(t.IVec3, t.Facing) bool) {
+	const center = cubeMeshUnits / 2
+	q := t.FacingToOrientation[f].Q
+	var ps [3]t.IVec3
+	for i := 0; i+voxelMeshStride*3 <= len(o.d); i += voxelMeshStride * 3 {
+		tri := o.d[i : i+voxelMeshStride*3]
+		// All vertexes of a triangle share the facing of their face
+		n := t.FacingOffsets[t.Facing(tri[9])]
+		rf := facingForNormal(q.Rotate(mgl32.Vec3{
+			float32(n[0]),
+			float32(n[1]),
+			float32(n[2]),
+		}))
+		valid := true
+		for j := range ps {
+			d := tri[j*voxelMeshStride:]
+			p := q.Rotate(mgl32.Vec3{
+				float32(binary.LittleEndian.Uint16(d[0:2])) - center,
+				float32(binary.LittleEndian.Uint16(d[2:4])) - center,
+				float32(binary.LittleEndian.Uint16(d[4:6])) - center,
+			})
+			for k := range ps[j] {
+				ps[j][k] = int(math.Round(float64(p[k]))) + center
+			}
+			for k, o := range [3]int{x, y, z} {
+				valid = valid && ps[j][k]+o >= 0 && ps[j][k]+o <= 0xFFFF
+			}
+		}
+		if !valid {
+			continue
+		}
+		if cull != nil {
+			if c, ok := faceCell(ps, rf); ok && cull(c, rf) {
+				continue
+			}
+		}
+		for j := range ps {
+			d := tri[j*voxelMeshStride:]
+			m.addVert(
+				uint16(ps[j][0]+x),
+				uint16(ps[j][1]+y),
+				uint16(ps[j][2]+z),
+				d[6], d[7], d[8],
+				rf,
+			)
+		}
+	}
+}
+
+// facingForNormal returns the facing whose offset matches the axis-aligned
+// unit normal n.
+func facingForNormal(n mgl32.Vec3) t.Facing {
+	for f := t.North; f <= t.Bottom; f++ {
+		o := t.FacingOffsets[f]
+		if int(math.Round(float64(n[0]))) == o[0] &&
+			int(math.Round(float64(n[1]))) == o[1] &&
+			int(math.Round(float64(n[2]))) == o[2] {
+			return f
+		}
+	}
+	return t.North
+}
+
+// faceCell returns the cell, relative to the cell at the origin, whose side f
+// the triangle ps lies on, if the triangle lies on the side of exactly one
+// cell. Positions are in voxels.
+func faceCell(ps [3]t.IVec3, f t.Facing) (t.IVec3, bool) {
+	o := t.FacingOffsets[f]
+	var ret t.IVec3
+	for k := range o {
+		if o[k] == 0 {
+			// Faces merged across several cells are never culled
+			lo := min(ps[0][k], ps[1][k], ps[2][k])
+			hi := max(ps[0][k], ps[1][k], ps[2][k])
+			ret[k] = floorDiv(lo, cubeMeshUnits)
+			if hi > (ret[k]+1)*cubeMeshUnits {
+				return ret, false
+			}
+			continue
+		}
+		c := ps[0][k]
+		if ps[1][k] != c || ps[2][k] != c || c%cubeMeshUnits != 0 {
+			return ret, false
+		}
+		ret[k] = floorDiv(c, cubeMeshUnits)
+		if o[k] > 0 {
+			ret[k]--
+		}
+	}
+	return ret, true
+}
+
+// floorDiv returns a divided by b rounded towards negative infinity.
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// VoxCellMeshMargin is the number of cells vertex positions of meshes built
+// by BuildVoxCellMesh are offset by on every axis. Vox models may extend
+// beyond the volume in any direction after rotation.
+const VoxCellMeshMargin = t.VoxMaxCells
+
+// BuildVoxCellMesh bakes the voxel meshes of all vox cells within a volume of
+// cells, such as a chunk, into one voxel mesh with the facing of each cell
+// applied. Vertex positions are in voxels relative to the volume offset by
+// VoxCellMeshMargin cells, so the mesh is drawn facing north at the position
+// of the volume minus the margin. Faces on the side of a cell are culled when
+// the neighboring cell is a cube that occludes them. The function meshes
+// returns the voxel mesh for a vox reference, or nil if there is none. Note
+// that the destination mesh is not reset before faces are added. An error is
+// returned if the volume with the margin on all sides exceeds MaxMeshDims.
+func BuildVoxCellMesh(v VoxelSource[t.Cell], defs []*t.Cube,
+	meshes func(t.VoxRef) *VoxelMesh, m *VoxelMesh) error {
+	width, height, depth := v.Dimensions()
+	const margin = VoxCellMeshMargin * 2
+	if err := checkMeshDims(width+margin, height+margin,
+		depth+margin); err != nil {
+		return err
+	}
+	src := &cubeSource{
+		v:    v,
+		defs: defs,
+	}
+	for iz := 0; iz < depth; iz++ {
+		for iy := 0; iy < height; iy++ {
+			for ix := 0; ix < width; ix++ {
+				c := v.Get(ix, iy, iz)
+				if !c.IsVox() {
+					continue
+				}
+				_, vr, f := c.Decompose()
+				vm := meshes(vr)
+				if vm == nil {
+					continue
+				}
+				cull := func(d t.IVec3, s t.Facing) bool {
+					o := t.FacingOffsets[s]
+					n := v.Get(ix+d[0]+o[0], iy+d[1]+o[1], iz+d[2]+o[2])
+					return src.Occludes(n, s.Opposite(), t.CellInvalid)
+				}
+				m.AppendRotated(vm,
+					(ix+VoxCellMeshMargin)*cubeMeshUnits,
+					(iy+VoxCellMeshMargin)*cubeMeshUnits,
+					(iz+VoxCellMeshMargin)*cubeMeshUnits,
+					f, cull)
+			}
+		}
+	}
+	return nil
+}
+
 // Reset rests the mesh builder state.
 func (m *VoxelMesh) Reset() {
 	m.d = m.d[:0]
 	m.count = 0
 	m.vboCurrent = true
+	m.bounds = t.AABB{}
+	m.lod = nil
 }
 
-// draw draws the voxel mesh.
-func (m *VoxelMesh) draw(p *program) {
+// Bounds returns the bounding box of all vertexes in the mesh in voxel units.
+func (m *VoxelMesh) Bounds() t.AABB {
+	return m.bounds
+}
+
+// bind binds the vertex array of the voxel mesh, uploading the mesh data if
+// needed.
+func (m *VoxelMesh) bind(p *program) {
+	b := p.b
 	if m.vao == invalidVAO {
-		gl.GenVertexArrays(1, &m.vao)
+		m.vao = b.NewVertexArray()
 	}
 	if m.vbo == invalidVBO {
 		// Note: we have to do this on-demand because voxel meshes are loaded
 		// during the mod loading phase, before the GL is initialized.
-		var stride int32 = 3*1 + 3*1 + 1*1
+		var stride int = voxelMeshStride
 		var offset int = 0
-		gl.GenBuffers(1, &m.vbo)
-		gl.BindVertexArray(m.vao)
-		gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexPosition")),
-			3, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexPosition")))
-		offset += 3 * 1
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexColor")),
-			3, gl.UNSIGNED_BYTE, true, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexColor")))
+		m.vbo = b.NewBuffer()
+		b.BindVertexArray(m.vao)
+		b.BindBuffer(m.vbo)
+		p.attrib("aVertexPosition", 3, AttribUShort, false, stride, offset)
+		offset += 3 * 2
+		p.attrib("aVertexColor", 3, AttribUByte, true, stride, offset)
 		offset += 3 * 1
-		gl.VertexAttribPointerWithOffset(uint32(p.attr("aVertexFacing")),
-			1, gl.UNSIGNED_BYTE, false, stride, uintptr(offset))
-		gl.EnableVertexAttribArray(uint32(p.attr("aVertexFacing")))
+		p.attrib("aVertexFacing", 1, AttribUByte, false, stride, offset)
 		offset += 1 * 1
 	}
 	if !m.vboCurrent {
 		if len(m.d) != 0 {
-			gl.BindVertexArray(m.vao)
-			gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-			gl.BufferData(gl.ARRAY_BUFFER, len(m.d), gl.Ptr(m.d), gl.STATIC_DRAW)
+			b.BindVertexArray(m.vao)
+			b.BindBuffer(m.vbo)
+			b.BufferData(m.d, false)
 		}
 		m.vboCurrent = true
 	}
-	gl.BindVertexArray(m.vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, m.count)
+	b.BindVertexArray(m.vao)
+}
+
+// draw draws the voxel mesh.
+func (m *VoxelMesh) draw(p *program) {
+	m.bind(p)
+	p.b.DrawArrays(PrimitiveTriangles, 0, m.count)
+}
+
+// drawInstanced draws one instance of the voxel mesh for every model matrix
+// of 16 floats in mms. The model matrixes are uploaded to the buffer vbo for
+// a single instanced draw. Backends without instancing draw each instance
+// separately with the model matrix as a constant attribute.
+func (m *VoxelMesh) drawInstanced(p *program, vbo uint32, mms []float32) {
+	m.bind(p)
+	loc := p.attr("aModelMatrix")
+	if !p.b.Instancing() {
+		for i := 0; i+16 <= len(mms); i += 16 {
+			for c := 0; c < 4; c++ {
+				o := i + c*4
+				p.b.VertexAttribConst(loc+int32(c), mgl32.Vec4{
+					mms[o], mms[o+1], mms[o+2], mms[o+3]})
+			}
+			p.b.DrawArrays(PrimitiveTriangles, 0, m.count)
+		}
+		return
+	}
+	p.b.BindBuffer(vbo)
+	p.b.BufferData(float32Bytes(mms), true)
+	for i := int32(0); i < 4; i++ {
+		p.b.VertexAttrib(loc+i, 4, AttribFloat, false, 16*4, int(i*4*4), 1)
+	}
+	p.b.DrawArraysInstanced(PrimitiveTriangles, 0, m.count,
+		int32(len(mms)/16))
 }
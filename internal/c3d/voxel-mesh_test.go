@@ -0,0 +1,176 @@
+package c3d
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// voxelMeshPositions returns the positions of all vertexes of the mesh.
+func voxelMeshPositions(m *VoxelMesh) [][3]int {
+	var ret [][3]int
+	for i := 0; i < len(m.d); i += voxelMeshStride {
+		ret = append(ret, [3]int{
+			int(binary.LittleEndian.Uint16(m.d[i:])),
+			int(binary.LittleEndian.Uint16(m.d[i+2:])),
+			int(binary.LittleEndian.Uint16(m.d[i+4:])),
+		})
+	}
+	return ret
+}
+
+func TestAppendRotatedRange(t *testing.T) {
+	m := NewVoxelMesh()
+	// The far side of the 8 voxel box lies past the largest mesh position
+	x := 0xFFFF - 4
+	m.AppendRotated(testVoxelMesh(), x, 0, 0, 0, nil)
+	ps := voxelMeshPositions(m)
+	if len(ps) == 0 {
+		t.Fatal("no vertexes within range")
+	}
+	for _, p := range ps {
+		if p[0] < x {
+			t.Fatalf("vertex %v wrapped around", p)
+		}
+	}
+}
+
+// Cells used by the vox cell mesh tests, see testShapeDefs.
+var (
+	testEmptyCell = t.CellInvalid
+	testFullCell  = t.CellForCube(0, t.North)
+	testSlabCell  = t.CellForCube(1, t.North)
+)
+
+// testVoxBox returns the mesh of a box of w x h x d gray voxels.
+func testVoxBox(w, h, d int) *VoxelMesh {
+	m := NewVoxelMesh()
+	BuildVoxelMesh[[4]uint8](&testVoxels{
+		w:    w,
+		h:    h,
+		d:    d,
+		c:    [4]uint8{160, 160, 160, 255},
+		hole: [3]int{-1, -1, -1},
+	}, m)
+	return m
+}
+
+// facingTriangles returns the number of triangles of the mesh by facing.
+func facingTriangles(m *VoxelMesh) [6]int {
+	var ret [6]int
+	for i := 0; i < len(m.d); i += voxelMeshStride * 3 {
+		ret[m.d[i+9]]++
+	}
+	return ret
+}
+
+// testShapeDefs returns a full cube definition with reference 0 and a slab
+// definition with reference 1.
+func testShapeDefs() []*t.Cube {
+	return []*t.Cube{
+		{Ref: 0, Name: "full"},
+		{Ref: 1, Name: "slab", Shape: t.ShapeSlab},
+	}
+}
+
+// voxCellTriangles builds the vox cell mesh of the cells with every vox cell
+// using the model mesh vm and returns the number of triangles by facing.
+func voxCellTriangles(tt *testing.T, cells *testCells,
+	vm *VoxelMesh) [6]int {
+	m := NewVoxelMesh()
+	err := BuildVoxCellMesh(cells, testShapeDefs(),
+		func(t.VoxRef) *VoxelMesh { return vm }, m)
+	if err != nil {
+		tt.Fatal(err)
+	}
+	return facingTriangles(m)
+}
+
+// rotatedModelCells returns a row of three cells with a vox model facing east
+// in the middle, the cell side on either side of it and the cell above above
+// it.
+func rotatedModelCells(side, above t.Cell) *testCells {
+	ret := newTestCells(3, 2, 1)
+	ret.set(0, 0, 0, side)
+	ret.set(1, 0, 0, t.CellForVox(0, t.East))
+	ret.set(2, 0, 0, side)
+	ret.set(1, 1, 0, above)
+	return ret
+}
+
+// mergedModelCells returns a row of three cells with a vox model facing north
+// in the first cell, the cell side after the second cell and the cell above
+// above the first cell.
+func mergedModelCells(side, above t.Cell) *testCells {
+	ret := newTestCells(3, 2, 1)
+	ret.set(0, 0, 0, t.CellForVox(0, t.North))
+	ret.set(2, 0, 0, side)
+	ret.set(0, 1, 0, above)
+	return ret
+}
+
+func TestVoxCellMeshCulling(t *testing.T) {
+	// Triangles by facing: north, south, east, west, top, bottom
+	tests := []struct {
+		name  string
+		cells *testCells
+		model *VoxelMesh
+		want  [6]int
+	}{
+		// The model fills the north half of its cell, which becomes the east
+		// half facing east. The west face of the rotated model lies within
+		// the cell and is never culled.
+		{"rotated open", rotatedModelCells(testEmptyCell,
+			testEmptyCell),
+			testVoxBox(16, 16, 8), [6]int{2, 2, 2, 2, 2, 2}},
+		{"rotated between cubes", rotatedModelCells(testFullCell,
+			testFullCell), testVoxBox(16, 16, 8), [6]int{2, 2, 0, 2, 0, 2}},
+		// Slabs only occlude the faces below them
+		{"rotated between slabs", rotatedModelCells(testSlabCell,
+			testSlabCell), testVoxBox(16, 16, 8), [6]int{2, 2, 2, 2, 0, 2}},
+		// The top face spans both cells of the model and is never culled,
+		// the east face lies on the side of the second cell only
+		{"merged", mergedModelCells(testFullCell, testFullCell),
+			testVoxBox(32, 16, 16), [6]int{2, 2, 0, 2, 2, 2}},
+	}
+	for _, tt := range tests {
+		if got := voxCellTriangles(t, tt.cells, tt.model); got != tt.want {
+			t.Errorf("%s: triangles by facing %v, want %v", tt.name, got,
+				tt.want)
+		}
+	}
+}
+
+// faceCellTests are triangles in voxels with the facing of their face and the
+// cell faceCell is expected to return for them.
+var faceCellTests = []struct {
+	name string
+	ps   [3]t.IVec3
+	f    t.Facing
+	want t.IVec3
+	ok   bool
+}{
+	{"north side", [3]t.IVec3{{0, 0, 0}, {16, 0, 0}, {0, 16, 0}}, t.North,
+		t.IVec3{0, 0, 0}, true},
+	{"south side", [3]t.IVec3{{0, 0, 16}, {16, 0, 16}, {0, 16, 16}}, t.South,
+		t.IVec3{0, 0, 0}, true},
+	{"east side of negative cell", [3]t.IVec3{{-16, -16, 0}, {-16, 0, 0},
+		{-16, 0, 16}}, t.East, t.IVec3{-2, -1, 0}, true},
+	{"west side of negative cell", [3]t.IVec3{{-16, -16, 0}, {-16, 0, 0},
+		{-16, 0, 16}}, t.West, t.IVec3{-1, -1, 0}, true},
+	{"within cell", [3]t.IVec3{{0, 0, 8}, {16, 0, 8}, {0, 16, 8}}, t.North,
+		t.IVec3{}, false},
+	{"across cells", [3]t.IVec3{{0, 16, 0}, {32, 16, 0}, {0, 16, 16}}, t.Top,
+		t.IVec3{}, false},
+}
+
+func TestFaceCell(t *testing.T) {
+	for _, tt := range faceCellTests {
+		got, ok := faceCell(tt.ps, tt.f)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, ok, tt.want,
+				tt.ok)
+		}
+	}
+}
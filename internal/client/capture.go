@@ -0,0 +1,132 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// captureDir is the directory screenshots and frame captures are written to.
+const captureDir = "screenshots"
+
+// captureTimeFormat is the time format used to name captures.
+const captureTimeFormat = "2006-01-02_15-04-05.000"
+
+// capture manages screenshots and frame sequence captures of the frames drawn
+// by the main loop.
+type capture struct {
+	screenshot bool    // If true, take a screenshot of the next frame
+	recording  bool    // If true, every frame is written to dir
+	dir        string  // Directory the current frame sequence is written to
+	dt         float32 // Simulated frame time while recording
+	frame      int     // Number of the next frame of the sequence
+}
+
+// capturer is the capture manager of the main loop.
+var capturer capture
+
+// takeScreenshot requests a screenshot of the next frame drawn.
+func (c *capture) takeScreenshot() {
+	c.screenshot = true
+}
+
+// pending returns true if the next frame drawn will be written.
+func (c *capture) pending() bool {
+	return c.screenshot || c.recording
+}
+
+// start starts recording a frame sequence at the given frames per second.
+// While recording every frame simulates exactly 1/fps seconds regardless of
+// the real frame rate.
+func (c *capture) start(fps float32) error {
+	if fps <= 0 {
+		return errors.New("frames per second must be greater than zero")
+	}
+	if c.recording {
+		return errors.New("already recording")
+	}
+	dir := filepath.Join(captureDir, "capture_"+
+		time.Now().Format(captureTimeFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	c.recording = true
+	c.dir = dir
+	c.dt = 1 / fps
+	c.frame = 0
+	return nil
+}
+
+// stop stops recording the frame sequence and returns the number of frames
+// written.
+func (c *capture) stop() int {
+	c.recording = false
+	return c.frame
+}
+
+// frameTime returns the simulated frame time while recording or the real
+// frame time rt otherwise.
+func (c *capture) frameTime(rt float32) float32 {
+	if c.recording {
+		return c.dt
+	}
+	return rt
+}
+
+// update writes the frame just drawn as requested. This must be called after
+// app.Draw and before the buffers are swapped.
+func (c *capture) update() {
+	if !c.screenshot && !c.recording {
+		return
+	}
+	if c.screenshot {
+		c.screenshot = false
+		p := filepath.Join(captureDir, "screenshot_"+
+			time.Now().Format(captureTimeFormat)+".png")
+		if err := c.write(p); err != nil {
+			console.printf([3]uint8{255, 0, 0}, "error: screenshot: %s", err)
+		} else {
+			console.printf([3]uint8{0, 255, 0}, "saved %s", p)
+		}
+	}
+	if c.recording {
+		p := filepath.Join(c.dir, fmt.Sprintf("frame_%06d.png", c.frame))
+		if err := c.write(p); err != nil {
+			c.stop()
+			console.printf([3]uint8{255, 0, 0}, "error: capture: %s", err)
+			return
+		}
+		c.frame++
+	}
+}
+
+// write reads back the current frame and writes it to path as a PNG. Frames
+// drawn while the window is minimized are read back from the software
+// renderer the backend mirrors to, which requires the -mirror-capture flag.
+func (c *capture) write(p string) error {
+	if mirror == nil && win.GetAttrib(glfw.Iconified) == glfw.True {
+		return errors.New("can not capture a minimized window without " +
+			"the -mirror-capture flag")
+	}
+	img := app.Screenshot()
+	if img == nil {
+		return errors.New("the renderer can not read back frames")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"log"
+
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/c3d"
 	"github.com/qbradq/cubit/internal/mod"
@@ -29,21 +31,66 @@ func (r ChunkLocationRef) ToPosition() t.IVec3 {
 	}
 }
 
+// chunkSource is the cells of a chunk implementing c3d.VoxelSource. Cells
+// outside of the chunk are read from the world so that faces on the edges of
+// the chunk are culled against the neighboring chunks.
+type chunkSource struct {
+	c *t.Chunk // Chunk
+}
+
+// Get implements the c3d.VoxelSource interface.
+func (s chunkSource) Get(x, y, z int) t.Cell {
+	if x < 0 || x > 15 || y < 0 || y > 15 || z < 0 || z > 15 {
+		return world.GetCell(s.c.Position.Add(t.IVec3{x, y, z}))
+	}
+	return s.c.Get(x, y, z)
+}
+
+// Dimensions implements the c3d.VoxelSource interface.
+func (s chunkSource) Dimensions() (w, h, d int) {
+	return s.c.Dimensions()
+}
+
+// IsEmpty implements the c3d.VoxelSource interface.
+func (s chunkSource) IsEmpty(v t.Cell) bool {
+	return s.c.IsEmpty(v)
+}
+
 // Chunk represents a 16x16x16 chunk of space.
 type Chunk struct {
-	p   t.IVec3                  // Chunk position in world coordinates
-	c   *t.Chunk                 // The chunk we are modeling
-	cdd *c3d.ChunkDrawDescriptor // Draw descriptor holding all 3D assets
-	lcr uint32                   // Last compiled revision of the chunk data
-	lvr uint32                   // Last compiled revision of the chunk vox data
+	p     t.IVec3                      // Chunk position in world coordinates
+	c     *t.Chunk                     // The chunk we are modeling
+	cdd   *c3d.ChunkDrawDescriptor     // Draw descriptor holding all 3D assets
+	lcr   uint32                       // Last compiled revision of the chunk data
+	lvr   uint32                       // Last compiled revision of the chunk vox data
+	vdd   *c3d.VoxelMeshDrawDescriptor // Draw descriptor of all vox cells
+	vox   bool                         // If true the chunk has vox cells
+	lnr   [27]uint32                   // Revisions of the surrounding chunks the vox mesh was compiled with
+	label *c3d.BillboardDrawDescriptor // Debug label shown with the wire frames
 }
 
 // NewChunk creates a new Chunk ready for use.
 func NewChunk(p t.IVec3) *Chunk {
 	ref := t.NewChunkRefForWorldPosition(p)
+	vp := p.Sub(t.IVec3{
+		c3d.VoxCellMeshMargin,
+		c3d.VoxCellMeshMargin,
+		c3d.VoxCellMeshMargin,
+	})
+	vdd := &c3d.VoxelMeshDrawDescriptor{
+		ID:   uint32(ref),
+		Mesh: c3d.NewVoxelMesh(),
+		Position: mgl32.Vec3{
+			float32(vp[0]),
+			float32(vp[1]),
+			float32(vp[2]),
+		},
+		Facing: t.North,
+	}
 	ret := &Chunk{
-		p: p,
-		c: world.GetChunkByRef(ref),
+		p:   p,
+		c:   world.GetChunkByRef(ref),
+		vdd: vdd,
 		cdd: &c3d.ChunkDrawDescriptor{
 			ID: uint32(ref),
 			CubeDD: c3d.CubeMeshDrawDescriptor{
@@ -56,8 +103,25 @@ func NewChunk(p t.IVec3) *Chunk {
 				},
 				Orientation: t.O(),
 			},
-			VoxelDDs: []*c3d.VoxelMeshDrawDescriptor{},
+			VoxelDDs:     []*c3d.VoxelMeshDrawDescriptor{vdd},
+			LODVoxelMesh: c3d.NewVoxelMesh(),
+		},
+	}
+	label := app.NewUIMesh()
+	label.Print(0, 0, [3]uint8{0, 255, 0}, "Chunk %d,%d,%d",
+		p[0]/16, p[1]/16, p[2]/16)
+	ret.label = &c3d.BillboardDrawDescriptor{
+		ID:   newBillboardID(),
+		Mesh: label,
+		Position: mgl32.Vec3{
+			float32(p[0]) + 8,
+			float32(p[1]) + 8,
+			float32(p[2]) + 8,
 		},
+		Overlay: true,
+	}
+	for i := range ret.cdd.LODCubeMeshes {
+		ret.cdd.LODCubeMeshes[i] = c3d.NewCubeMesh(mod.CubeDefs)
 	}
 	return ret
 }
@@ -65,35 +129,68 @@ func NewChunk(p t.IVec3) *Chunk {
 // update does periodic updates on the chunk for client-side things like chunk
 // compilation.
 func (c *Chunk) update() {
-	if c.lcr < c.c.Revision {
+	c.label.Mesh.Hidden = !app.WireFramesVisible
+	cubesChanged := c.lcr < c.c.Revision
+	if cubesChanged {
 		c.cdd.CubeDD.Mesh.Reset()
-		c3d.BuildVoxelMesh[t.Cell](c.c, c.cdd.CubeDD.Mesh)
+		if err := c3d.BuildCubeMesh(c.c, c.cdd.CubeDD.Mesh); err != nil {
+			log.Println(err)
+		}
+		for i, m := range c.cdd.LODCubeMeshes {
+			m.Reset()
+			if err := c3d.BuildCubeMeshLOD(c.c, i+1, m); err != nil {
+				log.Println(err)
+			}
+		}
+		c.cdd.Visibility = c3d.BuildChunkVisibility(c.c, mod.CubeDefs)
 		c.lcr = c.c.Revision
 	}
-	if c.lvr < c.c.VoxRevision {
-		c.cdd.VoxelDDs = c.cdd.VoxelDDs[:0]
-		for iz := 0; iz < 16; iz++ {
-			for iy := 0; iy < 16; iy++ {
-				for ix := 0; ix < 16; ix++ {
-					cell := c.c.Get(ix, iy, iz)
-					_, vr, f := cell.Decompose()
-					if vr == t.VoxRefInvalid {
-						continue
-					}
-					c.cdd.VoxelDDs = append(c.cdd.VoxelDDs,
-						&c3d.VoxelMeshDrawDescriptor{
-							ID:   1,
-							Mesh: mod.VoxDefs[vr].Mesh,
-							Position: mgl32.Vec3{
-								float32(ix),
-								float32(iy),
-								float32(iz),
-							},
-							Facing: f,
-						})
-				}
+	// Vox faces are culled against neighboring cubes, which may lie in the
+	// surrounding chunks for vox models that span several cells, so the vox
+	// mesh is rebuilt when any of them change if the chunk has vox cells
+	nr := c.neighborRevisions()
+	if c.lvr < c.c.VoxRevision || (c.vox && nr != c.lnr) {
+		meshes := func(r t.VoxRef) *c3d.VoxelMesh {
+			if int(r) >= len(mod.VoxDefs) {
+				return nil
 			}
+			c.vox = true
+			return mod.VoxDefs[r].Mesh
+		}
+		src := chunkSource{c: c.c}
+		c.vox = false
+		c.vdd.Mesh.Reset()
+		err := c3d.BuildVoxCellMesh(src, mod.CubeDefs, meshes, c.vdd.Mesh)
+		if err != nil {
+			log.Println(err)
+		}
+		c.cdd.LODVoxelMesh.Reset()
+		err = c3d.BuildVoxCellMeshLOD(src, mod.CubeDefs, meshes,
+			c.cdd.LODVoxelMesh)
+		if err != nil {
+			log.Println(err)
 		}
 		c.lvr = c.c.VoxRevision
+		c.lnr = nr
 	}
 }
+
+// neighborRevisions returns the revisions of the chunk and all chunks
+// surrounding it, zero for those that do not exist.
+func (c *Chunk) neighborRevisions() [27]uint32 {
+	var ret [27]uint32
+	i := 0
+	for dz := -1; dz <= 1; dz++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				p := c.p.Add(t.IVec3{dx * 16, dy * 16, dz * 16})
+				if n := world.GetChunkByRef(
+					t.NewChunkRefForWorldPosition(p)); n != nil {
+					ret[i] = n.Revision
+				}
+				i++
+			}
+		}
+	}
+	return ret
+}
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/go-gl/mathgl/mgl32"
@@ -189,10 +191,77 @@ func (w *consoleWidget) input() {
 // handleCommand handles a command line.
 func (w *consoleWidget) handleCommand(l string) {
 	fields := strings.Fields(l)
+	if len(fields) < 1 {
+		return
+	}
+	var err error
 	switch strings.ToLower(fields[0]) {
 	case "exit":
 		win.SetShouldClose(true)
+	case "edit-model":
+		if len(fields) != 2 {
+			err = errors.New("usage: edit-model <model path>")
+			break
+		}
+		err = editor.open(fields[1])
+	case "edit-anim":
+		if len(fields) != 2 {
+			err = errors.New("usage: edit-anim <animation path>")
+			break
+		}
+		err = editor.openAnimation(fields[1])
+	case "edit-length":
+		var s float64
+		if len(fields) != 2 {
+			err = errors.New("usage: edit-length <seconds>")
+			break
+		}
+		if s, err = strconv.ParseFloat(fields[1], 32); err != nil {
+			break
+		}
+		err = editor.setLength(float32(s))
+	case "edit-save":
+		if err = editor.save(); err == nil {
+			w.printf([3]uint8{0, 255, 0}, "saved %s", editor.modelPath)
+		}
+	case "edit-close":
+		editor.close()
+	case "reload-shaders":
+		if err = app.ReloadShaders(); err == nil {
+			w.printf([3]uint8{0, 255, 0}, "shaders reloaded")
+		}
+	case "screenshot":
+		capturer.takeScreenshot()
+	case "export-vox":
+		var p string
+		if p, err = exportVox(fields[1:]); err == nil {
+			w.printf([3]uint8{0, 255, 0}, "saved %s", p)
+		}
+	case "capture-start":
+		fps := 30.0
+		if len(fields) > 2 {
+			err = errors.New("usage: capture-start [frames per second]")
+			break
+		}
+		if len(fields) == 2 {
+			if fps, err = strconv.ParseFloat(fields[1], 32); err != nil {
+				break
+			}
+		}
+		if err = capturer.start(float32(fps)); err == nil {
+			w.printf([3]uint8{0, 255, 0}, "capturing to %s", capturer.dir)
+		}
+	case "occlusion-culling":
+		app.OcclusionCulling = !app.OcclusionCulling
+		w.printf([3]uint8{0, 255, 0}, "occlusion culling: %t",
+			app.OcclusionCulling)
+	case "capture-stop":
+		n := capturer.stop()
+		w.printf([3]uint8{0, 255, 0}, "captured %d frames", n)
 	default:
 		w.printf([3]uint8{255, 0, 0}, "error: unknown command %s", fields[0])
 	}
+	if err != nil {
+		w.printf([3]uint8{255, 0, 0}, "error: %s", err)
+	}
 }
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qbradq/cubit/internal/mod"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// exportDir is the directory .vox exports are written to.
+const exportDir = "exports"
+
+// exportUsage is the usage of the export-vox console command.
+const exportUsage = "usage: export-vox <x1> <y1> <z1> <x2> <y2> <z2> " +
+	"[expand] | export-vox <vox path>"
+
+// exportVox implements the export-vox console command. Given six coordinates
+// the world region between the two corners is exported, with each cell
+// expanded to 16x16x16 voxels if the expand argument is given. Given a vox
+// model path the model is exported. The path of the file written is returned.
+func exportVox(args []string) (string, error) {
+	var write func(w io.Writer) error
+	var name string
+	switch len(args) {
+	case 1:
+		v := mod.GetVoxByPath(args[0])
+		if v == nil {
+			return "", errors.New("unknown vox model " + args[0])
+		}
+		write = v.Write
+		name = strings.ReplaceAll(strings.Trim(args[0], "/"), "/", "_")
+	case 6, 7:
+		var c [6]int
+		for i := range c {
+			var err error
+			if c[i], err = strconv.Atoi(args[i]); err != nil {
+				return "", err
+			}
+		}
+		expand := false
+		if len(args) == 7 {
+			if args[6] != "expand" {
+				return "", errors.New(exportUsage)
+			}
+			expand = true
+		}
+		a := t.IVec3{c[0], c[1], c[2]}
+		b := t.IVec3{c[3], c[4], c[5]}
+		// The region is exported before creating the file so regions past
+		// the export limits do not leave empty files behind
+		v, err := mod.ExportRegion(world, a, b, expand)
+		if err != nil {
+			return "", err
+		}
+		write = v.Write
+		name = "region"
+	default:
+		return "", errors.New(exportUsage)
+	}
+	p := filepath.Join(exportDir, name+"_"+
+		time.Now().Format(captureTimeFormat)+".vox")
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	return p, f.Close()
+}
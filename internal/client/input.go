@@ -19,42 +19,56 @@ type keySpec struct {
 }
 
 var KeyConfig = map[string][]keySpec{
-	"cancel":      {{glfw.KeyEscape, 0}},
-	"confirm":     {{glfw.KeyEnter, 0}},
-	"forward":     {{glfw.KeyW, 0}},
-	"backward":    {{glfw.KeyS, 0}},
-	"left":        {{glfw.KeyA, 0}},
-	"right":       {{glfw.KeyD, 0}},
-	"up":          {{glfw.KeyV, 0}},
-	"down":        {{glfw.KeyC, 0}},
-	"turn-left":   {{glfw.KeyQ, 0}},
-	"turn-right":  {{glfw.KeyE, 0}},
-	"tool-belt-1": {{glfw.Key1, 0}},
-	"tool-belt-2": {{glfw.Key2, 0}},
-	"tool-belt-3": {{glfw.Key3, 0}},
-	"tool-belt-4": {{glfw.Key4, 0}},
-	"tool-belt-5": {{glfw.Key5, 0}},
-	"tool-belt-6": {{glfw.Key6, 0}},
-	"tool-belt-7": {{glfw.Key7, 0}},
-	"tool-belt-8": {{glfw.Key8, 0}},
-	"tool-belt-9": {{glfw.Key9, 0}},
-	"tool-belt-0": {{glfw.Key0, 0}},
-	"console":     {{glfw.KeyGraveAccent, glfw.ModControl}},
-	"backspace":   {{glfw.KeyBackspace, 0}},
-	"delete":      {{glfw.KeyDelete, 0}},
-	"ui-toggle":   {{glfw.KeyTab, 0}},
-	"ui-left":     {{glfw.KeyLeft, 0}},
-	"ui-right":    {{glfw.KeyRight, 0}},
-	"ui-up":       {{glfw.KeyUp, 0}},
-	"ui-down":     {{glfw.KeyDown, 0}},
-	"debug":       {{glfw.KeyF12, 0}},
-	"debug-x-inc": {{glfw.KeyPageUp, 0}},
-	"debug-x-dec": {{glfw.KeyPageDown, 0}},
-	"debug-y-inc": {{glfw.KeyHome, 0}},
-	"debug-y-dec": {{glfw.KeyEnd, 0}},
-	"debug-z-inc": {{glfw.KeyInsert, 0}},
-	"debug-z-dec": {{glfw.KeyDelete, 0}},
-	"test-button": {{glfw.KeyF11, 0}},
+	"cancel":           {{glfw.KeyEscape, 0}},
+	"confirm":          {{glfw.KeyEnter, 0}},
+	"forward":          {{glfw.KeyW, 0}},
+	"backward":         {{glfw.KeyS, 0}},
+	"left":             {{glfw.KeyA, 0}},
+	"right":            {{glfw.KeyD, 0}},
+	"up":               {{glfw.KeyV, 0}},
+	"down":             {{glfw.KeyC, 0}},
+	"turn-left":        {{glfw.KeyQ, 0}},
+	"turn-right":       {{glfw.KeyE, 0}},
+	"tool-belt-1":      {{glfw.Key1, 0}},
+	"tool-belt-2":      {{glfw.Key2, 0}},
+	"tool-belt-3":      {{glfw.Key3, 0}},
+	"tool-belt-4":      {{glfw.Key4, 0}},
+	"tool-belt-5":      {{glfw.Key5, 0}},
+	"tool-belt-6":      {{glfw.Key6, 0}},
+	"tool-belt-7":      {{glfw.Key7, 0}},
+	"tool-belt-8":      {{glfw.Key8, 0}},
+	"tool-belt-9":      {{glfw.Key9, 0}},
+	"tool-belt-0":      {{glfw.Key0, 0}},
+	"console":          {{glfw.KeyGraveAccent, glfw.ModControl}},
+	"backspace":        {{glfw.KeyBackspace, 0}},
+	"delete":           {{glfw.KeyDelete, 0}},
+	"ui-toggle":        {{glfw.KeyTab, 0}},
+	"ui-left":          {{glfw.KeyLeft, 0}},
+	"ui-right":         {{glfw.KeyRight, 0}},
+	"ui-up":            {{glfw.KeyUp, 0}},
+	"ui-down":          {{glfw.KeyDown, 0}},
+	"debug":            {{glfw.KeyF12, 0}},
+	"debug-x-inc":      {{glfw.KeyPageUp, 0}},
+	"debug-x-dec":      {{glfw.KeyPageDown, 0}},
+	"debug-y-inc":      {{glfw.KeyHome, 0}},
+	"debug-y-dec":      {{glfw.KeyEnd, 0}},
+	"debug-z-inc":      {{glfw.KeyInsert, 0}},
+	"debug-z-dec":      {{glfw.KeyDelete, 0}},
+	"test-button":      {{glfw.KeyF11, 0}},
+	"screenshot":       {{glfw.KeyPrintScreen, 0}, {glfw.KeyF10, 0}},
+	"editor-pitch-inc": {{glfw.KeyR, 0}},
+	"editor-pitch-dec": {{glfw.KeyF, 0}},
+	"editor-yaw-inc":   {{glfw.KeyT, 0}},
+	"editor-yaw-dec":   {{glfw.KeyG, 0}},
+	"editor-roll-inc":  {{glfw.KeyY, 0}},
+	"editor-roll-dec":  {{glfw.KeyH, 0}},
+	"editor-next-part": {{glfw.KeyN, 0}},
+	"editor-time-inc":  {{glfw.KeyRightBracket, 0}},
+	"editor-time-dec":  {{glfw.KeyLeftBracket, 0}},
+	"editor-key":       {{glfw.KeyK, 0}},
+	"editor-unkey":     {{glfw.KeyJ, 0}},
+	"editor-play":      {{glfw.KeyP, 0}},
+	"editor-save":      {{glfw.KeyF5, 0}},
 }
 
 // Input manages the input and input configuration.
@@ -270,6 +284,9 @@ func debugInput() {
 	if input.WasPressed("debug") {
 		app.DebugTextVisible = !app.DebugTextVisible
 	}
+	if input.WasPressed("screenshot") {
+		capturer.takeScreenshot()
+	}
 	if input.WasPressed("debug-x-inc") {
 		debugVector[0] += 1.0
 	}
@@ -1,10 +1,13 @@
 package client
 
 import (
+	"flag"
+	"log"
+	"math"
+	"os"
 	"runtime"
 	"time"
 
-	gl "github.com/go-gl/gl/v3.1/gles2"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/c3d"
@@ -15,6 +18,9 @@ import (
 // Configuration variables
 var mouseSensitivity float32 = 0.15
 var walkSpeed float32 = 5
+var mirrorCapture = flag.Bool("mirror-capture", false,
+	"mirror rendering to a software renderer so frames can be captured "+
+		"while the window is minimized")
 
 // Super globals
 var dt float32                                    // Delta time for the current frame
@@ -24,9 +30,11 @@ var screenHeight int = 720                        // Height of the screen in pix
 var zoom int = screenWidth / t.VirtualScreenWidth // Zoom level
 var win *glfw.Window                              // GLFW window
 var app *c3d.App                                  // Graphics application
+var mirror *c3d.MirrorBackend                     // Software mirror of the backend of app, if enabled
 var console *consoleWidget                        // Console widget
 var toolBelt *toolBeltWidget                      // Tool belt widget
 var palette *paletteWidget                        // Cell palette
+var editor *modelEditor                           // Skeletal model editor
 var input *Input                                  // Input instance
 var world *t.World                                // The currently loaded world
 var debugVector mgl32.Vec3                        // Debug vector
@@ -34,6 +42,9 @@ var debugLines *c3d.LineMesh                      // Debug lines mesh
 var cam *c3d.Camera                               // Player camera
 var cubeSelector *c3d.LineMesh                    // Cube selection mesh
 var csDD *c3d.LineMeshDrawDescriptor              // Cube selector draw descriptor
+var heldCell t.Cell = t.CellInvalid               // Cell held in the test model's hand
+var dust *c3d.ParticleEmitter                     // Ambient dust following the camera
+var lastBillboardID uint32                        // Last billboard draw descriptor ID allocated
 
 func init() {
 	c := [4]uint8{0, 255, 0, 255}
@@ -75,6 +86,7 @@ func init() {
 
 func Main() {
 	var err error
+	flag.Parse()
 	// Window creation
 	if err := glfw.Init(); err != nil {
 		panic(err)
@@ -131,12 +143,19 @@ func Main() {
 	console.printf([3]uint8{0, 255, 255},
 		"%s: Welcome to Cubit!", time.Now().Format(time.DateTime))
 	console.add(app)
+	// Shader overrides from mods and the data directory on disk
+	app.ShaderSources = append(mod.ShaderSources(), os.DirFS("data"))
+	if err := app.ReloadShaders(); err != nil {
+		console.printf([3]uint8{255, 0, 0}, "error: %s", err)
+	}
 	toolBelt = newToolBeltWidget(app)
 	toolBelt.setItem(t.CellForCube(mod.GetCubeRef("/cubit/cubes/grass"),
 		t.North), 0)
 	toolBelt.add(app)
 	palette = newPaletteWidget()
 	palette.add(app)
+	editor = newModelEditor(app)
+	editor.add(app)
 	app.SetCrosshair(mod.GetUITile("/cubit/003"), layerCrosshair)
 	app.SetCursor(mod.GetUITile("/cubit/004"), layerCursor)
 	app.CrosshairVisible = true
@@ -144,16 +163,35 @@ func Main() {
 	app.WireFramesVisible = true
 	app.DebugTextVisible = true
 	// World setup
-	world = t.NewWorld()
+	world = t.NewWorld(mod.CubeDefs, mod.GetVoxVolume)
 	TestGen(world)
+	app.Particles.Solid = func(p mgl32.Vec3) bool {
+		c := world.GetCube(t.IVec3{
+			int(math.Floor(float64(p[0]))),
+			int(math.Floor(float64(p[1]))),
+			int(math.Floor(float64(p[2]))),
+		})
+		return c != nil && c.Shape == t.ShapeFull
+	}
+	app.Particles.AddEmitter(&c3d.ParticleEmitter{
+		Effect:   mod.GetParticleEffect("/cubit/particles/effects/smoke"),
+		Position: mgl32.Vec3{9.5, 6.25, 5.5},
+	})
+	dust = &c3d.ParticleEmitter{
+		Effect: mod.GetParticleEffect("/cubit/particles/effects/dust"),
+	}
+	app.Particles.AddEmitter(dust)
 	chunk := NewChunk(t.IVec3{0, 0, 0})
 	chunk.update()
 	app.AddChunkDD(chunk.cdd)
+	app.AddBillboardDD(chunk.label)
 	model := mod.NewModel("/cubit/models/characters/brad")
 	model.DrawDescriptor.Orientation.P = mgl32.Vec3{6.5, 1.75, 10.5}
 	model.DrawDescriptor.Orientation = model.DrawDescriptor.Orientation.Yaw(180)
 	model.StartAnimation("/cubit/animations/characters/walk", "legs")
 	app.AddModelDD(model.DrawDescriptor)
+	namePlate := newNamePlate("Brad")
+	app.AddBillboardDD(namePlate)
 	cam = c3d.NewCamera(mgl32.Vec3{6.5, 2, 7})
 	cam.Yaw = 90.001
 	// TODO DEBUG REMOVE
@@ -171,13 +209,23 @@ func Main() {
 		glfw.PollEvents()
 		input.PollEvents()
 		runTime = float32(glfw.GetTime())
-		dt = float32(float64(runTime) - lastRuntime)
+		dt = capturer.frameTime(float32(float64(runTime) - lastRuntime))
 		lastRuntime = float64(runTime)
+		world.AdvanceTime(dt)
+		app.Sky.Time = world.Time
 		chunk.update()
 		model.Update(dt)
+		model.PlaceFeet(world, 0.5, "leftFoot", "rightFoot")
+		model.LookAt(cam.Position, 1)
+		namePlate.Position = model.DrawDescriptor.Orientation.P.Add(
+			mgl32.Vec3{0, 1.25, 0})
+		app.Update(dt)
 		console.update()
 		toolBelt.update()
 		palette.update()
+		editor.update()
+		updateHeldCell(model)
+		dust.Position = cam.Position
 		// Handle input
 		debugInput()
 		if console.isFocused() {
@@ -186,7 +234,11 @@ func Main() {
 			cameraInput(cam)
 			toolBelt.input()
 			palette.input()
-			editInput()
+			if editor.isOpen() {
+				editor.input()
+			} else {
+				editInput()
+			}
 		}
 		// TODO REMOVE
 		app.AddDebugLine([3]uint8{255, 255, 0}, "Position: X=%d Y=%d Z=%d",
@@ -194,34 +246,97 @@ func Main() {
 			int(cam.Position[1]),
 			int(cam.Position[2]),
 		)
+		app.AddDebugLine([3]uint8{255, 255, 0}, "Time: %02d:%02d",
+			int(world.Time*24), int(world.Time*24*60)%60)
+		app.AddDebugLine([3]uint8{255, 255, 0}, "Chunks: %d/%d",
+			app.VisibleChunks(), app.Chunks())
 		if wi != nil {
-			app.AddDebugLine([3]uint8{0, 255, 0}, "WI: Pos=%v Face=%d",
-				wi.Position, wi.Face)
+			app.AddDebugLine([3]uint8{0, 255, 0}, "WI: Pos=%v Face=%d Dist=%.2f",
+				wi.Position, wi.Face, wi.Distance)
 		} else {
 			app.AddDebugLine([3]uint8{0, 255, 0}, "WI: nil")
 		}
+		if mi := model.Intersect(t.NewRay(cam.Position, cam.Front,
+			8.0)); mi != nil {
+			app.AddDebugLine([3]uint8{0, 255, 0}, "MI: Part=%s Dist=%.2f",
+				mi.Part, mi.Distance)
+		} else {
+			app.AddDebugLine([3]uint8{0, 255, 0}, "MI: nil")
+		}
 		// Draw
+		if mirror != nil {
+			mirror.Rasterize = capturer.pending() &&
+				win.GetAttrib(glfw.Iconified) == glfw.True
+		}
 		app.Draw(cam)
+		capturer.update()
 		// Finish the frame
 		win.SwapBuffers()
 	}
 }
 
+// updateHeldCell attaches the selected tool belt cell to the right hand of
+// the model.
+func updateHeldCell(m *mod.Model) {
+	c := toolBelt.getSelectedCell()
+	if c == heldCell {
+		return
+	}
+	heldCell = c
+	m.Detach("rightHand")
+	var p *c3d.Part
+	var err error
+	if c.IsCube() {
+		p, err = m.AttachCube("rightHand", c, t.O())
+	} else if _, vr, _ := c.Decompose(); c.IsVox() &&
+		int(vr) < len(mod.VoxDefs) {
+		p, err = m.AttachVox("rightHand", mod.VoxDefs[vr], t.O())
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if p != nil {
+		p.Scale = mgl32.Vec3{0.25, 0.25, 0.25}
+	}
+}
+
+// newBillboardID returns a new billboard draw descriptor ID. Billboard IDs
+// are allocated separately from those of the things they are attached to so
+// they never collide.
+func newBillboardID() uint32 {
+	lastBillboardID++
+	return lastBillboardID
+}
+
+// newNamePlate returns a billboard showing the name framed by a window nine
+// patch, anchored at the bottom center of the frame.
+func newNamePlate(name string) *c3d.BillboardDrawDescriptor {
+	m := app.NewUIMesh()
+	w := len(name)*t.VSGlyphWidth + t.CellDimsVS*2
+	h := t.VSCellHeight + t.CellDimsVS*2
+	m.NinePatch(0, 0, w, h, npWindow)
+	m.Print(t.CellDimsVS, t.CellDimsVS+t.VSBaseline, [3]uint8{255, 255, 255},
+		name)
+	return &c3d.BillboardDrawDescriptor{
+		ID:     newBillboardID(),
+		Mesh:   m,
+		Anchor: mgl32.Vec2{float32(w) / 2, float32(h)},
+	}
+}
+
 func glInit() (*c3d.App, error) {
-	if err := gl.Init(); err != nil {
+	b, err := c3d.NewGLES2Backend()
+	if err != nil {
 		return nil, err
 	}
-	gl.ClearColor(0, 0.5, 1, 0.0)
-	gl.Enable(gl.DEPTH_TEST)
-	gl.Enable(gl.BLEND)
-	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
-	gl.BlendEquation(gl.FUNC_ADD)
-	gl.ClearDepthf(1)
-	gl.DepthFunc(gl.LEQUAL)
-	gl.Enable(gl.CULL_FACE)
-	gl.CullFace(gl.BACK)
-	gl.FrontFace(gl.CW)
-	return c3d.NewApp(mod.Faces, mod.UITiles)
+	if !*mirrorCapture {
+		return c3d.NewApp(b, mod.Faces, mod.UITiles)
+	}
+	// Frames are mirrored to a software renderer so they can still be
+	// captured while the window is minimized
+	mirror = c3d.NewMirrorBackend(b, screenWidth, screenHeight)
+	return c3d.NewApp(mirror, mod.Faces, mod.UITiles)
 }
 
 // TODO DEBUG REMOVE
@@ -247,6 +362,8 @@ func TestGen(w *t.World) {
 	rect(t.IVec3{4, 1, 10}, t.IVec3{10, 3, 10}, t.CellForCube(rStone, t.North))
 	// Ceiling
 	rect(t.IVec3{4, 4, 4}, t.IVec3{10, 4, 10}, t.CellForCube(rStone, t.North))
+	// Chimney
+	w.SetCell(t.IVec3{9, 5, 5}, t.CellForCube(rStone, t.North))
 	// Window and doorway
 	w.SetCell(t.IVec3{6, 1, 10}, t.CellInvalid)
 	w.SetCell(t.IVec3{6, 2, 10}, t.CellInvalid)
@@ -275,15 +392,25 @@ func editInput() {
 	if input.ButtonPushed(0) {
 		p := t.PositionOffsets[wi.Face].Add(wi.Position)
 		c := toolBelt.getSelectedCell()
-		if c != t.CellInvalid {
+		if c != t.CellInvalid && world.CanPlace(p, c) {
 			world.SetCell(p, c)
 		}
 	}
 	if input.ButtonPushed(1) {
-		c := world.GetCell(wi.Position)
+		op, _ := world.VoxOrigin(wi.Position)
+		c := world.GetCell(op)
 		toolBelt.setSelectedItem(c)
 	}
 	if input.ButtonPushed(2) {
+		if cube := world.GetCube(wi.Position); cube != nil {
+			app.Particles.Emit(
+				mod.GetParticleEffect("/cubit/particles/effects/debris"),
+				mgl32.Vec3{
+					float32(wi.Position[0]) + 0.5,
+					float32(wi.Position[1]) + 0.5,
+					float32(wi.Position[2]) + 0.5,
+				}, cube.Faces[:])
+		}
 		world.SetCell(wi.Position, t.CellInvalid)
 	}
 }
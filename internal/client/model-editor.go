@@ -0,0 +1,424 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/c3d"
+	"github.com/qbradq/cubit/internal/mod"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// Model editor configuration
+const editorRotationStep float32 = 5 // Degrees rotated per key press
+const editorTimeStep float32 = 0.05  // Seconds scrubbed per key press
+const editorGizmoID uint32 = 2       // Line draw descriptor ID of the gizmos
+const editorLayer string = "editor"  // Animation layer used for playback
+
+// Gizmo colors
+var editorBoneColor = [4]uint8{255, 255, 255, 255}
+var editorJointColor = [4]uint8{255, 255, 0, 255}
+var editorSelectedColor = [4]uint8{255, 0, 255, 255}
+var editorAxisColors = [3][4]uint8{
+	{255, 0, 0, 255},
+	{0, 255, 0, 255},
+	{0, 0, 255, 255},
+}
+
+// modelEditor implements the skeletal model editor mode. The editor shows the
+// joints of a model with line gizmos and lets the user pick and rotate parts.
+// Without an animation open rotations edit the bind pose of the model. With
+// an animation open the timeline may be scrubbed and rotations keyed at the
+// current time.
+type modelEditor struct {
+	baseWidget
+	model     *mod.Model                  // Model being edited, nil if the editor is closed
+	modelPath string                      // Resource path of the model
+	desc      *mod.ModelDescriptor        // Descriptor of the model
+	anim      *mod.Animation              // Animation being edited, if any
+	animPath  string                      // Resource path of the animation
+	playback  *c3d.Animation              // Playback state of the animation
+	playing   bool                        // If true the animation is playing
+	parts     []string                    // IDs of all parts in depth-first order
+	selected  int                         // Index of the selected part
+	edits     map[string]mgl32.Quat       // Rotations not yet keyed by part ID
+	gizmo     *c3d.LineMesh               // Joint gizmos
+	gizmoDD   *c3d.LineMeshDrawDescriptor // Draw descriptor of the gizmos
+}
+
+// newModelEditor returns a new, closed model editor ready for use.
+func newModelEditor(app *c3d.App) *modelEditor {
+	ret := &modelEditor{
+		baseWidget: *newBaseWidget(app),
+		edits:      map[string]mgl32.Quat{},
+		gizmo:      c3d.NewLineMesh(),
+	}
+	ret.Layer = layerModelEditor
+	ret.gizmoDD = &c3d.LineMeshDrawDescriptor{
+		ID:          editorGizmoID,
+		Orientation: t.O(),
+		Mesh:        ret.gizmo,
+		Overlay:     true,
+	}
+	return ret
+}
+
+// add adds the widget and its gizmos to the app to be drawn.
+func (e *modelEditor) add(app *c3d.App) {
+	e.baseWidget.add(app)
+	app.AddLineDD(e.gizmoDD)
+}
+
+// isOpen returns true if a model is open in the editor.
+func (e *modelEditor) isOpen() bool {
+	return e.model != nil
+}
+
+// open opens the model with the given resource path in front of the camera,
+// closing any model already open.
+func (e *modelEditor) open(p string) error {
+	desc := mod.GetModelDescriptor(p)
+	if desc == nil {
+		return fmt.Errorf("unknown model %s", p)
+	}
+	e.close()
+	e.model = mod.NewModel(p)
+	e.modelPath = p
+	e.desc = desc
+	e.parts = e.model.PartIDs()
+	e.selected = 0
+	f := mgl32.Vec3{cam.Front[0], 0, cam.Front[2]}
+	if f.Len() < 0.001 {
+		f = mgl32.Vec3{0, 0, -1}
+	}
+	f = f.Normalize()
+	o := t.O().Translate(cam.Position.Add(f.Mul(3)))
+	o.Q = mgl32.QuatBetweenVectors(mgl32.Vec3{0, 0, 1}, f.Mul(-1))
+	e.model.DrawDescriptor.Orientation = o
+	app.AddModelDD(e.model.DrawDescriptor)
+	return nil
+}
+
+// openAnimation opens the animation with the given resource path for editing.
+// A new animation one second long is created if the path is unknown.
+func (e *modelEditor) openAnimation(p string) error {
+	if !e.isOpen() {
+		return fmt.Errorf("no model open")
+	}
+	a := mod.GetAnimation(p)
+	if a == nil {
+		a = &mod.Animation{
+			Length:   1,
+			Channels: map[string]*mod.AnimationChannels{},
+		}
+		mod.SetAnimation(p, a)
+	}
+	e.anim = a
+	e.animPath = p
+	e.playing = false
+	e.restart(0)
+	return nil
+}
+
+// setLength sets the length of the open animation in seconds.
+func (e *modelEditor) setLength(s float32) error {
+	if e.anim == nil {
+		return fmt.Errorf("no animation open")
+	}
+	if s <= 0 {
+		return fmt.Errorf("invalid animation length %g", s)
+	}
+	e.anim.Length = s
+	mod.SetAnimation(e.animPath, e.anim)
+	e.restart(min(e.playback.Time, s))
+	return nil
+}
+
+// save saves the open model and animation back to their mods.
+func (e *modelEditor) save() error {
+	if !e.isOpen() {
+		return fmt.Errorf("no model open")
+	}
+	if err := mod.SaveModel(e.modelPath); err != nil {
+		return err
+	}
+	if e.anim != nil {
+		if err := mod.SaveAnimation(e.animPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the model editor. Unsaved changes to the model and animation
+// remain in memory.
+func (e *modelEditor) close() {
+	if !e.isOpen() {
+		return
+	}
+	app.RemoveModelDD(e.model.DrawDescriptor.ID)
+	e.model = nil
+	e.desc = nil
+	e.anim = nil
+	e.playback = nil
+	e.playing = false
+	e.parts = nil
+	clear(e.edits)
+	e.gizmo.Reset()
+	e.Reset(false, true)
+}
+
+// restart restarts playback of the animation from a new clip at time s,
+// discarding all rotations not yet keyed.
+func (e *modelEditor) restart(s float32) {
+	clear(e.edits)
+	e.playback = e.model.PlayClip(editorLayer, e.anim.Clip(), 0, true)
+	e.playback.Time = s
+}
+
+// part returns the selected part and its ID.
+func (e *modelEditor) part() (*c3d.Part, string) {
+	if e.selected < 0 || e.selected >= len(e.parts) {
+		return nil, ""
+	}
+	id := e.parts[e.selected]
+	return e.model.Part(id), id
+}
+
+// time returns the current time into the animation rounded to the
+// millisecond.
+func (e *modelEditor) time() float32 {
+	return float32(math.Round(float64(e.playback.Time)*1000) / 1000)
+}
+
+// scrub moves the current time into the animation by steps time steps,
+// wrapping around the ends of the animation.
+func (e *modelEditor) scrub(steps int) {
+	l := e.playback.Clip.Length
+	if l <= 0 {
+		return
+	}
+	s := float32(math.Round(float64(e.playback.Time/editorTimeStep))) +
+		float32(steps)
+	s *= editorTimeStep
+	for s < 0 {
+		s += l
+	}
+	for s >= l {
+		s -= l
+	}
+	e.playing = false
+	clear(e.edits)
+	e.playback.Time = s
+}
+
+// rotate rotates the selected part about the axes of its parent by the given
+// degrees.
+func (e *modelEditor) rotate(pitch, yaw, roll float32) {
+	p, id := e.part()
+	if p == nil {
+		return
+	}
+	r := func(o t.Orientation) t.Orientation {
+		return o.Pitch(mgl32.DegToRad(pitch)).Yaw(mgl32.DegToRad(yaw)).
+			Roll(mgl32.DegToRad(roll))
+	}
+	if e.anim == nil {
+		d := e.desc.FindPart(id)
+		if d == nil {
+			return
+		}
+		d.Orientation = r(d.Orientation)
+		p.Pose.Q = d.Orientation.Q
+		return
+	}
+	e.playing = false
+	e.edits[id] = r(p.Orientation).Q
+}
+
+// key keys the rotation of the selected part at the current time.
+func (e *modelEditor) key() {
+	p, id := e.part()
+	if p == nil || e.anim == nil {
+		return
+	}
+	s := e.time()
+	e.anim.SetRotationKey(id, s, t.QuatToEuler(p.Orientation.Q))
+	mod.SetAnimation(e.animPath, e.anim)
+	e.restart(s)
+}
+
+// unkey removes all keys of the selected part at the current time.
+func (e *modelEditor) unkey() {
+	_, id := e.part()
+	if id == "" || e.anim == nil {
+		return
+	}
+	s := e.time()
+	e.anim.DeleteKeys(id, s)
+	mod.SetAnimation(e.animPath, e.anim)
+	e.restart(s)
+}
+
+// update implements the widget interface.
+func (e *modelEditor) update() {
+	if !e.isOpen() {
+		return
+	}
+	if e.playing {
+		e.model.Update(dt)
+	} else {
+		e.model.Update(0)
+	}
+	for id, q := range e.edits {
+		if p := e.model.Part(id); p != nil {
+			p.Orientation.Q = q
+		}
+	}
+	e.updateGizmo()
+	e.updateText()
+}
+
+// updateGizmo rebuilds the joint gizmos.
+func (e *modelEditor) updateGizmo() {
+	e.gizmo.Reset()
+	root := e.model.DrawDescriptor.Root
+	o := e.model.DrawDescriptor.Orientation
+	sel, _ := e.part()
+	for _, id := range e.parts {
+		p := e.model.Part(id)
+		parent, w, ok := c3d.PartWorld(root, p, o)
+		if !ok {
+			continue
+		}
+		if p != root {
+			e.gizmo.Line(parent.P, w.P, editorBoneColor)
+		}
+		c := editorJointColor
+		s := float32(0.05)
+		if p == sel {
+			c = editorSelectedColor
+			s = 0.08
+		}
+		for _, a := range []mgl32.Vec3{t.XAxis, t.YAxis, t.ZAxis} {
+			e.gizmo.Line(w.P.Sub(a.Mul(s)), w.P.Add(a.Mul(s)), c)
+		}
+		if p != sel {
+			continue
+		}
+		for i, a := range []mgl32.Vec3{t.XAxis, t.YAxis, t.ZAxis} {
+			e.gizmo.Line(w.P, w.P.Add(w.Q.Rotate(a).Mul(0.25)),
+				editorAxisColors[i])
+		}
+	}
+}
+
+// updateText redraws the status text of the editor.
+func (e *modelEditor) updateText() {
+	e.Reset(false, true)
+	x := t.CellDimsVS
+	y := t.CellDimsVS
+	line := func(c [3]uint8, f string, args ...any) {
+		e.Print(x, y, c, f, args...)
+		y += t.LineSpacingVS
+	}
+	line([3]uint8{0, 255, 255}, "Model: %s", e.modelPath)
+	p, id := e.part()
+	if e.anim == nil {
+		line([3]uint8{0, 255, 255}, "Animation: none (editing pose)")
+	} else {
+		state := "paused"
+		if e.playing {
+			state = "playing"
+		}
+		line([3]uint8{0, 255, 255}, "Animation: %s", e.animPath)
+		line([3]uint8{255, 255, 255}, "Time: %.2f / %.2f (%s)",
+			e.playback.Time, e.playback.Clip.Length, state)
+	}
+	if p == nil {
+		return
+	}
+	r := t.QuatToEuler(p.Orientation.Q)
+	line([3]uint8{255, 255, 0}, "Part: %s (%d/%d)", id, e.selected+1,
+		len(e.parts))
+	line([3]uint8{255, 255, 255}, "Rotation: X=%.1f Y=%.1f Z=%.1f",
+		r[0], r[1], r[2])
+	if _, edited := e.edits[id]; edited {
+		line([3]uint8{255, 127, 0}, "Rotation not keyed")
+	}
+	if e.anim == nil {
+		return
+	}
+	var keys []string
+	now := e.time()
+	for _, s := range e.anim.KeyTimes(id) {
+		k := fmt.Sprintf("%.2f", s)
+		if s == now {
+			k = "*" + k
+		}
+		keys = append(keys, k)
+	}
+	line([3]uint8{255, 255, 255}, "Keys: %s", strings.Join(keys, " "))
+}
+
+// input handles input while the editor is open.
+func (e *modelEditor) input() {
+	if !input.InUIMode && input.ButtonPushed(0) {
+		r := t.NewRay(cam.Position, cam.Front, 8.0)
+		if mi := e.model.Intersect(r); mi != nil {
+			if i := slices.Index(e.parts, mi.Part); i >= 0 {
+				e.selected = i
+			}
+		}
+	}
+	if input.WasPressed("editor-next-part") && len(e.parts) > 0 {
+		e.selected = (e.selected + 1) % len(e.parts)
+	}
+	if input.WasPressed("editor-pitch-inc") {
+		e.rotate(editorRotationStep, 0, 0)
+	}
+	if input.WasPressed("editor-pitch-dec") {
+		e.rotate(-editorRotationStep, 0, 0)
+	}
+	if input.WasPressed("editor-yaw-inc") {
+		e.rotate(0, editorRotationStep, 0)
+	}
+	if input.WasPressed("editor-yaw-dec") {
+		e.rotate(0, -editorRotationStep, 0)
+	}
+	if input.WasPressed("editor-roll-inc") {
+		e.rotate(0, 0, editorRotationStep)
+	}
+	if input.WasPressed("editor-roll-dec") {
+		e.rotate(0, 0, -editorRotationStep)
+	}
+	if input.WasPressed("editor-save") {
+		if err := e.save(); err != nil {
+			console.printf([3]uint8{255, 0, 0}, "error: %s", err)
+		} else {
+			console.printf([3]uint8{0, 255, 0}, "saved %s", e.modelPath)
+		}
+	}
+	if e.anim == nil {
+		return
+	}
+	if input.WasPressed("editor-time-inc") {
+		e.scrub(1)
+	}
+	if input.WasPressed("editor-time-dec") {
+		e.scrub(-1)
+	}
+	if input.WasPressed("editor-key") {
+		e.key()
+	}
+	if input.WasPressed("editor-unkey") {
+		e.unkey()
+	}
+	if input.WasPressed("editor-play") {
+		e.playing = !e.playing
+		clear(e.edits)
+	}
+}
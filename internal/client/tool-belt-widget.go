@@ -74,11 +74,11 @@ func (w *toolBeltWidget) updateCube(i int) {
 	if cube == t.CubeRefInvalid || int(cube) >= len(mod.CubeDefs) {
 		return
 	}
-	d := uint8(t.VirtualScreenGlyphSize * 2)
+	d := uint16(t.VirtualScreenGlyphSize * 2)
 	w.cds[i].Mesh.Reset()
 	c3d.AddCube(
-		[3]uint8{0, 0, 0},
-		[3]uint8{d, d, d},
+		[3]uint16{0, 0, 0},
+		[3]uint16{d, d, d},
 		d, f,
 		mod.CubeDefs[cube],
 		w.cds[i].Mesh,
@@ -5,12 +5,13 @@ import (
 )
 
 const (
-	layerToolBelt  uint16 = 0x0100
-	layerPalette   uint16 = 0x0200
-	layerCursor    uint16 = 0x7FFF
-	layerConsole   uint16 = 0x7FFE
-	layerCrosshair uint16 = 0x7FFD
-	layerHighest   uint16 = 0x7FFC
+	layerToolBelt    uint16 = 0x0100
+	layerPalette     uint16 = 0x0200
+	layerModelEditor uint16 = 0x0300
+	layerCursor      uint16 = 0x7FFF
+	layerConsole     uint16 = 0x7FFE
+	layerCrosshair   uint16 = 0x7FFD
+	layerHighest     uint16 = 0x7FFC
 )
 
 // baseWidget is a mixin struct that provides common functionality to all
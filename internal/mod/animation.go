@@ -1,10 +1,13 @@
 package mod
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/qbradq/cubit/internal/c3d"
@@ -28,51 +31,280 @@ func (q *AFQuat) UnmarshalJSON(d []byte) error {
 	return nil
 }
 
-// AnimationFrame describes a single frame of the animation.
+// AFEasing is the JSON structure used to hold the name of an easing function.
+type AFEasing c3d.Easing
+
+func (e *AFEasing) UnmarshalJSON(d []byte) error {
+	var s string
+	if err := json.Unmarshal(d, &s); err != nil {
+		return err
+	}
+	v, found := c3d.EasingByName(s)
+	if !found {
+		return fmt.Errorf("unknown easing function %s", s)
+	}
+	*e = AFEasing(v)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e AFEasing) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c3d.Easing(e).String())
+}
+
+// AnimationFrame describes a single frame of the animation in the frame list
+// format. The frame's values are reached Time seconds after the previous
+// frame.
 type AnimationFrame struct {
-	Time      float32           `json:"time"`      // Time T for interpolation to Q
-	Rotations map[string]AFQuat `json:"rotations"` // Map of part ID's to quaternions that describe that part's final rotation
+	Time      float32               `json:"time"`      // Time T for interpolation to Q
+	Easing    AFEasing              `json:"easing"`    // Easing function used to reach the frame
+	Rotations map[string]AFQuat     `json:"rotations"` // Map of part ID's to quaternions that describe that part's final rotation
+	Positions map[string]mgl32.Vec3 `json:"positions"` // Map of part ID's to translations from the bind pose in voxels
+	Scales    map[string]mgl32.Vec3 `json:"scales"`    // Map of part ID's to mesh scales
+	Events    []string              `json:"events"`    // Names of events fired when the frame is reached
 }
 
-// Animation contains the information and functionality to play an animation
-// loop on a model. Animations consist of rotations to joints only.
-type Animation []*AnimationFrame
+// AnimationKey describes a single key of a channel in the channel format.
+type AnimationKey struct {
+	Time   float32    `json:"time"`             // Time of the key from the start of the animation in seconds
+	Value  mgl32.Vec3 `json:"value"`            // Value of the key, rotations are in degrees
+	Easing AFEasing   `json:"easing,omitempty"` // Easing function used to reach the key
+	Event  string     `json:"event,omitempty"`  // Name of an event fired when the key is reached, if any
+}
+
+// AnimationEventDescriptor describes a named event at a point in time of an
+// animation.
+type AnimationEventDescriptor struct {
+	Time float32 `json:"time"` // Time of the event from the start of the animation in seconds
+	Name string  `json:"name"` // Name of the event
+}
+
+// AnimationChannels describes the independent channels of one part in the
+// channel format.
+type AnimationChannels struct {
+	Rotation []AnimationKey `json:"rotation,omitempty"` // Rotation keys
+	Position []AnimationKey `json:"position,omitempty"` // Translation keys in voxels
+	Scale    []AnimationKey `json:"scale,omitempty"`    // Mesh scale keys
+}
 
-// animationsMap is the map of resource paths to animations.
-var animationsMap = map[string]Animation{}
+// Animation describes an animation of a model's parts. Animations may be given
+// in JSON either as a list of frames, all channels of which share a timeline,
+// or as an object with a length and independent key lists for each channel of
+// each part.
+type Animation struct {
+	Length   float32                       `json:"length"`           // Length of the animation in seconds, zero for the time of the last key
+	Channels map[string]*AnimationChannels `json:"channels"`         // Channels by part ID
+	Events   []AnimationEventDescriptor    `json:"events,omitempty"` // Events fired during playback
+}
 
-func registerAnimation(p string, a Animation) error {
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *Animation) UnmarshalJSON(d []byte) error {
+	d = bytes.TrimSpace(d)
+	if len(d) > 0 && d[0] == '{' {
+		type animation Animation
+		return json.Unmarshal(d, (*animation)(a))
+	}
+	frames := []*AnimationFrame{}
+	if err := json.Unmarshal(d, &frames); err != nil {
+		return err
+	}
+	// Convert frames to channels
+	a.Channels = map[string]*AnimationChannels{}
+	channels := func(k string) *AnimationChannels {
+		ret := a.Channels[k]
+		if ret == nil {
+			ret = &AnimationChannels{}
+			a.Channels[k] = ret
+		}
+		return ret
+	}
+	var t float32
+	for _, f := range frames {
+		t += f.Time
+		for _, e := range f.Events {
+			a.Events = append(a.Events, AnimationEventDescriptor{
+				Time: t,
+				Name: e,
+			})
+		}
+		for k, v := range f.Rotations {
+			c := channels(k)
+			c.Rotation = append(c.Rotation, AnimationKey{
+				Time: t,
+				Value: mgl32.Vec3{
+					mgl32.RadToDeg(v[0]),
+					mgl32.RadToDeg(v[1]),
+					mgl32.RadToDeg(v[2]),
+				},
+				Easing: f.Easing,
+			})
+		}
+		for k, v := range f.Positions {
+			c := channels(k)
+			c.Position = append(c.Position, AnimationKey{
+				Time:   t,
+				Value:  v,
+				Easing: f.Easing,
+			})
+		}
+		for k, v := range f.Scales {
+			c := channels(k)
+			c.Scale = append(c.Scale, AnimationKey{
+				Time:   t,
+				Value:  v,
+				Easing: f.Easing,
+			})
+		}
+	}
+	a.Length = t
+	return nil
+}
+
+// Clip returns a new animation clip for the animation.
+func (a *Animation) Clip() *c3d.AnimationClip {
+	var channels []*c3d.Channel
+	var events []c3d.AnimationEvent
+	for _, e := range a.Events {
+		events = append(events, c3d.AnimationEvent{
+			Time: e.Time,
+			Name: e.Name,
+		})
+	}
+	add := func(joint string, ct c3d.ChannelType, keys []AnimationKey) {
+		if len(keys) < 1 {
+			return
+		}
+		c := &c3d.Channel{
+			Joint: joint,
+			Type:  ct,
+		}
+		for _, k := range keys {
+			if k.Event != "" {
+				events = append(events, c3d.AnimationEvent{
+					Time: k.Time,
+					Name: k.Event,
+				})
+			}
+			c.Keys = append(c.Keys, c3d.Keyframe{
+				Time:   k.Time,
+				Value:  k.Value,
+				Easing: c3d.Easing(k.Easing),
+			})
+		}
+		channels = append(channels, c)
+	}
+	for joint, c := range a.Channels {
+		// Rotation keys are authored in degrees
+		rotations := make([]AnimationKey, len(c.Rotation))
+		for i, k := range c.Rotation {
+			rotations[i] = k
+			for j := range k.Value {
+				rotations[i].Value[j] = mgl32.DegToRad(k.Value[j])
+			}
+		}
+		add(joint, c3d.ChannelRotation, rotations)
+		add(joint, c3d.ChannelPosition, c.Position)
+		add(joint, c3d.ChannelScale, c.Scale)
+	}
+	return c3d.NewAnimationClip(a.Length, channels, events)
+}
+
+// animationsMap is the map of resource paths to animation clips.
+var animationsMap = map[string]*c3d.AnimationClip{}
+
+// animationDescs is the map of resource paths to animation descriptions.
+var animationDescs = map[string]*Animation{}
+
+// registerAnimation registers an animation by resource path.
+func registerAnimation(p string, a *Animation) error {
 	if _, duplicate := animationsMap[p]; duplicate {
 		return fmt.Errorf("duplicate animation path %s", p)
 	}
-	animationsMap[p] = a
+	animationsMap[p] = a.Clip()
+	animationDescs[p] = a
 	return nil
 }
 
-// getAnimation constructs a new c3d.Animation object with the animation with
-// the given resource path.
-func getAnimation(p string, joints map[string]*mgl32.Quat) *c3d.Animation {
-	a := animationsMap[p]
-	if a == nil {
-		log.Printf("warning: unknown animation %s\n", p)
+// GetAnimation returns the description of the animation with the given
+// resource path, or nil if there is none.
+func GetAnimation(p string) *Animation {
+	return animationDescs[p]
+}
+
+// SetAnimation replaces or adds the animation with the given resource path.
+// Animations already playing continue with the previous clip.
+func SetAnimation(p string, a *Animation) {
+	animationsMap[p] = a.Clip()
+	animationDescs[p] = a
+}
+
+// KeyTimes returns the sorted times of all keys of the joint's channels.
+func (a *Animation) KeyTimes(joint string) []float32 {
+	c := a.Channels[joint]
+	if c == nil {
 		return nil
 	}
-	ret := &c3d.Animation{}
-	for _, f := range a {
-		af := &c3d.AnimationFrame{
-			Time:    f.Time,
-			TTL:     f.Time,
-			Joints:  make(map[string]*mgl32.Quat),
-			Targets: make(map[string]mgl32.Quat),
-			Starts:  make(map[string]mgl32.Quat),
-		}
-		for k, f := range f.Rotations {
-			af.Targets[k] = mgl32.AnglesToQuat(f[2], f[1], f[0], mgl32.ZYX)
+	var ret []float32
+	for _, keys := range [][]AnimationKey{c.Rotation, c.Position, c.Scale} {
+		for _, k := range keys {
+			if !slices.Contains(ret, k.Time) {
+				ret = append(ret, k.Time)
+			}
 		}
-		for k, j := range joints {
-			af.Joints[k] = j
-		}
-		ret.Frames = append(ret.Frames, af)
 	}
+	slices.Sort(ret)
 	return ret
 }
+
+// SetRotationKey sets the rotation key of the joint at time s to the X, Y,
+// and Z rotations in degrees, adding the key if needed.
+func (a *Animation) SetRotationKey(joint string, s float32, v mgl32.Vec3) {
+	if a.Channels == nil {
+		a.Channels = map[string]*AnimationChannels{}
+	}
+	c := a.Channels[joint]
+	if c == nil {
+		c = &AnimationChannels{}
+		a.Channels[joint] = c
+	}
+	for i := range c.Rotation {
+		if c.Rotation[i].Time == s {
+			c.Rotation[i].Value = v
+			return
+		}
+	}
+	c.Rotation = append(c.Rotation, AnimationKey{
+		Time:  s,
+		Value: v,
+	})
+	slices.SortStableFunc(c.Rotation, func(a, b AnimationKey) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+}
+
+// DeleteKeys removes all keys of the joint at time s.
+func (a *Animation) DeleteKeys(joint string, s float32) {
+	c := a.Channels[joint]
+	if c == nil {
+		return
+	}
+	del := func(k AnimationKey) bool {
+		return k.Time == s
+	}
+	c.Rotation = slices.DeleteFunc(c.Rotation, del)
+	c.Position = slices.DeleteFunc(c.Position, del)
+	c.Scale = slices.DeleteFunc(c.Scale, del)
+	if len(c.Rotation) == 0 && len(c.Position) == 0 && len(c.Scale) == 0 {
+		delete(a.Channels, joint)
+	}
+}
+
+// getAnimationClip returns the animation clip with the given resource path,
+// or nil if there is none.
+func getAnimationClip(p string) *c3d.AnimationClip {
+	a := animationsMap[p]
+	if a == nil {
+		log.Printf("warning: unknown animation %s\n", p)
+	}
+	return a
+}
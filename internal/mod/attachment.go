@@ -0,0 +1,160 @@
+package mod
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/c3d"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// cellVolume is a single cell implementing c3d.VoxelSource.
+type cellVolume t.Cell
+
+// Get implements the c3d.VoxelSource interface.
+func (c cellVolume) Get(x, y, z int) t.Cell {
+	if x != 0 || y != 0 || z != 0 {
+		return t.CellInvalid
+	}
+	return t.Cell(c)
+}
+
+// Dimensions implements the c3d.VoxelSource interface.
+func (c cellVolume) Dimensions() (w, h, d int) {
+	return 1, 1, 1
+}
+
+// IsEmpty implements the c3d.VoxelSource interface.
+func (c cellVolume) IsEmpty(v t.Cell) bool {
+	return !v.IsCube()
+}
+
+// cubeMeshes is the map of cube cells to the meshes attached for them. Meshes
+// are shared by all attachments of the same cell so that repeated attachments
+// do not allocate new GPU buffers.
+var cubeMeshes = map[t.Cell]*c3d.CubeMesh{}
+
+// cubeMesh returns the cube mesh of the cube cell c, building it on first use.
+func cubeMesh(c t.Cell) (*c3d.CubeMesh, error) {
+	if m, found := cubeMeshes[c]; found {
+		return m, nil
+	}
+	m := c3d.NewCubeMesh(CubeDefs)
+	if err := c3d.BuildCubeMesh(cellVolume(c), m); err != nil {
+		return nil, err
+	}
+	cubeMeshes[c] = m
+	return m, nil
+}
+
+// attach attaches the part to the named socket replacing anything already
+// attached to it. The position of the orientation o is in voxels.
+func (m *Model) attach(socket string, p *c3d.Part, o t.Orientation) error {
+	s := m.sockets[socket]
+	if s == nil {
+		return fmt.Errorf("unknown socket %s", socket)
+	}
+	p.ID = socket
+	p.Orientation = t.Orientation{
+		P: o.P.Mul(t.VoxelScale),
+		Q: o.Q,
+	}
+	p.Pose = p.Orientation
+	p.Scale = mgl32.Vec3{1, 1, 1}
+	s.Children = []*c3d.Part{p}
+	return nil
+}
+
+// AttachPartMesh attaches the part mesh at resource path p to the named
+// socket, replacing anything already attached to it. The point origin of the
+// mesh in voxels is placed at the socket offset by the orientation o, whose
+// position is in voxels. The attached part is returned so that it may be
+// further adjusted, for instance by setting its Scale.
+func (m *Model) AttachPartMesh(socket, p string, origin mgl32.Vec3,
+	o t.Orientation) (*c3d.Part, error) {
+	mesh := GetPartMesh(p)
+	if mesh == nil {
+		return nil, fmt.Errorf("unknown part mesh %s", p)
+	}
+	ret := &c3d.Part{
+		Mesh:   mesh,
+		Origin: origin,
+	}
+	if err := m.attach(socket, ret, o); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// AttachVox attaches the vox model to the named socket, replacing anything
+// already attached to it. The center of the vox model is placed at the socket
+// offset by the orientation o, whose position is in voxels. The attached part
+// is returned so that it may be further adjusted, for instance by setting its
+// Scale.
+func (m *Model) AttachVox(socket string, v *Vox, o t.Orientation) (*c3d.Part,
+	error) {
+	if v == nil {
+		return nil, fmt.Errorf("attaching nil vox model to socket %s", socket)
+	}
+	w, h, d := v.Dimensions()
+	ret := &c3d.Part{
+		Mesh:   v.Mesh,
+		Origin: mgl32.Vec3{float32(w) / 2, float32(h) / 2, float32(d) / 2},
+	}
+	if err := m.attach(socket, ret, o); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// AttachCube attaches a cube mesh of the cube cell c to the named socket,
+// replacing anything already attached to it. The center of the cube is placed
+// at the socket offset by the orientation o, whose position is in voxels. The
+// cube is 16 voxels across. The attached part is returned so that it may be
+// further adjusted, for instance by setting its Scale.
+func (m *Model) AttachCube(socket string, c t.Cell, o t.Orientation) (
+	*c3d.Part, error) {
+	if !c.IsCube() {
+		return nil, fmt.Errorf("cell %08X is not a cube", uint32(c))
+	}
+	cm, err := cubeMesh(c)
+	if err != nil {
+		return nil, err
+	}
+	ret := &c3d.Part{
+		Cube:   cm,
+		Origin: mgl32.Vec3{8, 8, 8},
+	}
+	if err := m.attach(socket, ret, o); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Detach removes anything attached to the named socket.
+func (m *Model) Detach(socket string) {
+	if s := m.sockets[socket]; s != nil {
+		s.Children = nil
+	}
+}
+
+// Attached returns the part attached to the named socket, or nil if there is
+// none.
+func (m *Model) Attached(socket string) *c3d.Part {
+	s := m.sockets[socket]
+	if s == nil || len(s.Children) == 0 {
+		return nil
+	}
+	return s.Children[0]
+}
+
+// Sockets returns the names of all sockets of the model in sorted order.
+func (m *Model) Sockets() []string {
+	ret := make([]string, 0, len(m.sockets))
+	for k := range m.sockets {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
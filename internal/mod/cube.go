@@ -2,6 +2,7 @@ package mod
 
 import (
 	"fmt"
+	"image"
 
 	"github.com/qbradq/cubit/internal/c3d"
 	"github.com/qbradq/cubit/internal/t"
@@ -10,6 +11,16 @@ import (
 // Faces is the global face atlas.
 var Faces *c3d.FaceAtlas = c3d.NewFaceAtlas()
 
+// faceImages holds a copy of every face graphic added to Faces by atlas index.
+// Animated faces hold the graphic of the first frame.
+var faceImages = map[t.FaceIndex]*image.RGBA{}
+
+// GetFaceImage returns the graphic for the face index, or nil if there is
+// none.
+func GetFaceImage(i t.FaceIndex) *image.RGBA {
+	return faceImages[i]
+}
+
 // GetCubeRef returns the CubeRef assigned to the given id.
 func GetCubeRef(id string) t.CubeRef {
 	c, found := cubeDefsById[id]
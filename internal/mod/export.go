@@ -0,0 +1,309 @@
+package mod
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/t"
+	"github.com/qbradq/cubit/internal/util"
+)
+
+// MaxExportDims is the largest dimension of an exported region along any axis
+// in voxels. The total number of voxels exported is further limited to
+// util.VoxMaxVolume so the export can be read back.
+const MaxExportDims = 4096
+
+// regionExporter holds the state of a single world region export.
+type regionExporter struct {
+	averages map[t.Cell][4]uint8        // Average colors by cell value
+	cubes    map[t.CubeRef][4]uint8     // Average colors by cube reference
+	expanded map[t.Cell]*[4096][4]uint8 // Expanded cell volumes by cell value
+}
+
+// ExportRegion returns the contents of the world region between the corners
+// a and b inclusive as a voxel model. If expand is false each cell becomes a
+// single voxel of the average color of the cube faces or vox model. If expand
+// is true each cell becomes 16x16x16 voxels sampled from the face graphics
+// of cubes, respecting cube shapes, and vox models are written rotated in
+// full, clipped to the region. An error is returned if the region exceeds
+// MaxExportDims or util.VoxMaxVolume voxels.
+func ExportRegion(w *t.World, a, b t.IVec3, expand bool) (*util.Vox, error) {
+	var p0, p1 t.IVec3
+	for i := 0; i < 3; i++ {
+		p0[i] = min(a[i], b[i])
+		p1[i] = max(a[i], b[i])
+	}
+	dims := p1.Sub(p0).Add(t.IVec3{1, 1, 1})
+	s := 1
+	if expand {
+		s = 16
+	}
+	for i := 0; i < 3; i++ {
+		if dims[i] < 1 || dims[i] > MaxExportDims/s {
+			return nil, fmt.Errorf(
+				"export region exceeds the limit of %d voxels per axis",
+				MaxExportDims)
+		}
+	}
+	if dims[0]*dims[1]*dims[2]*s*s*s > util.VoxMaxVolume {
+		return nil, fmt.Errorf("export region exceeds the limit of %d voxels",
+			util.VoxMaxVolume)
+	}
+	e := &regionExporter{
+		averages: map[t.Cell][4]uint8{},
+		cubes:    map[t.CubeRef][4]uint8{},
+		expanded: map[t.Cell]*[4096][4]uint8{},
+	}
+	// Primary cells of vox models already stamped in expanded exports
+	stamped := map[t.IVec3]bool{}
+	ret := util.NewVox(dims[0]*s, dims[1]*s, dims[2]*s)
+	for z := 0; z < dims[2]; z++ {
+		for y := 0; y < dims[1]; y++ {
+			for x := 0; x < dims[0]; x++ {
+				p := p0.Add(t.IVec3{x, y, z})
+				c := w.GetCell(p)
+				if !expand {
+					if op, ok := w.VoxOrigin(p); ok {
+						c = w.GetCell(op)
+					}
+					if c.IsCube() || c.IsVox() {
+						ret.Set(x, y, z, e.average(c))
+					}
+					continue
+				}
+				if op, ok := w.VoxOrigin(p); ok {
+					// Models are stamped once from their primary cell, which
+					// may lie outside of the region
+					if !stamped[op] {
+						stamped[op] = true
+						e.stampVox(ret, w.GetCell(op),
+							op.Sub(p0).Mul(t.IVec3{16, 16, 16}))
+					}
+					continue
+				}
+				if !c.IsCube() {
+					continue
+				}
+				vs := e.expand(c)
+				for i, v := range vs {
+					if v[3] < 255 {
+						continue
+					}
+					ret.Set(x*16+i%16, y*16+(i/16)%16, z*16+i/256, v)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// WriteRegion writes the contents of the world region between the corners a
+// and b inclusive to w in the MagicaVoxel .vox format. See ExportRegion.
+func WriteRegion(wr io.Writer, w *t.World, a, b t.IVec3, expand bool) error {
+	v, err := ExportRegion(w, a, b, expand)
+	if err != nil {
+		return err
+	}
+	return v.Write(wr)
+}
+
+// averageColors returns the average of all opaque colors, or a fully
+// transparent color if there are none.
+func averageColors(colors [][4]uint8) [4]uint8 {
+	var r, g, b, n int
+	for _, c := range colors {
+		if c[3] < 128 {
+			continue
+		}
+		r += int(c[0])
+		g += int(c[1])
+		b += int(c[2])
+		n++
+	}
+	if n == 0 {
+		return [4]uint8{0, 0, 0, 0}
+	}
+	return [4]uint8{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+}
+
+// average returns the average color of the cell.
+func (e *regionExporter) average(c t.Cell) [4]uint8 {
+	if ret, found := e.averages[c]; found {
+		return ret
+	}
+	var ret [4]uint8
+	cr, vr, _ := c.Decompose()
+	if cd := GetCubeDefFromRef(cr); cd != nil {
+		ret = e.cubeAverage(cd)
+	} else if int(vr) < len(VoxDefs) {
+		ret = averageColors(VoxDefs[vr].voxels)
+	}
+	e.averages[c] = ret
+	return ret
+}
+
+// cubeAverage returns the average color of all face graphics of the cube.
+func (e *regionExporter) cubeAverage(cd *t.Cube) [4]uint8 {
+	if ret, found := e.cubes[cd.Ref]; found {
+		return ret
+	}
+	var colors [][4]uint8
+	for _, fi := range cd.Faces {
+		img := GetFaceImage(fi)
+		if img == nil {
+			continue
+		}
+		for i := 0; i < len(img.Pix); i += 4 {
+			colors = append(colors, [4]uint8(img.Pix[i:i+4]))
+		}
+	}
+	ret := averageColors(colors)
+	e.cubes[cd.Ref] = ret
+	return ret
+}
+
+// expand returns the 16x16x16 voxels of the cube cell indexed by
+// z*256+y*16+x.
+func (e *regionExporter) expand(c t.Cell) *[4096][4]uint8 {
+	if ret, found := e.expanded[c]; found {
+		return ret
+	}
+	ret := &[4096][4]uint8{}
+	cr, _, f := c.Decompose()
+	if cd := GetCubeDefFromRef(cr); cd != nil {
+		for i := range ret {
+			ret[i] = cubeVoxel(cd, f, i%16, (i/16)%16, i/256)
+		}
+	}
+	e.expanded[c] = ret
+	return ret
+}
+
+// stampVox sets the voxels of the vox model of cell c, whose primary cell
+// origin is at voxel o of dst, rotated by the facing of the cell about the
+// center of the primary cell. Voxels outside of dst are discarded.
+func (e *regionExporter) stampVox(dst *util.Vox, c t.Cell, o t.IVec3) {
+	_, vr, f := c.Decompose()
+	if int(vr) >= len(VoxDefs) {
+		return
+	}
+	v := VoxDefs[vr]
+	q := t.FacingToOrientation[f].Q
+	h := mgl32.Vec3{0.5, 0.5, 0.5}
+	w, vh, d := v.Dimensions()
+	for z := 0; z < d; z++ {
+		for y := 0; y < vh; y++ {
+			for x := 0; x < w; x++ {
+				col := v.Color(x, y, z)
+				if col[3] < 255 {
+					continue
+				}
+				p := mgl32.Vec3{
+					(float32(x) + 0.5) * t.VoxelScale,
+					(float32(y) + 0.5) * t.VoxelScale,
+					(float32(z) + 0.5) * t.VoxelScale,
+				}
+				p = q.Rotate(p.Sub(h)).Add(h).Mul(1 / t.VoxelScale)
+				dst.Set(
+					o[0]+int(math.Floor(float64(p[0]))),
+					o[1]+int(math.Floor(float64(p[1]))),
+					o[2]+int(math.Floor(float64(p[2]))),
+					col,
+				)
+			}
+		}
+	}
+}
+
+// faceColor returns the color of the face graphic at the given texture
+// coordinates, or a fully transparent color if the texel is transparent.
+func faceColor(fi t.FaceIndex, u, v float32) [4]uint8 {
+	img := GetFaceImage(fi)
+	if img == nil {
+		return [4]uint8{0, 0, 0, 0}
+	}
+	px := min(max(int(u*t.FaceDims), 0), t.FaceDims-1)
+	py := min(max(int(v*t.FaceDims), 0), t.FaceDims-1)
+	c := img.RGBAAt(px, py)
+	if c.A < 128 {
+		return [4]uint8{0, 0, 0, 0}
+	}
+	return [4]uint8{c.R, c.G, c.B, 255}
+}
+
+// cubeVoxel returns the color of the voxel at the given position within a
+// cube with the given facing. Voxels outside the cube's shape are transparent.
+// Voxels within the shape take the color of the nearest face of the box they
+// are in, using the same texture mapping as the cube mesh.
+func cubeVoxel(cd *t.Cube, f t.Facing, x, y, z int) [4]uint8 {
+	p := mgl32.Vec3{
+		(float32(x) + 0.5) * t.VoxelScale,
+		(float32(y) + 0.5) * t.VoxelScale,
+		(float32(z) + 0.5) * t.VoxelScale,
+	}
+	inside := func(b t.AABB) bool {
+		return p[0] >= b[0][0] && p[0] <= b[1][0] &&
+			p[1] >= b[0][1] && p[1] <= b[1][1] &&
+			p[2] >= b[0][2] && p[2] <= b[1][2]
+	}
+	for _, b := range cd.Shape.Boxes(f) {
+		if !inside(b) {
+			continue
+		}
+		x0, y0, z0 := b[0][0], b[0][1], b[0][2]
+		x1, y1, z1 := b[1][0], b[1][1], b[1][2]
+		if cd.Shape == t.ShapeCross {
+			// Voxels on either diagonal plane, see CubeMesh.addCross
+			s := (p[0] - x0) / (x1 - x0)
+			d0 := z0 + s*(z1-z0)
+			d1 := z1 + s*(z0-z1)
+			if abs(p[2]-d0) < t.VoxelScale/2 ||
+				abs(p[2]-d1) < t.VoxelScale/2 {
+				return faceColor(cd.Faces[t.North], s, (y1-p[1])/(y1-y0))
+			}
+			return [4]uint8{0, 0, 0, 0}
+		}
+		dists := [6]float32{
+			p[2] - z0, // North
+			z1 - p[2], // South
+			x1 - p[0], // East
+			p[0] - x0, // West
+			y1 - p[1], // Top
+			p[1] - y0, // Bottom
+		}
+		side := t.North
+		for s := t.South; s <= t.Bottom; s++ {
+			if dists[s] < dists[side] {
+				side = s
+			}
+		}
+		// Texture coordinates, see CubeMesh.addBox
+		var u, v float32
+		switch side {
+		case t.North:
+			u, v = 1-p[0], 1-p[1]
+		case t.South:
+			u, v = p[0], 1-p[1]
+		case t.East:
+			u, v = 1-p[2], 1-p[1]
+		case t.West:
+			u, v = p[2], 1-p[1]
+		case t.Top:
+			u, v = p[0], p[2]
+		case t.Bottom:
+			u, v = 1-p[0], p[2]
+		}
+		return faceColor(cd.Faces[side], u, v)
+	}
+	return [4]uint8{0, 0, 0, 0}
+}
+
+// abs returns the absolute value of v.
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package mod
+
+import (
+	"testing"
+
+	"github.com/qbradq/cubit/internal/t"
+	"github.com/qbradq/cubit/internal/util"
+)
+
+// exportWorld returns a world holding a solid red vox model two cells wide
+// along the X axis placed at the origin. VoxDefs is restored by cleanup.
+func exportWorld(tb testing.TB) *t.World {
+	defs := VoxDefs
+	tb.Cleanup(func() { VoxDefs = defs })
+	v := &Vox{
+		width:  32,
+		height: 16,
+		depth:  16,
+		voxels: make([][4]uint8, 32*16*16),
+	}
+	for i := range v.voxels {
+		v.voxels[i] = [4]uint8{255, 0, 0, 255}
+	}
+	VoxDefs = []*Vox{v}
+	w := t.NewWorld(nil, GetVoxVolume)
+	w.SetCell(t.IVec3{}, t.CellForVox(0, t.North))
+	return w
+}
+
+// export exports the region of w between the corners a and b.
+func export(w *t.World, a, b [3]int, expand bool) (*util.Vox, error) {
+	return ExportRegion(w, t.IVec3(a), t.IVec3(b), expand)
+}
+
+func TestExportRegionLimits(t *testing.T) {
+	w := exportWorld(t)
+	tests := []struct {
+		name   string
+		b      [3]int
+		expand bool
+		ok     bool
+	}{
+		{"small", [3]int{3, 3, 3}, false, true},
+		{"small expanded", [3]int{3, 3, 3}, true, true},
+		{"axis", [3]int{100000, 0, 0}, false, false},
+		{"axis expanded", [3]int{MaxExportDims / 16, 0, 0}, true, false},
+		{"volume", [3]int{1000, 1000, 1000}, false, false},
+		{"volume expanded", [3]int{16, 16, 16}, true, false},
+		{"huge", [3]int{100000, 100000, 100000}, true, false},
+	}
+	for _, tt := range tests {
+		_, err := export(w, [3]int{}, tt.b, tt.expand)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
+
+func TestExportRegionVoxFiller(t *testing.T) {
+	w := exportWorld(t)
+	// Only the filler cell of the model is within the region
+	v, err := export(w, [3]int{1, 0, 0}, [3]int{1, 0, 0}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := [4]uint8{255, 0, 0, 255}
+	for _, p := range [][3]int{{0, 0, 0}, {15, 15, 15}} {
+		if got := v.Get(p[0], p[1], p[2]); got != red {
+			t.Errorf("voxel %v is %v, want %v", p, got, red)
+		}
+	}
+	// The non-expanded export uses the color of the model
+	v, err = export(w, [3]int{1, 0, 0}, [3]int{1, 0, 0}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Get(0, 0, 0); got != red {
+		t.Errorf("voxel is %v, want %v", got, red)
+	}
+}
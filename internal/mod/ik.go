@@ -0,0 +1,109 @@
+package mod
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/c3d"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// newIKChain returns a new IK chain for the limb descriptor using the given
+// joints, or nil if the limb references unknown parts.
+func newIKChain(d *ModelLimbDescriptor,
+	joints map[string]*c3d.Part) *c3d.IKChain {
+	ret := &c3d.IKChain{
+		Upper: joints[d.Upper],
+		Pole:  d.Pole,
+	}
+	if ret.Upper == nil {
+		return nil
+	}
+	last := ret.Upper
+	if d.Lower != "" {
+		ret.Lower = joints[d.Lower]
+		if ret.Lower == nil {
+			return nil
+		}
+		last = ret.Lower
+	}
+	ret.End = d.End.Sub(last.Origin).Mul(t.VoxelScale)
+	return ret
+}
+
+// LimbEnd returns the position of the end of the named limb in world space.
+// False is returned if the model has no such limb.
+func (m *Model) LimbEnd(limb string) (mgl32.Vec3, bool) {
+	c := m.limbs[limb]
+	if c == nil {
+		return mgl32.Vec3{}, false
+	}
+	return c.EndPosition(m.DrawDescriptor.Root, m.DrawDescriptor.Orientation)
+}
+
+// SolveLimb bends the named limb so that its end reaches for the target in
+// world space. The weight in the range 0-1 blends between the animated pose
+// and the solution. True is returned if the target was reached. This must be
+// called after Update every frame the limb should be adjusted, as Update
+// replaces the pose with the result of the animations.
+func (m *Model) SolveLimb(limb string, target mgl32.Vec3, weight float32) bool {
+	c := m.limbs[limb]
+	if c == nil {
+		return false
+	}
+	return c.Solve(m.DrawDescriptor.Root, m.DrawDescriptor.Orientation, target,
+		weight)
+}
+
+// PlaceFeet adjusts the named limbs so that their ends rest on the surface
+// of the world below them, within maxStep world units above or below their
+// animated positions. The root part of the model is lowered to let the
+// lowest foot reach the ground and all other feet are raised with inverse
+// kinematics. Feet with no ground within range keep their animated positions.
+// This must be called after Update every frame.
+func (m *Model) PlaceFeet(w *t.World, maxStep float32, limbs ...string) {
+	root := m.DrawDescriptor.Root
+	o := m.DrawDescriptor.Orientation
+	if root == nil || maxStep <= 0 {
+		return
+	}
+	targets := make([]mgl32.Vec3, len(limbs))
+	valid := make([]bool, len(limbs))
+	var drop float32
+	for i, limb := range limbs {
+		e, ok := m.LimbEnd(limb)
+		if !ok {
+			continue
+		}
+		valid[i] = true
+		targets[i] = e
+		r := t.NewRay(e.Add(mgl32.Vec3{0, maxStep, 0}), mgl32.Vec3{0, -1, 0},
+			maxStep*2)
+		wi := r.IntersectWorld(w)
+		if wi == nil {
+			continue
+		}
+		targets[i][1] = r.O[1] - wi.Distance
+		drop = min(drop, targets[i][1]-e[1])
+	}
+	if drop < 0 {
+		root.Orientation.P = root.Orientation.P.Add(
+			o.Q.Inverse().Rotate(mgl32.Vec3{0, drop, 0}))
+	}
+	for i, limb := range limbs {
+		if valid[i] {
+			m.SolveLimb(limb, targets[i], 1)
+		}
+	}
+}
+
+// LookAt turns the look-at part of the model, usually the head, toward the
+// target in world space within the limits given by the model. The weight in
+// the range 0-1 blends between the animated pose and the solution. This must
+// be called after Update every frame.
+func (m *Model) LookAt(target mgl32.Vec3, weight float32) {
+	if m.lookAt == nil {
+		return
+	}
+	c3d.LookAt(m.DrawDescriptor.Root, m.joints[m.lookAt.Part],
+		m.DrawDescriptor.Orientation, m.lookAt.Forward, target,
+		mgl32.DegToRad(m.lookAt.MaxAngle), weight)
+}
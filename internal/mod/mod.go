@@ -51,6 +51,9 @@ func newMod() *Mod {
 // Mods is the global map of all mods by ID
 var Mods = map[string]*Mod{}
 
+// loadedMods are the mods loaded by LoadMods in order.
+var loadedMods []*Mod
+
 // loadModInfo loads the top-level info for a single mod.
 func loadModInfo(name string) error {
 	if _, duplicate := Mods[name]; duplicate {
@@ -68,14 +71,20 @@ func loadModInfo(name string) error {
 // ReloadModInfo reloads all top-level info for all mods present.
 func ReloadModInfo() error {
 	Mods = map[string]*Mod{}
+	loadedMods = nil
 	cubeDefsById = map[string]*t.Cube{}
 	CubeDefs = []*t.Cube{}
 	voxIndex = map[string]*Vox{}
+	VoxDefs = []*Vox{}
 	Faces = c3d.NewFaceAtlas()
+	faceImages = map[t.FaceIndex]*image.RGBA{}
 	UITiles = c3d.NewFaceAtlas()
 	partsMeshMap = map[string]*c3d.VoxelMesh{}
 	modelsMap = map[string]*ModelDescriptor{}
-	animationsMap = map[string]Animation{}
+	animationsMap = map[string]*c3d.AnimationClip{}
+	animationDescs = map[string]*Animation{}
+	particleEffectsMap = map[string]*c3d.ParticleEffect{}
+	cubeMeshes = map[t.Cell]*c3d.CubeMesh{}
 	dirs, err := os.ReadDir("mods")
 	if err != nil {
 		return err
@@ -93,6 +102,17 @@ func ReloadModInfo() error {
 	return nil
 }
 
+// ShaderSources returns the file systems of the loaded mods to search for
+// shader program overrides, with later mods taking priority over earlier
+// ones. Programs are read from glsl/<name>.glsl within each mod.
+func ShaderSources() []fs.FS {
+	ret := make([]fs.FS, 0, len(loadedMods))
+	for i := len(loadedMods) - 1; i >= 0; i-- {
+		ret = append(ret, loadedMods[i].f)
+	}
+	return ret
+}
+
 // LoadMods loads the named mods in order.
 func LoadMods(mods ...string) error {
 	ms := []*Mod{}
@@ -103,6 +123,7 @@ func LoadMods(mods ...string) error {
 		}
 		ms = append(ms, mod)
 	}
+	loadedMods = ms
 	stage := func(fn func(*Mod) error) error {
 		for _, mod := range ms {
 			if err := fn(mod); err != nil {
@@ -132,6 +153,9 @@ func LoadMods(mods ...string) error {
 	if err := stage(func(m *Mod) error { return m.loadAnimations() }); err != nil {
 		return err
 	}
+	if err := stage(func(m *Mod) error { return m.loadParticles() }); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -185,6 +209,26 @@ func (m *Mod) loadFaces() error {
 	})
 }
 
+// facePageDims validates the dimensions of a face or ui page image and returns
+// the dimensions of the page in faces.
+func facePageDims(img image.Image) (w, h int, err error) {
+	b := img.Bounds()
+	if b.Dx() < t.FaceDims || b.Dy() < t.FaceDims ||
+		b.Dx() > t.FaceDims*t.FacePageDims ||
+		b.Dy() > t.FaceDims*t.FacePageDims {
+		return 0, 0, fmt.Errorf(
+			"page images must be between %dx%d and %dx%d pixels in size",
+			t.FaceDims, t.FaceDims,
+			t.FaceDims*t.FacePageDims, t.FaceDims*t.FacePageDims)
+	}
+	if b.Dx()%t.FaceDims != 0 || b.Dy()%t.FaceDims != 0 {
+		return 0, 0, fmt.Errorf(
+			"page image dimensions must be multiples of %d pixels",
+			t.FaceDims)
+	}
+	return b.Dx() / t.FaceDims, b.Dy() / t.FaceDims, nil
+}
+
 // loadFacePage loads the image as a face page, adding all non-empty (all
 // transparent) faces into Faces.
 func (m *Mod) loadFacePage(n uint8, r io.Reader) error {
@@ -200,21 +244,29 @@ func (m *Mod) loadFacePage(n uint8, r io.Reader) error {
 	if err != nil {
 		return m.wrap("decoding face %d", err, n)
 	}
-	if img.Bounds().Max.X != 256 || img.Bounds().Max.Y != 256 {
-		return m.wrap("decoding face",
-			errors.New("face page images must be 256x256 pixels in size"))
+	w, h, err := facePageDims(img)
+	if err != nil {
+		return m.wrap("decoding face %d", err, n)
 	}
+	min := img.Bounds().Min
 	face := image.NewRGBA(image.Rect(0, 0, t.FaceDims, t.FaceDims))
-	for fy := 0; fy < 8; fy++ {
-		for fx := 0; fx < 8; fx++ {
+	for fy := 0; fy < h; fy++ {
+		for fx := 0; fx < w; fx++ {
 			draw.Draw(
 				face, image.Rect(0, 0, t.FaceDims, t.FaceDims),
-				img, image.Pt(fx*t.FaceDims, fy*t.FaceDims),
+				img, min.Add(image.Pt(fx*t.FaceDims, fy*t.FaceDims)),
 				draw.Src)
 			if isEmpty(face) {
 				continue
 			}
-			m.faceMap[t.FaceIndexFromXYZ(fx, fy, int(n))] = Faces.AddFace(face)
+			fi := Faces.AddFace(face)
+			if fi == t.FaceIndexInvalid {
+				return m.wrap("adding face %d", errors.New("face atlas is full"),
+					n)
+			}
+			m.faceMap[t.FaceIndexFromXYZ(fx, fy, int(n))] = fi
+			faceImages[fi] = image.NewRGBA(face.Rect)
+			copy(faceImages[fi].Pix, face.Pix)
 		}
 	}
 	return nil
@@ -256,6 +308,20 @@ func (m *Mod) loadCubes() error {
 			cube.ID = "/" + m.ID + "/cubes/" + k
 			// Convert mod-relative face references to global
 			for i := range cube.Faces {
+				if a := cube.Animations[i]; a != nil {
+					for j, f := range a.Frames {
+						fi, found := m.faceMap[f]
+						if !found {
+							fi = t.FaceIndexInvalid
+						}
+						a.Frames[j] = fi
+					}
+					cube.Faces[i] = Faces.AddAnimation(a.Frames, a.FrameTime)
+					if cube.Faces[i] != t.FaceIndexInvalid {
+						faceImages[cube.Faces[i]] = faceImages[a.Frames[0]]
+					}
+					continue
+				}
 				fi, found := m.faceMap[cube.Faces[i]]
 				if !found {
 					cube.Faces[i] = t.FaceIndexInvalid
@@ -300,9 +366,12 @@ func (m *Mod) loadVox() error {
 		if vf, err := util.NewVoxFromReader(f); err != nil {
 			return m.wrap("processing vox file %s", err, path)
 		} else {
-			if vf.Width != 16 || vf.Height != 16 || vf.Depth != 16 {
-				return m.wrap("validating vox file %s",
-					errors.New("vox models must be 16x16x16"), path)
+			for _, d := range []int{vf.Width, vf.Height, vf.Depth} {
+				if d < 16 || d > t.VoxMaxCells*16 || d%16 != 0 {
+					return m.wrap("validating vox file %s", fmt.Errorf(
+						"vox dimensions must be multiples of 16 up to %d",
+						t.VoxMaxCells*16), path)
+				}
 			}
 			registerVox(modPath, NewVox(vf))
 		}
@@ -366,22 +435,28 @@ func (m *Mod) loadUIPage(n uint8, r io.Reader) error {
 	if err != nil {
 		return m.wrap("decoding ui tile %d", err, n)
 	}
-	if img.Bounds().Max.X != 256 || img.Bounds().Max.Y != 256 {
-		return m.wrap("decoding ui tile",
-			errors.New("ui page images must be 256x256 pixels in size"))
+	w, h, err := facePageDims(img)
+	if err != nil {
+		return m.wrap("decoding ui tile %d", err, n)
 	}
+	min := img.Bounds().Min
 	face := image.NewRGBA(image.Rect(0, 0, t.FaceDims, t.FaceDims))
-	for fy := 0; fy < 16; fy++ {
-		for fx := 0; fx < 16; fx++ {
+	for fy := 0; fy < h; fy++ {
+		for fx := 0; fx < w; fx++ {
 			draw.Draw(
 				face, image.Rect(0, 0, t.FaceDims, t.FaceDims),
-				img, image.Pt(fx*t.FaceDims, fy*t.FaceDims),
+				img, min.Add(image.Pt(fx*t.FaceDims, fy*t.FaceDims)),
 				draw.Src)
 			if isEmpty(face) {
 				continue
 			}
+			fi := UITiles.AddFace(face)
+			if fi == t.FaceIndexInvalid {
+				return m.wrap("adding ui tile %d",
+					errors.New("ui tile atlas is full"), n)
+			}
 			ns := fmt.Sprintf("/%s/%0X%01X%01X", m.ID, n, fy, fx)
-			uiTilesMap[ns] = UITiles.AddFace(face)
+			uiTilesMap[ns] = fi
 		}
 	}
 	return nil
@@ -501,7 +576,51 @@ func (m *Mod) loadAnimations() error {
 			return m.wrap("unmarshaling animations file %s", err, path)
 		}
 		for k, a := range as {
-			if err := registerAnimation(modPath+"/"+k, *a); err != nil {
+			if err := registerAnimation(modPath+"/"+k, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadParticles loads all particle effect definitions for the mod.
+func (m *Mod) loadParticles() error {
+	return fs.WalkDir(m.f, "particles", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return m.wrap("walking particles directory, path=%s", err, path)
+		}
+		if len(path) < 1 {
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := filepath.Ext(path)
+		ext = strings.ToLower(ext)
+		ns := path[:len(path)-len(ext)]
+		if ext != ".json" {
+			return nil
+		}
+		modPath := "/" + m.ID + "/" + ns
+		f, err := m.f.Open(path)
+		if err != nil {
+			return m.wrap("opening particles file %s", err, path)
+		}
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return m.wrap("reading particles file %s", err, path)
+		}
+		es := map[string]*ParticleEffect{}
+		if err := json.Unmarshal(data, &es); err != nil {
+			return m.wrap("unmarshaling particles file %s", err, path)
+		}
+		for k, e := range es {
+			if err := registerParticleEffect(modPath+"/"+k,
+				e.Effect(m)); err != nil {
 				return err
 			}
 		}
@@ -10,33 +10,72 @@ import (
 
 // ModelPartDescriptor describes how to initialize a part attached to a model.
 type ModelPartDescriptor struct {
-	ID          string                `json:"id"`          // ID of the part for animations
-	Mesh        string                `json:"mesh"`        // Absolute path to the part mesh
-	Origin      mgl32.Vec3            `json:"origin"`      // Center point / rotation point of the part
-	Orientation t.Orientation         `json:"orientation"` // T-pose orientation
-	Children    []ModelPartDescriptor `json:"children"`    // Child parts
+	ID          string                   `json:"id"`                 // ID of the part for animations
+	Mesh        string                   `json:"mesh,omitempty"`     // Absolute path to the part mesh
+	Origin      mgl32.Vec3               `json:"origin"`             // Center point / rotation point of the part
+	Orientation t.Orientation            `json:"orientation"`        // T-pose orientation
+	Sockets     map[string]t.Orientation `json:"sockets,omitempty"`  // Attachment points by name relative to the part's origin
+	Children    []ModelPartDescriptor    `json:"children,omitempty"` // Child parts
 }
 
-// ModelDescriptor describes how to initialize a model.
-type ModelDescriptor struct {
-	Bounds t.AABB               `json:"bounds"` // Bounding box of the model
-	Root   *ModelPartDescriptor `json:"root"`   // Root part
+// ModelLimbDescriptor describes a limb of one or two parts solved with
+// inverse kinematics.
+type ModelLimbDescriptor struct {
+	Upper string     `json:"upper"`           // ID of the upper part of the limb
+	Lower string     `json:"lower,omitempty"` // ID of the lower part of the limb, if any
+	End   mgl32.Vec3 `json:"end"`             // End effector of the limb in the last part's mesh coordinates
+	Pole  mgl32.Vec3 `json:"pole"`            // Direction in model space the limb bends toward
+}
+
+// ModelLookAtDescriptor describes the part that turns to look at points of
+// interest.
+type ModelLookAtDescriptor struct {
+	Part     string     `json:"part"`               // ID of the part, usually the head
+	Forward  mgl32.Vec3 `json:"forward"`            // Direction the part faces in its bind pose
+	MaxAngle float32    `json:"maxAngle,omitempty"` // Maximum rotation from the animated pose in degrees, zero for no limit
 }
 
-// animationContext is a context for an animation.
-type animationContext struct {
-	a       *c3d.Animation // Animation to play
-	running bool           // If true the animation is running
+// ModelDescriptor describes how to initialize a model.
+type ModelDescriptor struct {
+	Bounds t.AABB                          `json:"bounds"`           // Bounding box of the model
+	Root   *ModelPartDescriptor            `json:"root"`             // Root part
+	Limbs  map[string]*ModelLimbDescriptor `json:"limbs,omitempty"`  // Limbs solved with inverse kinematics by name
+	LookAt *ModelLookAtDescriptor          `json:"lookAt,omitempty"` // Look-at part, if any
 }
 
 // Model describes a hierarchy of parts with defined animations.
 type Model struct {
-	DrawDescriptor *c3d.ModelDrawDescriptor    // The draw descriptor this model manages
-	Bounds         t.AABB                      // Model bounds in world coordinates
-	joints         map[string]*mgl32.Quat      // Joint name to rotation quaternion mapping
-	animations     map[string]animationContext // Animation layer name to running animation map
+	DrawDescriptor *c3d.ModelDrawDescriptor // The draw descriptor this model manages
+	Bounds         t.AABB                   // Model bounds in world coordinates
+	joints         map[string]*c3d.Part     // Joint name to part mapping
+	sockets        map[string]*c3d.Part     // Socket name to socket part mapping
+	limbs          map[string]*c3d.IKChain  // Inverse kinematics limbs by name
+	lookAt         *ModelLookAtDescriptor   // Look-at part description, if any
+	animator       *c3d.Animator            // Blends animation layers onto the parts
+	listeners      []animationListener      // Animation event listeners
+	events         []AnimationEvent         // Events pending dispatch
+}
+
+// AnimationEvent describes an animation event fired on a model.
+type AnimationEvent struct {
+	Model *Model  // Model the animation is playing on
+	Layer string  // Animation layer the animation is playing on
+	Name  string  // Name of the event
+	Time  float32 // Time of the event from the start of the animation
+}
+
+// AnimationListener is a function that receives animation events.
+type AnimationListener func(e *AnimationEvent)
+
+// animationListener is a registered animation listener.
+type animationListener struct {
+	name string            // Event name to listen for, empty for all events
+	fn   AnimationListener // Listener function
 }
 
+// nextModelID is the draw descriptor ID of the next model created.
+var nextModelID uint32 = 1
+
 // modelsMap is the mapping of resource paths to model descriptors.
 var modelsMap = map[string]*ModelDescriptor{}
 
@@ -49,9 +88,38 @@ func registerModel(p string, m *ModelDescriptor) error {
 	return nil
 }
 
+// GetModelDescriptor returns the model descriptor with the given resource
+// path, or nil if there is none. Changes to the descriptor apply to models
+// created afterward.
+func GetModelDescriptor(p string) *ModelDescriptor {
+	return modelsMap[p]
+}
+
+// FindPart returns the descriptor of the part with the given ID, or nil if
+// there is none.
+func (d *ModelDescriptor) FindPart(id string) *ModelPartDescriptor {
+	var fn func(p *ModelPartDescriptor) *ModelPartDescriptor
+	fn = func(p *ModelPartDescriptor) *ModelPartDescriptor {
+		if p.ID == id {
+			return p
+		}
+		for i := range p.Children {
+			if ret := fn(&p.Children[i]); ret != nil {
+				return ret
+			}
+		}
+		return nil
+	}
+	if d.Root == nil {
+		return nil
+	}
+	return fn(d.Root)
+}
+
 // newPart creates a new part (and its children) from the part descriptor
-// given.
-func newPart(d *ModelPartDescriptor) *c3d.Part {
+// given. Sockets of the part are added to the sockets map as child parts
+// without meshes.
+func newPart(d *ModelPartDescriptor, sockets map[string]*c3d.Part) *c3d.Part {
 	ret := &c3d.Part{
 		ID:     d.ID,
 		Mesh:   GetPartMesh(d.Mesh),
@@ -60,76 +128,282 @@ func newPart(d *ModelPartDescriptor) *c3d.Part {
 			P: d.Orientation.P.Mul(t.VoxelScale),
 			Q: d.Orientation.Q,
 		},
+		Scale: mgl32.Vec3{1, 1, 1},
 	}
+	ret.Pose = ret.Orientation
 	for _, cd := range d.Children {
-		ret.Children = append(ret.Children, newPart(&cd))
+		ret.Children = append(ret.Children, newPart(&cd, sockets))
+	}
+	for name, o := range d.Sockets {
+		s := &c3d.Part{
+			ID: name,
+			Orientation: t.Orientation{
+				P: o.P.Mul(t.VoxelScale),
+				Q: o.Q,
+			},
+			Scale: mgl32.Vec3{1, 1, 1},
+		}
+		s.Pose = s.Orientation
+		sockets[name] = s
+		ret.Children = append(ret.Children, s)
 	}
 	return ret
 }
 
 // NewModel constructs a new model from the model descriptor at the resource
-// path given.
+// path given. Each model has its own draw descriptor ID and animation state.
+// Part meshes and animation clips are shared by all models.
 func NewModel(p string) *Model {
 	d := modelsMap[p]
 	if d == nil {
 		return nil
 	}
+	id := nextModelID
+	nextModelID++
+	sockets := map[string]*c3d.Part{}
 	ret := &Model{
 		DrawDescriptor: &c3d.ModelDrawDescriptor{
-			ID: 1,
+			ID: id,
 			Bounds: c3d.AABB{
 				Bounds: d.Bounds,
 			},
 			Orientation: t.O(),
-			Root:        newPart(d.Root),
+			Root:        newPart(d.Root, sockets),
 		},
-		joints:     make(map[string]*mgl32.Quat),
-		animations: make(map[string]animationContext),
+		joints:  make(map[string]*c3d.Part),
+		sockets: sockets,
 	}
 	var fn func(p *c3d.Part)
 	fn = func(p *c3d.Part) {
-		ret.joints[p.ID] = &p.Orientation.Q
+		if sockets[p.ID] == p {
+			return
+		}
+		ret.joints[p.ID] = p
 		for _, c := range p.Children {
 			fn(c)
 		}
 	}
 	fn(ret.DrawDescriptor.Root)
+	ret.limbs = map[string]*c3d.IKChain{}
+	for k, ld := range d.Limbs {
+		if c := newIKChain(ld, ret.joints); c != nil {
+			ret.limbs[k] = c
+		}
+	}
+	if d.LookAt != nil && ret.joints[d.LookAt.Part] != nil {
+		ret.lookAt = d.LookAt
+	}
+	ret.animator = c3d.NewAnimator(ret.joints)
+	ret.animator.OnEvent = func(l *c3d.AnimationLayer, e c3d.AnimationEvent) {
+		ret.events = append(ret.events, AnimationEvent{
+			Model: ret,
+			Layer: l.Name,
+			Name:  e.Name,
+			Time:  e.Time,
+		})
+	}
 	return ret
 }
 
-// StartAnimation starts the animation identified by the resource path on the
-// model on the given animation layer.
-func (m *Model) StartAnimation(p, l string) {
-	var a *c3d.Animation
-	if ac, found := m.animations[l]; found {
-		if ac.running {
+// Part returns the part with the given ID, or nil if there is none. Sockets
+// are not parts.
+func (m *Model) Part(id string) *c3d.Part {
+	return m.joints[id]
+}
+
+// PartIDs returns the IDs of all parts of the model in depth-first order.
+func (m *Model) PartIDs() []string {
+	var ret []string
+	var fn func(p *c3d.Part)
+	fn = func(p *c3d.Part) {
+		if m.joints[p.ID] != p {
 			return
 		}
-		a = ac.a
-	} else {
-		a = getAnimation(p, m.joints)
+		ret = append(ret, p.ID)
+		for _, c := range p.Children {
+			fn(c)
+		}
+	}
+	if m.DrawDescriptor.Root != nil {
+		fn(m.DrawDescriptor.Root)
+	}
+	return ret
+}
+
+// PlayClip starts the animation clip on the given animation layer,
+// crossfading from any animation playing on the layer over fade seconds, and
+// returns the playback state of the animation.
+func (m *Model) PlayClip(l string, c *c3d.AnimationClip, fade float32,
+	loop bool) *c3d.Animation {
+	return m.animator.Play(l, c, fade, loop, nil)
+}
+
+// StartAnimation starts the looping animation identified by the resource path
+// on the given animation layer, replacing any animation playing on the layer
+// immediately. If the animation is already playing on the layer it continues
+// uninterrupted.
+func (m *Model) StartAnimation(p, l string) {
+	m.CrossfadeAnimation(p, l, 0)
+}
+
+// CrossfadeAnimation starts the looping animation identified by the resource
+// path on the given animation layer, crossfading from any animation playing on
+// the layer over fade seconds.
+func (m *Model) CrossfadeAnimation(p, l string, fade float32) {
+	c := getAnimationClip(p)
+	if c == nil {
+		return
+	}
+	m.animator.Play(l, c, fade, true, nil)
+}
+
+// PlayAnimationOnce plays the animation identified by the resource path once
+// on the given animation layer, crossfading in and out over fade seconds. The
+// done function, if not nil, is called once the animation has completed or
+// has been stopped or replaced.
+func (m *Model) PlayAnimationOnce(p, l string, fade float32, done func()) {
+	c := getAnimationClip(p)
+	if c == nil {
+		if done != nil {
+			done()
+		}
+		return
 	}
-	if a == nil {
+	m.animator.Play(l, c, fade, false, done)
+}
+
+// StopAnimation fades out the animation playing on the given layer over fade
+// seconds. Joints return to the pose of the layers below.
+func (m *Model) StopAnimation(l string, fade float32) {
+	m.animator.Stop(l, fade)
+}
+
+// SetLayerWeight sets the weight of the animation layer in the range 0-1.
+// Layers are blended in the order they are first used.
+func (m *Model) SetLayerWeight(l string, w float32) {
+	m.animator.Layer(l).Weight = min(max(w, 0), 1)
+}
+
+// SetLayerAdditive sets the animation layer to add the motion of its
+// animations relative to their first keys to the layers below rather than
+// replacing them.
+func (m *Model) SetLayerAdditive(l string, additive bool) {
+	m.animator.Layer(l).Additive = additive
+}
+
+// SetLayerMask restricts the animation layer to the given joints and all of
+// their descendants. Calling with no joints removes the mask.
+func (m *Model) SetLayerMask(l string, joints ...string) {
+	if len(joints) == 0 {
+		m.animator.Layer(l).Mask = nil
 		return
 	}
-	m.animations[l] = animationContext{
-		a:       a,
-		running: true,
+	mask := map[string]float32{}
+	var fn func(p *c3d.Part)
+	fn = func(p *c3d.Part) {
+		mask[p.ID] = 1
+		for _, c := range p.Children {
+			fn(c)
+		}
 	}
-	a.Play()
+	for _, j := range joints {
+		if p := m.joints[j]; p != nil {
+			fn(p)
+		}
+	}
+	m.animator.Layer(l).Mask = mask
+}
+
+// SetLayerJointWeight sets the weight of a single joint within the mask of the
+// animation layer in the range 0-1, creating the mask if needed.
+func (m *Model) SetLayerJointWeight(l, joint string, w float32) {
+	layer := m.animator.Layer(l)
+	if layer.Mask == nil {
+		layer.Mask = map[string]float32{}
+		for k := range m.joints {
+			layer.Mask[k] = 1
+		}
+	}
+	layer.Mask[joint] = min(max(w, 0), 1)
+}
+
+// AddAnimationListener registers a function to be called from Update for
+// every animation event with the given name that fires on the model. If name
+// is empty the function is called for all events.
+func (m *Model) AddAnimationListener(name string, fn AnimationListener) {
+	m.listeners = append(m.listeners, animationListener{
+		name: name,
+		fn:   fn,
+	})
 }
 
 // Update should be called once per frame to update internal model state, such
 // as animations.
 func (m *Model) Update(dt float32) {
-	for _, a := range m.animations {
-		if !a.running {
-			continue
+	m.animator.Update(dt)
+	for i := range m.events {
+		e := &m.events[i]
+		for _, l := range m.listeners {
+			if l.name == "" || l.name == e.Name {
+				l.fn(e)
+			}
 		}
-		a.a.Update(dt)
 	}
+	m.events = m.events[:0]
 	m.Bounds[0] = m.DrawDescriptor.Bounds.Bounds[0].Add(
 		m.DrawDescriptor.Orientation.P)
 	m.Bounds[1] = m.DrawDescriptor.Bounds.Bounds[1].Add(
 		m.DrawDescriptor.Orientation.P)
 }
+
+// ModelIntersection describes a ray intersection with a part of a model.
+type ModelIntersection struct {
+	Part     string     // ID of the part struck
+	Distance float32    // Distance along the ray to the point of intersection
+	Normal   mgl32.Vec3 // Normal of the surface struck in world space
+}
+
+// Intersect returns a description of the nearest point at which the ray
+// intersects the bounds of one of the model's parts in their current pose, or
+// nil if there is no intersection.
+func (m *Model) Intersect(r *t.Ray) *ModelIntersection {
+	var ret *ModelIntersection
+	var fn func(p *c3d.Part, o t.Orientation)
+	fn = func(p *c3d.Part, o t.Orientation) {
+		po := p.Orientation.Accumulate(o)
+		if p.Mesh != nil {
+			// Transform the ray into the part's model space
+			b := p.Mesh.Bounds()
+			s := mgl32.Scale3D(p.Scale[0], p.Scale[1], p.Scale[2])
+			b[0] = s.Mul4x1(b[0].Sub(p.Origin).Mul(t.VoxelScale).Vec4(1)).Vec3()
+			b[1] = s.Mul4x1(b[1].Sub(p.Origin).Mul(t.VoxelScale).Vec4(1)).Vec3()
+			for i := 0; i < 3; i++ {
+				if b[0][i] > b[1][i] {
+					b[0][i], b[1][i] = b[1][i], b[0][i]
+				}
+			}
+			qi := po.Q.Inverse()
+			lr := t.NewRay(qi.Rotate(r.O.Sub(po.P)), qi.Rotate(r.N), r.L)
+			d, f, hit := lr.IntersectAABB(b)
+			if hit && (ret == nil || d < ret.Distance) {
+				n := t.FacingOffsets[f]
+				ret = &ModelIntersection{
+					Part:     p.ID,
+					Distance: d,
+					Normal: po.Q.Rotate(mgl32.Vec3{
+						float32(n[0]),
+						float32(n[1]),
+						float32(n[2]),
+					}),
+				}
+			}
+		}
+		for _, c := range p.Children {
+			fn(c, po)
+		}
+	}
+	if m.DrawDescriptor.Root != nil {
+		fn(m.DrawDescriptor.Root, m.DrawDescriptor.Orientation)
+	}
+	return ret
+}
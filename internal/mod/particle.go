@@ -0,0 +1,96 @@
+package mod
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qbradq/cubit/internal/c3d"
+	"github.com/qbradq/cubit/internal/t"
+)
+
+// PEKind is the JSON structure used to hold the name of a particle kind.
+type PEKind c3d.ParticleKind
+
+func (k *PEKind) UnmarshalJSON(d []byte) error {
+	var s string
+	if err := json.Unmarshal(d, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "", "billboard":
+		*k = PEKind(c3d.ParticleBillboard)
+	case "voxel":
+		*k = PEKind(c3d.ParticleVoxel)
+	default:
+		return fmt.Errorf("unknown particle kind %s", s)
+	}
+	return nil
+}
+
+// ParticleEffect describes a particle effect in the mod JSON format. Faces are
+// mod-relative face indexes and angles are in degrees.
+type ParticleEffect struct {
+	Kind         PEKind        `json:"kind"`         // Either billboard or voxel
+	Faces        []t.FaceIndex `json:"faces"`        // Faces billboards are textured with
+	Colors       [][3]uint8    `json:"colors"`       // Colors of particles as [r, g, b]
+	FaceFraction float32       `json:"faceFraction"` // Fraction of the face shown by each billboard
+	Burst        int           `json:"burst"`        // Number of particles in a burst
+	Rate         float32       `json:"rate"`         // Particles per second of emitters
+	Life         [2]float32    `json:"life"`         // Range of life times in seconds
+	Size         [2]float32    `json:"size"`         // Size in cubes at the start and end of life
+	Speed        [2]float32    `json:"speed"`        // Range of initial speeds in cubes per second
+	Direction    mgl32.Vec3    `json:"direction"`    // Initial direction of travel
+	Spread       float32       `json:"spread"`       // Angle initial directions deviate from direction by at most
+	Extents      mgl32.Vec3    `json:"extents"`      // Half size of the box particles are created in
+	Gravity      float32       `json:"gravity"`      // Downward acceleration in cubes per second squared
+	Drag         float32       `json:"drag"`         // Fraction of velocity lost per second
+	Fade         bool          `json:"fade"`         // If true particles fade out
+	Collide      bool          `json:"collide"`      // If true particles come to rest on solid cells
+}
+
+// Effect returns the c3d particle effect described, with faces mapped through
+// the face map of the mod.
+func (e *ParticleEffect) Effect(m *Mod) *c3d.ParticleEffect {
+	ret := &c3d.ParticleEffect{
+		Kind:         c3d.ParticleKind(e.Kind),
+		Colors:       e.Colors,
+		FaceFraction: e.FaceFraction,
+		Burst:        e.Burst,
+		Rate:         e.Rate,
+		Life:         e.Life,
+		Size:         e.Size,
+		Speed:        e.Speed,
+		Direction:    e.Direction,
+		Spread:       mgl32.DegToRad(e.Spread),
+		Extents:      e.Extents,
+		Gravity:      e.Gravity,
+		Drag:         e.Drag,
+		Fade:         e.Fade,
+		Collide:      e.Collide,
+	}
+	for _, f := range e.Faces {
+		if fi, found := m.faceMap[f]; found {
+			ret.Faces = append(ret.Faces, fi)
+		}
+	}
+	return ret
+}
+
+// particleEffectsMap is the map of resource paths to particle effects.
+var particleEffectsMap = map[string]*c3d.ParticleEffect{}
+
+// registerParticleEffect registers a particle effect by resource path.
+func registerParticleEffect(p string, e *c3d.ParticleEffect) error {
+	if _, duplicate := particleEffectsMap[p]; duplicate {
+		return fmt.Errorf("duplicate particle effect path %s", p)
+	}
+	particleEffectsMap[p] = e
+	return nil
+}
+
+// GetParticleEffect returns the particle effect with the given resource path,
+// or nil if there is none.
+func GetParticleEffect(p string) *c3d.ParticleEffect {
+	return particleEffectsMap[p]
+}
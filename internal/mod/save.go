@@ -0,0 +1,79 @@
+package mod
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qbradq/cubit/internal/util"
+)
+
+// resourceFile returns the path on disk of the JSON file that defines the
+// resource at path p within the named directory of its mod, along with the
+// key of the resource within the file.
+func resourceFile(p, dir string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) < 4 || parts[1] != dir {
+		return "", "", fmt.Errorf("invalid %s resource path %s", dir, p)
+	}
+	if _, found := Mods[parts[0]]; !found {
+		return "", "", fmt.Errorf("mod %s not found", parts[0])
+	}
+	fp := filepath.Join(append([]string{"mods"},
+		parts[:len(parts)-1]...)...) + ".json"
+	return fp, parts[len(parts)-1], nil
+}
+
+// saveResource writes the value v into the JSON file defining the resource at
+// path p within the named directory of its mod. All other resources defined
+// in the file are preserved. The file is created if needed.
+func saveResource(p, dir string, v any) error {
+	fp, key, err := resourceFile(p, dir)
+	if err != nil {
+		return err
+	}
+	rs := map[string]json.RawMessage{}
+	d, err := os.ReadFile(fp)
+	if err == nil {
+		if err := json.Unmarshal(d, &rs); err != nil {
+			return fmt.Errorf("unmarshaling %s: %w", fp, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if rs[key], err = json.Marshal(v); err != nil {
+		return fmt.Errorf("marshaling %s: %w", p, err)
+	}
+	if d, err = json.Marshal(rs); err != nil {
+		return fmt.Errorf("marshaling %s: %w", fp, err)
+	}
+	if d, err = util.FormatJSON(d, "    "); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fp, d, 0644)
+}
+
+// SaveModel writes the model descriptor with the given resource path back to
+// its mod's models directory.
+func SaveModel(p string) error {
+	d := modelsMap[p]
+	if d == nil {
+		return fmt.Errorf("unknown model %s", p)
+	}
+	return saveResource(p, "models", d)
+}
+
+// SaveAnimation writes the animation with the given resource path back to its
+// mod's animations directory in the channel format.
+func SaveAnimation(p string) error {
+	a := animationDescs[p]
+	if a == nil {
+		return fmt.Errorf("unknown animation %s", p)
+	}
+	return saveResource(p, "animations", a)
+}
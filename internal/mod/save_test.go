@@ -0,0 +1,78 @@
+package mod
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveResourcePreservesOtherKeys(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	mods := Mods
+	Mods = map[string]*Mod{"test": {}}
+	t.Cleanup(func() { Mods = mods })
+	fp := filepath.Join("mods", "test", "models", "people.json")
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := `{"alice": {"height": 2, "tags": ["a", "b"]}, "bob": {"height": 1}}`
+	if err := os.WriteFile(fp, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = saveResource("/test/models/people/bob", "models",
+		map[string]int{"height": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[string]any
+	if err := json.Unmarshal(d, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("file has %d resources, want 2", len(got))
+	}
+	if got["bob"]["height"] != 3.0 {
+		t.Errorf("saved resource is %v", got["bob"])
+	}
+	alice := got["alice"]
+	if tags, _ := alice["tags"].([]any); alice["height"] != 2.0 ||
+		len(tags) != 2 {
+		t.Errorf("other resource became %v", alice)
+	}
+}
+
+func TestSaveResourceCreatesFile(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	mods := Mods
+	Mods = map[string]*Mod{"test": {}}
+	t.Cleanup(func() { Mods = mods })
+	if err := saveResource("/test/models/new/thing", "models", 1); err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(filepath.Join("mods", "test", "models", "new.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != "{\n    \"thing\": 1\n}\n" {
+		t.Errorf("created file contains %q", d)
+	}
+}
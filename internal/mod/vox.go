@@ -1,6 +1,8 @@
 package mod
 
 import (
+	"io"
+
 	"github.com/qbradq/cubit/internal/c3d"
 	"github.com/qbradq/cubit/internal/t"
 	"github.com/qbradq/cubit/internal/util"
@@ -47,3 +49,52 @@ func NewVox(v *util.Vox) *Vox {
 		voxels: v.Voxels,
 	}
 }
+
+// GetVoxVolume returns the vox model for the reference as a t.VoxVolume, or
+// nil if the reference is not valid. This implements the t.VoxLookup type.
+func GetVoxVolume(r t.VoxRef) t.VoxVolume {
+	if int(r) >= len(VoxDefs) {
+		return nil
+	}
+	return VoxDefs[r]
+}
+
+// Dimensions implements the t.VoxVolume interface.
+func (v *Vox) Dimensions() (w, h, d int) {
+	return v.width, v.depth, v.height
+}
+
+// Color returns the RGBA color of the voxel at the given position using the
+// same coordinate system as util.Vox.Get().
+func (v *Vox) Color(x, y, z int) [4]uint8 {
+	sx := x
+	sy := v.height - (z + 1)
+	sz := y
+	if sx < 0 || sx >= v.width || sy < 0 || sy >= v.height || sz < 0 ||
+		sz >= v.depth {
+		return [4]uint8{0, 0, 0, 0}
+	}
+	return v.voxels[sz*v.width*v.height+sy*v.width+sx]
+}
+
+// Solid implements the t.VoxVolume interface.
+func (v *Vox) Solid(x, y, z int) bool {
+	return v.Color(x, y, z)[3] == 255
+}
+
+// ToVox returns a copy of the voxel model as a util.Vox.
+func (v *Vox) ToVox() *util.Vox {
+	ret := &util.Vox{
+		Width:  v.width,
+		Height: v.height,
+		Depth:  v.depth,
+		Voxels: make([][4]uint8, len(v.voxels)),
+	}
+	copy(ret.Voxels, v.voxels)
+	return ret
+}
+
+// Write writes the voxel model to w in the MagicaVoxel .vox format.
+func (v *Vox) Write(w io.Writer) error {
+	return v.ToVox().Write(w)
+}
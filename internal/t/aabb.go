@@ -25,3 +25,10 @@ func (b *AABB) UnmarshalJSON(d []byte) error {
 	}
 	return nil
 }
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b AABB) MarshalJSON() ([]byte, error) {
+	p0 := b[0].Mul(1 / VoxelScale)
+	p1 := b[1].Mul(1 / VoxelScale)
+	return json.Marshal([6]float32{p0[0], p0[1], p0[2], p1[0], p1[1], p1[2]})
+}
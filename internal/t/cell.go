@@ -17,6 +17,15 @@ func CellForVox(r VoxRef, f Facing) Cell {
 	return Cell(r) | (Cell(f) << 16) | 0x80000000
 }
 
+// CellForVoxFiller returns the cell value for a filler cell of a vox model
+// spanning multiple cells. The offset o is the position of the filler cell
+// relative to the primary cell before rotation, and f is the facing of the vox
+// model.
+func CellForVoxFiller(o IVec3, f Facing) Cell {
+	return Cell((o[0]&0xF)|((o[1]&0xF)<<4)|((o[2]&0xF)<<8)) | (Cell(f) << 16) |
+		0xC0000000
+}
+
 // IsCube returns true if this is a cube reference.
 func (l Cell) IsCube() bool {
 	if l == CellInvalid {
@@ -34,14 +43,27 @@ func (l Cell) IsVox() bool {
 	if l == CellInvalid {
 		return false
 	}
-	if l&0x80000000 == 0 {
+	if l&0xC0000000 != 0x80000000 {
 		return false
 	}
 	c := VoxRef(l & 0xFFFF)
 	return c != VoxRefInvalid
 }
 
-// Decompose returns the portions of the cell value broken out.
+// IsVoxFiller returns true if this is a filler cell of a vox model spanning
+// multiple cells.
+func (l Cell) IsVoxFiller() bool {
+	return l != CellInvalid && l&0xC0000000 == 0xC0000000
+}
+
+// FillerOffset returns the offset of a filler cell from the primary cell of
+// its vox model before rotation.
+func (l Cell) FillerOffset() IVec3 {
+	return IVec3{int(l & 0xF), int((l >> 4) & 0xF), int((l >> 8) & 0xF)}
+}
+
+// Decompose returns the portions of the cell value broken out. Vox filler cells
+// return invalid cube and vox references.
 func (l Cell) Decompose() (c CubeRef, v VoxRef, f Facing) {
 	f = Facing((l >> 16) & 0x7)
 	if f > Bottom {
@@ -51,7 +73,7 @@ func (l Cell) Decompose() (c CubeRef, v VoxRef, f Facing) {
 	v = VoxRefInvalid
 	if l&0x80000000 == 0 {
 		c = CubeRef(l & 0xFFFF)
-	} else {
+	} else if l&0x40000000 == 0 {
 		v = VoxRef(l & 0xFFFF)
 	}
 	return c, v, f
@@ -37,6 +37,9 @@ func (c *Chunk) Get(x, y, z int) Cell {
 	if x < 0 || x > 15 || y < 0 || y > 15 || z < 0 || z > 15 {
 		return CellInvalid
 	}
+	if c.isSolid {
+		return c.solid
+	}
 	return c.cells[(z*16*16)+(y*16)+x]
 }
 
@@ -63,6 +66,9 @@ func (c *Chunk) GetRelative(p IVec3) Cell {
 	if p[0] < 0 || p[0] > 15 || p[1] < 0 || p[1] > 15 || p[2] < 0 || p[2] > 15 {
 		return CellInvalid
 	}
+	if c.isSolid {
+		return c.solid
+	}
 	return c.cells[(p[2]*16*16)+(p[1]*16)+p[0]]
 }
 
@@ -95,7 +101,7 @@ func (c *Chunk) SetRelative(p IVec3, v Cell) bool {
 	}
 	c.cells[idx] = v
 	c.Revision++
-	if ov.IsVox() || v.IsVox() {
+	if ov.IsVox() || v.IsVox() || ov.IsVoxFiller() || v.IsVoxFiller() {
 		c.VoxRevision++
 	}
 	return true
@@ -10,12 +10,34 @@ const FaceDims = 16
 // atlasDims are the X and Y dimensions of the face atlas in faces.
 const AtlasDims = AtlasTextureDims / FaceDims
 
+// AtlasPageFaces is the number of faces that fit on a single atlas page.
+const AtlasPageFaces = AtlasDims * AtlasDims
+
+// AtlasPages is the maximum number of atlas pages a face atlas may grow to.
+const AtlasPages = 4
+
+// FacePageDims are the maximum X and Y dimensions of a face page image in
+// faces.
+const FacePageDims = 16
+
 // Stepping value for face U and V offsets.
 const PageStep float32 = float32(1) / float32(AtlasDims)
 
 // VoxelScale is the scale of a single voxel.
 const VoxelScale float32 = 1.0 / 16.0
 
+// VoxMaxCells is the maximum size of a vox model along any axis in cells.
+const VoxMaxCells = 15
+
+// DayLength is the length of one full day in the world in seconds.
+const DayLength float32 = 20 * 60
+
+// DawnTime is the time of day the sun rises as a fraction of the day.
+const DawnTime float32 = 0.25
+
+// DuskTime is the time of day the sun sets as a fraction of the day.
+const DuskTime float32 = 0.75
+
 // VirtualScreenWidth is the width of the virtual 2D screen in pixels.
 const VirtualScreenWidth int = 320
 
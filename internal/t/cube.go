@@ -1,5 +1,11 @@
 package t
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
 // FacingMap maps orientation facing to face index.
 var FacingMap = [6][6]Facing{
 	{
@@ -52,13 +58,81 @@ var FacingMap = [6][6]Facing{
 	},
 }
 
+// FaceAnimation describes a looping sequence of face graphics displayed on a
+// single face of a cube.
+type FaceAnimation struct {
+	Frames    []FaceIndex `json:"frames"`    // Face graphics to display in order
+	FrameTime float32     `json:"frameTime"` // Time each frame is displayed in seconds
+}
+
 // Cube represents one cubic meter of the world.
 type Cube struct {
-	Ref         CubeRef      `json:"-"`           // CubeRef value assigned to the cube definition
-	ID          string       `json:"-"`           // Unique ID
-	Name        string       `json:"name"`        // Descriptive name
-	Faces       [6]FaceIndex `json:"faces"`       // Face graphic to use for each face of the cube.
-	Transparent bool         `json:"transparent"` // If true this cube can be seen through
+	Ref         CubeRef           `json:"-"`           // CubeRef value assigned to the cube definition
+	ID          string            `json:"-"`           // Unique ID
+	Name        string            `json:"name"`        // Descriptive name
+	Faces       [6]FaceIndex      `json:"faces"`       // Face graphic to use for each face of the cube.
+	Animations  [6]*FaceAnimation `json:"-"`           // Face animation for each face of the cube, if any
+	Transparent bool              `json:"transparent"` // If true this cube can be seen through
+	Shape       Shape             `json:"shape"`       // Geometric shape of the cube
+}
+
+// Occludes returns true if the cube with the given facing completely hides the
+// face of the neighboring cube n that touches side s of the cube's cell. N may
+// be nil for cells that are not cubes. Transparent cubes only hide the faces
+// of the same cube, so the inside faces of a glass wall are not drawn.
+func (c *Cube) Occludes(f Facing, s Facing, n *Cube) bool {
+	if c.Transparent && c != n {
+		return false
+	}
+	return c.Shape.Occludes(f, s)
+}
+
+// ConnectsTo returns true if the cube extends fence arms toward the
+// neighboring cube n, which may be nil.
+func (c *Cube) ConnectsTo(n *Cube) bool {
+	return c.Shape == ShapeFence && n != nil && n.Shape == ShapeFence
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Each element of
+// the faces array may either be a face index or a face animation object.
+func (c *Cube) UnmarshalJSON(d []byte) error {
+	type cube Cube
+	aux := struct {
+		*cube
+		Faces [6]json.RawMessage `json:"faces"`
+	}{
+		cube: (*cube)(c),
+	}
+	if err := json.Unmarshal(d, &aux); err != nil {
+		return err
+	}
+	for i, f := range aux.Faces {
+		c.Faces[i] = FaceIndexInvalid
+		c.Animations[i] = nil
+		f = bytes.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		if f[0] != '{' {
+			if err := json.Unmarshal(f, &c.Faces[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		a := &FaceAnimation{}
+		if err := json.Unmarshal(f, a); err != nil {
+			return err
+		}
+		if len(a.Frames) < 1 {
+			return errors.New("face animations require at least one frame")
+		}
+		if a.FrameTime <= 0 {
+			return errors.New("face animation frame times must be greater than zero")
+		}
+		c.Faces[i] = a.Frames[0]
+		c.Animations[i] = a
+	}
+	return nil
 }
 
 // CubeInvalid is the invalid cube definition.
@@ -42,10 +42,18 @@ func (t FaceIndex) ToXYZ() (x int, y int, z int) {
 	return
 }
 
-// ToXY returns the X and Y coordinates of the tile in atlas texture.
+// ToXY returns the X and Y coordinates of the tile in atlas texture page.
 func (t FaceIndex) ToAtlasXY() (x int, y int) {
 	x = int(t) % AtlasDims
-	y = int(t) / AtlasDims
+	y = (int(t) / AtlasDims) % AtlasDims
+	return
+}
+
+// ToAtlasXYZ returns the X and Y coordinates of the tile in atlas texture page
+// along with the index of the page as Z.
+func (t FaceIndex) ToAtlasXYZ() (x int, y int, z int) {
+	x, y = t.ToAtlasXY()
+	z = int(t) / AtlasPageFaces
 	return
 }
 
@@ -64,7 +72,7 @@ func (t FaceIndex) ToUV() (mgl32.Vec2, mgl32.Vec2) {
 }
 
 // FaceIndexFromXYZ returns the FaceIndex value for the given coordinates, where
-// X and Y range 0-7 and Z ranges 0-255.
+// X and Y range 0-15 and Z ranges 0-255.
 func FaceIndexFromXYZ(x, y, z int) FaceIndex {
 	if x < 0 || x > 15 || y < 0 || y > 15 || z < 0 || z > 255 {
 		return FaceIndexInvalid
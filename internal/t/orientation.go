@@ -41,6 +41,39 @@ func (o *Orientation) UnmarshalJSON(d []byte) error {
 	return nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (o Orientation) MarshalJSON() ([]byte, error) {
+	e := QuatToEuler(o.Q)
+	return json.Marshal([6]float32{
+		o.P[0], o.P[1], o.P[2],
+		roundDegrees(mgl32.RadToDeg(e[0])),
+		roundDegrees(mgl32.RadToDeg(e[1])),
+		roundDegrees(mgl32.RadToDeg(e[2])),
+	})
+}
+
+// roundDegrees rounds the angle in degrees to three decimal places to avoid
+// writing floating point noise.
+func roundDegrees(d float32) float32 {
+	return float32(math.Round(float64(d)*1000) / 1000)
+}
+
+// QuatToEuler returns the X, Y, and Z rotations in radians of the quaternion
+// using the same convention as data files. This is the inverse of
+// mgl32.AnglesToQuat(z, y, x, mgl32.ZYX).
+func QuatToEuler(q mgl32.Quat) mgl32.Vec3 {
+	q = q.Normalize()
+	w, x, y, z := float64(q.W), float64(q.V[0]), float64(q.V[1]),
+		float64(q.V[2])
+	sy := 2 * (w*y - z*x)
+	sy = math.Min(math.Max(sy, -1), 1)
+	return mgl32.Vec3{
+		float32(math.Atan2(2*(w*x+y*z), 1-2*(x*x+y*y))),
+		float32(math.Asin(sy)),
+		float32(math.Atan2(2*(w*z+x*y), 1-2*(y*y+z*z))),
+	}
+}
+
 // FacingToOrientation is the facing to orientation table.
 var FacingToOrientation = [6]Orientation{
 	O(),
@@ -0,0 +1,46 @@
+package t
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestQuatToEuler(t *testing.T) {
+	for _, e := range []mgl32.Vec3{
+		{0, 0, 0},
+		{90, 0, 0},
+		{0, 45, 0},
+		{0, 0, -135},
+		{30, -60, 120},
+		{-170, 80, 10},
+	} {
+		r := mgl32.Vec3{
+			mgl32.DegToRad(e[0]),
+			mgl32.DegToRad(e[1]),
+			mgl32.DegToRad(e[2]),
+		}
+		q := mgl32.AnglesToQuat(r[2], r[1], r[0], mgl32.ZYX)
+		got := QuatToEuler(q)
+		if !got.ApproxEqualThreshold(r, 1e-4) {
+			t.Errorf("rotation %v degrees became %v radians, want %v", e,
+				got, r)
+		}
+	}
+}
+
+func TestOrientationJSONRoundTrip(t *testing.T) {
+	src := `[1.5,-2,3,30,-60,120]`
+	var o Orientation
+	if err := json.Unmarshal([]byte(src), &o); err != nil {
+		t.Fatal(err)
+	}
+	d, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != src {
+		t.Errorf("marshaled %s, want %s", d, src)
+	}
+}
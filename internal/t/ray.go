@@ -45,13 +45,228 @@ func (r *Ray) IntersectsAABB(b AABB) bool {
 	return tmax >= math.Max(0.0, tmin) && tmin < float64(r.L)
 }
 
+// IntersectAABB returns the distance along the ray at which it enters the given
+// AABB and the face of the box struck. If the ray does not intersect the box
+// within its length, or starts within the box, hit is false.
+func (r *Ray) IntersectAABB(b AABB) (d float32, face Facing, hit bool) {
+	tmin := math.Inf(-1)
+	tmax := math.Inf(1)
+	for i := 0; i < 3; i++ {
+		t1 := float64((b[0][i] - r.O[i]) * r.i[i])
+		t2 := float64((b[1][i] - r.O[i]) * r.i[i])
+		if math.IsNaN(t1) || math.IsNaN(t2) {
+			// Ray is parallel to and on the plane of this slab
+			continue
+		}
+		near := math.Min(t1, t2)
+		if near > tmin {
+			tmin = near
+			switch i {
+			case 0:
+				face = West
+				if r.N[0] < 0 {
+					face = East
+				}
+			case 1:
+				face = Bottom
+				if r.N[1] < 0 {
+					face = Top
+				}
+			case 2:
+				face = North
+				if r.N[2] < 0 {
+					face = South
+				}
+			}
+		}
+		tmax = math.Min(tmax, math.Max(t1, t2))
+	}
+	if tmax < math.Max(0, tmin) || tmin < 0 || tmin > float64(r.L) {
+		return 0, face, false
+	}
+	return float32(tmin), face, true
+}
+
+// VoxVolume is implemented by voxel models that occupy a cell so rays may be
+// tested against their individual voxels. Coordinates are in the model's mesh
+// space with Y being the up axis.
+type VoxVolume interface {
+	// Dimensions returns the dimensions of the volume in voxels.
+	Dimensions() (w, h, d int)
+	// Solid returns true if the voxel at the given position is solid.
+	Solid(x, y, z int) bool
+}
+
+// IntersectVox returns the distance along the ray at which it strikes a solid
+// voxel of v when v is placed in the cell at p with facing f, along with the
+// normal of the surface struck in world space. The origin of the volume is the
+// minimum corner of the cell and the volume is rotated about the center of the
+// cell, with one voxel being VoxelScale units in size. Volumes larger than 16
+// voxels extend into neighboring cells.
+func (r *Ray) IntersectVox(v VoxVolume, p IVec3, f Facing) (
+	d float32, n mgl32.Vec3, hit bool) {
+	w, h, dp := v.Dimensions()
+	dims := [3]int{w, h, dp}
+	center := mgl32.Vec3{8, 8, 8}
+	q := FacingToOrientation[f].Q
+	qi := q.Inverse()
+	cell := mgl32.Vec3{float32(p[0]), float32(p[1]), float32(p[2])}.
+		Add(mgl32.Vec3{0.5, 0.5, 0.5})
+	// Transform the ray into the voxel space of the volume
+	lr := NewRay(
+		qi.Rotate(r.O.Sub(cell)).Mul(1/VoxelScale).Add(center),
+		qi.Rotate(r.N),
+		r.L/VoxelScale,
+	)
+	box := AABB{{0, 0, 0}, {float32(w), float32(h), float32(dp)}}
+	var t0 float32
+	var normal mgl32.Vec3
+	inside := true
+	for i := 0; i < 3; i++ {
+		if lr.O[i] < 0 || lr.O[i] >= box[1][i] {
+			inside = false
+		}
+	}
+	if inside {
+		// Starting within the volume, report the normal facing back along
+		// the ray's major axis
+		a := 0
+		for i := 1; i < 3; i++ {
+			if math.Abs(float64(lr.N[i])) > math.Abs(float64(lr.N[a])) {
+				a = i
+			}
+		}
+		if lr.N[a] > 0 {
+			normal[a] = -1
+		} else {
+			normal[a] = 1
+		}
+	} else {
+		var face Facing
+		var ok bool
+		t0, face, ok = lr.IntersectAABB(box)
+		if !ok {
+			return 0, n, false
+		}
+		o := FacingOffsets[face]
+		normal = mgl32.Vec3{float32(o[0]), float32(o[1]), float32(o[2])}
+	}
+	// Walk the voxels from the entry point
+	pos := lr.O.Add(lr.N.Mul(t0))
+	var voxel, step [3]int
+	var tMax, tDelta [3]float32
+	for i := 0; i < 3; i++ {
+		voxel[i] = int(math.Floor(float64(pos[i])))
+		if voxel[i] < 0 {
+			voxel[i] = 0
+		}
+		if voxel[i] >= dims[i] {
+			voxel[i] = dims[i] - 1
+		}
+		switch {
+		case lr.N[i] > 0:
+			step[i] = 1
+			tDelta[i] = 1 / lr.N[i]
+			tMax[i] = t0 + (float32(voxel[i]+1)-pos[i])*tDelta[i]
+		case lr.N[i] < 0:
+			step[i] = -1
+			tDelta[i] = -1 / lr.N[i]
+			tMax[i] = t0 + (pos[i]-float32(voxel[i]))*tDelta[i]
+		default:
+			tDelta[i] = math.MaxFloat32
+			tMax[i] = math.MaxFloat32
+		}
+	}
+	t := t0
+	for t <= lr.L {
+		if v.Solid(voxel[0], voxel[1], voxel[2]) {
+			return t * VoxelScale, q.Rotate(normal), true
+		}
+		a := 0
+		if tMax[1] < tMax[a] {
+			a = 1
+		}
+		if tMax[2] < tMax[a] {
+			a = 2
+		}
+		t = tMax[a]
+		tMax[a] += tDelta[a]
+		voxel[a] += step[a]
+		if voxel[a] < 0 || voxel[a] >= dims[a] {
+			break
+		}
+		normal = mgl32.Vec3{}
+		normal[a] = float32(-step[a])
+	}
+	return 0, n, false
+}
+
 // WorldIntersection represents a ray intersection with the world.
 type WorldIntersection struct {
-	Position IVec3   // World coordinate of the cell hit
-	Face     Facing  // Face struck
-	Cube     CubeRef // The cube intersected, if any
-	Vox      VoxRef  // The voxel model intersected, if any
-	Facing   Facing  // Facing of the cube or voxel model intersected
+	Position IVec3      // World coordinate of the cell hit
+	Face     Facing     // Face of the cell the ray entered through
+	Cube     CubeRef    // The cube intersected, if any
+	Vox      VoxRef     // The voxel model intersected, if any
+	Facing   Facing     // Facing of the cube or voxel model intersected
+	Distance float32    // Distance along the ray to the point of intersection
+	Normal   mgl32.Vec3 // Normal of the surface struck
+}
+
+// rayCellEpsilon is the distance a ray may strike a vox model past the end of
+// the segment of a cell, absorbing rounding at cell boundaries.
+const rayCellEpsilon = 1e-4
+
+// intersectCell tests the ray against the contents of the cell at p, which the
+// ray entered through face at distance d and leaves at distance exit. Nil is
+// returned if the ray passes through the cell without striking anything.
+func (r *Ray) intersectCell(w *World, p IVec3, face Facing,
+	d, exit float32) *WorldIntersection {
+	op, _ := w.VoxOrigin(p)
+	cRef, vRef, f := w.GetCell(op).Decompose()
+	if cRef == CubeRefInvalid && vRef == VoxRefInvalid {
+		return nil
+	}
+	o := FacingOffsets[face]
+	ret := &WorldIntersection{
+		Position: p,
+		Cube:     cRef,
+		Vox:      vRef,
+		Face:     face,
+		Facing:   f,
+		Distance: d,
+		Normal:   mgl32.Vec3{float32(o[0]), float32(o[1]), float32(o[2])},
+	}
+	if cd := w.GetCube(p); cd != nil && cd.Shape != ShapeFull {
+		// Partial shapes are tested against their boxes
+		hit := false
+		for _, b := range w.SelectionBoxes(p) {
+			bd, bf, bHit := r.IntersectAABB(b)
+			if bHit && (!hit || bd < ret.Distance) {
+				hit = true
+				o := FacingOffsets[bf]
+				ret.Distance = bd
+				ret.Normal = mgl32.Vec3{float32(o[0]), float32(o[1]),
+					float32(o[2])}
+			}
+		}
+		if !hit {
+			return nil
+		}
+	} else if vRef != VoxRefInvalid && w.vox != nil {
+		// Vox models are tested voxel by voxel. Models may span many cells,
+		// so only voxels struck within this cell count.
+		v := w.vox(vRef)
+		if v == nil {
+			return ret
+		}
+		vd, n, hit := r.IntersectVox(v, op, f)
+		if !hit || vd > exit+rayCellEpsilon {
+			return nil
+		}
+		ret.Distance = vd
+		ret.Normal = n
+	}
+	return ret
 }
 
 // IntersectWorld returns a description of the point at which the ray
@@ -127,21 +342,17 @@ func (r *Ray) IntersectWorld(w *World) *WorldIntersection {
 	voxel[2] = int(z1)
 
 	var face Facing
+	var tEntry float32 // Segment parameter at which the current cell was entered
 	for {
-		cell := w.GetCell(voxel)
-		cRef, vRef, f := cell.Decompose()
-		if cRef != CubeRefInvalid || vRef != VoxRefInvalid {
-			return &WorldIntersection{
-				Position: voxel,
-				Cube:     cRef,
-				Vox:      vRef,
-				Face:     face,
-				Facing:   f,
-			}
+		tExit := min(tMaxX, tMaxY, tMaxZ)
+		if wi := r.intersectCell(w, voxel, face, tEntry*r.L,
+			tExit*r.L); wi != nil {
+			return wi
 		}
 		if tMaxX < tMaxY {
 			if tMaxX < tMaxZ {
 				voxel[0] += int(dx)
+				tEntry = tMaxX
 				tMaxX += tDeltaX
 				face = West
 				if dx < 0 {
@@ -149,6 +360,7 @@ func (r *Ray) IntersectWorld(w *World) *WorldIntersection {
 				}
 			} else {
 				voxel[2] += int(dz)
+				tEntry = tMaxZ
 				tMaxZ += tDeltaZ
 				face = North
 				if dz < 0 {
@@ -158,6 +370,7 @@ func (r *Ray) IntersectWorld(w *World) *WorldIntersection {
 		} else {
 			if tMaxY < tMaxZ {
 				voxel[1] += int(dy)
+				tEntry = tMaxY
 				tMaxY += tDeltaY
 				face = Bottom
 				if dy < 0 {
@@ -165,6 +378,7 @@ func (r *Ray) IntersectWorld(w *World) *WorldIntersection {
 				}
 			} else {
 				voxel[2] += int(dz)
+				tEntry = tMaxZ
 				tMaxZ += tDeltaZ
 				face = North
 				if dz < 0 {
@@ -172,7 +386,7 @@ func (r *Ray) IntersectWorld(w *World) *WorldIntersection {
 				}
 			}
 		}
-		if tMaxX > 1 && tMaxY > 1 && tMaxZ > 1 {
+		if tEntry > 1 {
 			break
 		}
 	}
@@ -0,0 +1,73 @@
+package t
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// testWorld returns a new world with a single full cube definition.
+func testWorld() *World {
+	return NewWorld([]*Cube{{Ref: 0, Name: "test"}}, nil)
+}
+
+func TestIntersectWorldEndCell(t *testing.T) {
+	w := testWorld()
+	w.SetCell(IVec3{3, 0, 0}, CellForCube(0, North))
+	// The ray ends within the cube's cell
+	r := NewRay(mgl32.Vec3{0.5, 0.5, 0.5}, mgl32.Vec3{1, 0, 0}, 3)
+	wi := r.IntersectWorld(w)
+	if wi == nil {
+		t.Fatal("ray ending within a cube did not strike it")
+	}
+	if wi.Position != (IVec3{3, 0, 0}) || wi.Face != West {
+		t.Errorf("struck %v face %d, want %v face %d", wi.Position, wi.Face,
+			IVec3{3, 0, 0}, West)
+	}
+	if wi.Distance < 2.49 || wi.Distance > 2.51 {
+		t.Errorf("distance %f, want 2.5", wi.Distance)
+	}
+	// The ray ends before the cube's cell
+	r = NewRay(mgl32.Vec3{0.5, 0.5, 0.5}, mgl32.Vec3{1, 0, 0}, 2.4)
+	if wi := r.IntersectWorld(w); wi != nil {
+		t.Errorf("ray ending before a cube struck %v", wi.Position)
+	}
+}
+
+// testVox is a vox volume in which only the voxels in the given range along X
+// are solid.
+type testVox struct {
+	w, h, d int // Dimensions
+	x0, x1  int // Range of solid voxels along X, inclusive
+}
+
+// Dimensions implements the VoxVolume interface.
+func (v *testVox) Dimensions() (w, h, d int) {
+	return v.w, v.h, v.d
+}
+
+// Solid implements the VoxVolume interface.
+func (v *testVox) Solid(x, y, z int) bool {
+	return x >= v.x0 && x <= v.x1 && x < v.w && y >= 0 && y < v.h &&
+		z >= 0 && z < v.d
+}
+
+func TestIntersectWorldVoxCell(t *testing.T) {
+	// Three cells wide, solid only within the last cell
+	v := &testVox{w: 48, h: 16, d: 16, x0: 32, x1: 47}
+	w := NewWorld([]*Cube{{Ref: 0, Name: "test"}}, func(VoxRef) VoxVolume {
+		return v
+	})
+	w.SetCell(IVec3{0, 0, 0}, CellForVox(0, North))
+	r := NewRay(mgl32.Vec3{-1.5, 0.5, 0.5}, mgl32.Vec3{1, 0, 0}, 8)
+	wi := r.IntersectWorld(w)
+	if wi == nil {
+		t.Fatal("ray did not strike the vox model")
+	}
+	if wi.Position != (IVec3{2, 0, 0}) {
+		t.Errorf("struck cell %v, want %v", wi.Position, IVec3{2, 0, 0})
+	}
+	if wi.Distance < 3.49 || wi.Distance > 3.51 {
+		t.Errorf("distance %f, want 3.5", wi.Distance)
+	}
+}
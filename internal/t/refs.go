@@ -19,6 +19,11 @@ const (
 	Bottom
 )
 
+// Opposite returns the facing opposite this one.
+func (f Facing) Opposite() Facing {
+	return f ^ 1
+}
+
 // FacingOffsets are the offsets from the center voxel to the voxel in the
 // direction of the indexed facing.
 var FacingOffsets = [6][3]int{
@@ -0,0 +1,246 @@
+package t
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Shape encodes the geometric shape of a cube.
+type Shape uint8
+
+const (
+	ShapeFull  Shape = iota // Full cube
+	ShapeSlab               // Bottom half of a cube
+	ShapeStair              // Bottom half slab with a back half step on top
+	ShapeFence              // Center post
+	ShapeCross              // Two crossed planes, as in plants
+)
+
+// shapeNames is the mapping of shape names used in JSON to shape values.
+var shapeNames = map[string]Shape{
+	"full":  ShapeFull,
+	"slab":  ShapeSlab,
+	"stair": ShapeStair,
+	"fence": ShapeFence,
+	"cross": ShapeCross,
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *Shape) UnmarshalJSON(d []byte) error {
+	var n string
+	if err := json.Unmarshal(d, &n); err != nil {
+		return err
+	}
+	v, found := shapeNames[strings.ToLower(n)]
+	if !found {
+		return fmt.Errorf("unknown cube shape %s", n)
+	}
+	*s = v
+	return nil
+}
+
+// shapeBoxes are the boxes that make up each shape facing north, in cell
+// units.
+var shapeBoxes = [...][]AABB{
+	ShapeFull: {
+		{{0, 0, 0}, {1, 1, 1}},
+	},
+	ShapeSlab: {
+		{{0, 0, 0}, {1, 0.5, 1}},
+	},
+	ShapeStair: {
+		{{0, 0, 0}, {1, 0.5, 1}},
+		{{0, 0.5, 0}, {1, 1, 0.5}},
+	},
+	ShapeFence: {
+		{{6 * VoxelScale, 0, 6 * VoxelScale},
+			{10 * VoxelScale, 1, 10 * VoxelScale}},
+	},
+	ShapeCross: {
+		{{2 * VoxelScale, 0, 2 * VoxelScale},
+			{14 * VoxelScale, 1, 14 * VoxelScale}},
+	},
+}
+
+// fenceArms are the rails extending from a fence post to each horizontal side
+// of the cell, in cell units.
+var fenceArms = [4][2]AABB{
+	North: {
+		{{7 * VoxelScale, 6 * VoxelScale, 0},
+			{9 * VoxelScale, 9 * VoxelScale, 6 * VoxelScale}},
+		{{7 * VoxelScale, 12 * VoxelScale, 0},
+			{9 * VoxelScale, 15 * VoxelScale, 6 * VoxelScale}},
+	},
+	South: {
+		{{7 * VoxelScale, 6 * VoxelScale, 10 * VoxelScale},
+			{9 * VoxelScale, 9 * VoxelScale, 1}},
+		{{7 * VoxelScale, 12 * VoxelScale, 10 * VoxelScale},
+			{9 * VoxelScale, 15 * VoxelScale, 1}},
+	},
+	East: {
+		{{10 * VoxelScale, 6 * VoxelScale, 7 * VoxelScale},
+			{1, 9 * VoxelScale, 9 * VoxelScale}},
+		{{10 * VoxelScale, 12 * VoxelScale, 7 * VoxelScale},
+			{1, 15 * VoxelScale, 9 * VoxelScale}},
+	},
+	West: {
+		{{0, 6 * VoxelScale, 7 * VoxelScale},
+			{6 * VoxelScale, 9 * VoxelScale, 9 * VoxelScale}},
+		{{0, 12 * VoxelScale, 7 * VoxelScale},
+			{6 * VoxelScale, 15 * VoxelScale, 9 * VoxelScale}},
+	},
+}
+
+// Rotate returns the bounding box rotated about the center of a cell by the
+// orientation of the facing. The bounding box is in cell units.
+func (b AABB) Rotate(f Facing) AABB {
+	c := mgl32.Vec3{0.5, 0.5, 0.5}
+	q := FacingToOrientation[f].Q
+	p0 := q.Rotate(b[0].Sub(c)).Add(c)
+	p1 := q.Rotate(b[1].Sub(c)).Add(c)
+	snap := func(v float32) float32 {
+		return float32(int(v/VoxelScale+0.5)) * VoxelScale
+	}
+	var ret AABB
+	for i := 0; i < 3; i++ {
+		ret[0][i] = snap(min(p0[i], p1[i]))
+		ret[1][i] = snap(max(p0[i], p1[i]))
+	}
+	return ret
+}
+
+// Translate returns the bounding box offset by the given position.
+func (b AABB) Translate(p mgl32.Vec3) AABB {
+	return AABB{b[0].Add(p), b[1].Add(p)}
+}
+
+// Boxes returns the boxes that make up the shape with the given facing in cell
+// units. These describe the visible geometry of the shape for all shapes but
+// ShapeCross, in which case they describe the selection bounds.
+func (s Shape) Boxes(f Facing) []AABB {
+	if int(s) >= len(shapeBoxes) {
+		s = ShapeFull
+	}
+	ret := make([]AABB, len(shapeBoxes[s]))
+	for i, b := range shapeBoxes[s] {
+		ret[i] = b.Rotate(f)
+	}
+	return ret
+}
+
+// ConnectedBoxes is like Boxes, but fences also include the arms toward every
+// horizontal side of the cell for which connects returns true. Sides are in
+// world space and are not rotated by the facing.
+func (s Shape) ConnectedBoxes(f Facing, connects func(side Facing) bool) []AABB {
+	ret := s.Boxes(f)
+	if s != ShapeFence {
+		return ret
+	}
+	for side := North; side <= West; side++ {
+		if connects(side) {
+			ret = append(ret, fenceArms[side][:]...)
+		}
+	}
+	return ret
+}
+
+// CollisionBoxes returns the boxes that should be used for physical collision
+// with the shape in cell units.
+func (s Shape) CollisionBoxes(f Facing) []AABB {
+	if s == ShapeCross {
+		return nil
+	}
+	return s.Boxes(f)
+}
+
+// shapeOcclusion caches the results of occludes by shape, facing and side.
+var shapeOcclusion [len(shapeBoxes)][6][6]bool
+
+func init() {
+	for s := range shapeOcclusion {
+		for f := North; f <= Bottom; f++ {
+			for side := North; side <= Bottom; side++ {
+				shapeOcclusion[s][f][side] = Shape(s).occludes(f, side)
+			}
+		}
+	}
+}
+
+// Occludes returns true if the shape with the given facing completely covers
+// the side s of the cell it occupies.
+func (s Shape) Occludes(f Facing, side Facing) bool {
+	if int(s) >= len(shapeOcclusion) || f > Bottom || side > Bottom {
+		return false
+	}
+	return shapeOcclusion[s][f][side]
+}
+
+// occludes implements Occludes without caching.
+func (s Shape) occludes(f Facing, side Facing) bool {
+	if s == ShapeFull {
+		return true
+	}
+	if s == ShapeCross {
+		return false
+	}
+	boxes := s.Boxes(f)
+	// Test the center of every voxel-sized square of the side against all
+	// boxes touching that side
+	for iv := 0; iv < 16; iv++ {
+		for iu := 0; iu < 16; iu++ {
+			u := (float32(iu) + 0.5) * VoxelScale
+			v := (float32(iv) + 0.5) * VoxelScale
+			covered := false
+			for _, b := range boxes {
+				if b.coversPoint(side, u, v) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// coversPoint returns true if the bounding box in cell units touches the given
+// side of the cell and covers the point u, v on that side. U and V are the two
+// axes other than the side's normal in X, Y, Z order.
+func (b AABB) coversPoint(side Facing, u, v float32) bool {
+	var axis int
+	var edge float32
+	switch side {
+	case North:
+		axis, edge = 2, b[0][2]
+	case South:
+		axis, edge = 2, 1-b[1][2]
+	case East:
+		axis, edge = 0, 1-b[1][0]
+	case West:
+		axis, edge = 0, b[0][0]
+	case Top:
+		axis, edge = 1, 1-b[1][1]
+	case Bottom:
+		axis, edge = 1, b[0][1]
+	}
+	if edge != 0 {
+		return false
+	}
+	uv := [2]float32{u, v}
+	j := 0
+	for i := 0; i < 3; i++ {
+		if i == axis {
+			continue
+		}
+		if uv[j] < b[0][i] || uv[j] > b[1][i] {
+			return false
+		}
+		j++
+	}
+	return true
+}
@@ -1,5 +1,11 @@
 package t
 
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
 // ChunkRef references a single chunk within the world.
 type ChunkRef uint32
 
@@ -27,31 +33,169 @@ func NewChunkRefForWorldPosition(p IVec3) ChunkRef {
 	return NewChunkRef(p.Div(IVec3{16, 16, 16}))
 }
 
+// VoxLookup returns the voxel volume for the given vox reference, or nil if
+// there is none.
+type VoxLookup func(VoxRef) VoxVolume
+
 // World manages the state of the entire world.
 type World struct {
+	Time   float32 // Time of day in the range 0-1, 0 being midnight and 0.5 noon
 	chunks map[ChunkRef]*Chunk
+	cubes  []*Cube   // Cube definitions by CubeRef
+	vox    VoxLookup // Vox model lookup function
 }
 
-// NewWorld returns a new World object read for use.
-func NewWorld() *World {
+// NewWorld returns a new World object read for use. The cube definitions are
+// indexed by CubeRef and are used for shape and collision queries. The vox
+// lookup function is used for precise ray picking of vox cells and may be nil.
+func NewWorld(cubes []*Cube, vox VoxLookup) *World {
 	return &World{
+		Time:   DawnTime + 0.05,
 		chunks: map[ChunkRef]*Chunk{},
+		cubes:  cubes,
+		vox:    vox,
 	}
 }
 
+// AdvanceTime advances the time of day by dt seconds, wrapping at midnight.
+func (w *World) AdvanceTime(dt float32) {
+	w.Time += dt / DayLength
+	w.Time -= float32(int(w.Time))
+}
+
 // SetCell sets the cube and facing at the given position in the world. Returns
-// true if the voxel was changed.
+// true if the voxel was changed. If the cell was part of a vox model spanning
+// multiple cells the entire footprint of the model is cleared. If the new
+// value is a vox model spanning multiple cells the filler cells of the
+// footprint are set as well, clearing any overlapping vox models. See
+// CanPlace to test if the footprint is free.
 func (w *World) SetCell(p IVec3, v Cell) bool {
+	changed := w.clearVox(p)
+	if v.IsVox() {
+		_, vRef, f := v.Decompose()
+		for i, fp := range w.Footprint(vRef, p, f) {
+			if i == 0 {
+				continue
+			}
+			changed = w.clearVox(fp) || changed
+			changed = w.setCell(fp, CellForVoxFiller(
+				w.footprintOffset(vRef, i), f)) || changed
+		}
+	}
+	return w.setCell(p, v) || changed
+}
+
+// setCell sets the value of a single cell.
+func (w *World) setCell(p IVec3, v Cell) bool {
 	cp := p.Div(IVec3{16, 16, 16})
 	cr := NewChunkRef(cp)
 	c := w.chunks[cr]
 	if c == nil {
-		c = newChunk(p, CellInvalid)
+		c = newChunk(cp.Mul(IVec3{16, 16, 16}), CellInvalid)
 		w.chunks[cr] = c
 	}
 	return c.SetCell(p, v)
 }
 
+// voxSize returns the size of the vox model in cells before rotation.
+func (w *World) voxSize(r VoxRef) IVec3 {
+	ret := IVec3{1, 1, 1}
+	if w.vox == nil {
+		return ret
+	}
+	v := w.vox(r)
+	if v == nil {
+		return ret
+	}
+	x, y, z := v.Dimensions()
+	for i, d := range []int{x, y, z} {
+		ret[i] = min(max((d+15)/16, 1), VoxMaxCells)
+	}
+	return ret
+}
+
+// footprintOffset returns the offset from the primary cell before rotation of
+// the i-th cell of the footprint returned by Footprint.
+func (w *World) footprintOffset(r VoxRef, i int) IVec3 {
+	s := w.voxSize(r)
+	return IVec3{i % s[0], (i / s[0]) % s[1], i / (s[0] * s[1])}
+}
+
+// rotateOffset rotates a cell offset about the center of the primary cell.
+func rotateOffset(o IVec3, f Facing) IVec3 {
+	v := FacingToOrientation[f].Q.Rotate(
+		mgl32.Vec3{float32(o[0]), float32(o[1]), float32(o[2])})
+	round := func(f float32) int {
+		return int(math.Floor(float64(f) + 0.5))
+	}
+	return IVec3{round(v[0]), round(v[1]), round(v[2])}
+}
+
+// Footprint returns the positions of all cells occupied by the vox model
+// placed at p with facing f. The primary cell p is always the first position.
+func (w *World) Footprint(r VoxRef, p IVec3, f Facing) []IVec3 {
+	s := w.voxSize(r)
+	ret := make([]IVec3, 0, s[0]*s[1]*s[2])
+	for i := 0; i < s[0]*s[1]*s[2]; i++ {
+		ret = append(ret, p.Add(rotateOffset(w.footprintOffset(r, i), f)))
+	}
+	return ret
+}
+
+// VoxOrigin returns the position of the primary cell of the vox model that
+// occupies the cell at p. False is returned if the cell does not contain a
+// vox model or a vox filler cell.
+func (w *World) VoxOrigin(p IVec3) (IVec3, bool) {
+	c := w.GetCell(p)
+	if c.IsVox() {
+		return p, true
+	}
+	if !c.IsVoxFiller() {
+		return p, false
+	}
+	_, _, f := c.Decompose()
+	op := p.Sub(rotateOffset(c.FillerOffset(), f))
+	if !w.GetCell(op).IsVox() {
+		return p, false
+	}
+	return op, true
+}
+
+// clearVox clears the entire footprint of the vox model occupying the cell at
+// p, if any. Returns true if any cells were changed.
+func (w *World) clearVox(p IVec3) bool {
+	op, ok := w.VoxOrigin(p)
+	if !ok {
+		return false
+	}
+	_, vRef, f := w.GetCell(op).Decompose()
+	changed := false
+	for _, fp := range w.Footprint(vRef, op, f) {
+		c := w.GetCell(fp)
+		if fp != op && !c.IsVoxFiller() {
+			continue
+		}
+		changed = w.setCell(fp, CellInvalid) || changed
+	}
+	return changed
+}
+
+// CanPlace returns true if every cell of the footprint of the cell value
+// placed at p is empty. Cells other than vox models always occupy only p.
+func (w *World) CanPlace(p IVec3, v Cell) bool {
+	fp := []IVec3{p}
+	if v.IsVox() {
+		_, vRef, f := v.Decompose()
+		fp = w.Footprint(vRef, p, f)
+	}
+	for _, p := range fp {
+		if w.GetCell(p) != CellInvalid {
+			return false
+		}
+	}
+	return true
+}
+
 // GetCell returns the cell value at the given position in the world.
 func (w *World) GetCell(p IVec3) Cell {
 	cp := p.Div(IVec3{16, 16, 16})
@@ -68,3 +212,60 @@ func (w *World) GetCell(p IVec3) Cell {
 func (w *World) GetChunkByRef(r ChunkRef) *Chunk {
 	return w.chunks[r]
 }
+
+// GetCube returns the cube definition for the cube at the given position in
+// the world, or nil if the cell does not contain a cube.
+func (w *World) GetCube(p IVec3) *Cube {
+	c := w.GetCell(p)
+	if !c.IsCube() {
+		return nil
+	}
+	r, _, _ := c.Decompose()
+	if int(r) >= len(w.cubes) {
+		return nil
+	}
+	return w.cubes[r]
+}
+
+// cellBoxes returns the boxes of the cell at the given position in world
+// coordinates. If collision is true only boxes that participate in physical
+// collision are returned, otherwise the selection boxes are returned.
+func (w *World) cellBoxes(p IVec3, collision bool) []AABB {
+	c := w.GetCell(p)
+	cRef, vRef, f := c.Decompose()
+	if cRef == CubeRefInvalid && vRef == VoxRefInvalid && !c.IsVoxFiller() {
+		return nil
+	}
+	o := mgl32.Vec3{float32(p[0]), float32(p[1]), float32(p[2])}
+	var boxes []AABB
+	if cd := w.GetCube(p); cd != nil {
+		if collision {
+			boxes = cd.Shape.CollisionBoxes(f)
+		} else {
+			boxes = cd.Shape.Boxes(f)
+		}
+		for side := North; side <= West; side++ {
+			if cd.ConnectsTo(w.GetCube(p.Add(FacingOffsets[side]))) {
+				boxes = append(boxes, fenceArms[side][:]...)
+			}
+		}
+	} else {
+		boxes = ShapeFull.Boxes(f)
+	}
+	for i, b := range boxes {
+		boxes[i] = b.Translate(o)
+	}
+	return boxes
+}
+
+// CollisionBoxes returns the boxes of the cell at the given position that
+// participate in physical collision, in world coordinates.
+func (w *World) CollisionBoxes(p IVec3) []AABB {
+	return w.cellBoxes(p, true)
+}
+
+// SelectionBoxes returns the boxes of the cell at the given position used for
+// ray picking, in world coordinates.
+func (w *World) SelectionBoxes(p IVec3) []AABB {
+	return w.cellBoxes(p, false)
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// FormatJSON returns the JSON document d indented by indent per level, in the
+// style of hand-written data files. Objects and arrays containing objects or
+// arrays are broken across lines while arrays of only numbers, strings and
+// literals are kept on a single line.
+func FormatJSON(d []byte, indent string) ([]byte, error) {
+	var c bytes.Buffer
+	if err := json.Compact(&c, d); err != nil {
+		return nil, err
+	}
+	src := c.Bytes()
+	var ret bytes.Buffer
+	depth := 0
+	newline := func() {
+		ret.WriteByte('\n')
+		ret.WriteString(strings.Repeat(indent, depth))
+	}
+	// flat returns true if the array starting at index i contains no objects
+	// or arrays.
+	flat := func(i int) bool {
+		inString := false
+		for j := i + 1; j < len(src); j++ {
+			b := src[j]
+			if inString {
+				if b == '\\' {
+					j++
+				} else if b == '"' {
+					inString = false
+				}
+				continue
+			}
+			switch b {
+			case '"':
+				inString = true
+			case '[', '{':
+				return false
+			case ']':
+				return true
+			}
+		}
+		return true
+	}
+	inString := false
+	inFlat := false
+	for i := 0; i < len(src); i++ {
+		b := src[i]
+		if inString {
+			ret.WriteByte(b)
+			if b == '\\' {
+				i++
+				ret.WriteByte(src[i])
+			} else if b == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+			ret.WriteByte(b)
+		case '{', '[':
+			ret.WriteByte(b)
+			if i+1 < len(src) && (src[i+1] == '}' || src[i+1] == ']') {
+				i++
+				ret.WriteByte(src[i])
+				continue
+			}
+			if b == '[' && flat(i) {
+				inFlat = true
+				continue
+			}
+			depth++
+			newline()
+		case '}', ']':
+			if inFlat {
+				inFlat = false
+				ret.WriteByte(b)
+				continue
+			}
+			depth--
+			newline()
+			ret.WriteByte(b)
+		case ',':
+			ret.WriteByte(b)
+			if inFlat {
+				ret.WriteByte(' ')
+			} else {
+				newline()
+			}
+		case ':':
+			ret.WriteString(": ")
+		default:
+			ret.WriteByte(b)
+		}
+	}
+	ret.WriteByte('\n')
+	return ret.Bytes(), nil
+}
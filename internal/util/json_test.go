@@ -0,0 +1,33 @@
+package util
+
+import "testing"
+
+func TestFormatJSON(t *testing.T) {
+	src := `{"a": {"b": [1, 2, 3], "c": [{"d": "x,[y]"}], "e": {}}, "f": []}`
+	want := `{
+    "a": {
+        "b": [1, 2, 3],
+        "c": [
+            {
+                "d": "x,[y]"
+            }
+        ],
+        "e": {}
+    },
+    "f": []
+}
+`
+	got, err := FormatJSON([]byte(src), "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("formatted as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatJSONInvalid(t *testing.T) {
+	if _, err := FormatJSON([]byte(`{"a":`), "  "); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
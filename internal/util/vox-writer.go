@@ -0,0 +1,392 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// voxMaxModelDims is the largest model dimension written. MagicaVoxel
+// supports up to 256 but models are kept within the size the reader accepts.
+const voxMaxModelDims = maxVoxDims
+
+// NewVox returns a new, empty Vox structure with the given dimensions. The
+// dimensions are given in the same order as returned by Dimensions().
+func NewVox(w, h, d int) *Vox {
+	return &Vox{
+		Width:  w,
+		Height: d,
+		Depth:  h,
+		Voxels: make([][4]uint8, w*h*d),
+	}
+}
+
+// Set sets the color of a single voxel using the same coordinate system as
+// Get(). Out of bounds coordinates are ignored.
+func (v *Vox) Set(x, y, z int, c [4]uint8) {
+	sx := x
+	sy := v.Height - (z + 1)
+	sz := y
+	if sx < 0 || sx >= v.Width || sy < 0 || sy >= v.Height || sz < 0 ||
+		sz >= v.Depth {
+		return
+	}
+	v.Voxels[sz*v.Width*v.Height+sy*v.Width+sx] = c
+}
+
+// Write writes the voxel model to w in the MagicaVoxel .vox format. Models
+// larger than voxMaxModelDims voxels along any axis are split into multiple
+// models.
+func (v *Vox) Write(w io.Writer) error {
+	return NewVoxScene(v).Write(w)
+}
+
+// NewVoxScene returns a new VoxScene containing the voxel model. A palette of
+// at most 255 colors is built from the voxel colors, reducing color precision
+// as needed. Models larger than voxMaxModelDims voxels along any axis are
+// split into multiple models placed with the scene graph.
+func NewVoxScene(v *Vox) *VoxScene {
+	ret := &VoxScene{
+		Nodes: map[int]*VoxNode{},
+	}
+	// Build the palette
+	var shift uint
+	quantize := func(c [4]uint8) [4]uint8 {
+		if shift == 0 {
+			return [4]uint8{c[0], c[1], c[2], 255}
+		}
+		mask := uint8(0xff << shift)
+		half := uint8(1 << (shift - 1))
+		return [4]uint8{c[0]&mask | half, c[1]&mask | half, c[2]&mask | half,
+			255}
+	}
+	colors := map[[4]uint8]uint8{}
+	for ; shift < 8; shift++ {
+		clear(colors)
+		for _, c := range v.Voxels {
+			if c[3] < 255 {
+				continue
+			}
+			colors[quantize(c)] = 0
+			if len(colors) > 255 {
+				break
+			}
+		}
+		if len(colors) <= 255 {
+			break
+		}
+	}
+	keys := make([][4]uint8, 0, len(colors))
+	for c := range colors {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		return uint32(a[0])<<16|uint32(a[1])<<8|uint32(a[2]) <
+			uint32(b[0])<<16|uint32(b[1])<<8|uint32(b[2])
+	})
+	for i, c := range keys {
+		colors[c] = uint8(i + 1)
+		ret.Palette[i+1] = c
+	}
+	// Split the volume into models
+	ret.Nodes[0] = &VoxNode{
+		ID:     0,
+		Type:   VoxNodeTransform,
+		Child:  1,
+		Layer:  -1,
+		Frames: []VoxFrame{{Rotation: VoxIdentity}},
+	}
+	group := &VoxNode{
+		ID:    1,
+		Type:  VoxNodeGroup,
+		Layer: -1,
+	}
+	ret.Nodes[1] = group
+	ret.Layers = append(ret.Layers, &VoxLayer{ID: 0})
+	for oz := 0; oz < v.Depth; oz += voxMaxModelDims {
+		for oy := 0; oy < v.Height; oy += voxMaxModelDims {
+			for ox := 0; ox < v.Width; ox += voxMaxModelDims {
+				m := &Vox{
+					Width:  min(voxMaxModelDims, v.Width-ox),
+					Height: min(voxMaxModelDims, v.Height-oy),
+					Depth:  min(voxMaxModelDims, v.Depth-oz),
+				}
+				n := m.Width * m.Height * m.Depth
+				m.Voxels = make([][4]uint8, n)
+				m.Indexes = make([]uint8, n)
+				for z := 0; z < m.Depth; z++ {
+					for y := 0; y < m.Height; y++ {
+						for x := 0; x < m.Width; x++ {
+							c := v.Voxels[(z+oz)*v.Width*v.Height+
+								(y+oy)*v.Width+(x+ox)]
+							if c[3] < 255 {
+								continue
+							}
+							ci := colors[quantize(c)]
+							i := z*m.Width*m.Height + y*m.Width + x
+							m.Indexes[i] = ci
+							m.Voxels[i] = ret.Palette[ci]
+						}
+					}
+				}
+				tn := &VoxNode{
+					ID:    len(ret.Nodes),
+					Type:  VoxNodeTransform,
+					Child: len(ret.Nodes) + 1,
+					Frames: []VoxFrame{{
+						Rotation: VoxIdentity,
+						Translation: [3]int{
+							ox + m.Width/2,
+							oy + m.Height/2,
+							oz + m.Depth/2,
+						},
+					}},
+				}
+				ret.Nodes[tn.ID] = tn
+				ret.Nodes[tn.Child] = &VoxNode{
+					ID:     tn.Child,
+					Type:   VoxNodeShape,
+					Layer:  -1,
+					Models: []int{len(ret.Models)},
+				}
+				group.Children = append(group.Children, tn.ID)
+				ret.Models = append(ret.Models, m)
+			}
+		}
+	}
+	return ret
+}
+
+// byte encodes the rotation in the packed format used by nTRN chunks.
+func (r VoxRotation) byte() uint8 {
+	var ret uint8
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			if r[row][col] == 0 {
+				continue
+			}
+			if row < 2 {
+				ret |= uint8(col) << (row * 2)
+			}
+			if r[row][col] < 0 {
+				ret |= 1 << (4 + row)
+			}
+		}
+	}
+	return ret
+}
+
+// voxWriter accumulates chunks for writing.
+type voxWriter struct {
+	buf bytes.Buffer
+}
+
+// chunk writes a chunk with the given content and no children.
+func (w *voxWriter) chunk(ct string, data []byte) {
+	w.buf.WriteString(ct)
+	PutUint32(&w.buf, uint32(len(data)))
+	PutUint32(&w.buf, 0)
+	w.buf.Write(data)
+}
+
+// putInt32 writes a signed 32-bit integer.
+func putInt32(w io.Writer, v int) {
+	PutUint32(w, uint32(int32(v)))
+}
+
+// putDict writes a dictionary of strings with the keys in sorted order.
+func putDict(w io.Writer, d map[string]string) {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	putInt32(w, len(keys))
+	for _, k := range keys {
+		PutBytes(w, []byte(k))
+		PutBytes(w, []byte(d[k]))
+	}
+}
+
+// nodeAttributes returns the attributes to write for a node.
+func nodeAttributes(n *VoxNode) map[string]string {
+	ret := map[string]string{}
+	for k, v := range n.Attributes {
+		ret[k] = v
+	}
+	delete(ret, "_name")
+	delete(ret, "_hidden")
+	if n.Name != "" {
+		ret["_name"] = n.Name
+	}
+	if n.Hidden {
+		ret["_hidden"] = "1"
+	}
+	return ret
+}
+
+// Write writes the scene to w in the MagicaVoxel .vox format.
+func (s *VoxScene) Write(w io.Writer) error {
+	var cw voxWriter
+	for i, m := range s.Models {
+		if m.Width > voxMaxModelDims || m.Height > voxMaxModelDims ||
+			m.Depth > voxMaxModelDims {
+			return fmt.Errorf("model %d exceeds %d voxels on an axis", i,
+				voxMaxModelDims)
+		}
+		if len(m.Indexes) != m.Width*m.Height*m.Depth {
+			return fmt.Errorf("model %d has no palette indexes", i)
+		}
+		var d bytes.Buffer
+		putInt32(&d, m.Width)
+		putInt32(&d, m.Height)
+		putInt32(&d, m.Depth)
+		cw.chunk("SIZE", d.Bytes())
+		d.Reset()
+		n := 0
+		for _, ci := range m.Indexes {
+			if ci != 0 {
+				n++
+			}
+		}
+		putInt32(&d, n)
+		for z := 0; z < m.Depth; z++ {
+			for y := 0; y < m.Height; y++ {
+				for x := 0; x < m.Width; x++ {
+					ci := m.Indexes[z*m.Width*m.Height+y*m.Width+x]
+					if ci == 0 {
+						continue
+					}
+					d.Write([]byte{uint8(x), uint8(y), uint8(z), ci})
+				}
+			}
+		}
+		cw.chunk("XYZI", d.Bytes())
+	}
+	ids := make([]int, 0, len(s.Nodes))
+	for id := range s.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		n := s.Nodes[id]
+		var d bytes.Buffer
+		putInt32(&d, n.ID)
+		putDict(&d, nodeAttributes(n))
+		switch n.Type {
+		case VoxNodeTransform:
+			putInt32(&d, n.Child)
+			putInt32(&d, -1)
+			putInt32(&d, n.Layer)
+			putInt32(&d, len(n.Frames))
+			for _, f := range n.Frames {
+				fa := map[string]string{}
+				for k, v := range f.Attributes {
+					fa[k] = v
+				}
+				delete(fa, "_r")
+				delete(fa, "_t")
+				if f.Rotation != VoxIdentity {
+					fa["_r"] = strconv.Itoa(int(f.Rotation.byte()))
+				}
+				if f.Translation != [3]int{} {
+					fa["_t"] = fmt.Sprintf("%d %d %d", f.Translation[0],
+						f.Translation[1], f.Translation[2])
+				}
+				putDict(&d, fa)
+			}
+			cw.chunk("nTRN", d.Bytes())
+		case VoxNodeGroup:
+			putInt32(&d, len(n.Children))
+			for _, c := range n.Children {
+				putInt32(&d, c)
+			}
+			cw.chunk("nGRP", d.Bytes())
+		case VoxNodeShape:
+			putInt32(&d, len(n.Models))
+			for _, m := range n.Models {
+				putInt32(&d, m)
+				putDict(&d, nil)
+			}
+			cw.chunk("nSHP", d.Bytes())
+		}
+	}
+	for _, l := range s.Layers {
+		var d bytes.Buffer
+		attrs := map[string]string{}
+		for k, v := range l.Attributes {
+			attrs[k] = v
+		}
+		delete(attrs, "_name")
+		delete(attrs, "_hidden")
+		if l.Name != "" {
+			attrs["_name"] = l.Name
+		}
+		if l.Hidden {
+			attrs["_hidden"] = "1"
+		}
+		putInt32(&d, l.ID)
+		putDict(&d, attrs)
+		putInt32(&d, -1)
+		cw.chunk("LAYR", d.Bytes())
+	}
+	var pal bytes.Buffer
+	for i := 1; i < 256; i++ {
+		pal.Write(s.Palette[i][:])
+	}
+	pal.Write([]byte{0, 0, 0, 0})
+	cw.chunk("RGBA", pal.Bytes())
+	for i, m := range s.Materials {
+		if m.Attributes == nil && m.Type == VoxMaterialDiffuse {
+			continue
+		}
+		var d bytes.Buffer
+		putInt32(&d, i)
+		putDict(&d, m.attributes())
+		cw.chunk("MATL", d.Bytes())
+	}
+	// File header and MAIN chunk
+	var hdr bytes.Buffer
+	hdr.WriteString("VOX ")
+	PutUint32(&hdr, minVoxFileVersion)
+	hdr.WriteString("MAIN")
+	PutUint32(&hdr, 0)
+	PutUint32(&hdr, uint32(cw.buf.Len()))
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(cw.buf.Bytes())
+	return err
+}
+
+// attributes returns the MATL dictionary for the material.
+func (m *VoxMaterial) attributes() map[string]string {
+	ret := map[string]string{}
+	for k, v := range m.Attributes {
+		ret[k] = v
+	}
+	for k, v := range voxMaterialTypes {
+		if v == m.Type {
+			ret["_type"] = k
+		}
+	}
+	put := func(k string, v float32) {
+		if v == 0 {
+			delete(ret, k)
+			return
+		}
+		ret[k] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	put("_weight", m.Weight)
+	put("_rough", m.Rough)
+	put("_spec", m.Spec)
+	put("_ior", m.IOR)
+	put("_flux", m.Flux)
+	put("_emit", m.Emit)
+	put("_metal", m.Metal)
+	put("_trans", m.Trans)
+	return ret
+}
@@ -5,17 +5,62 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"strings"
+
+	"github.com/qbradq/cubit/internal/t"
 )
 
 const minVoxFileVersion uint32 = 150
 
+// maxVoxDims is the largest model dimension accepted along any axis, which is
+// the largest vox model the world can hold.
+const maxVoxDims = t.VoxMaxCells * 16
+
+// VoxMaxVolume is the largest number of voxels read from a .vox file across
+// all of its models. This bounds the memory a file can claim with repeated
+// SIZE chunks regardless of the size of the file itself.
+const VoxMaxVolume = 1 << 24
+
+// voxDefaultPalette is the palette MagicaVoxel uses for files that do not
+// contain an RGBA chunk, in 0xAABBGGRR format.
+var voxDefaultPalette = func() [256]uint32 {
+	var ret [256]uint32
+	i := 1
+	steps := []uint32{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+	for _, r := range steps {
+		for _, g := range steps {
+			for _, b := range steps {
+				if r == 0 && g == 0 && b == 0 {
+					continue
+				}
+				ret[i] = 0xff000000 | b<<16 | g<<8 | r
+				i++
+			}
+		}
+	}
+	ramp := []uint32{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22,
+		0x11}
+	for _, shift := range []uint32{0, 8, 16} {
+		for _, v := range ramp {
+			ret[i] = 0xff000000 | v<<shift
+			i++
+		}
+	}
+	for _, v := range ramp {
+		ret[i] = 0xff000000 | v<<16 | v<<8 | v
+		i++
+	}
+	return ret
+}()
+
 // Vox represents the contents of a .vox file in a generic format. The internal
 // voxels are laid out with Z being the up axis and with the Y axis facing
 // forward.
 type Vox struct {
 	Width, Height, Depth int
-	Voxels               [][4]uint8
+	Voxels               [][4]uint8 // RGBA color of each voxel
+	Indexes              []uint8    // Palette index of each voxel, zero is empty
 }
 
 // Get implements the c3d.VoxelSource interface.
@@ -40,96 +85,551 @@ func (v *Vox) IsEmpty(c [4]uint8) bool {
 	return c[3] < 255
 }
 
-// NewVoxFromReader returns a new Vox structure with the contents loaded from
-// a MagicaVoxel .vox file.
-func NewVoxFromReader(r io.Reader) (*Vox, error) {
-	type chunk struct {
-		ct        string
-		data      []byte
-		childData []byte
-	}
-	newChunk := func(r io.Reader) *chunk {
-		ret := &chunk{}
-		var buf = []byte{0, 0, 0, 0}
-		if _, err := r.Read(buf); err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
+// VoxNodeType identifies the type of a node in a .vox scene graph.
+type VoxNodeType uint8
+
+const (
+	VoxNodeTransform VoxNodeType = iota // Transform node, nTRN
+	VoxNodeGroup                        // Group node, nGRP
+	VoxNodeShape                        // Shape node, nSHP
+)
+
+// VoxRotation is a 3x3 rotation matrix in row-major order whose elements are
+// all -1, 0, or 1.
+type VoxRotation [3][3]int
+
+// VoxIdentity is the identity rotation.
+var VoxIdentity = VoxRotation{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// Mul returns the result of r * o.
+func (r VoxRotation) Mul(o VoxRotation) VoxRotation {
+	var ret VoxRotation
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				ret[i][j] += r[i][k] * o[k][j]
 			}
 		}
-		ret.ct = string(buf)
-		nData := GetUint32(r)
-		nChild := GetUint32(r)
-		ret.data = make([]byte, nData)
-		ret.childData = make([]byte, nChild)
-		r.Read(ret.data)
-		r.Read(ret.childData)
+	}
+	return ret
+}
+
+// Apply returns the vector v rotated by r.
+func (r VoxRotation) Apply(v [3]int) [3]int {
+	var ret [3]int
+	for i := 0; i < 3; i++ {
+		ret[i] = r[i][0]*v[0] + r[i][1]*v[1] + r[i][2]*v[2]
+	}
+	return ret
+}
+
+// newVoxRotation decodes the packed rotation byte format used by nTRN chunks.
+func newVoxRotation(b uint8) VoxRotation {
+	var ret VoxRotation
+	i0 := int(b & 0x3)
+	i1 := int((b >> 2) & 0x3)
+	i2 := 3 - i0 - i1
+	s := func(bit uint) int {
+		if b&(1<<bit) != 0 {
+			return -1
+		}
+		return 1
+	}
+	if i0 > 2 || i1 > 2 || i2 < 0 || i2 > 2 || i0 == i1 {
+		return VoxIdentity
+	}
+	ret[0][i0] = s(4)
+	ret[1][i1] = s(5)
+	ret[2][i2] = s(6)
+	return ret
+}
+
+// VoxFrame is one animation frame of a transform node.
+type VoxFrame struct {
+	Frame       int               // Frame index
+	Rotation    VoxRotation       // Rotation
+	Translation [3]int            // Translation
+	Attributes  map[string]string // All frame attributes
+}
+
+// VoxNode is one node of the scene graph of a .vox file.
+type VoxNode struct {
+	ID         int               // Node ID
+	Type       VoxNodeType       // Type of the node
+	Name       string            // Name of the node, if any
+	Hidden     bool              // If true the node is hidden
+	Attributes map[string]string // All node attributes
+	Child      int               // Child node ID for transform nodes
+	Layer      int               // Layer ID for transform nodes, -1 for none
+	Frames     []VoxFrame        // Transform frames for transform nodes
+	Children   []int             // Child node IDs for group nodes
+	Models     []int             // Model indexes for shape nodes
+}
+
+// VoxMaterialType identifies the type of a material.
+type VoxMaterialType uint8
+
+const (
+	VoxMaterialDiffuse VoxMaterialType = iota // Plain diffuse material
+	VoxMaterialMetal                          // Metallic material
+	VoxMaterialGlass                          // Glass material
+	VoxMaterialEmit                           // Emissive material
+	VoxMaterialBlend                          // Blended material
+	VoxMaterialMedia                          // Cloud / media material
+)
+
+// voxMaterialTypes maps MATL _type values to material types.
+var voxMaterialTypes = map[string]VoxMaterialType{
+	"_diffuse": VoxMaterialDiffuse,
+	"_metal":   VoxMaterialMetal,
+	"_glass":   VoxMaterialGlass,
+	"_emit":    VoxMaterialEmit,
+	"_blend":   VoxMaterialBlend,
+	"_media":   VoxMaterialMedia,
+}
+
+// VoxMaterial describes the material properties of one palette index.
+type VoxMaterial struct {
+	Type       VoxMaterialType   // Material type
+	Weight     float32           // Blend weight of the material type
+	Rough      float32           // Roughness
+	Spec       float32           // Specular
+	IOR        float32           // Index of refraction
+	Flux       float32           // Radiant flux of emissive materials
+	Emit       float32           // Emission of emissive materials
+	Metal      float32           // Metalness
+	Trans      float32           // Transparency of glass materials
+	Attributes map[string]string // All material attributes
+}
+
+// IsEmissive returns true if the material emits light.
+func (m *VoxMaterial) IsEmissive() bool {
+	return m.Type == VoxMaterialEmit && m.Emit > 0
+}
+
+// IsGlass returns true if the material is glass.
+func (m *VoxMaterial) IsGlass() bool {
+	return m.Type == VoxMaterialGlass
+}
+
+// IsMetal returns true if the material is metallic.
+func (m *VoxMaterial) IsMetal() bool {
+	return m.Type == VoxMaterialMetal
+}
+
+// VoxLayer describes one layer of a .vox scene.
+type VoxLayer struct {
+	ID         int               // Layer ID
+	Name       string            // Layer name
+	Hidden     bool              // If true the layer is hidden
+	Attributes map[string]string // All layer attributes
+}
+
+// VoxInstance is one placement of a model within the scene, with all
+// transforms of the scene graph accumulated.
+type VoxInstance struct {
+	Model       int         // Index of the model in VoxScene.Models
+	Name        string      // Name of the nearest named transform node
+	Layer       int         // Layer ID of the nearest transform node, -1 for none
+	Hidden      bool        // If true the instance or one of its parents is hidden
+	Rotation    VoxRotation // Accumulated rotation
+	Translation [3]int      // Accumulated translation of the model's center
+}
+
+// VoxScene represents the complete contents of a .vox file.
+type VoxScene struct {
+	Models    []*Vox           // All models in file order
+	Nodes     map[int]*VoxNode // Scene graph nodes by ID
+	Materials [256]VoxMaterial // Materials by palette index
+	Layers    []*VoxLayer      // Layers in file order
+	Palette   [256][4]uint8    // RGBA palette, index zero is empty
+}
+
+// Instances walks the scene graph from the root node and returns every model
+// instance with its accumulated transforms using the first frame of each
+// transform node. If the file has no scene graph each model is returned as a
+// single instance at the origin.
+func (s *VoxScene) Instances() []VoxInstance {
+	var ret []VoxInstance
+	if _, found := s.Nodes[0]; !found {
+		for i := range s.Models {
+			ret = append(ret, VoxInstance{
+				Model:    i,
+				Layer:    -1,
+				Rotation: VoxIdentity,
+			})
+		}
 		return ret
 	}
-	var buf = []byte{0, 0, 0, 0}
-	ret := &Vox{}
-	r.Read(buf)
-	version := GetUint32(r)
+	visited := map[int]bool{}
+	var fn func(id int, inst VoxInstance)
+	fn = func(id int, inst VoxInstance) {
+		n := s.Nodes[id]
+		if n == nil || visited[id] {
+			return
+		}
+		visited[id] = true
+		defer delete(visited, id)
+		inst.Hidden = inst.Hidden || n.Hidden
+		switch n.Type {
+		case VoxNodeTransform:
+			if n.Name != "" {
+				inst.Name = n.Name
+			}
+			if n.Layer >= 0 {
+				inst.Layer = n.Layer
+				for _, l := range s.Layers {
+					if l.ID == n.Layer && l.Hidden {
+						inst.Hidden = true
+					}
+				}
+			}
+			if len(n.Frames) > 0 {
+				f := n.Frames[0]
+				t := inst.Rotation.Apply(f.Translation)
+				inst.Translation[0] += t[0]
+				inst.Translation[1] += t[1]
+				inst.Translation[2] += t[2]
+				inst.Rotation = inst.Rotation.Mul(f.Rotation)
+			}
+			fn(n.Child, inst)
+		case VoxNodeGroup:
+			for _, c := range n.Children {
+				fn(c, inst)
+			}
+		case VoxNodeShape:
+			for _, m := range n.Models {
+				if m < 0 || m >= len(s.Models) {
+					continue
+				}
+				i := inst
+				i.Model = m
+				ret = append(ret, i)
+			}
+		}
+	}
+	fn(0, VoxInstance{
+		Layer:    -1,
+		Rotation: VoxIdentity,
+	})
+	return ret
+}
+
+// voxReader wraps a reader and records the first error encountered. All
+// lengths read are checked against the bytes remaining before allocating.
+type voxReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+// read fills the buffer completely.
+func (r *voxReader) read(buf []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		r.err = err
+	}
+}
+
+// int32 reads a signed 32-bit integer.
+func (r *voxReader) int32() int {
+	var buf [4]byte
+	r.read(buf[:])
+	return int(int32(GetUint32(bytes.NewReader(buf[:]))))
+}
+
+// string reads a length-prefixed string.
+func (r *voxReader) string() string {
+	n := r.int32()
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || n > r.r.Len() {
+		r.err = errors.New("invalid string length")
+		return ""
+	}
+	buf := make([]byte, n)
+	r.read(buf)
+	return string(buf)
+}
+
+// dict reads a dictionary of strings.
+func (r *voxReader) dict() map[string]string {
+	ret := map[string]string{}
+	n := r.int32()
+	for i := 0; i < n && r.err == nil; i++ {
+		k := r.string()
+		ret[k] = r.string()
+	}
+	return ret
+}
+
+// voxChunk is a single chunk of a .vox file.
+type voxChunk struct {
+	ct        string // Chunk type
+	data      []byte // Chunk content
+	childData []byte // Children chunks
+}
+
+// next reads the next chunk, returning nil at the end of the input.
+func (r *voxReader) next() *voxChunk {
+	var buf [4]byte
+	if r.err != nil {
+		return nil
+	}
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		if !errors.Is(err, io.EOF) {
+			r.err = err
+		}
+		return nil
+	}
+	ret := &voxChunk{
+		ct: string(buf[:]),
+	}
+	nData := r.int32()
+	nChild := r.int32()
+	if r.err != nil {
+		return nil
+	}
+	if nData < 0 || nChild < 0 || nData > r.r.Len() ||
+		nChild > r.r.Len()-nData {
+		r.err = fmt.Errorf("invalid size for chunk %s", ret.ct)
+		return nil
+	}
+	ret.data = make([]byte, nData)
+	ret.childData = make([]byte, nChild)
+	r.read(ret.data)
+	r.read(ret.childData)
+	if r.err != nil {
+		return nil
+	}
+	return ret
+}
+
+// parseVoxFloat parses a floating point attribute value, returning zero if
+// not present or invalid.
+func parseVoxFloat(attrs map[string]string, k string) float32 {
+	v, err := strconv.ParseFloat(attrs[k], 32)
+	if err != nil {
+		return 0
+	}
+	return float32(v)
+}
+
+// NewVoxSceneFromReader returns a new VoxScene structure with the contents
+// loaded from a MagicaVoxel .vox file.
+func NewVoxSceneFromReader(r io.Reader) (*VoxScene, error) {
+	// The whole file is read up front so chunk sizes can be checked against
+	// the data actually present
+	d, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	vr := &voxReader{r: bytes.NewReader(d)}
+	var buf [4]byte
+	vr.read(buf[:])
+	if vr.err != nil || string(buf[:]) != "VOX " {
+		return nil, errors.New("not a .vox file")
+	}
+	version := uint32(vr.int32())
 	if version < minVoxFileVersion {
 		return nil, fmt.Errorf(
 			".vox files must be at least version %d, found version %d",
 			minVoxFileVersion, version)
 	}
-	main := newChunk(r)
+	main := vr.next()
+	if vr.err != nil {
+		return nil, vr.err
+	}
 	if main == nil || main.ct != "MAIN" {
 		return nil, errors.New("did not find MAIN chunk in .vox file")
 	}
-	r = bytes.NewReader(main.childData)
-	sizeSeen := false
-	voxSeen := false
-	var xyziBuf []uint8
-	var pal = [256][4]uint8{}
+	ret := &VoxScene{
+		Nodes: map[int]*VoxNode{},
+	}
+	for i, c := range voxDefaultPalette {
+		ret.Palette[i] = [4]uint8{
+			uint8(c), uint8(c >> 8), uint8(c >> 16), uint8(c >> 24),
+		}
+	}
+	vr = &voxReader{r: bytes.NewReader(main.childData)}
+	var size *[3]int
+	var xyzi [][]uint8
+	budget := VoxMaxVolume
 	for {
-		c := newChunk(r)
+		c := vr.next()
 		if c == nil {
 			break
 		}
+		cr := &voxReader{r: bytes.NewReader(c.data)}
 		switch c.ct {
 		case "SIZE":
-			if sizeSeen {
-				return nil, errors.New("multiple SIZE chunks found")
-			}
-			sizeSeen = true
-			cr := bytes.NewReader(c.data)
-			ret.Width = int(GetUint32(cr))
-			ret.Height = int(GetUint32(cr))
-			ret.Depth = int(GetUint32(cr))
+			size = &[3]int{cr.int32(), cr.int32(), cr.int32()}
+			if size[0] < 0 || size[1] < 0 || size[2] < 0 ||
+				size[0] > maxVoxDims || size[1] > maxVoxDims ||
+				size[2] > maxVoxDims {
+				return nil, errors.New("invalid SIZE chunk")
+			}
 		case "XYZI":
-			if voxSeen {
-				return nil, errors.New("multiple XYZI chunks found")
-			}
-			voxSeen = true
-			cr := bytes.NewReader(c.data)
-			n := int(GetUint32(cr))
-			xyziBuf = make([]uint8, ret.Width*ret.Height*ret.Depth)
-			for i := 0; i < n; i++ {
-				x := int(GetByte(cr))
-				y := int(GetByte(cr))
-				z := int(GetByte(cr))
-				idx := uint8(GetByte(cr))
-				xyziBuf[z*ret.Width*ret.Height+y*ret.Width+x] = idx
+			if size == nil {
+				return nil, errors.New("XYZI chunk without preceding SIZE chunk")
+			}
+			m := &Vox{
+				Width:  size[0],
+				Height: size[1],
+				Depth:  size[2],
+			}
+			size = nil
+			if m.Width*m.Height*m.Depth > budget {
+				return nil, fmt.Errorf(
+					".vox file models exceed the limit of %d voxels",
+					VoxMaxVolume)
+			}
+			budget -= m.Width * m.Height * m.Depth
+			n := cr.int32()
+			idx := make([]uint8, m.Width*m.Height*m.Depth)
+			var v [4]byte
+			for i := 0; i < n && cr.err == nil; i++ {
+				cr.read(v[:])
+				x, y, z := int(v[0]), int(v[1]), int(v[2])
+				if x >= m.Width || y >= m.Height || z >= m.Depth {
+					continue
+				}
+				idx[z*m.Width*m.Height+y*m.Width+x] = v[3]
 			}
+			m.Indexes = idx
+			ret.Models = append(ret.Models, m)
+			xyzi = append(xyzi, idx)
 		case "RGBA":
-			cr := bytes.NewReader(c.data)
+			var v [4]byte
 			for i := 0; i < 255; i++ {
-				pal[i+1][0] = GetByte(cr)
-				pal[i+1][1] = GetByte(cr)
-				pal[i+1][2] = GetByte(cr)
-				pal[i+1][3] = GetByte(cr)
-				if pal[i+1][3] < 255 {
-					log.Println(pal[i+1][3])
+				cr.read(v[:])
+				ret.Palette[i+1] = v
+			}
+		case "nTRN":
+			n := &VoxNode{
+				Type: VoxNodeTransform,
+			}
+			n.ID = cr.int32()
+			n.Attributes = cr.dict()
+			n.Child = cr.int32()
+			cr.int32() // Reserved ID
+			n.Layer = cr.int32()
+			nFrames := cr.int32()
+			for i := 0; i < nFrames && cr.err == nil; i++ {
+				f := VoxFrame{
+					Rotation:   VoxIdentity,
+					Attributes: cr.dict(),
+				}
+				if v, found := f.Attributes["_r"]; found {
+					r, err := strconv.Atoi(v)
+					if err == nil {
+						f.Rotation = newVoxRotation(uint8(r))
+					}
 				}
+				if v, found := f.Attributes["_t"]; found {
+					for i, s := range strings.Fields(v) {
+						if i > 2 {
+							break
+						}
+						f.Translation[i], _ = strconv.Atoi(s)
+					}
+				}
+				if v, found := f.Attributes["_f"]; found {
+					f.Frame, _ = strconv.Atoi(v)
+				}
+				n.Frames = append(n.Frames, f)
+			}
+			ret.addNode(n)
+		case "nGRP":
+			n := &VoxNode{
+				Type:  VoxNodeGroup,
+				Layer: -1,
+			}
+			n.ID = cr.int32()
+			n.Attributes = cr.dict()
+			nChildren := cr.int32()
+			for i := 0; i < nChildren && cr.err == nil; i++ {
+				n.Children = append(n.Children, cr.int32())
 			}
+			ret.addNode(n)
+		case "nSHP":
+			n := &VoxNode{
+				Type:  VoxNodeShape,
+				Layer: -1,
+			}
+			n.ID = cr.int32()
+			n.Attributes = cr.dict()
+			nModels := cr.int32()
+			for i := 0; i < nModels && cr.err == nil; i++ {
+				n.Models = append(n.Models, cr.int32())
+				cr.dict() // Model attributes
+			}
+			ret.addNode(n)
+		case "MATL":
+			id := cr.int32()
+			attrs := cr.dict()
+			if id < 0 || id > 255 {
+				continue
+			}
+			ret.Materials[id] = VoxMaterial{
+				Type:       voxMaterialTypes[attrs["_type"]],
+				Weight:     parseVoxFloat(attrs, "_weight"),
+				Rough:      parseVoxFloat(attrs, "_rough"),
+				Spec:       parseVoxFloat(attrs, "_spec"),
+				IOR:        parseVoxFloat(attrs, "_ior"),
+				Flux:       parseVoxFloat(attrs, "_flux"),
+				Emit:       parseVoxFloat(attrs, "_emit"),
+				Metal:      parseVoxFloat(attrs, "_metal"),
+				Trans:      parseVoxFloat(attrs, "_trans"),
+				Attributes: attrs,
+			}
+		case "LAYR":
+			l := &VoxLayer{}
+			l.ID = cr.int32()
+			l.Attributes = cr.dict()
+			l.Name = l.Attributes["_name"]
+			l.Hidden = l.Attributes["_hidden"] == "1"
+			ret.Layers = append(ret.Layers, l)
 		}
+		if cr.err != nil {
+			return nil, fmt.Errorf("reading %s chunk: %w", c.ct, cr.err)
+		}
+	}
+	if vr.err != nil {
+		return nil, vr.err
 	}
-	// Compile voxel volume from the XYZI buffer
-	ret.Voxels = make([][4]uint8, ret.Width*ret.Height*ret.Depth)
-	for i, ci := range xyziBuf {
-		ret.Voxels[i] = pal[ci]
+	// Compile voxel volumes from the XYZI buffers
+	for i, m := range ret.Models {
+		m.Voxels = make([][4]uint8, len(xyzi[i]))
+		for j, ci := range xyzi[i] {
+			if ci == 0 {
+				continue
+			}
+			m.Voxels[j] = ret.Palette[ci]
+		}
 	}
 	return ret, nil
 }
+
+// addNode adds a node to the scene graph, populating common attributes.
+func (s *VoxScene) addNode(n *VoxNode) {
+	n.Name = n.Attributes["_name"]
+	n.Hidden = n.Attributes["_hidden"] == "1"
+	s.Nodes[n.ID] = n
+}
+
+// NewVoxFromReader returns a new Vox structure with the contents of the first
+// model loaded from a MagicaVoxel .vox file. Use NewVoxSceneFromReader to
+// access all models and the scene graph.
+func NewVoxFromReader(r io.Reader) (*Vox, error) {
+	s, err := NewVoxSceneFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Models) < 1 {
+		return nil, errors.New("no models found in .vox file")
+	}
+	return s.Models[0], nil
+}
@@ -0,0 +1,325 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+// voxFile returns a .vox file with a MAIN chunk holding the given children.
+func voxFile(children ...[]byte) []byte {
+	var c bytes.Buffer
+	for _, ch := range children {
+		c.Write(ch)
+	}
+	var b bytes.Buffer
+	b.WriteString("VOX ")
+	PutUint32(&b, 150)
+	b.WriteString("MAIN")
+	PutUint32(&b, 0)
+	PutUint32(&b, uint32(c.Len()))
+	b.Write(c.Bytes())
+	return b.Bytes()
+}
+
+// voxChunkBytes returns a chunk with the given type and content. The content
+// size is given separately so it may disagree with the content.
+func voxChunkBytes(ct string, size uint32, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(ct)
+	PutUint32(&b, size)
+	PutUint32(&b, 0)
+	b.Write(data)
+	return b.Bytes()
+}
+
+// voxChunkOf returns a chunk with the given type and the content written by
+// fn.
+func voxChunkOf(ct string, fn func(w *bytes.Buffer)) []byte {
+	var data bytes.Buffer
+	fn(&data)
+	return voxChunkBytes(ct, uint32(data.Len()), data.Bytes())
+}
+
+// voxModelChunks returns the SIZE and XYZI chunks of a model with the given
+// dimensions and voxels, each voxel being x, y, z and palette index.
+func voxModelChunks(w, h, d int, voxels ...[4]uint8) [][]byte {
+	return [][]byte{
+		voxChunkOf("SIZE", func(b *bytes.Buffer) {
+			putInt32(b, w)
+			putInt32(b, h)
+			putInt32(b, d)
+		}),
+		voxChunkOf("XYZI", func(b *bytes.Buffer) {
+			putInt32(b, len(voxels))
+			for _, v := range voxels {
+				b.Write(v[:])
+			}
+		}),
+	}
+}
+
+func TestVoxRejectsOversizedChunk(t *testing.T) {
+	d := voxFile(voxChunkBytes("LAYR", 0x7FFFFFFF, nil))
+	if _, err := NewVoxSceneFromReader(bytes.NewReader(d)); err == nil {
+		t.Fatal("expected an error for a chunk larger than the file")
+	}
+}
+
+func TestVoxRejectsOversizedString(t *testing.T) {
+	var data bytes.Buffer
+	PutUint32(&data, 0)          // Layer ID
+	PutUint32(&data, 1)          // Dictionary entries
+	PutUint32(&data, 0x7FFFFFFF) // Key length
+	d := voxFile(voxChunkBytes("LAYR", uint32(data.Len()), data.Bytes()))
+	if _, err := NewVoxSceneFromReader(bytes.NewReader(d)); err == nil {
+		t.Fatal("expected an error for a string longer than the chunk")
+	}
+}
+
+func TestVoxRejectsOversizedModel(t *testing.T) {
+	var data bytes.Buffer
+	PutUint32(&data, 0x10000)
+	PutUint32(&data, 0x10000)
+	PutUint32(&data, 0x10000)
+	d := voxFile(voxChunkBytes("SIZE", uint32(data.Len()), data.Bytes()))
+	if _, err := NewVoxSceneFromReader(bytes.NewReader(d)); err == nil {
+		t.Fatal("expected an error for model dimensions past the limit")
+	}
+}
+
+func TestVoxRejectsVoxelBudget(t *testing.T) {
+	var chunks [][]byte
+	for i := 0; i < 2; i++ {
+		chunks = append(chunks, voxModelChunks(maxVoxDims, maxVoxDims,
+			maxVoxDims)...)
+	}
+	d := voxFile(chunks...)
+	if _, err := NewVoxSceneFromReader(bytes.NewReader(d)); err == nil {
+		t.Fatal("expected an error for models past the voxel budget")
+	}
+}
+
+func TestVoxDefaultPalette(t *testing.T) {
+	d := voxFile(voxModelChunks(2, 1, 1, [4]uint8{0, 0, 0, 1},
+		[4]uint8{1, 0, 0, 2})...)
+	s, err := NewVoxSceneFromReader(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		i    int
+		want [4]uint8
+	}{
+		{1, [4]uint8{255, 255, 255, 255}},
+		{2, [4]uint8{255, 255, 204, 255}},
+		{255, [4]uint8{17, 17, 17, 255}},
+	}
+	for _, tt := range tests {
+		if got := s.Palette[tt.i]; got != tt.want {
+			t.Errorf("palette index %d is %v, want %v", tt.i, got, tt.want)
+		}
+	}
+	m := s.Models[0]
+	if m.Voxels[0] != s.Palette[1] || m.Voxels[1] != s.Palette[2] {
+		t.Errorf("voxels are %v, want palette colors 1 and 2", m.Voxels)
+	}
+}
+
+func TestVoxPalette(t *testing.T) {
+	rgba := voxChunkOf("RGBA", func(b *bytes.Buffer) {
+		for i := 0; i < 256; i++ {
+			b.Write([]byte{uint8(i), 0, 0, 255})
+		}
+	})
+	chunks := append(voxModelChunks(1, 1, 1, [4]uint8{0, 0, 0, 3}), rgba)
+	s, err := NewVoxSceneFromReader(bytes.NewReader(voxFile(chunks...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Palette entry i of the file is color index i+1
+	if got := s.Models[0].Voxels[0]; got != [4]uint8{2, 0, 0, 255} {
+		t.Errorf("voxel is %v, want the third palette entry", got)
+	}
+}
+
+func TestVoxSceneGraph(t *testing.T) {
+	trn := func(id, child, layer int, attrs map[string]string,
+		frame map[string]string) []byte {
+		return voxChunkOf("nTRN", func(b *bytes.Buffer) {
+			putInt32(b, id)
+			putDict(b, attrs)
+			putInt32(b, child)
+			putInt32(b, -1)
+			putInt32(b, layer)
+			putInt32(b, 1)
+			putDict(b, frame)
+		})
+	}
+	shp := func(id, model int) []byte {
+		return voxChunkOf("nSHP", func(b *bytes.Buffer) {
+			putInt32(b, id)
+			putDict(b, nil)
+			putInt32(b, 1)
+			putInt32(b, model)
+			putDict(b, nil)
+		})
+	}
+	layer := func(id int, attrs map[string]string) []byte {
+		return voxChunkOf("LAYR", func(b *bytes.Buffer) {
+			putInt32(b, id)
+			putDict(b, attrs)
+			putInt32(b, -1)
+		})
+	}
+	chunks := append(voxModelChunks(1, 1, 1), voxModelChunks(2, 2, 2)...)
+	chunks = append(chunks,
+		// Root transform swapping the X and Y axes
+		trn(0, 1, -1, nil, map[string]string{"_r": "1", "_t": "1 2 3"}),
+		voxChunkOf("nGRP", func(b *bytes.Buffer) {
+			putInt32(b, 1)
+			putDict(b, nil)
+			putInt32(b, 2)
+			putInt32(b, 2)
+			putInt32(b, 4)
+		}),
+		trn(2, 3, 1, map[string]string{"_name": "a"},
+			map[string]string{"_t": "10 0 0"}),
+		shp(3, 0),
+		trn(4, 5, 0, map[string]string{"_hidden": "1"},
+			map[string]string{"_t": "0 0 5", "_f": "2"}),
+		shp(5, 1),
+		layer(0, map[string]string{"_name": "base"}),
+		layer(1, map[string]string{"_name": "hidden", "_hidden": "1"}),
+	)
+	s, err := NewVoxSceneFromReader(bytes.NewReader(voxFile(chunks...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Nodes) != 6 {
+		t.Fatalf("read %d nodes, want 6", len(s.Nodes))
+	}
+	if n := s.Nodes[4]; n.Frames[0].Frame != 2 || !n.Hidden {
+		t.Errorf("node 4 has frame %d, hidden %v", n.Frames[0].Frame,
+			n.Hidden)
+	}
+	if len(s.Layers) != 2 || s.Layers[0].Name != "base" ||
+		s.Layers[0].Hidden || !s.Layers[1].Hidden {
+		t.Fatalf("layers not read correctly")
+	}
+	swap := VoxRotation{{0, 1, 0}, {1, 0, 0}, {0, 0, 1}}
+	want := []VoxInstance{
+		{
+			Model:       0,
+			Name:        "a",
+			Layer:       1,
+			Hidden:      true,
+			Rotation:    swap,
+			Translation: [3]int{1, 12, 3},
+		},
+		{
+			Model:       1,
+			Layer:       0,
+			Hidden:      true,
+			Rotation:    swap,
+			Translation: [3]int{1, 2, 8},
+		},
+	}
+	got := s.Instances()
+	if len(got) != len(want) {
+		t.Fatalf("got %d instances, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("instance %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestVoxMaterials(t *testing.T) {
+	matl := func(id int, attrs map[string]string) []byte {
+		return voxChunkOf("MATL", func(b *bytes.Buffer) {
+			putInt32(b, id)
+			putDict(b, attrs)
+		})
+	}
+	d := voxFile(
+		matl(5, map[string]string{"_type": "_emit", "_emit": "0.5",
+			"_flux": "2"}),
+		matl(7, map[string]string{"_type": "_glass", "_trans": "0.25"}),
+		matl(8, map[string]string{"_type": "_metal", "_metal": "x"}),
+		matl(300, map[string]string{"_type": "_emit", "_emit": "1"}),
+	)
+	s, err := NewVoxSceneFromReader(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Materials[5]; !m.IsEmissive() || m.Emit != 0.5 || m.Flux != 2 {
+		t.Errorf("material 5 is %+v", m)
+	}
+	if m := s.Materials[7]; !m.IsGlass() || m.Trans != 0.25 {
+		t.Errorf("material 7 is %+v", m)
+	}
+	if m := s.Materials[8]; !m.IsMetal() || m.Metal != 0 {
+		t.Errorf("material 8 is %+v", m)
+	}
+	if m := s.Materials[0]; m.Type != VoxMaterialDiffuse || m.IsEmissive() {
+		t.Errorf("material 0 is %+v", m)
+	}
+}
+
+func TestVoxRoundTrip(t *testing.T) {
+	v := NewVox(3, 4, 5)
+	colors := [][4]uint8{
+		{255, 0, 0, 255},
+		{0, 255, 0, 255},
+		{0, 0, 255, 255},
+	}
+	for i, c := range colors {
+		v.Set(i, i+1, i+2, c)
+	}
+	var b bytes.Buffer
+	if err := v.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewVoxFromReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h, d := r.Dimensions()
+	if w != 3 || h != 4 || d != 5 {
+		t.Fatalf("dimensions %dx%dx%d, want 3x4x5", w, h, d)
+	}
+	for z := 0; z < d; z++ {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if got, want := r.Get(x, y, z), v.Get(x, y, z); got != want {
+					t.Errorf("voxel %d,%d,%d is %v, want %v", x, y, z, got,
+						want)
+				}
+			}
+		}
+	}
+}
+
+func TestVoxRoundTripSplit(t *testing.T) {
+	v := NewVox(voxMaxModelDims+10, 1, 1)
+	v.Set(0, 0, 0, [4]uint8{255, 0, 0, 255})
+	v.Set(voxMaxModelDims+9, 0, 0, [4]uint8{0, 0, 255, 255})
+	var b bytes.Buffer
+	if err := v.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewVoxSceneFromReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Models) != 2 {
+		t.Fatalf("read %d models, want 2", len(s.Models))
+	}
+	if n := len(s.Instances()); n != 2 {
+		t.Fatalf("read %d instances, want 2", n)
+	}
+	if got := s.Models[1].Get(9, 0, 0); got != [4]uint8{0, 0, 255, 255} {
+		t.Errorf("last voxel is %v", got)
+	}
+}